@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+	"github.com/pressly/goose/v3"
+)
+
+const defaultDemoEmail = "demo@unsubtle.local"
+
+// adminCommand loads the configuration of an admin command, opens the database and calls fn. The flags of the
+// command are registered by define before the arguments are parsed.
+func adminCommand(ctx context.Context, name string, w io.Writer, args []string, getenv func(string) string, define func(fs *flag.FlagSet), fn func(config *Config, db *sql.DB, fs *flag.FlagSet) error) error {
+	fs := newFlagSet(name, w)
+	define(fs)
+	config, err := loadConfig(fs, args, getenv, (*Config).validateDatabase)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("could not connect to the database: %w", err)
+	}
+	return fn(config, db, fs)
+}
+
+// requireEmail returns an error unless the -email flag of a command is set.
+func requireEmail(email string) error {
+	if email == "" {
+		return errors.New("-email is required")
+	}
+	return nil
+}
+
+// -- migrate
+
+func runMigrate(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	// The action may be given before or after the flags
+	var action string
+	if len(args) > 0 && (args[0] == "up" || args[0] == "down" || args[0] == "status") {
+		action, args = args[0], args[1:]
+	}
+
+	var dir string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&dir, "migrations-dir", "", "read the goose migrations from this directory instead of the embedded ones")
+	}
+	return adminCommand(ctx, "migrate", w, args, getenv, define, func(config *Config, db *sql.DB, fs *flag.FlagSet) error {
+		if action == "" && fs.NArg() > 0 {
+			action = fs.Arg(0)
+		}
+		migrations := migrationsFS()
+		if dir != "" {
+			migrations = os.DirFS(dir)
+		}
+		migrator, err := newMigrator(db, migrations)
+		if err != nil {
+			return err
+		}
+		return migrate(ctx, w, migrator, action)
+	})
+}
+
+func migrate(ctx context.Context, w io.Writer, migrator *goose.Provider, action string) error {
+	switch action {
+	case "up":
+		results, err := migrator.Up(ctx)
+		for _, result := range results {
+			fmt.Fprintf(w, "applied %s (%s)\n", result.Source.Path, result.Duration.Round(time.Millisecond))
+		}
+		if err != nil {
+			return fmt.Errorf("migrating up: %w", err)
+		}
+		if len(results) == 0 {
+			fmt.Fprintln(w, "database is up to date")
+		}
+	case "down":
+		result, err := migrator.Down(ctx)
+		if err != nil {
+			return fmt.Errorf("migrating down: %w", err)
+		}
+		fmt.Fprintf(w, "rolled back %s (%s)\n", result.Source.Path, result.Duration.Round(time.Millisecond))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("reading migration status: %w", err)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tMIGRATION\tSTATE\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "-"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Source.Version, status.Source.Path, status.State, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected up, down or status", action)
+	}
+	return nil
+}
+
+// -- create-admin
+
+func runCreateAdmin(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	var email string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&email, "email", "", "email address of the admin")
+	}
+	return adminCommand(ctx, "create-admin", w, args, getenv, define, func(config *Config, db *sql.DB, _ *flag.FlagSet) error {
+		if err := requireEmail(email); err != nil {
+			return err
+		}
+		return createAdmin(ctx, w, newSQLStore(db), config, email)
+	})
+}
+
+// createAdmin promotes the user with the given email to admin. If no such user exists it is created with a random
+// password, which is printed once.
+func createAdmin(ctx context.Context, w io.Writer, db dbQuerier, config *Config, email string) error {
+	user, err := db.GetUserByEmail(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("looking up %s: %w", email, err)
+	}
+
+	var password string
+	if errors.Is(err, sql.ErrNoRows) {
+		password, err = generatePassword()
+		if err != nil {
+			return err
+		}
+		created, err := createUser(ctx, db, config, userRequestData{Email: email, Password: password})
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", email, err)
+		}
+		user.ID = created.ID
+	}
+
+	if _, err := db.SetUserAdmin(ctx, database.SetUserAdminParams{ID: user.ID, IsAdmin: true}); err != nil {
+		return fmt.Errorf("promoting %s: %w", email, err)
+	}
+
+	if password == "" {
+		fmt.Fprintf(w, "promoted existing user %s to admin\n", email)
+		return nil
+	}
+	fmt.Fprintf(w, "created admin %s with password %s\n", email, password)
+	return nil
+}
+
+// -- reset-password
+
+func runResetPassword(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	var email string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&email, "email", "", "email address of the user")
+	}
+	return adminCommand(ctx, "reset-password", w, args, getenv, define, func(config *Config, db *sql.DB, _ *flag.FlagSet) error {
+		if err := requireEmail(email); err != nil {
+			return err
+		}
+		return resetPassword(ctx, w, newSQLStore(db), config, email)
+	})
+}
+
+// resetPassword gives the user a new random password and revokes its refresh token so that existing sessions cannot
+// be renewed.
+func resetPassword(ctx context.Context, w io.Writer, db dbQuerier, config *Config, email string) error {
+	user, err := db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", email)
+		}
+		return fmt.Errorf("looking up %s: %w", email, err)
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword(password, config.Auth.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	if _, err := db.UpdateUser(ctx, database.UpdateUserParams{
+		ID:             user.ID,
+		Email:          user.Email,
+		HashedPassword: hash,
+		UpdatedAt:      time.Now(),
+	}); err != nil {
+		return fmt.Errorf("updating %s: %w", email, err)
+	}
+	if _, err := db.RevokeRefreshToken(ctx, user.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("revoking the refresh token of %s: %w", email, err)
+	}
+
+	fmt.Fprintf(w, "new password for %s: %s\n", email, password)
+	return nil
+}
+
+// generatePassword returns a random password that satisfies the password policy of createUser.
+func generatePassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// -- seed-demo
+
+func runSeedDemo(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	var email string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&email, "email", defaultDemoEmail, "email address of the demo user")
+	}
+	return adminCommand(ctx, "seed-demo", w, args, getenv, define, func(config *Config, db *sql.DB, _ *flag.FlagSet) error {
+		return seedDemo(ctx, w, newSQLStore(db), config, email)
+	})
+}
+
+type demoSubscription struct {
+	name        string
+	category    string
+	monthlyCost int32
+	frequency   string
+}
+
+var (
+	demoCategories = []database.CreateCategoryParams{
+		{Name: "Entertainment", Description: "Streaming, music and games"},
+		{Name: "Productivity", Description: "Tools for work"},
+		{Name: "Utilities", Description: "Phone, internet and storage"},
+	}
+	demoSubscriptions = []demoSubscription{
+		{name: "Netflix", category: "Entertainment", monthlyCost: 149, frequency: "monthly"},
+		{name: "Spotify", category: "Entertainment", monthlyCost: 119, frequency: "monthly"},
+		{name: "Notion", category: "Productivity", monthlyCost: 95, frequency: "yearly"},
+		{name: "iCloud+", category: "Utilities", monthlyCost: 39, frequency: "monthly"},
+	}
+)
+
+// seedDemo creates a demo user with a card, categories and active subscriptions so that a fresh installation has
+// something to show. Everything is created in a single transaction, so a failed run leaves no half-seeded user behind.
+func seedDemo(ctx context.Context, w io.Writer, db dbQuerier, config *Config, email string) error {
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	err = db.WithTx(ctx, func(db dbQuerier) error {
+		user, err := createUser(ctx, db, config, userRequestData{Email: email, Password: password})
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", email, err)
+		}
+
+		card, err := db.CreateCard(ctx, database.CreateCardParams{
+			Name:      "Demo Visa",
+			Owner:     user.ID,
+			ExpiresAt: time.Now().AddDate(3, 0, 0),
+		})
+		if err != nil {
+			return fmt.Errorf("creating card: %w", err)
+		}
+
+		categories := make(map[string]uuid.UUID, len(demoCategories))
+		for _, params := range demoCategories {
+			params.CreatedBy = user.ID
+			category, err := db.CreateCategory(ctx, params)
+			if err != nil {
+				return fmt.Errorf("creating category %s: %w", params.Name, err)
+			}
+			categories[category.Name] = category.ID
+		}
+
+		for _, demo := range demoSubscriptions {
+			subscription, err := db.CreateSubscription(ctx, database.CreateSubscriptionParams{
+				Name:        demo.name,
+				MonthlyCost: demo.monthlyCost,
+				Currency:    "SEK",
+				CategoryID:  uuid.NullUUID{UUID: categories[demo.category], Valid: true},
+				CreatedBy:   user.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("creating subscription %s: %w", demo.name, err)
+			}
+			if _, err := db.CreateActiveSubscription(ctx, database.CreateActiveSubscriptionParams{
+				SubscriptionID:   subscription.ID,
+				UserID:           user.ID,
+				CardID:           card.ID,
+				UpdatedAt:        time.Now(),
+				BillingFrequency: demo.frequency,
+				AutoRenewEnabled: sql.NullBool{Bool: true, Valid: true},
+			}); err != nil {
+				return fmt.Errorf("activating subscription %s: %w", demo.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "created demo user %s with password %s\n", email, password)
+	return nil
+}
+
+// -- export-user
+
+func runExportUser(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	var email, out string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&email, "email", "", "email address of the user")
+		fs.StringVar(&out, "out", "", "file to write the export to, defaults to stdout")
+	}
+	return adminCommand(ctx, "export-user", w, args, getenv, define, func(config *Config, db *sql.DB, _ *flag.FlagSet) error {
+		if err := requireEmail(email); err != nil {
+			return err
+		}
+		if out == "" {
+			return exportUser(ctx, w, newSQLStore(db), email)
+		}
+
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		if err := exportUser(ctx, f, newSQLStore(db), email); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// userExport is everything stored about a user, except for its password hash and tokens.
+type userExport struct {
+	ExportedAt          time.Time                     `json:"exported_at"`
+	User                database.User                 `json:"user"`
+	Categories          []database.Category           `json:"categories"`
+	Subscriptions       []database.Subscription       `json:"subscriptions"`
+	Cards               []database.Card               `json:"cards"`
+	ActiveSubscriptions []database.ActiveSubscription `json:"active_subscriptions"`
+}
+
+func exportUser(ctx context.Context, w io.Writer, db dbQuerier, email string) error {
+	user, err := db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", email)
+		}
+		return fmt.Errorf("looking up %s: %w", email, err)
+	}
+	user.HashedPassword = ""
+
+	export := userExport{ExportedAt: time.Now().UTC(), User: user}
+	if export.Categories, err = db.ListCategoriesForUserId(ctx, user.ID); err != nil {
+		return fmt.Errorf("listing categories: %w", err)
+	}
+	if export.Subscriptions, err = db.ListSubscriptionsForUserId(ctx, user.ID); err != nil {
+		return fmt.Errorf("listing subscriptions: %w", err)
+	}
+	if export.Cards, err = db.ListCardsForOwner(ctx, user.ID); err != nil {
+		return fmt.Errorf("listing cards: %w", err)
+	}
+	if export.ActiveSubscriptions, err = db.ListActiveSubscriptionByUserId(ctx, user.ID); err != nil {
+		return fmt.Errorf("listing active subscriptions: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
+
+// -- import-user
+
+func runImportUser(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	var email, in string
+	define := func(fs *flag.FlagSet) {
+		fs.StringVar(&email, "email", "", "email address of the user to import into")
+		fs.StringVar(&in, "in", "", "file with an export written by export-user")
+	}
+	return adminCommand(ctx, "import-user", w, args, getenv, define, func(config *Config, db *sql.DB, _ *flag.FlagSet) error {
+		if err := requireEmail(email); err != nil {
+			return err
+		}
+		if in == "" {
+			return errors.New("-in is required")
+		}
+
+		f, err := os.Open(in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return importUser(ctx, w, f, newSQLStore(db), email)
+	})
+}
+
+/*
+importUser imports an export written by export-user into the existing user with the given email. Categories,
+subscriptions and cards are matched by name and active subscriptions by their subscription, so importing the same
+export again updates the rows it created before instead of duplicating them. The ids of the export are replaced by the
+ids of the imported rows, and everything is imported in a single transaction.
+*/
+func importUser(ctx context.Context, w io.Writer, r io.Reader, db dbQuerier, email string) error {
+	var export userExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return fmt.Errorf("reading export: %w", err)
+	}
+
+	user, err := db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no user with email %s", email)
+		}
+		return fmt.Errorf("looking up %s: %w", email, err)
+	}
+
+	err = db.WithTx(ctx, func(db dbQuerier) error {
+		categories := make(map[uuid.UUID]uuid.UUID, len(export.Categories))
+		for _, category := range export.Categories {
+			imported, err := db.UpsertCategory(ctx, database.UpsertCategoryParams{
+				Name:        category.Name,
+				Description: category.Description,
+				CreatedBy:   user.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("importing category %s: %w", category.Name, err)
+			}
+			categories[category.ID] = imported.ID
+		}
+
+		subscriptions := make(map[uuid.UUID]uuid.UUID, len(export.Subscriptions))
+		for _, subscription := range export.Subscriptions {
+			categoryID := subscription.CategoryID
+			if categoryID.Valid {
+				categoryID.UUID, categoryID.Valid = categories[categoryID.UUID]
+			}
+			imported, err := db.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+				Name:           subscription.Name,
+				MonthlyCost:    subscription.MonthlyCost,
+				Currency:       subscription.Currency,
+				UnsubscribeUrl: subscription.UnsubscribeUrl,
+				Description:    subscription.Description,
+				CategoryID:     categoryID,
+				CreatedBy:      user.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("importing subscription %s: %w", subscription.Name, err)
+			}
+			subscriptions[subscription.ID] = imported.ID
+		}
+
+		cards := make(map[uuid.UUID]uuid.UUID, len(export.Cards))
+		for _, card := range export.Cards {
+			imported, err := db.UpsertCard(ctx, database.UpsertCardParams{
+				Name:      card.Name,
+				Owner:     user.ID,
+				ExpiresAt: card.ExpiresAt,
+			})
+			if err != nil {
+				return fmt.Errorf("importing card %s: %w", card.Name, err)
+			}
+			cards[card.ID] = imported.ID
+		}
+
+		for _, activeSubscription := range export.ActiveSubscriptions {
+			subscriptionID, subscriptionFound := subscriptions[activeSubscription.SubscriptionID]
+			cardID, cardFound := cards[activeSubscription.CardID]
+			if !subscriptionFound || !cardFound {
+				return fmt.Errorf("active subscription %s refers to a subscription or card that is not part of the export", activeSubscription.ID)
+			}
+			if _, err := db.UpsertActiveSubscription(ctx, database.UpsertActiveSubscriptionParams{
+				SubscriptionID:   subscriptionID,
+				UserID:           user.ID,
+				CardID:           cardID,
+				BillingFrequency: activeSubscription.BillingFrequency,
+				AutoRenewEnabled: activeSubscription.AutoRenewEnabled,
+			}); err != nil {
+				return fmt.Errorf("importing active subscription %s: %w", activeSubscription.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "imported %d categories, %d subscriptions, %d cards and %d active subscriptions into %s\n",
+		len(export.Categories), len(export.Subscriptions), len(export.Cards), len(export.ActiveSubscriptions), email)
+	return nil
+}
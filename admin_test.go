@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/database"
+)
+
+func TestRunCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+		wantOut string
+	}{
+		{
+			name:    "it lists the commands",
+			args:    []string{"help"},
+			wantOut: "migrate up|down|status",
+		},
+		{
+			name:    "it rejects unknown commands",
+			args:    []string{"frobnicate"},
+			wantErr: `unknown command "frobnicate"`,
+		},
+		{
+			name:    "it validates the configuration of admin commands",
+			args:    []string{"create-admin", "-email", "admin@example.com"},
+			wantErr: "DB_CONNECTION_STRING not set",
+		},
+		{
+			name:    "it does not require the server settings for admin commands",
+			args:    []string{"create-admin", "-email", "admin@example.com", "-config", writeConfigFile(t, "config.yaml", "database:\n  connection_string: postgres://127.0.0.1:1/unsubtle?sslmode=disable&connect_timeout=1\n")},
+			wantErr: "could not connect to the database",
+		},
+		{
+			name:    "it prints the flags of a command on -h",
+			args:    []string{"export-user", "-h"},
+			wantOut: "-out",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(context.Background(), tt.args, &out, fakeEnv(nil))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("expected output to contain %q, got %s", tt.wantOut, out.String())
+			}
+		})
+	}
+}
+
+func TestCreateAdmin(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	config := newTestConfig()
+
+	t.Run("it creates a new admin with a generated password", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := createAdmin(ctx, &out, store, config, "admin@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		user, err := store.GetUserByEmail(ctx, "admin@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.IsAdmin {
+			t.Error("expected the user to be an admin")
+		}
+		password := strings.TrimSpace(out.String()[strings.LastIndex(out.String(), " "):])
+		if !auth.IsValid(password, user.HashedPassword) {
+			t.Errorf("expected the printed password to be valid, got %q", out.String())
+		}
+	})
+
+	t.Run("it promotes an existing user without changing the password", func(t *testing.T) {
+		existing, err := createUser(ctx, store, config, userRequestData{Email: "user@example.com", Password: "Syp9393-Syp9292-Syp9191"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := createAdmin(ctx, &out, store, config, existing.Email); err != nil {
+			t.Fatal(err)
+		}
+		user, _ := store.GetUserById(ctx, existing.ID)
+		if !user.IsAdmin || !auth.IsValid("Syp9393-Syp9292-Syp9191", user.HashedPassword) {
+			t.Errorf("expected an admin with the old password, got %+v", user)
+		}
+	})
+}
+
+func TestUserRoutesRequireAdmin(t *testing.T) {
+	config := newTestConfig()
+	handler, store, user, token := newAuthenticatedServer(t, config)
+
+	// serve sends a request as the user
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	update := func() *httptest.ResponseRecorder {
+		body := `{"email": "renamed@example.com", "password": "Kup1212-Kup3434-Kup5656"}`
+		return serve(http.MethodPut, "/api/users/"+user.ID.String(), body)
+	}
+
+	t.Run("it forbids users that are not admins", func(t *testing.T) {
+		for _, path := range []string{"/api/users", "/api/users/" + user.ID.String()} {
+			assertProblem(t, serve(http.MethodGet, path, ""), http.StatusForbidden, CodeForbidden)
+		}
+		assertProblem(t, update(), http.StatusForbidden, CodeForbidden)
+	})
+
+	t.Run("it serves admins without exposing password hashes", func(t *testing.T) {
+		if err := createAdmin(context.Background(), io.Discard, store, config, user.Email); err != nil {
+			t.Fatal(err)
+		}
+		rec := update()
+		assertStatusCode(t, rec.Code, http.StatusOK)
+		if strings.Contains(rec.Body.String(), "hashed_password") {
+			t.Errorf("expected no password hash in the response, got %s", rec.Body.String())
+		}
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	config := newTestConfig()
+
+	user, err := createUser(ctx, store, config, userRequestData{Email: "user@example.com", Password: "Syp9393-Syp9292-Syp9191"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{UserID: user.ID, Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := resetPassword(ctx, &out, store, config, user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, _ := store.GetUserById(ctx, user.ID)
+	password := strings.TrimSpace(out.String()[strings.LastIndex(out.String(), " "):])
+	if !auth.IsValid(password, updated.HashedPassword) || auth.IsValid("Syp9393-Syp9292-Syp9191", updated.HashedPassword) {
+		t.Error("expected only the new password to be valid")
+	}
+	if _, err := store.GetRefreshToken(ctx, user.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the refresh token to be revoked, got %v", err)
+	}
+
+	if err := resetPassword(ctx, &out, store, config, "nobody@example.com"); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
+
+func TestSeedDemoAndExportUser(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+
+	if err := seedDemo(ctx, &bytes.Buffer{}, store, newTestConfig(), defaultDemoEmail); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := exportUser(ctx, &out, store, defaultDemoEmail); err != nil {
+		t.Fatal(err)
+	}
+
+	var export userExport
+	if err := json.Unmarshal(out.Bytes(), &export); err != nil {
+		t.Fatalf("expected a JSON export, got %s", err)
+	}
+	if export.User.Email != defaultDemoEmail || export.User.HashedPassword != "" {
+		t.Errorf("expected the demo user without its password hash, got %+v", export.User)
+	}
+	if len(export.Categories) != len(demoCategories) || len(export.Subscriptions) != len(demoSubscriptions) ||
+		len(export.Cards) != 1 || len(export.ActiveSubscriptions) != len(demoSubscriptions) {
+		t.Errorf("expected all demo data in the export, got %d categories, %d subscriptions, %d cards and %d active subscriptions",
+			len(export.Categories), len(export.Subscriptions), len(export.Cards), len(export.ActiveSubscriptions))
+	}
+
+	if err := seedDemo(ctx, &bytes.Buffer{}, store, newTestConfig(), defaultDemoEmail); err == nil {
+		t.Error("expected seeding the same user twice to fail")
+	}
+}
+
+func TestImportUser(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	config := newTestConfig()
+
+	if err := seedDemo(ctx, &bytes.Buffer{}, store, config, defaultDemoEmail); err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	if err := exportUser(ctx, &export, store, defaultDemoEmail); err != nil {
+		t.Fatal(err)
+	}
+	user, err := createUser(ctx, store, config, userRequestData{Email: "copy@example.com", Password: "Syp9393-Syp9292-Syp9191"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := func() [4]int {
+		categories, _ := store.ListCategoriesForUserId(ctx, user.ID)
+		subscriptions, _ := store.ListSubscriptionsForUserId(ctx, user.ID)
+		cards, _ := store.ListCardsForOwner(ctx, user.ID)
+		activeSubscriptions, _ := store.ListActiveSubscriptionByUserId(ctx, user.ID)
+		return [4]int{len(categories), len(subscriptions), len(cards), len(activeSubscriptions)}
+	}
+	want := [4]int{len(demoCategories), len(demoSubscriptions), 1, len(demoSubscriptions)}
+
+	t.Run("it imports an export into another user", func(t *testing.T) {
+		if err := importUser(ctx, &bytes.Buffer{}, bytes.NewReader(export.Bytes()), store, user.Email); err != nil {
+			t.Fatal(err)
+		}
+		if got := counts(); got != want {
+			t.Errorf("expected %v categories, subscriptions, cards and active subscriptions, got %v", want, got)
+		}
+
+		// The imported rows refer to each other, not to the rows of the demo user
+		subscriptions, _ := store.ListSubscriptionsForUserId(ctx, user.ID)
+		for _, subscription := range subscriptions {
+			if category, err := store.GetCategory(ctx, subscription.CategoryID.UUID); err != nil || category.CreatedBy != user.ID {
+				t.Errorf("expected subscription %s in a category of the user, got %+v", subscription.Name, category)
+			}
+		}
+	})
+
+	t.Run("it updates what it imported before", func(t *testing.T) {
+		if err := importUser(ctx, &bytes.Buffer{}, bytes.NewReader(export.Bytes()), store, user.Email); err != nil {
+			t.Fatal(err)
+		}
+		if got := counts(); got != want {
+			t.Errorf("expected importing twice not to duplicate rows, got %v", got)
+		}
+		cards, _ := store.ListCardsForOwner(ctx, user.ID)
+		if cards[0].Version != 2 {
+			t.Errorf("expected the card to be updated, got version %d", cards[0].Version)
+		}
+	})
+
+	t.Run("it imports into existing users only", func(t *testing.T) {
+		if err := importUser(ctx, &bytes.Buffer{}, bytes.NewReader(export.Bytes()), store, "nobody@example.com"); err == nil {
+			t.Error("expected an error for an unknown user")
+		}
+	})
+
+	t.Run("it rolls back exports that refer to missing rows", func(t *testing.T) {
+		other, err := createUser(ctx, store, config, userRequestData{Email: "broken@example.com", Password: "Syp9393-Syp9292-Syp9191"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var broken userExport
+		if err := json.Unmarshal(export.Bytes(), &broken); err != nil {
+			t.Fatal(err)
+		}
+		broken.Cards = nil
+		data, _ := json.Marshal(broken)
+
+		if err := importUser(ctx, &bytes.Buffer{}, bytes.NewReader(data), store, other.Email); err == nil {
+			t.Fatal("expected an error for active subscriptions without their card")
+		}
+		if categories, _ := store.ListCategoriesForUserId(ctx, other.ID); len(categories) != 0 {
+			t.Errorf("expected the import to be rolled back, got %d categories", len(categories))
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/frontend"
+	"github.com/benkoben/unsubtle-core/internal/billing"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// -- Dashboard charts
+//
+// The handlers below compute the charts of the dashboard from the active subscriptions of the user and render them
+// as SVG. Amounts in different currencies are never added up, every chart shows the active subscriptions in a single
+// currency. Like the fragments, errors are rendered as an alert.
+
+const (
+	defaultChartMonths = 12
+	maxChartMonths     = 60
+)
+
+// chartData is what the dashboard charts are computed from.
+type chartData struct {
+	active        []database.ActiveSubscription
+	subscriptions map[uuid.UUID]database.Subscription
+	// currency is the currency of the subscriptions shown in the chart
+	currency string
+	// locale is the language of the labels of the chart
+	locale *frontend.Locale
+}
+
+// includes reports whether the active subscription is shown in the chart.
+func (d chartData) includes(a database.ActiveSubscription) (database.Subscription, bool) {
+	subscription, ok := d.subscriptions[a.SubscriptionID]
+	return subscription, ok && subscription.Currency == d.currency
+}
+
+// loadChartData loads the active subscriptions of the user in the currency of the request.
+func loadChartData(r *http.Request, db dbQuerier, userId uuid.UUID) (chartData, error) {
+	active, err := db.ListActiveSubscriptionByUserId(r.Context(), userId)
+	if err != nil {
+		return chartData{}, InternalError.Wrap(err)
+	}
+	subscriptions, err := db.ListSubscriptionsForUserId(r.Context(), userId)
+	if err != nil {
+		return chartData{}, InternalError.Wrap(err)
+	}
+
+	data := chartData{
+		active:        active,
+		subscriptions: make(map[uuid.UUID]database.Subscription, len(subscriptions)),
+		locale:        frontend.LocaleFor(r),
+	}
+	for _, s := range subscriptions {
+		data.subscriptions[s.ID] = s
+	}
+	data.currency = r.URL.Query().Get("currency")
+	if data.currency == "" {
+		data.currency = chartCurrency(active, data.subscriptions)
+	}
+	return data, nil
+}
+
+// chartCurrency returns the currency of most active subscriptions, the first in alphabetical order on a tie.
+func chartCurrency(active []database.ActiveSubscription, subscriptions map[uuid.UUID]database.Subscription) string {
+	counts := map[string]int{}
+	for _, a := range active {
+		if subscription, ok := subscriptions[a.SubscriptionID]; ok {
+			counts[subscription.Currency]++
+		}
+	}
+
+	var currency string
+	for c, n := range counts {
+		if n > counts[currency] || n == counts[currency] && c < currency {
+			currency = c
+		}
+	}
+	return currency
+}
+
+/*
+monthlySpend sums the charges of the active subscriptions per calendar month, for the given number of months up to
+and including the month of now. Active subscriptions are charged for the first time when they are created, those
+with an unknown billing frequency are left out.
+*/
+func monthlySpend(data chartData, now time.Time, months int) []frontend.ChartValue {
+	from := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, now.Location())
+	to := from.AddDate(0, months, 0)
+
+	spend := make([]frontend.ChartValue, months)
+	for i := range spend {
+		spend[i].Label = data.locale.Month(from.AddDate(0, i, 0))
+	}
+
+	for _, a := range data.active {
+		subscription, ok := data.includes(a)
+		if !ok {
+			continue
+		}
+		period, err := billing.ParsePeriod(a.BillingFrequency)
+		if err != nil {
+			continue
+		}
+		for n := 0; ; n++ {
+			date := period.Nth(a.CreatedAt.In(now.Location()), n)
+			if !date.Before(to) {
+				break
+			}
+			if date.Before(from) {
+				continue
+			}
+			month := (date.Year()-from.Year())*12 + int(date.Month()-from.Month())
+			spend[month].Value += period.Charge(subscription.MonthlyCost)
+		}
+	}
+	return spend
+}
+
+// categorySpend sums the monthly cost of the active subscriptions per category, largest first.
+func categorySpend(data chartData, categories map[uuid.UUID]string) []frontend.ChartValue {
+	byCategory := map[string]int64{}
+	for _, a := range data.active {
+		subscription, ok := data.includes(a)
+		if !ok {
+			continue
+		}
+		category := data.locale.T("chart.uncategorized")
+		if name, ok := categories[subscription.CategoryID.UUID]; ok && subscription.CategoryID.Valid {
+			category = name
+		}
+		byCategory[category] += int64(subscription.MonthlyCost)
+	}
+
+	spend := make([]frontend.ChartValue, 0, len(byCategory))
+	for category, value := range byCategory {
+		spend = append(spend, frontend.ChartValue{Label: category, Value: value})
+	}
+	sort.Slice(spend, func(i, j int) bool {
+		if spend[i].Value != spend[j].Value {
+			return spend[i].Value > spend[j].Value
+		}
+		return spend[i].Label < spend[j].Label
+	})
+	return spend
+}
+
+// cardSpend sums the monthly cost of the active subscriptions per card, with a segment per subscription. Cards and
+// subscriptions are ordered by name.
+func cardSpend(data chartData, cards map[uuid.UUID]string) []frontend.StackedBar {
+	byCard := map[string]map[string]int64{}
+	for _, a := range data.active {
+		subscription, ok := data.includes(a)
+		if !ok {
+			continue
+		}
+		card := cards[a.CardID]
+		if byCard[card] == nil {
+			byCard[card] = map[string]int64{}
+		}
+		byCard[card][subscription.Name] += int64(subscription.MonthlyCost)
+	}
+
+	bars := make([]frontend.StackedBar, 0, len(byCard))
+	for card, subscriptions := range byCard {
+		bar := frontend.StackedBar{Label: card}
+		for name, value := range subscriptions {
+			bar.Segments = append(bar.Segments, frontend.ChartValue{Label: name, Value: value})
+		}
+		sort.Slice(bar.Segments, func(i, j int) bool { return bar.Segments[i].Label < bar.Segments[j].Label })
+		bars = append(bars, bar)
+	}
+	sort.Slice(bars, func(i, j int) bool { return bars[i].Label < bars[j].Label })
+	return bars
+}
+
+// handleSpendChart renders a bar chart of the spend per month. The months query parameter selects how many months
+// are shown.
+func handleSpendChart(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+
+		months := defaultChartMonths
+		if value := r.URL.Query().Get("months"); value != "" {
+			var err error
+			if months, err = strconv.Atoi(value); err != nil || months < 1 || months > maxChartMonths {
+				renderFragmentError(w, r, assets, ValidationFailedError.WithFields(FieldError{
+					Field: "months", Code: FieldOutOfRange, Message: fmt.Sprintf("months must be a number between 1 and %d", maxChartMonths),
+				}))
+				return
+			}
+		}
+
+		data, err := loadChartData(r, db, userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		frontend.RenderChart(w, r, frontend.BarChart{
+			Title:    data.locale.T("chart.spend"),
+			Currency: data.currency,
+			Bars:     monthlySpend(data, time.Now(), months),
+			Locale:   data.locale,
+		})
+	})
+}
+
+// handleCategoryChart renders a donut chart of the monthly spend per category.
+func handleCategoryChart(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+
+		data, err := loadChartData(r, db, userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		categories, err := categoryNamesForUser(r.Context(), db, userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		frontend.RenderChart(w, r, frontend.DonutChart{
+			Title:    data.locale.T("chart.categories"),
+			Currency: data.currency,
+			Slices:   categorySpend(data, categories),
+			Locale:   data.locale,
+		})
+	})
+}
+
+// handleCardChart renders a stacked bar chart of the monthly spend per card, split by subscription.
+func handleCardChart(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+
+		data, err := loadChartData(r, db, userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		cardList, err := db.ListCardsForOwner(r.Context(), userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, InternalError.Wrap(err))
+			return
+		}
+		cards := make(map[uuid.UUID]string, len(cardList))
+		for _, card := range cardList {
+			cards[card.ID] = card.Name
+		}
+		frontend.RenderChart(w, r, frontend.StackedBarChart{
+			Title:    data.locale.T("chart.cards"),
+			Currency: data.currency,
+			Bars:     cardSpend(data, cards),
+			Locale:   data.locale,
+		})
+	})
+}
+
+// categoryNamesForUser maps the IDs of the categories of the user onto their names.
+func categoryNamesForUser(ctx context.Context, db dbQuerier, userId uuid.UUID) (map[uuid.UUID]string, error) {
+	categories, err := db.ListCategoriesForUserId(ctx, userId)
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	names := make(map[uuid.UUID]string, len(categories))
+	for _, category := range categories {
+		names[category.ID] = category.Name
+	}
+	return names, nil
+}
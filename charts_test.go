@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/frontend"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestChartData(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	streaming, visa, amex := uuid.New(), uuid.New(), uuid.New()
+	netflix := database.Subscription{ID: uuid.New(), Name: "Netflix", MonthlyCost: 100, Currency: "SEK", CategoryID: uuid.NullUUID{UUID: streaming, Valid: true}}
+	notion := database.Subscription{ID: uuid.New(), Name: "Notion", MonthlyCost: 10, Currency: "SEK"}
+	github := database.Subscription{ID: uuid.New(), Name: "GitHub", MonthlyCost: 4, Currency: "USD"}
+
+	data := chartData{
+		active: []database.ActiveSubscription{
+			{SubscriptionID: netflix.ID, CardID: visa, CreatedAt: day("2024-11-15"), BillingFrequency: "monthly"},
+			{SubscriptionID: notion.ID, CardID: visa, CreatedAt: day("2025-02-01"), BillingFrequency: "yearly"},
+			{SubscriptionID: github.ID, CardID: amex, CreatedAt: day("2025-01-01"), BillingFrequency: "monthly"},
+			{SubscriptionID: notion.ID, CardID: amex, CreatedAt: day("2025-01-01"), BillingFrequency: "sometimes"},
+		},
+		subscriptions: map[uuid.UUID]database.Subscription{netflix.ID: netflix, notion.ID: notion, github.ID: github},
+		locale:        frontend.DefaultLocale,
+	}
+
+	t.Run("it defaults to the currency of most active subscriptions", func(t *testing.T) {
+		if got := chartCurrency(data.active, data.subscriptions); got != "SEK" {
+			t.Errorf("expected SEK, got %q", got)
+		}
+	})
+
+	data.currency = "SEK"
+
+	t.Run("it sums the charges per month", func(t *testing.T) {
+		got := monthlySpend(data, day("2025-03-10"), 6)
+		want := []frontend.ChartValue{
+			{Label: "Oct 2024", Value: 0},
+			{Label: "Nov 2024", Value: 100},
+			{Label: "Dec 2024", Value: 100},
+			{Label: "Jan 2025", Value: 100},
+			// The yearly subscription is charged for the whole year at once
+			{Label: "Feb 2025", Value: 220},
+			{Label: "Mar 2025", Value: 100},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("it sums the monthly cost per category", func(t *testing.T) {
+		got := categorySpend(data, map[uuid.UUID]string{streaming: "Streaming"})
+		want := []frontend.ChartValue{{Label: "Streaming", Value: 100}, {Label: "Uncategorized", Value: 20}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("it stacks the subscriptions of every card", func(t *testing.T) {
+		got := cardSpend(data, map[uuid.UUID]string{visa: "Visa", amex: "Amex"})
+		want := []frontend.StackedBar{
+			{Label: "Amex", Segments: []frontend.ChartValue{{Label: "Notion", Value: 10}}},
+			{Label: "Visa", Segments: []frontend.ChartValue{{Label: "Netflix", Value: 100}, {Label: "Notion", Value: 10}}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+}
+
+func TestChartHandlers(t *testing.T) {
+	handler, store, user, token := newAuthenticatedServer(t, newTestConfig())
+
+	ctx := context.Background()
+	category, _ := store.CreateCategory(ctx, database.CreateCategoryParams{Name: "Streaming", CreatedBy: user.ID})
+	subscription, _ := store.CreateSubscription(ctx, database.CreateSubscriptionParams{
+		Name: "Netflix", MonthlyCost: 129, Currency: "SEK", CreatedBy: user.ID, CategoryID: uuid.NullUUID{UUID: category.ID, Valid: true},
+	})
+	card, _ := store.CreateCard(ctx, database.CreateCardParams{Name: "Visa", Owner: user.ID, ExpiresAt: time.Now().AddDate(1, 0, 0)})
+	if _, err := store.CreateActiveSubscription(ctx, database.CreateActiveSubscriptionParams{
+		SubscriptionID: subscription.ID, UserID: user.ID, CardID: card.ID, BillingFrequency: "monthly",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		target      string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{target: "/dashboard/charts/spend.svg?months=12", status: http.StatusOK, contentType: "image/svg+xml", contains: []string{"Spend per month", "129 SEK"}},
+		{target: "/dashboard/charts/categories.svg", status: http.StatusOK, contentType: "image/svg+xml", contains: []string{"Streaming: 129 SEK (100%)"}},
+		{target: "/dashboard/charts/cards.svg", status: http.StatusOK, contentType: "image/svg+xml", contains: []string{"Visa, Netflix: 129 SEK"}},
+		{target: "/dashboard/charts/cards.svg?currency=EUR", status: http.StatusOK, contentType: "image/svg+xml", contains: []string{"No data yet"}},
+		{target: "/dashboard/charts/spend.svg?months=0", status: http.StatusBadRequest, contentType: "text/html", contains: []string{"Months is out of range"}},
+		{target: "/dashboard/charts/spend.svg?months=many", status: http.StatusBadRequest, contentType: "text/html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := get(tt.target)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("expected Content-Type %s, got %s", tt.contentType, got)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("expected the response to contain %q, got %s", want, rec.Body.String())
+				}
+			}
+		})
+	}
+
+	t.Run("it requires authentication", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard/charts/spend.svg", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+)
+
+func runLogin(ctx context.Context, c *cli, args []string) error {
+	cfg, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	fs := c.newFlagSet("login")
+	url := fs.String("url", c.serverURL(cfg), "URL of the unsubtle-core API")
+	email := fs.String("email", cfg.Email, "email address of the account")
+	if err := fs.Parse(args); err != nil {
+		return usageError{usage: c.usage, err: err}
+	}
+	if *email == "" {
+		return usageError{usage: c.usage, err: errors.New("-email is required")}
+	}
+
+	password, err := c.password()
+	if err != nil {
+		return err
+	}
+
+	client, err := unsubtle.New(*url)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Login(ctx, *email, password); err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+
+	tokens := client.Tokens()
+	cfg = cliConfig{URL: *url, Email: *email, Token: tokens.Token, RefreshToken: tokens.RefreshToken}
+	if err := saveConfig(c.configPath, cfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "logged in as %s, credentials stored in %s\n", *email, c.configPath)
+	return nil
+}
+
+// password returns $UNSUBTLE_PASSWORD, or the first line of standard input. Passwords are never accepted as flags
+// since those end up in the shell history.
+func (c *cli) password() (string, error) {
+	if password := c.getenv("UNSUBTLE_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(c.stderr, "password: ")
+	scanner := bufio.NewScanner(c.stdin)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		return "", errors.New("no password given")
+	}
+	fmt.Fprintln(c.stderr)
+	return strings.TrimRight(scanner.Text(), "\r"), nil
+}
+
+func runLogout(ctx context.Context, c *cli, args []string) error {
+	err := c.authenticated(ctx, func(client *unsubtle.Client) error {
+		_, err := client.Revoke(ctx)
+		return err
+	})
+	if err != nil && !unsubtle.IsCode(err, unsubtle.CodeUnauthenticated) {
+		return fmt.Errorf("revoking refresh token: %w", err)
+	}
+
+	cfg, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	cfg.Token, cfg.RefreshToken = "", ""
+	return saveConfig(c.configPath, cfg)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/billing"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+	"github.com/google/uuid"
+)
+
+// charge is an upcoming payment of an active subscription.
+type charge struct {
+	Date               time.Time `json:"date"`
+	ActiveSubscription uuid.UUID `json:"active_subscription_id"`
+	Subscription       string    `json:"subscription"`
+	Card               string    `json:"card"`
+	Amount             int64     `json:"amount"`
+	Currency           string    `json:"currency"`
+}
+
+/*
+upcomingCharges lists the charges of the active subscriptions in [from, to), ordered by date. Active subscriptions are
+charged for the first time when they are created. Those with an unknown billing frequency are skipped and returned in
+skipped.
+*/
+func upcomingCharges(
+	active []database.ActiveSubscription,
+	subscriptions map[uuid.UUID]database.Subscription,
+	cards map[uuid.UUID]database.Card,
+	from, to time.Time,
+) (charges []charge, skipped []database.ActiveSubscription) {
+	for _, a := range active {
+		period, err := billing.ParsePeriod(a.BillingFrequency)
+		if err != nil {
+			skipped = append(skipped, a)
+			continue
+		}
+		subscription := subscriptions[a.SubscriptionID]
+
+		for n := 0; ; n++ {
+			date := period.Nth(a.CreatedAt, n)
+			if !date.Before(to) {
+				break
+			}
+			if date.Before(from) {
+				continue
+			}
+			charges = append(charges, charge{
+				Date:               date,
+				ActiveSubscription: a.ID,
+				Subscription:       subscription.Name,
+				Card:               cards[a.CardID].Name,
+				Amount:             period.Charge(subscription.MonthlyCost),
+				Currency:           subscription.Currency,
+			})
+		}
+	}
+
+	sort.SliceStable(charges, func(i, j int) bool { return charges[i].Date.Before(charges[j].Date) })
+	return charges, skipped
+}
+
+func runCalendar(ctx context.Context, c *cli, args []string) error {
+	fs := c.newFlagSet("calendar")
+	days := fs.Int("days", 30, "number of days to look ahead")
+	if err := fs.Parse(args); err != nil {
+		return usageError{usage: c.usage, err: err}
+	}
+	if *days <= 0 {
+		return usageError{usage: c.usage, err: errors.New("-days must be positive")}
+	}
+
+	return c.authenticated(ctx, func(client *unsubtle.Client) error {
+		active, subscriptions, cards, err := loadActiveSubscriptions(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		from := time.Now()
+		charges, skipped := upcomingCharges(active, subscriptions, cards, from, from.AddDate(0, 0, *days))
+		for _, a := range skipped {
+			fmt.Fprintf(c.stderr, "skipping %s: unknown billing frequency %q\n", subscriptions[a.SubscriptionID].Name, a.BillingFrequency)
+		}
+
+		t := table{header: []string{"DATE", "SUBSCRIPTION", "CARD", "AMOUNT", "CURRENCY"}}
+		for _, ch := range charges {
+			t.add(ch.Date.Format(time.DateOnly), ch.Subscription, ch.Card, strconv.FormatInt(ch.Amount, 10), ch.Currency)
+		}
+		return c.format.print(c.stdout, charges, t)
+	})
+}
+
+// loadActiveSubscriptions returns the active subscriptions of the user together with the subscriptions and cards they
+// refer to, keyed by ID.
+func loadActiveSubscriptions(ctx context.Context, client *unsubtle.Client) (
+	[]database.ActiveSubscription,
+	map[uuid.UUID]database.Subscription,
+	map[uuid.UUID]database.Card,
+	error,
+) {
+	active, err := client.ListActiveSubscriptions(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	subscriptionList, err := client.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	subscriptions := make(map[uuid.UUID]database.Subscription, len(subscriptionList))
+	for _, s := range subscriptionList {
+		subscriptions[s.ID] = s
+	}
+
+	cardList, err := client.ListCards(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cards := make(map[uuid.UUID]database.Card, len(cardList))
+	for _, card := range cardList {
+		cards[card.ID] = card
+	}
+	return active, subscriptions, cards, nil
+}
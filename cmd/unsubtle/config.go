@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+)
+
+const defaultServerURL = "http://localhost:8081"
+
+// cliConfig is the content of the config file. It holds credentials and is therefore only readable by its owner.
+type cliConfig struct {
+	URL          string `json:"url"`
+	Email        string `json:"email"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (cfg cliConfig) tokens() unsubtle.Tokens {
+	return unsubtle.Tokens{Token: cfg.Token, RefreshToken: cfg.RefreshToken}
+}
+
+// defaultConfigPath is $UNSUBTLE_CONFIG, or unsubtle/config.json in the user's configuration directory.
+func defaultConfigPath(getenv func(string) string) string {
+	if path := getenv("UNSUBTLE_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "unsubtle.json"
+	}
+	return filepath.Join(dir, "unsubtle", "config.json")
+}
+
+// loadConfig reads the config file. A missing file results in an empty config.
+func loadConfig(path string) (cliConfig, error) {
+	var cfg cliConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("reading config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func saveConfig(path string, cfg cliConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
+}
+
+// serverURL returns the URL of the API: $UNSUBTLE_URL, the URL stored at login or the default.
+func (c *cli) serverURL(cfg cliConfig) string {
+	if url := c.getenv("UNSUBTLE_URL"); url != "" {
+		return url
+	}
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+	return defaultServerURL
+}
+
+/*
+authenticated runs fn with a client that uses the stored credentials. When the client renewed its JWT while running
+fn, the new tokens are written back to the config file, even if fn failed.
+*/
+func (c *cli) authenticated(ctx context.Context, fn func(client *unsubtle.Client) error) error {
+	cfg, err := loadConfig(c.configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.Token == "" {
+		return errors.New("not logged in, run: unsubtle login")
+	}
+
+	client, err := unsubtle.New(c.serverURL(cfg), unsubtle.WithTokens(cfg.tokens()))
+	if err != nil {
+		return err
+	}
+
+	err = fn(client)
+	if tokens := client.Tokens(); tokens != cfg.tokens() {
+		cfg.Token, cfg.RefreshToken = tokens.Token, tokens.RefreshToken
+		if saveErr := saveConfig(c.configPath, cfg); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+/*
+Command unsubtle is a terminal client for the unsubtle-core API.
+
+	unsubtle login -url http://localhost:8081 -email me@example.com
+	unsubtle subs add -name Netflix -cost 129 -currency SEK -category Streaming
+	unsubtle subs list -o csv
+	unsubtle calendar -days 60
+	unsubtle report -o json
+
+The password is read from UNSUBTLE_PASSWORD or, when that is not set, from the first line of standard input. The
+server URL and the tokens issued by login are stored in a config file, see -config, and renewed tokens are written
+back to it.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// usageError is returned for invalid invocations. main prints the usage of the command along with it.
+type usageError struct {
+	usage string
+	err   error
+}
+
+func (e usageError) Error() string {
+	return fmt.Sprintf("%s\n\nusage: %s", e.err, e.usage)
+}
+
+// cli holds the state shared by all commands of a single invocation.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	configPath string
+	format     outputFormat
+	// usage of the command being run
+	usage string
+}
+
+// command is a subcommand such as login or calendar. Commands with actions, like subs, dispatch on their first
+// argument themselves.
+type command struct {
+	usage   string
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"login":      {usage: "login [-url URL] -email EMAIL", summary: "log in and store the credentials", run: runLogin},
+	"logout":     {usage: "logout", summary: "revoke the refresh token and forget the credentials", run: runLogout},
+	"subs":       {usage: "subs list|add|edit|rm", summary: "manage subscriptions", run: runSubscriptions},
+	"cards":      {usage: "cards list|add|edit|rm", summary: "manage cards", run: runCards},
+	"categories": {usage: "categories list|add|edit|rm", summary: "manage categories", run: runCategories},
+	"calendar":   {usage: "calendar [-days N]", summary: "list upcoming charges of active subscriptions", run: runCalendar},
+	"report":     {usage: "report", summary: "summarize the monthly and yearly spend per category", run: runReport},
+}
+
+// run parses the global flags and runs the requested command. Like the server, all external dependencies are passed
+// in so that it can be called from tests.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) error {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, getenv: getenv}
+
+	fs := flag.NewFlagSet("unsubtle", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&c.configPath, "config", defaultConfigPath(getenv), "path of the config file holding the credentials")
+	fs.Var(&c.format, "o", "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		return usageError{usage: globalUsage(), err: err}
+	}
+
+	if fs.NArg() == 0 {
+		return usageError{usage: globalUsage(), err: errors.New("no command given")}
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return usageError{usage: globalUsage(), err: fmt.Errorf("unknown command %q", fs.Arg(0))}
+	}
+	c.usage = "unsubtle " + cmd.usage
+	return cmd.run(ctx, c, fs.Args()[1:])
+}
+
+func globalUsage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("unsubtle [-config FILE] [-o table|json|csv] COMMAND [ARGS]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-32s %s\n", commands[name].usage, commands[name].summary)
+	}
+	return b.String()
+}
+
+// newFlagSet returns a flag set for a command. Flags of commands may also be given after the global flags, e.g.
+// "unsubtle subs list -o json".
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var(&c.format, "o", "output format: table, json or csv")
+	return fs
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestUpcomingCharges(t *testing.T) {
+	netflix := database.Subscription{ID: uuid.New(), Name: "Netflix", MonthlyCost: 129, Currency: "SEK"}
+	card := database.Card{ID: uuid.New(), Name: "Visa"}
+	active := []database.ActiveSubscription{
+		{ID: uuid.New(), SubscriptionID: netflix.ID, CardID: card.ID, CreatedAt: date("2025-01-15"), BillingFrequency: "monthly"},
+		{ID: uuid.New(), SubscriptionID: netflix.ID, CardID: card.ID, CreatedAt: date("2025-01-15"), BillingFrequency: "sometimes"},
+	}
+
+	charges, skipped := upcomingCharges(
+		active,
+		map[uuid.UUID]database.Subscription{netflix.ID: netflix},
+		map[uuid.UUID]database.Card{card.ID: card},
+		date("2025-03-01"), date("2025-05-01"),
+	)
+
+	if len(charges) != 2 {
+		t.Fatalf("expected 2 charges, got %d", len(charges))
+	}
+	if got := charges[0].Date.Format(time.DateOnly); got != "2025-03-15" {
+		t.Errorf("expected the first charge on 2025-03-15, got %s", got)
+	}
+	if charges[1].Card != "Visa" || charges[1].Amount != 129 || charges[1].Currency != "SEK" {
+		t.Errorf("unexpected charge %+v", charges[1])
+	}
+	if len(skipped) != 1 || skipped[0].ID != active[1].ID {
+		t.Errorf("expected the subscription with an unknown frequency to be skipped, got %v", skipped)
+	}
+}
+
+func TestSummarizeSpend(t *testing.T) {
+	streaming := uuid.New()
+	subscriptions := map[uuid.UUID]database.Subscription{}
+	var active []database.ActiveSubscription
+	for _, s := range []database.Subscription{
+		{ID: uuid.New(), MonthlyCost: 100, Currency: "SEK", CategoryID: uuid.NullUUID{UUID: streaming, Valid: true}},
+		{ID: uuid.New(), MonthlyCost: 50, Currency: "SEK", CategoryID: uuid.NullUUID{UUID: streaming, Valid: true}},
+		{ID: uuid.New(), MonthlyCost: 10, Currency: "EUR"},
+	} {
+		subscriptions[s.ID] = s
+		active = append(active, database.ActiveSubscription{SubscriptionID: s.ID})
+	}
+
+	report := summarizeSpend(active, subscriptions, map[uuid.UUID]string{streaming: "Streaming"})
+
+	want := []spend{
+		{Category: uncategorized, Currency: "EUR", Subscriptions: 1, Monthly: 10, Yearly: 120},
+		{Category: "Streaming", Currency: "SEK", Subscriptions: 2, Monthly: 150, Yearly: 1800},
+	}
+	if len(report.Categories) != len(want) {
+		t.Fatalf("expected %d categories, got %v", len(want), report.Categories)
+	}
+	for i := range want {
+		if report.Categories[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], report.Categories[i])
+		}
+	}
+	if len(report.Totals) != 2 || report.Totals[1].Monthly != 150 {
+		t.Errorf("expected totals per currency, got %+v", report.Totals)
+	}
+}
+
+// newFakeAPI serves the login and the list endpoints used by TestRun.
+func newFakeAPI(t *testing.T, subscriptions []database.Subscription) *httptest.Server {
+	t.Helper()
+
+	token, err := auth.MakeJWT(uuid.New(), "secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respond := func(w http.ResponseWriter, content any) {
+		json.NewEncoder(w).Encode(map[string]any{"content": content, "status": http.StatusOK})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, map[string]string{"email": "cli@example.com", "token": token, "refresh_token": "refresh"})
+	})
+	mux.HandleFunc("GET /api/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		respond(w, subscriptions)
+	})
+	mux.HandleFunc("GET /api/categories", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, []database.Category{})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	subscription := database.Subscription{ID: uuid.New(), Name: "Netflix", MonthlyCost: 129, Currency: "SEK"}
+	api := newFakeAPI(t, []database.Subscription{subscription})
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	env := map[string]string{"UNSUBTLE_CONFIG": configPath}
+	getenv := func(key string) string { return env[key] }
+
+	t.Run("it requires a login", func(t *testing.T) {
+		err := run(ctx, []string{"subs", "list"}, nil, &bytes.Buffer{}, &bytes.Buffer{}, getenv)
+		if err == nil || !strings.Contains(err.Error(), "not logged in") {
+			t.Errorf("expected a not logged in error, got %v", err)
+		}
+	})
+
+	t.Run("it stores the credentials of a login", func(t *testing.T) {
+		stdin := strings.NewReader("Syp9393-Syp9292-Syp9191\n")
+		if err := run(ctx, []string{"login", "-url", api.URL, "-email", "cli@example.com"}, stdin, &bytes.Buffer{}, &bytes.Buffer{}, getenv); err != nil {
+			t.Fatalf("logging in: %s", err)
+		}
+
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.URL != api.URL || cfg.Email != "cli@example.com" || cfg.Token == "" || cfg.RefreshToken != "refresh" {
+			t.Errorf("unexpected config %+v", cfg)
+		}
+	})
+
+	t.Run("it prints subscriptions as csv", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if err := run(ctx, []string{"subs", "list", "-o", "csv"}, nil, &stdout, &bytes.Buffer{}, getenv); err != nil {
+			t.Fatalf("listing subscriptions: %s", err)
+		}
+
+		want := "ID,NAME,MONTHLY,CURRENCY,CATEGORY,DESCRIPTION\n" + subscription.ID.String() + ",Netflix,129,SEK,,\n"
+		if stdout.String() != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, stdout.String())
+		}
+	})
+
+	t.Run("it prints subscriptions as json", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if err := run(ctx, []string{"-o", "json", "subs", "list"}, nil, &stdout, &bytes.Buffer{}, getenv); err != nil {
+			t.Fatalf("listing subscriptions: %s", err)
+		}
+
+		var got []database.Subscription
+		if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+			t.Fatalf("decoding output: %s", err)
+		}
+		if len(got) != 1 || got[0].ID != subscription.ID {
+			t.Errorf("expected the subscription, got %+v", got)
+		}
+	})
+
+	t.Run("it rejects unknown commands and formats", func(t *testing.T) {
+		for _, args := range [][]string{{"subscribe"}, {"subs", "purge"}, {"-o", "xml", "subs", "list"}} {
+			if err := run(ctx, args, nil, &bytes.Buffer{}, &bytes.Buffer{}, getenv); err == nil {
+				t.Errorf("expected %v to fail", args)
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// outputFormat selects how results are printed. It implements flag.Value.
+type outputFormat string
+
+const (
+	formatTable outputFormat = "table"
+	formatJSON  outputFormat = "json"
+	formatCSV   outputFormat = "csv"
+)
+
+func (f *outputFormat) String() string {
+	if *f == "" {
+		return string(formatTable)
+	}
+	return string(*f)
+}
+
+func (f *outputFormat) Set(s string) error {
+	switch format := outputFormat(strings.ToLower(s)); format {
+	case formatTable, formatJSON, formatCSV:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, use table, json or csv", s)
+}
+
+// table is the tabular representation of a result, used by the table and csv formats.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+// print writes a result to w. The json format encodes v as is, the other formats render t.
+func (f outputFormat) print(w io.Writer, v any, t table) error {
+	switch f {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(t.header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(t.rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+	"github.com/google/uuid"
+)
+
+// uncategorized labels the spend of subscriptions without a category.
+const uncategorized = "(none)"
+
+// spend is the cost of the active subscriptions in one category and currency.
+type spend struct {
+	Category      string `json:"category"`
+	Currency      string `json:"currency"`
+	Subscriptions int    `json:"subscriptions"`
+	Monthly       int64  `json:"monthly"`
+	Yearly        int64  `json:"yearly"`
+}
+
+// spendReport is the result of the report command. Totals are per currency, amounts in different currencies are
+// never added up.
+type spendReport struct {
+	Categories []spend `json:"categories"`
+	Totals     []spend `json:"totals"`
+}
+
+// summarizeSpend groups the cost of the active subscriptions by category and currency, ordered by category name.
+func summarizeSpend(
+	active []database.ActiveSubscription,
+	subscriptions map[uuid.UUID]database.Subscription,
+	categories map[uuid.UUID]string,
+) spendReport {
+	type key struct{ category, currency string }
+	byCategory := map[key]*spend{}
+	totals := map[string]*spend{}
+
+	for _, a := range active {
+		subscription := subscriptions[a.SubscriptionID]
+		category := uncategorized
+		if subscription.CategoryID.Valid {
+			category = categories[subscription.CategoryID.UUID]
+		}
+
+		k := key{category, subscription.Currency}
+		if byCategory[k] == nil {
+			byCategory[k] = &spend{Category: category, Currency: subscription.Currency}
+		}
+		if totals[subscription.Currency] == nil {
+			totals[subscription.Currency] = &spend{Currency: subscription.Currency}
+		}
+		for _, s := range []*spend{byCategory[k], totals[subscription.Currency]} {
+			s.Subscriptions++
+			s.Monthly += int64(subscription.MonthlyCost)
+			s.Yearly += int64(subscription.MonthlyCost) * 12
+		}
+	}
+
+	var report spendReport
+	for _, s := range byCategory {
+		report.Categories = append(report.Categories, *s)
+	}
+	for _, s := range totals {
+		report.Totals = append(report.Totals, *s)
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		a, b := report.Categories[i], report.Categories[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.Currency < b.Currency
+	})
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+	return report
+}
+
+func runReport(ctx context.Context, c *cli, args []string) error {
+	if err := c.newFlagSet("report").Parse(args); err != nil {
+		return usageError{usage: c.usage, err: err}
+	}
+
+	return c.authenticated(ctx, func(client *unsubtle.Client) error {
+		active, subscriptions, _, err := loadActiveSubscriptions(ctx, client)
+		if err != nil {
+			return err
+		}
+		categories, err := categoryNames(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		report := summarizeSpend(active, subscriptions, categories)
+		t := table{header: []string{"CATEGORY", "CURRENCY", "SUBSCRIPTIONS", "MONTHLY", "YEARLY"}}
+		for _, s := range report.Categories {
+			t.add(s.Category, s.Currency, strconv.Itoa(s.Subscriptions), strconv.FormatInt(s.Monthly, 10), strconv.FormatInt(s.Yearly, 10))
+		}
+		for _, s := range report.Totals {
+			t.add("TOTAL", s.Currency, strconv.Itoa(s.Subscriptions), strconv.FormatInt(s.Monthly, 10), strconv.FormatInt(s.Yearly, 10))
+		}
+		return c.format.print(c.stdout, report, t)
+	})
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+	"github.com/google/uuid"
+)
+
+// action is one of list, add, edit and rm of a resource command.
+type action func(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error
+
+// dispatch runs the action named by the first argument of a resource command.
+func dispatch(ctx context.Context, c *cli, args []string, actions map[string]action) error {
+	if len(args) == 0 {
+		return usageError{usage: c.usage, err: errors.New("no action given")}
+	}
+	act, ok := actions[args[0]]
+	if !ok {
+		return usageError{usage: c.usage, err: fmt.Errorf("unknown action %q", args[0])}
+	}
+	return c.authenticated(ctx, func(client *unsubtle.Client) error {
+		return act(ctx, c, client, args[1:])
+	})
+}
+
+// parseID parses the ID that edit and rm expect as first argument, followed by flags.
+func parseID(fs *flag.FlagSet, args []string) (uuid.UUID, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return uuid.Nil, fmt.Errorf("%s requires an ID", fs.Name())
+	}
+	id, err := uuid.Parse(args[0])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid ID %q: %w", args[0], err)
+	}
+	return id, fs.Parse(args[1:])
+}
+
+// visited returns the names of the flags that were given on the command line.
+func visited(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// optional returns nil for an empty string flag.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullable converts an optional string flag into a merge patch value, an empty string clears the member.
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// -- Subscriptions
+
+func runSubscriptions(ctx context.Context, c *cli, args []string) error {
+	return dispatch(ctx, c, args, map[string]action{
+		"list": listSubscriptions,
+		"add":  addSubscription,
+		"edit": editSubscription,
+		"rm":   removeSubscription,
+	})
+}
+
+// subscriptionFlags are shared by subs add and subs edit.
+type subscriptionFlags struct {
+	name, currency, category, url, description string
+	cost                                       int
+}
+
+func (f *subscriptionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.name, "name", "", "name of the subscription")
+	fs.IntVar(&f.cost, "cost", 0, "monthly cost")
+	fs.StringVar(&f.currency, "currency", "", "currency of the cost, e.g. SEK")
+	fs.StringVar(&f.category, "category", "", "name or ID of the category")
+	fs.StringVar(&f.url, "url", "", "URL of the page to unsubscribe")
+	fs.StringVar(&f.description, "description", "", "description")
+}
+
+func listSubscriptions(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	if err := c.newFlagSet("list").Parse(args); err != nil {
+		return err
+	}
+	subscriptions, err := client.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	return c.printSubscriptions(ctx, client, subscriptions)
+}
+
+func addSubscription(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	var f subscriptionFlags
+	fs := c.newFlagSet("add")
+	f.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	categoryID, err := resolveCategory(ctx, client, f.category)
+	if err != nil {
+		return err
+	}
+	subscription, err := client.CreateSubscription(ctx, unsubtle.SubscriptionRequest{
+		Name:           f.name,
+		MonthlyCost:    int32(f.cost),
+		Currency:       f.currency,
+		UnsubscribeUrl: optional(f.url),
+		Description:    optional(f.description),
+		CategoryId:     categoryID,
+	})
+	if err != nil {
+		return err
+	}
+	return c.printSubscriptions(ctx, client, []database.Subscription{subscription})
+}
+
+func editSubscription(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	var f subscriptionFlags
+	fs := c.newFlagSet("edit")
+	f.register(fs)
+	id, err := parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	patch := unsubtle.Patch{}
+	for name := range visited(fs) {
+		switch name {
+		case "name":
+			patch["name"] = f.name
+		case "cost":
+			patch["monthly_cost"] = f.cost
+		case "currency":
+			patch["currency"] = f.currency
+		case "url":
+			patch["unsubscribe_url"] = nullable(f.url)
+		case "description":
+			patch["description"] = nullable(f.description)
+		case "category":
+			categoryID, err := resolveCategory(ctx, client, f.category)
+			if err != nil {
+				return err
+			}
+			patch["category_id"] = categoryID
+		}
+	}
+
+	current, err := client.GetSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	subscription, err := client.PatchSubscription(ctx, id, current.Version, patch)
+	if err != nil {
+		return err
+	}
+	return c.printSubscriptions(ctx, client, []database.Subscription{subscription})
+}
+
+func removeSubscription(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	id, err := parseID(c.newFlagSet("rm"), args)
+	if err != nil {
+		return err
+	}
+	current, err := client.GetSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	return client.DeleteSubscription(ctx, id, current.Version)
+}
+
+func (c *cli) printSubscriptions(ctx context.Context, client *unsubtle.Client, subscriptions []database.Subscription) error {
+	categories, err := categoryNames(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"ID", "NAME", "MONTHLY", "CURRENCY", "CATEGORY", "DESCRIPTION"}}
+	for _, s := range subscriptions {
+		category := ""
+		if s.CategoryID.Valid {
+			category = categories[s.CategoryID.UUID]
+		}
+		t.add(s.ID.String(), s.Name, strconv.Itoa(int(s.MonthlyCost)), s.Currency, category, s.Description.String)
+	}
+	return c.format.print(c.stdout, subscriptions, t)
+}
+
+// resolveCategory finds a category by ID or, case insensitively, by name. An empty value means no category.
+func resolveCategory(ctx context.Context, client *unsubtle.Client, nameOrID string) (uuid.NullUUID, error) {
+	if nameOrID == "" {
+		return uuid.NullUUID{}, nil
+	}
+	if id, err := uuid.Parse(nameOrID); err == nil {
+		return uuid.NullUUID{UUID: id, Valid: true}, nil
+	}
+
+	categories, err := client.ListCategories(ctx)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	for _, category := range categories {
+		if strings.EqualFold(category.Name, nameOrID) {
+			return uuid.NullUUID{UUID: category.ID, Valid: true}, nil
+		}
+	}
+	return uuid.NullUUID{}, fmt.Errorf("no category named %q, create it with: unsubtle categories add -name %q", nameOrID, nameOrID)
+}
+
+func categoryNames(ctx context.Context, client *unsubtle.Client) (map[uuid.UUID]string, error) {
+	categories, err := client.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uuid.UUID]string, len(categories))
+	for _, category := range categories {
+		names[category.ID] = category.Name
+	}
+	return names, nil
+}
+
+// -- Cards
+
+func runCards(ctx context.Context, c *cli, args []string) error {
+	return dispatch(ctx, c, args, map[string]action{
+		"list": listCards,
+		"add":  addCard,
+		"edit": editCard,
+		"rm":   removeCard,
+	})
+}
+
+// expiryDate is a flag.Value for the expiry date of a card. A month denotes the last day of that month, like the date
+// printed on a card.
+type expiryDate struct{ time.Time }
+
+func (d *expiryDate) String() string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(time.DateOnly)
+}
+
+func (d *expiryDate) Set(s string) error {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		d.Time = t
+		return nil
+	}
+	t, err := time.Parse("2006-01", s)
+	if err != nil {
+		return fmt.Errorf("use YYYY-MM-DD or YYYY-MM")
+	}
+	d.Time = t.AddDate(0, 1, -1)
+	return nil
+}
+
+func listCards(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	if err := c.newFlagSet("list").Parse(args); err != nil {
+		return err
+	}
+	cards, err := client.ListCards(ctx)
+	if err != nil {
+		return err
+	}
+	return c.printCards(cards)
+}
+
+func addCard(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	var expires expiryDate
+	fs := c.newFlagSet("add")
+	name := fs.String("name", "", "name of the card")
+	fs.Var(&expires, "expires", "expiry date, YYYY-MM-DD or YYYY-MM")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	created, err := client.CreateCard(ctx, unsubtle.CardRequest{Name: *name, ExpiresAt: expires.Time})
+	if err != nil {
+		return err
+	}
+	return c.printCards([]database.Card{{
+		ID:        created.ID,
+		Name:      created.Name,
+		Owner:     created.Owner,
+		CreatedAt: created.CreatedAt,
+		UpdatedAt: created.UpdatedAt,
+		ExpiresAt: created.ExpiresAt,
+		Version:   created.Version,
+	}})
+}
+
+func editCard(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	var expires expiryDate
+	fs := c.newFlagSet("edit")
+	name := fs.String("name", "", "name of the card")
+	fs.Var(&expires, "expires", "expiry date, YYYY-MM-DD or YYYY-MM")
+	id, err := parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	patch := unsubtle.Patch{}
+	set := visited(fs)
+	if set["name"] {
+		patch["name"] = *name
+	}
+	if set["expires"] {
+		patch["expires_at"] = expires.Time
+	}
+
+	current, err := client.GetCard(ctx, id)
+	if err != nil {
+		return err
+	}
+	card, err := client.PatchCard(ctx, id, current.Version, patch)
+	if err != nil {
+		return err
+	}
+	return c.printCards([]database.Card{card})
+}
+
+func removeCard(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	id, err := parseID(c.newFlagSet("rm"), args)
+	if err != nil {
+		return err
+	}
+	current, err := client.GetCard(ctx, id)
+	if err != nil {
+		return err
+	}
+	return client.DeleteCard(ctx, id, current.Version)
+}
+
+func (c *cli) printCards(cards []database.Card) error {
+	t := table{header: []string{"ID", "NAME", "EXPIRES"}}
+	for _, card := range cards {
+		t.add(card.ID.String(), card.Name, card.ExpiresAt.Format(time.DateOnly))
+	}
+	return c.format.print(c.stdout, cards, t)
+}
+
+// -- Categories
+
+func runCategories(ctx context.Context, c *cli, args []string) error {
+	return dispatch(ctx, c, args, map[string]action{
+		"list": listCategories,
+		"add":  addCategory,
+		"edit": editCategory,
+		"rm":   removeCategory,
+	})
+}
+
+func listCategories(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	if err := c.newFlagSet("list").Parse(args); err != nil {
+		return err
+	}
+	categories, err := client.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	return c.printCategories(categories)
+}
+
+func addCategory(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	fs := c.newFlagSet("add")
+	name := fs.String("name", "", "name of the category")
+	description := fs.String("description", "", "description")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	category, err := client.CreateCategory(ctx, unsubtle.CategoryRequest{Name: *name, Description: *description})
+	if err != nil {
+		return err
+	}
+	return c.printCategories([]database.Category{category})
+}
+
+func editCategory(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	fs := c.newFlagSet("edit")
+	name := fs.String("name", "", "name of the category")
+	description := fs.String("description", "", "description")
+	id, err := parseID(fs, args)
+	if err != nil {
+		return err
+	}
+
+	patch := unsubtle.Patch{}
+	set := visited(fs)
+	if set["name"] {
+		patch["name"] = *name
+	}
+	if set["description"] {
+		patch["description"] = *description
+	}
+
+	current, err := client.GetCategory(ctx, id)
+	if err != nil {
+		return err
+	}
+	category, err := client.PatchCategory(ctx, id, current.Version, patch)
+	if err != nil {
+		return err
+	}
+	return c.printCategories([]database.Category{category})
+}
+
+func removeCategory(ctx context.Context, c *cli, client *unsubtle.Client, args []string) error {
+	id, err := parseID(c.newFlagSet("rm"), args)
+	if err != nil {
+		return err
+	}
+	current, err := client.GetCategory(ctx, id)
+	if err != nil {
+		return err
+	}
+	return client.DeleteCategory(ctx, id, current.Version)
+}
+
+func (c *cli) printCategories(categories []database.Category) error {
+	t := table{header: []string{"ID", "NAME", "DESCRIPTION"}}
+	for _, category := range categories {
+		t.add(category.ID.String(), category.Name, category.Description)
+	}
+	return c.format.print(c.stdout, categories, t)
+}
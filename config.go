@@ -1,16 +1,145 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the optional settings, see also defaultHost and defaultPort.
+const (
+	defaultEnvironment          = "development"
+	defaultLogLevel             = slog.LevelInfo
+	defaultDBMaxOpenConns       = 25
+	defaultDBMaxIdleConns       = 5
+	defaultDBConnMaxLifetime    = 30 * time.Minute
+	defaultAccessTokenLifetime  = time.Hour
+	defaultRefreshTokenLifetime = 60 * 24 * time.Hour
+	defaultTracingEndpoint      = "localhost:4318"
+	defaultDrainDelay           = 5 * time.Second
+	defaultReadHeaderTimeout    = 5 * time.Second
+	defaultReadTimeout          = 15 * time.Second
+	defaultWriteTimeout         = 30 * time.Second
+	defaultIdleTimeout          = 2 * time.Minute
+	defaultMaxHeaderBytes       = 64 << 10
+	defaultMaxBodyBytes         = 1 << 20
+	defaultCORSMaxAge           = time.Hour
+	defaultRateLimitRequests    = 120
+	defaultRateLimitPeriod      = time.Minute
+	defaultAuthRateLimit        = 10
+	defaultAuthRateLimitPeriod  = time.Minute
 )
 
 type DatabaseConfig struct {
 	ConnectionString string
+	// Limits of the connection pool, see sql.DB. Zero means unlimited.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// Apply the embedded migrations before the server starts serving
+	MigrateOnStart bool
 }
 
 type ServiceConfig struct {
 	Host string
 	Port string
+	// DrainDelay is how long readiness fails before the server stops accepting connections on shutdown.
+	DrainDelay time.Duration
+	// Limits of a connection, see http.Server. ReadTimeout covers the headers and the body of a request, WriteTimeout
+	// the request from the end of its headers until the response is written.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// MaxBodyBytes is the largest request body that is read, larger ones are rejected with 413 Content Too Large.
+	MaxBodyBytes int
+}
+
+type AuthConfig struct {
+	// AccessTokenLifetime is how long a JWT issued by login or refresh is valid.
+	AccessTokenLifetime time.Duration
+	// RefreshTokenLifetime is how long a refresh token issued by login is valid.
+	RefreshTokenLifetime time.Duration
+	// BcryptCost is the cost of newly created password hashes. Existing hashes keep the cost they were created with.
+	BcryptCost int
+}
+
+type CORSConfig struct {
+	// AllowedOrigins are the origins that may call the API from a browser, "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders are what the requests of these origins may use.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// AllowCredentials lets the requests of these origins include cookies.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache the answer to a preflight request.
+	MaxAge time.Duration
+}
+
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// RedirectAddress of a plain HTTP listener that redirects to HTTPS, such as ":80". Empty disables it.
+	RedirectAddress string
+}
+
+type RateLimitConfig struct {
+	// Backend holding the buckets: "memory", "postgres" to share them between replicas, or "off".
+	Backend string
+	// Requests is how many requests a client can make in a burst, after which it is refilled with as many per Period.
+	Requests int
+	Period   time.Duration
+	// AuthRequests and AuthPeriod are the stricter limit of logging in, registering and refreshing tokens.
+	AuthRequests int
+	AuthPeriod   time.Duration
+	// ClientIPHeader is the header in which a proxy in front of the server passes the client IP, such as
+	// X-Forwarded-For. Without it, the address of the connection is used.
+	ClientIPHeader string
+}
+
+type AdminConfig struct {
+	// Address of the admin listener serving /metrics, such as "localhost:9090". Empty disables it.
+	Address string
+	// MetricsToken is the bearer token required by /metrics. Without an admin listener, /metrics is only served
+	// when it is set.
+	MetricsToken string
+	// ClientCAFile is a PEM file of the CAs that sign the client certificates required by the admin listener. The
+	// admin listener serves plain HTTP without it.
+	ClientCAFile string
+}
+
+type TracingConfig struct {
+	// Exporter of the spans: "stdout", "otlp" or empty to disable tracing.
+	Exporter string
+	// Endpoint of the OTLP/HTTP collector, as host and port.
+	Endpoint string
+}
+
+// Enabled reports whether spans are recorded and exported.
+func (tc TracingConfig) Enabled() bool {
+	return tc.Exporter != ""
+}
+
+type FrontendConfig struct {
+	// Directory containing html/ and static/ that is served instead of the embedded frontend
+	DevDir string
 }
 
 type Config struct {
@@ -18,8 +147,436 @@ type Config struct {
 	Service     *ServiceConfig
 	Environment string
     JWTSecret   string
+	Auth        *AuthConfig
+	CORS        *CORSConfig
+	RateLimit   *RateLimitConfig
+	TLS         *TLSConfig
+	Admin       *AdminConfig
+	Tracing     *TracingConfig
+	Frontend    *FrontendConfig
+	LogLevel    slog.Level
+	// Debug enables debug mode, which lowers the log level to debug.
+	Debug bool
 }
 
 func (sc ServiceConfig) Address() string {
 	return fmt.Sprintf("%s:%s", sc.Host, sc.Port)
 }
+
+// Enabled reports whether the server terminates TLS itself.
+func (tc TLSConfig) Enabled() bool {
+	return tc.CertFile != "" || tc.KeyFile != ""
+}
+
+// defaultConfig returns a configuration with the defaults of all optional settings.
+func defaultConfig() *Config {
+	return &Config{
+		Database: &DatabaseConfig{
+			MaxOpenConns:    defaultDBMaxOpenConns,
+			MaxIdleConns:    defaultDBMaxIdleConns,
+			ConnMaxLifetime: defaultDBConnMaxLifetime,
+		},
+		Service: &ServiceConfig{
+			Host:              defaultHost,
+			Port:              defaultPort,
+			DrainDelay:        defaultDrainDelay,
+			ReadHeaderTimeout: defaultReadHeaderTimeout,
+			ReadTimeout:       defaultReadTimeout,
+			WriteTimeout:      defaultWriteTimeout,
+			IdleTimeout:       defaultIdleTimeout,
+			MaxHeaderBytes:    defaultMaxHeaderBytes,
+			MaxBodyBytes:      defaultMaxBodyBytes,
+		},
+		Environment: defaultEnvironment,
+		Auth: &AuthConfig{
+			AccessTokenLifetime:  defaultAccessTokenLifetime,
+			RefreshTokenLifetime: defaultRefreshTokenLifetime,
+			BcryptCost:           auth.DefaultCost,
+		},
+		CORS: &CORSConfig{
+			AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", idempotencyKeyHeader, requestIdHeader, "traceparent"},
+			MaxAge:         defaultCORSMaxAge,
+		},
+		RateLimit: &RateLimitConfig{
+			Backend:      rateLimitBackendMemory,
+			Requests:     defaultRateLimitRequests,
+			Period:       defaultRateLimitPeriod,
+			AuthRequests: defaultAuthRateLimit,
+			AuthPeriod:   defaultAuthRateLimitPeriod,
+		},
+		TLS:      &TLSConfig{},
+		Admin:    &AdminConfig{},
+		Tracing:  &TracingConfig{Endpoint: defaultTracingEndpoint},
+		Frontend: &FrontendConfig{},
+		LogLevel: defaultLogLevel,
+	}
+}
+
+/*
+setting is a single configuration option. It can be set in the config file under key, with the environment variable
+env and with the command line flag flag. Secrets have no flag, since flags are visible in the process list.
+*/
+type setting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"environment", "ENVIRONMENT", "environment", "name of the environment, e.g. production", stringValue(func(c *Config) *string { return &c.Environment })},
+	{"log_level", "LOG_LEVEL", "log-level", "minimum level of log messages: debug, info, warn or error", logLevelValue},
+	{"debug", "DEBUG", "debug", "enable debug mode", boolValue(func(c *Config) *bool { return &c.Debug })},
+	{"jwt_secret", "JWT_SECRET", "", "secret used to sign JWTs", stringValue(func(c *Config) *string { return &c.JWTSecret })},
+	{"service.host", "SVC_HOST", "host", "host to listen on", stringValue(func(c *Config) *string { return &c.Service.Host })},
+	{"service.port", "SVC_PORT", "port", "port to listen on", stringValue(func(c *Config) *string { return &c.Service.Port })},
+	{"service.drain_delay", "SVC_DRAIN_DELAY", "drain-delay", "how long readiness fails on shutdown before connections are refused", durationValue(func(c *Config) *time.Duration { return &c.Service.DrainDelay })},
+	{"service.read_header_timeout", "SVC_READ_HEADER_TIMEOUT", "read-header-timeout", "time allowed to read the headers of a request", durationValue(func(c *Config) *time.Duration { return &c.Service.ReadHeaderTimeout })},
+	{"service.read_timeout", "SVC_READ_TIMEOUT", "read-timeout", "time allowed to read a whole request", durationValue(func(c *Config) *time.Duration { return &c.Service.ReadTimeout })},
+	{"service.write_timeout", "SVC_WRITE_TIMEOUT", "write-timeout", "time allowed to handle a request and write the response", durationValue(func(c *Config) *time.Duration { return &c.Service.WriteTimeout })},
+	{"service.idle_timeout", "SVC_IDLE_TIMEOUT", "idle-timeout", "how long idle keep-alive connections are kept open", durationValue(func(c *Config) *time.Duration { return &c.Service.IdleTimeout })},
+	{"service.max_header_bytes", "SVC_MAX_HEADER_BYTES", "max-header-bytes", "largest size of the headers of a request in bytes", intValue(func(c *Config) *int { return &c.Service.MaxHeaderBytes })},
+	{"service.max_body_bytes", "SVC_MAX_BODY_BYTES", "max-body-bytes", "largest size of the body of a request in bytes", intValue(func(c *Config) *int { return &c.Service.MaxBodyBytes })},
+	{"database.connection_string", "DB_CONNECTION_STRING", "", "Postgres connection string", stringValue(func(c *Config) *string { return &c.Database.ConnectionString })},
+	{"database.max_open_conns", "DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum number of open database connections, 0 is unlimited", intValue(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"database.max_idle_conns", "DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum number of idle database connections", intValue(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection, 0 is unlimited", durationValue(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+	{"database.migrate_on_start", "DB_MIGRATE_ON_START", "migrate", "apply pending database migrations on startup", boolValue(func(c *Config) *bool { return &c.Database.MigrateOnStart })},
+	{"auth.access_token_lifetime", "ACCESS_TOKEN_LIFETIME", "access-token-lifetime", "lifetime of issued JWTs", durationValue(func(c *Config) *time.Duration { return &c.Auth.AccessTokenLifetime })},
+	{"auth.refresh_token_lifetime", "REFRESH_TOKEN_LIFETIME", "refresh-token-lifetime", "lifetime of issued refresh tokens", durationValue(func(c *Config) *time.Duration { return &c.Auth.RefreshTokenLifetime })},
+	{"auth.bcrypt_cost", "BCRYPT_COST", "bcrypt-cost", "bcrypt cost of password hashes", intValue(func(c *Config) *int { return &c.Auth.BcryptCost })},
+	{"cors.allowed_origins", "CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins allowed to call the API from a browser", listValue(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"cors.allowed_methods", "CORS_ALLOWED_METHODS", "cors-allowed-methods", "comma separated methods allowed in cross-origin requests", listValue(func(c *Config) *[]string { return &c.CORS.AllowedMethods })},
+	{"cors.allowed_headers", "CORS_ALLOWED_HEADERS", "cors-allowed-headers", "comma separated headers allowed in cross-origin requests", listValue(func(c *Config) *[]string { return &c.CORS.AllowedHeaders })},
+	{"cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "allow cross-origin requests with cookies", boolValue(func(c *Config) *bool { return &c.CORS.AllowCredentials })},
+	{"cors.max_age", "CORS_MAX_AGE", "cors-max-age", "how long browsers may cache a preflight response", durationValue(func(c *Config) *time.Duration { return &c.CORS.MaxAge })},
+	{"ratelimit.backend", "RATE_LIMIT_BACKEND", "rate-limit-backend", "where the rate limits are kept: memory, postgres to share them between replicas, or off", stringValue(func(c *Config) *string { return &c.RateLimit.Backend })},
+	{"ratelimit.requests", "RATE_LIMIT_REQUESTS", "rate-limit-requests", "requests a client can make per rate-limit-period", intValue(func(c *Config) *int { return &c.RateLimit.Requests })},
+	{"ratelimit.period", "RATE_LIMIT_PERIOD", "rate-limit-period", "period of rate-limit-requests", durationValue(func(c *Config) *time.Duration { return &c.RateLimit.Period })},
+	{"ratelimit.auth_requests", "RATE_LIMIT_AUTH_REQUESTS", "rate-limit-auth-requests", "login, register and refresh requests a client can make per rate-limit-auth-period", intValue(func(c *Config) *int { return &c.RateLimit.AuthRequests })},
+	{"ratelimit.auth_period", "RATE_LIMIT_AUTH_PERIOD", "rate-limit-auth-period", "period of rate-limit-auth-requests", durationValue(func(c *Config) *time.Duration { return &c.RateLimit.AuthPeriod })},
+	{"ratelimit.client_ip_header", "RATE_LIMIT_CLIENT_IP_HEADER", "rate-limit-client-ip-header", "header in which a proxy passes the client IP, e.g. X-Forwarded-For", stringValue(func(c *Config) *string { return &c.RateLimit.ClientIPHeader })},
+	{"tls.cert_file", "TLS_CERT_FILE", "tls-cert-file", "PEM encoded certificate, enables TLS together with -tls-key-file", stringValue(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls.key_file", "TLS_KEY_FILE", "tls-key-file", "PEM encoded private key of the certificate", stringValue(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls.redirect_address", "TLS_REDIRECT_ADDRESS", "tls-redirect-address", "address of a listener redirecting HTTP to HTTPS, e.g. :80", stringValue(func(c *Config) *string { return &c.TLS.RedirectAddress })},
+	{"admin.address", "ADMIN_ADDRESS", "admin-address", "address of the admin listener serving /metrics, e.g. localhost:9090", stringValue(func(c *Config) *string { return &c.Admin.Address })},
+	{"admin.metrics_token", "METRICS_TOKEN", "", "bearer token required by /metrics", stringValue(func(c *Config) *string { return &c.Admin.MetricsToken })},
+	{"admin.client_ca_file", "ADMIN_CLIENT_CA_FILE", "admin-client-ca-file", "PEM encoded CA certificates, requires the admin listener's clients to present a certificate they signed", stringValue(func(c *Config) *string { return &c.Admin.ClientCAFile })},
+	{"tracing.exporter", "TRACING_EXPORTER", "tracing-exporter", "export OpenTelemetry traces to stdout or otlp, disabled when empty", stringValue(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"tracing.endpoint", "TRACING_ENDPOINT", "tracing-endpoint", "host and port of the OTLP/HTTP collector", stringValue(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"frontend.dev_dir", "FRONTEND_DEV_DIR", "frontend-dev-dir", "serve the frontend from this directory instead of the embedded files, for development", stringValue(func(c *Config) *string { return &c.Frontend.DevDir })},
+}
+
+func stringValue(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intValue(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolValue(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationValue(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 90m or 720h", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func listValue(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func logLevelValue(c *Config, value string) error {
+	if err := c.LogLevel.UnmarshalText([]byte(value)); err != nil {
+		return fmt.Errorf("%q is not a log level", value)
+	}
+	return nil
+}
+
+// settingFlag holds the raw value of a setting given on the command line. It is parsed by the setting once the
+// lower precedence sources have been applied.
+type settingFlag struct {
+	value   string
+	boolean bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(v string) error { f.value = v; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.boolean }
+
+/*
+loadConfig builds the configuration from, in order of precedence, the command line flags in args, the environment,
+the config file and the defaults. The config file is given with -config or CONFIG_FILE and may be YAML or TOML,
+depending on its extension. The settings are checked by validate, which is (*Config).validate for serve and
+(*Config).validateDatabase for the admin commands. All invalid settings are reported at once.
+
+The configuration flags are added to fs, which may already hold flags of the command being run. Arguments that are not
+flags are left in fs.Args().
+*/
+func loadConfig(fs *flag.FlagSet, args []string, getenv func(string) string, validate func(*Config) error) (*Config, error) {
+	configFile := fs.String("config", "", "path of a YAML or TOML config file (CONFIG_FILE)")
+	flagValues := map[string]*settingFlag{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.flag] = &settingFlag{boolean: s.key == "debug"}
+			fs.Var(flagValues[s.flag], s.flag, fmt.Sprintf("%s (%s)", s.usage, s.env))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	var errs []error
+
+	if *configFile == "" {
+		*configFile = getenv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		fileValues, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if value, ok := fileValues[s.key]; ok {
+				if err := s.set(cfg, value); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %w", *configFile, s.key, err))
+				}
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(cfg, flagValues[s.flag].value); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", s.flag, err))
+				}
+			}
+		}
+	})
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := validate(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Debug {
+		cfg.LogLevel = slog.LevelDebug
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads a YAML or TOML config file into a flat map of setting keys, such as "database.max_open_conns",
+// to their values. Keys that are not a setting are rejected to catch typos.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	document := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &document)
+	case ".toml":
+		err = toml.Unmarshal(data, &document)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", document, values)
+
+	known := map[string]bool{}
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("config file %s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// flatten converts the nested tables of a config file into dotted keys. Lists are joined with commas.
+func flatten(prefix string, value any, values map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flatten(key, child, values)
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		values[prefix] = strings.Join(items, ",")
+	default:
+		values[prefix] = fmt.Sprint(v)
+	}
+}
+
+// validateDatabase reports the missing or out of range settings the admin commands need: the database and the
+// bcrypt cost of the passwords they set.
+func (c *Config) validateDatabase() error {
+	var errs []error
+	if c.Database.ConnectionString == "" {
+		errs = append(errs, errors.New("DB_CONNECTION_STRING not set"))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("database pool settings cannot be negative"))
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("db-max-idle-conns (%d) cannot exceed db-max-open-conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns))
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	return errors.Join(errs...)
+}
+
+// validate reports all settings that are missing or out of range, as needed by serve.
+func (c *Config) validate() error {
+	errs := []error{c.validateDatabase()}
+	if c.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET not set"))
+	}
+	if port, err := strconv.Atoi(c.Service.Port); err != nil || port < 0 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q is not a valid port number", c.Service.Port))
+	}
+	if c.Service.DrainDelay < 0 {
+		errs = append(errs, errors.New("drain-delay cannot be negative"))
+	}
+	if c.Service.ReadHeaderTimeout <= 0 || c.Service.ReadTimeout <= 0 || c.Service.WriteTimeout <= 0 || c.Service.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("server timeouts must be positive"))
+	}
+	if c.Service.ReadHeaderTimeout > c.Service.ReadTimeout {
+		errs = append(errs, errors.New("read-header-timeout cannot exceed read-timeout"))
+	}
+	if c.Service.MaxHeaderBytes <= 0 || c.Service.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("max-header-bytes and max-body-bytes must be positive"))
+	}
+	if c.Auth.AccessTokenLifetime <= 0 || c.Auth.RefreshTokenLifetime <= 0 {
+		errs = append(errs, errors.New("token lifetimes must be positive"))
+	}
+	if c.Auth.RefreshTokenLifetime < c.Auth.AccessTokenLifetime {
+		errs = append(errs, errors.New("refresh-token-lifetime cannot be shorter than access-token-lifetime"))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("CORS origin %q must be \"*\" or a scheme and host, e.g. https://example.com", origin))
+		}
+	}
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors-allow-credentials cannot be combined with the origin \"*\", list the origins instead"))
+	}
+	for _, method := range c.CORS.AllowedMethods {
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			errs = append(errs, fmt.Errorf("CORS method %q must be one of GET, HEAD, POST, PUT, PATCH or DELETE", method))
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors-max-age cannot be negative"))
+	}
+	switch c.RateLimit.Backend {
+	case rateLimitBackendMemory, rateLimitBackendPostgres, rateLimitBackendOff:
+	default:
+		errs = append(errs, fmt.Errorf("rate-limit-backend %q must be %s, %s or %s", c.RateLimit.Backend, rateLimitBackendMemory, rateLimitBackendPostgres, rateLimitBackendOff))
+	}
+	if c.RateLimit.Requests <= 0 || c.RateLimit.Period <= 0 || c.RateLimit.AuthRequests <= 0 || c.RateLimit.AuthPeriod <= 0 {
+		errs = append(errs, errors.New("rate limits must be positive"))
+	}
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls-cert-file and tls-key-file must be set together"))
+	}
+	if c.TLS.RedirectAddress != "" {
+		if _, port, err := net.SplitHostPort(c.TLS.RedirectAddress); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("tls-redirect-address %q must be a host and port, e.g. :80", c.TLS.RedirectAddress))
+		}
+		if !c.TLS.Enabled() {
+			errs = append(errs, errors.New("tls-redirect-address requires tls-cert-file and tls-key-file"))
+		}
+	}
+	if c.Admin.Address != "" {
+		if _, port, err := net.SplitHostPort(c.Admin.Address); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("admin-address %q must be a host and port, e.g. localhost:9090", c.Admin.Address))
+		}
+	}
+	if c.Admin.ClientCAFile != "" && (c.Admin.Address == "" || !c.TLS.Enabled()) {
+		errs = append(errs, errors.New("admin-client-ca-file requires admin-address, tls-cert-file and tls-key-file"))
+	}
+	switch c.Tracing.Exporter {
+	case "", tracingExporterStdout, tracingExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing-exporter %q must be %s or %s", c.Tracing.Exporter, tracingExporterStdout, tracingExporterOTLP))
+	}
+	if c.Frontend.DevDir != "" {
+		if info, err := os.Stat(filepath.Join(c.Frontend.DevDir, "html")); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("frontend-dev-dir %q must contain the html and static directories", c.Frontend.DevDir))
+		}
+	}
+	return errors.Join(errs...)
+}
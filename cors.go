@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// -- CORS
+//
+// Browsers only let pages of another origin, such as a separate SPA, call the API when the responses allow it. With
+// cors.allowed_origins set, handleCORS answers the preflight requests of these origins itself, since the mux only
+// knows the methods of the routes and would answer OPTIONS with 405 Method Not Allowed, and marks the responses of
+// their actual requests as readable. Requests of other origins are served without CORS headers, which the browser
+// then refuses to expose.
+
+// corsExposedHeaders are the response headers besides the CORS-safelisted ones that cross-origin pages can read.
+var corsExposedHeaders = []string{
+	"ETag", "Location", idempotencyReplayedHeader, requestIdHeader, "traceparent",
+	"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+}
+
+/*
+handleCORS applies the CORS policy of config. Without allowed origins, CORS is disabled and browsers keep
+cross-origin pages from reading the responses.
+
+With credentials allowed, the origin of the request is echoed rather than "*", which browsers do not accept for
+requests with cookies.
+*/
+func handleCORS(next http.Handler, config *CORSConfig) http.Handler {
+	if len(config.AllowedOrigins) == 0 {
+		return next
+	}
+
+	anyOrigin := slices.Contains(config.AllowedOrigins, "*")
+	origins := map[string]bool{}
+	for _, origin := range config.AllowedOrigins {
+		origins[normalizeOrigin(origin)] = true
+	}
+	headers := map[string]bool{}
+	for _, header := range config.AllowedHeaders {
+		headers[http.CanonicalHeaderKey(header)] = true
+	}
+	allowMethods := strings.Join(config.AllowedMethods, ", ")
+	allowHeaders := strings.Join(config.AllowedHeaders, ", ")
+	exposeHeaders := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
+	// allowOrigin sets the origin the response may be read by, it reports false for origins that are not allowed
+	allowOrigin := func(header http.Header, origin string) bool {
+		if !anyOrigin && !origins[normalizeOrigin(origin)] {
+			return false
+		}
+		if anyOrigin && !config.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+			return true
+		}
+		header.Set("Access-Control-Allow-Origin", origin)
+		if config.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		return true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		header := w.Header()
+		// Responses differ by origin, caches must not serve one origin the response of another
+		header.Add("Vary", "Origin")
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestedMethod == "" {
+			if origin != "" && allowOrigin(header, origin) {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A preflight request: the browser asks whether the actual request may be sent. A preflight that is not
+		// allowed is answered without CORS headers, which makes the browser refuse the actual request.
+		header.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+		if origin != "" && slices.Contains(config.AllowedMethods, requestedMethod) && allowedHeaders(r, headers) && allowOrigin(header, origin) {
+			header.Set("Access-Control-Allow-Methods", allowMethods)
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowedHeaders reports whether every header named in the Access-Control-Request-Headers of a preflight request is
+// allowed.
+func allowedHeaders(r *http.Request, allowed map[string]bool) bool {
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !allowed[http.CanonicalHeaderKey(name)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizeOrigin makes origins comparable: the scheme and host are case-insensitive and the configuration may end
+// them with a slash.
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	const allowed = "https://app.example.com"
+
+	// serve sends a request from origin to a server allowing the origins
+	serve := func(t *testing.T, configure func(*CORSConfig), req *http.Request, origin string) *httptest.ResponseRecorder {
+		t.Helper()
+		config := newTestConfig()
+		config.CORS.AllowedOrigins = []string{allowed}
+		if configure != nil {
+			configure(config.CORS)
+		}
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		NewServerHandler(config, newMemoryStore(), newHealth()).ServeHTTP(rec, req)
+		return rec
+	}
+	preflight := func(method string, headers ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodOptions, "/api/register", nil)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if len(headers) > 0 {
+			req.Header.Set("Access-Control-Request-Headers", strings.Join(headers, ","))
+		}
+		return req
+	}
+
+	t.Run("it answers preflight requests of allowed origins", func(t *testing.T) {
+		rec := serve(t, nil, preflight(http.MethodPost, "content-type", "idempotency-key"), allowed)
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("expected status 204 rather than the 405 of the mux, got %d", rec.Code)
+		}
+		header := rec.Header()
+		if got := header.Get("Access-Control-Allow-Origin"); got != allowed {
+			t.Errorf("expected the origin to be allowed, got %q", got)
+		}
+		if got := header.Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPatch) {
+			t.Errorf("expected the allowed methods, got %q", got)
+		}
+		if got := header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, idempotencyKeyHeader) {
+			t.Errorf("expected the allowed headers, got %q", got)
+		}
+		if got := header.Get("Access-Control-Max-Age"); got != "3600" {
+			t.Errorf("expected the preflight to be cached for an hour, got %q", got)
+		}
+	})
+
+	t.Run("it refuses preflight requests that are not allowed", func(t *testing.T) {
+		tests := map[string]struct {
+			req    *http.Request
+			origin string
+		}{
+			"another origin":     {preflight(http.MethodPost), "https://evil.example.com"},
+			"another method":     {preflight("PROPFIND"), allowed},
+			"another header":     {preflight(http.MethodPost, "X-Forwarded-For"), allowed},
+			"a prefix of origin": {preflight(http.MethodPost), allowed + ".evil.example.com"},
+		}
+		for name, tt := range tests {
+			rec := serve(t, nil, tt.req, tt.origin)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+				t.Errorf("%s: expected no CORS headers, got Access-Control-Allow-Origin %q", name, got)
+			}
+		}
+	})
+
+	t.Run("it lets allowed origins read responses", func(t *testing.T) {
+		rec := serve(t, nil, httptest.NewRequest(http.MethodGet, "/healthz", nil), "https://APP.example.com")
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://APP.example.com" {
+			t.Errorf("expected the origin to be allowed, got %q", got)
+		}
+		if got := rec.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, "ETag") {
+			t.Errorf("expected the ETag to be exposed, got %q", got)
+		}
+		if got := rec.Header().Get("Vary"); !strings.Contains(got, "Origin") {
+			t.Errorf("expected the response to vary by origin, got %q", got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("expected no credentials by default, got %q", got)
+		}
+	})
+
+	t.Run("it allows any origin without credentials", func(t *testing.T) {
+		rec := serve(t, func(c *CORSConfig) { c.AllowedOrigins = []string{"*"} }, httptest.NewRequest(http.MethodGet, "/healthz", nil), "https://other.example.com")
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("expected any origin to be allowed, got %q", got)
+		}
+	})
+
+	t.Run("it allows credentials for cookie mode", func(t *testing.T) {
+		rec := serve(t, func(c *CORSConfig) { c.AllowCredentials = true }, preflight(http.MethodPost), allowed)
+		if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("expected credentials to be allowed, got %q", got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != allowed {
+			t.Errorf("expected the origin to be echoed, got %q", got)
+		}
+	})
+
+	t.Run("it is disabled without allowed origins", func(t *testing.T) {
+		rec := serve(t, func(c *CORSConfig) { c.AllowedOrigins = nil }, preflight(http.MethodPost), allowed)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" || rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected the mux to answer without CORS headers, got %d and %q", rec.Code, got)
+		}
+	})
+}
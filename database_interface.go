@@ -11,27 +11,34 @@ import (
 // dbQuerier is an interface that allows us to use dependency injection on handlers.
 // It's purpose is to enable self-contained unit testing. While this wont allow us to test queries, it does allow us to test the logic defined within a handler.
 type dbQuerier interface {
+	// WithTx runs fn as a unit of work, the queries fn runs through its dbQuerier are committed or rolled back together
+	WithTx(ctx context.Context, fn func(dbQuerier) error) error
+
 	// User interactions
 	GetUserById(context.Context, uuid.UUID) (database.User, error)
 	CreateUser(context.Context, database.CreateUserParams) (database.CreateUserRow, error)
 	GetUserByEmail(context.Context, string) (database.User, error)
 	DeleteUser(context.Context, uuid.UUID) (sql.Result, error)
+	ListUsers(context.Context) ([]database.ListUsersRow, error)
+	UpdateUser(context.Context, database.UpdateUserParams) (database.User, error)
+	SetUserAdmin(context.Context, database.SetUserAdminParams) (database.User, error)
 
 	// RefreshToken interactions
 	CreateRefreshToken(context.Context, database.CreateRefreshTokenParams) (database.RefreshToken, error)
 	UpdateRefreshToken(context.Context, database.UpdateRefreshTokenParams) (database.RefreshToken, error)
 	RevokeRefreshToken(context.Context, uuid.UUID) (database.RefreshToken, error)
 	GetRefreshToken(context.Context, uuid.UUID) (database.RefreshToken, error)
+	GetRefreshTokenByToken(context.Context, string) (database.RefreshToken, error)
 
 	// Subscription interactions
 	CreateSubscription(ctx context.Context, arg database.CreateSubscriptionParams) (database.Subscription, error)
-	DeleteSubscription(ctx context.Context, id uuid.UUID) (sql.Result, error)
+	DeleteSubscription(ctx context.Context, arg database.DeleteSubscriptionParams) (sql.Result, error)
 	GetSubscription(ctx context.Context, id uuid.UUID) (database.Subscription, error)
 	ListSubscriptions(ctx context.Context) ([]database.Subscription, error)
 	ListSubscriptionsForUserId(ctx context.Context, createdBy uuid.UUID) ([]database.Subscription, error)
 	ResetSubscriptions(ctx context.Context) ([]database.Subscription, error)
 	UpdateSubscription(ctx context.Context, arg database.UpdateSubscriptionParams) (database.Subscription, error)
-	GetSubscriptionByNameAndCreator(ctx context.Context, arg database.GetSubscriptionByNameAndCreatorParams) (database.Subscription, error)
+	UpsertSubscription(ctx context.Context, arg database.UpsertSubscriptionParams) (database.Subscription, error)
 
 	// Category interactions
 	UpdateCategory(context.Context, database.UpdateCategoryParams) (database.Category, error)
@@ -39,9 +46,9 @@ type dbQuerier interface {
 	ListCategories(context.Context) ([]database.Category, error)
 	GetCategory(context.Context, uuid.UUID) (database.Category, error)
 	CreateCategory(context.Context, database.CreateCategoryParams) (database.Category, error)
-	CheckExistingCategory(ctx context.Context, arg database.CheckExistingCategoryParams) (database.Category, error)
+	UpsertCategory(ctx context.Context, arg database.UpsertCategoryParams) (database.Category, error)
 	ListCategoriesForUserId(ctx context.Context, createdBy uuid.UUID) ([]database.Category, error)
-	DeleteCategory(ctx context.Context, id uuid.UUID) (sql.Result, error)
+	DeleteCategory(ctx context.Context, arg database.DeleteCategoryParams) (sql.Result, error)
 
 	// Card interactions
 	CreateCard(ctx context.Context, arg database.CreateCardParams) (database.CreateCardRow, error)
@@ -49,8 +56,8 @@ type dbQuerier interface {
 	GetCard(ctx context.Context, id uuid.UUID) (database.Card, error)
 	ListCards(ctx context.Context) ([]database.Card, error)
 	ListCardsForOwner(context.Context, uuid.UUID) ([]database.Card, error)
-	DeleteCard(ctx context.Context, id uuid.UUID) (sql.Result, error)
-	GetCardByName(ctx context.Context, params database.GetCardByNameParams) (database.Card, error)
+	DeleteCard(ctx context.Context, arg database.DeleteCardParams) (sql.Result, error)
+	UpsertCard(ctx context.Context, arg database.UpsertCardParams) (database.Card, error)
 
 	// ActiveTrails interactions
 
@@ -58,7 +65,26 @@ type dbQuerier interface {
 	ListActiveSubscriptionByUserId(ctx context.Context, userID uuid.UUID) ([]database.ActiveSubscription, error)
 	GetActiveSubscriptionById(ctx context.Context, id uuid.UUID) (database.ActiveSubscription, error)
 	UpdateActiveSubscription(ctx context.Context, arg database.UpdateActiveSubscriptionParams) (database.ActiveSubscription, error)
-	DeleteActiveSubscription(ctx context.Context, id uuid.UUID) (sql.Result, error)
+	DeleteActiveSubscription(ctx context.Context, arg database.DeleteActiveSubscriptionParams) (sql.Result, error)
 	CreateActiveSubscription(ctx context.Context, arg database.CreateActiveSubscriptionParams) (database.ActiveSubscription, error)
-	GetActiveSubscriptionByUserIdAndSubId(ctx context.Context, arg database.GetActiveSubscriptionByUserIdAndSubIdParams) (database.ActiveSubscription, error)
+	UpsertActiveSubscription(ctx context.Context, arg database.UpsertActiveSubscriptionParams) (database.ActiveSubscription, error)
+
+	// Search interactions
+	SearchSubscriptions(ctx context.Context, arg database.SearchSubscriptionsParams) ([]database.SearchSubscriptionsRow, error)
+	SearchCategories(ctx context.Context, arg database.SearchCategoriesParams) ([]database.SearchCategoriesRow, error)
+	SearchCards(ctx context.Context, arg database.SearchCardsParams) ([]database.SearchCardsRow, error)
+
+	// Idempotency key interactions
+	ReserveIdempotencyKey(ctx context.Context, arg database.ReserveIdempotencyKeyParams) (database.IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error
+	DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error
+
+	// Metrics
+	CountUsers(ctx context.Context) (int64, error)
+	CountActiveSubscriptions(ctx context.Context) (int64, error)
+
+	// Rate limits shared by the replicas
+	TakeRateLimitToken(ctx context.Context, arg database.TakeRateLimitTokenParams) (float64, error)
+	GetRateLimitTokens(ctx context.Context, arg database.GetRateLimitTokensParams) (float64, error)
 }
@@ -1,11 +1,180 @@
 package main
 
-import(
+import (
 	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
 )
 
 var (
-	ResponseFailureError = errors.New("failed to respond to client")
-	UnexpectedDbError = errors.New("failed to query database")
+	ResponseFailureError    = errors.New("failed to respond to client")
+	UnexpectedDbError       = errors.New("failed to query database")
 	MarhalResponseBodyError = errors.New("unable to marshal response body")
 )
+
+// ErrorCode is a stable, machine readable identifier of a DomainError.
+// Clients should branch on the code rather than on the human readable title or detail, which may change.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeValidationFailed     ErrorCode = "validation_failed"
+	CodeUnauthenticated      ErrorCode = "unauthenticated"
+	CodeInvalidCredentials   ErrorCode = "invalid_credentials"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeNotFound             ErrorCode = "not_found"
+	CodeConflict             ErrorCode = "conflict"
+	CodeInvalidEmail         ErrorCode = "invalid_email"
+	CodeInsecurePassword     ErrorCode = "insecure_password"
+	CodeEmailTaken           ErrorCode = "email_taken"
+	CodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	CodeRequestTooLarge      ErrorCode = "request_too_large"
+	CodePreconditionRequired ErrorCode = "precondition_required"
+	CodePreconditionFailed   ErrorCode = "precondition_failed"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeRequestInProgress    ErrorCode = "request_in_progress"
+	CodeRateLimited          ErrorCode = "rate_limited"
+	CodeInternal             ErrorCode = "internal"
+)
+
+// FieldCode is a stable, machine readable identifier of why a member of a request was rejected.
+type FieldCode string
+
+const (
+	FieldRequired      FieldCode = "required"
+	FieldNegative      FieldCode = "negative"
+	FieldInvalidURL    FieldCode = "invalid_url"
+	FieldInvalidNumber FieldCode = "invalid_number"
+	FieldInvalidID     FieldCode = "invalid_id"
+	FieldInvalidDate   FieldCode = "invalid_date"
+	FieldOutOfRange    FieldCode = "out_of_range"
+)
+
+// FieldError describes why a single member of a request was rejected.
+type FieldError struct {
+	Field   string    `json:"field"`
+	Code    FieldCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+/*
+DomainError is an error that can be reported to clients. Every DomainError maps onto an HTTP status code and an
+RFC 7807 problem document, see writeProblem. The package level DomainErrors below are templates: use WithDetail,
+WithFields and Wrap to derive an error for a specific occurrence. Derived errors still match their template with
+errors.Is, since DomainErrors are compared by code.
+*/
+type DomainError struct {
+	Code   ErrorCode
+	Status int
+	Title  string
+	Detail string
+	Fields []FieldError
+
+	// cause is the underlying error. It is logged but never exposed to clients.
+	cause error
+}
+
+func (e *DomainError) Error() string {
+	msg := e.Title
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Detail)
+	}
+	if e.cause != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.cause)
+	}
+	return msg
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is a DomainError with the same code.
+func (e *DomainError) Is(target error) bool {
+	t, ok := target.(*DomainError)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail returns a copy of e with a human readable explanation of this specific occurrence.
+func (e *DomainError) WithDetail(format string, args ...any) *DomainError {
+	derived := *e
+	derived.Detail = fmt.Sprintf(format, args...)
+	return &derived
+}
+
+// WithFields returns a copy of e that lists which members of the request were rejected.
+func (e *DomainError) WithFields(fields ...FieldError) *DomainError {
+	derived := *e
+	derived.Fields = append(append([]FieldError{}, e.Fields...), fields...)
+	return &derived
+}
+
+// Wrap returns a copy of e that records cause for logging purposes.
+func (e *DomainError) Wrap(cause error) *DomainError {
+	derived := *e
+	derived.cause = cause
+	return &derived
+}
+
+// Domain errors
+var (
+	// Generic errors
+	InvalidRequestError       = &DomainError{Code: CodeInvalidRequest, Status: http.StatusBadRequest, Title: "Invalid request"}
+	ValidationFailedError     = &DomainError{Code: CodeValidationFailed, Status: http.StatusBadRequest, Title: "Validation failed"}
+	NotFoundError             = &DomainError{Code: CodeNotFound, Status: http.StatusNotFound, Title: "Resource not found"}
+	ConflictError             = &DomainError{Code: CodeConflict, Status: http.StatusConflict, Title: "Resource already exists"}
+	UnsupportedMediaTypeError = &DomainError{Code: CodeUnsupportedMediaType, Status: http.StatusUnsupportedMediaType, Title: "Unsupported media type"}
+	RequestTooLargeError      = &DomainError{Code: CodeRequestTooLarge, Status: http.StatusRequestEntityTooLarge, Title: "Request body is too large"}
+	InternalError             = &DomainError{Code: CodeInternal, Status: http.StatusInternalServerError, Title: "Internal server error"}
+
+	// Authentication and authorization errors
+	UnauthenticatedError    = &DomainError{Code: CodeUnauthenticated, Status: http.StatusUnauthorized, Title: "Authentication required"}
+	InvalidCredentialsError = &DomainError{Code: CodeInvalidCredentials, Status: http.StatusUnauthorized, Title: "Invalid email or password"}
+	ForbiddenError          = &DomainError{Code: CodeForbidden, Status: http.StatusForbidden, Title: "Access to the resource is forbidden"}
+
+	// User registration errors
+	InvalidEmailError     = &DomainError{Code: CodeInvalidEmail, Status: http.StatusBadRequest, Title: "Invalid email"}
+	InsecurePasswordError = &DomainError{Code: CodeInsecurePassword, Status: http.StatusBadRequest, Title: "Insecure password"}
+	EmailTakenError       = &DomainError{Code: CodeEmailTaken, Status: http.StatusConflict, Title: "Email is already registered"}
+
+	// Optimistic concurrency errors
+	PreconditionRequiredError = &DomainError{Code: CodePreconditionRequired, Status: http.StatusPreconditionRequired, Title: "The If-Match header is required for this request"}
+	PreconditionFailedError   = &DomainError{Code: CodePreconditionFailed, Status: http.StatusPreconditionFailed, Title: "The resource has been modified, fetch the latest version and try again"}
+
+	// Idempotency errors
+	IdempotencyKeyReusedError = &DomainError{Code: CodeIdempotencyKeyReused, Status: http.StatusUnprocessableEntity, Title: "Idempotency key was already used for a different request"}
+	RequestInProgressError    = &DomainError{Code: CodeRequestInProgress, Status: http.StatusConflict, Title: "A request with this idempotency key is still being processed"}
+
+	// Rate limiting errors
+	TooManyRequestsError = &DomainError{Code: CodeRateLimited, Status: http.StatusTooManyRequests, Title: "Too many requests"}
+)
+
+// codeUniqueViolation is the SQLSTATE Postgres reports when a write violates a unique constraint.
+const codeUniqueViolation pq.ErrorCode = "23505"
+
+/*
+uniqueConstraints maps the unique constraints of the schema onto the errors reported when a write violates them.
+Uniqueness is only checked by the database, so that concurrent requests cannot both create a resource with the same
+name. Violations of constraints that are not listed here are reported as ConflictError.
+*/
+var uniqueConstraints = map[string]*DomainError{
+	"users_email_key":                                  EmailTakenError,
+	"subscriptions_created_by_name_key":                ConflictError.WithDetail("subscription is already registered"),
+	"categories_created_by_name_key":                   ConflictError.WithDetail("category already exists"),
+	"cards_owner_name_key":                             ConflictError.WithDetail("card is already registered"),
+	"active_subscriptions_user_id_subscription_id_key": ConflictError.WithDetail("subscription is already active"),
+}
+
+// conflictError returns the DomainError for the unique constraint err violates, if it violates one.
+func conflictError(err error) (*DomainError, bool) {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != codeUniqueViolation {
+		return nil, false
+	}
+	if conflict, ok := uniqueConstraints[pqErr.Constraint]; ok {
+		return conflict.Wrap(err), true
+	}
+	return ConflictError.Wrap(err), true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Optimistic concurrency control
+//
+// Every versioned resource carries a version column that is incremented on each update. The version is exposed to
+// clients as a strong ETag, and PUT, PATCH and DELETE requests must echo it back in an If-Match header. The version
+// taken from If-Match is also part of the WHERE clause of the UPDATE/DELETE statement, so a concurrent write that
+// slips in between reading and writing a row still results in 412 Precondition Failed instead of a lost update.
+
+// versionETag formats a resource version as a strong entity tag.
+func versionETag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+/*
+collectionETag returns a weak entity tag for a list of resources. The tag is derived from the version of each entry
+(as formatted by key), so it changes whenever an entry is added, removed or updated.
+*/
+func collectionETag[T any](entries []T, key func(T) string) string {
+	hash := sha256.New()
+	for _, entry := range entries {
+		hash.Write([]byte(key(entry)))
+		hash.Write([]byte{'\n'})
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
+
+// entityTags splits the value of an If-Match or If-None-Match header into its entity tags.
+func entityTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+/*
+checkIfMatch validates the If-Match header of a state changing request against the current version of a resource.
+It returns the version that should be used in the conditional UPDATE or DELETE statement. A missing header results in
+PreconditionRequiredError and a header that does not contain the current ETag results in PreconditionFailedError.
+As mandated by RFC 9110 only strong comparison is used, weak tags never match.
+*/
+func checkIfMatch(r *http.Request, currentVersion int32) (int32, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, PreconditionRequiredError
+	}
+
+	current := versionETag(currentVersion)
+	for _, tag := range entityTags(header) {
+		if tag == "*" || tag == current {
+			return currentVersion, nil
+		}
+	}
+	return 0, PreconditionFailedError
+}
+
+/*
+versionCheck checks the version of a resource a client expects against its current version, and returns the version
+that should be used in the conditional UPDATE or DELETE statement. The services run it once they have read the
+resource, in the transaction of the write.
+*/
+type versionCheck func(currentVersion int32) (int32, error)
+
+// ifMatch checks the version against the If-Match header of r, see checkIfMatch.
+func ifMatch(r *http.Request) versionCheck {
+	return func(currentVersion int32) (int32, error) {
+		return checkIfMatch(r, currentVersion)
+	}
+}
+
+// expectVersion checks the version against the version a form was rendered with.
+func expectVersion(version int32) versionCheck {
+	return func(currentVersion int32) (int32, error) {
+		if currentVersion != version {
+			return 0, PreconditionFailedError
+		}
+		return version, nil
+	}
+}
+
+/*
+notModified reports whether the If-None-Match header of a read request matches etag, in which case the client's cached
+copy is still fresh and a 304 Not Modified should be returned. Weak comparison is used as mandated by RFC 9110.
+*/
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range entityTags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/frontend"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
+	"github.com/google/uuid"
+)
+
+// -- HTMX fragments of the dashboard
+//
+// The handlers below render the panels of the dashboard and their create and edit forms as HTML fragments. Submitted
+// forms are converted into the request types of the JSON API and saved by the same services, so the dashboard
+// accepts exactly the data the API accepts. Errors are translated by renderRejectedForm and
+// renderFragmentError, the HTML counterparts of writeProblem.
+
+// dateLayout is the format of <input type="date"> values.
+const dateLayout = "2006-01-02"
+
+// fragmentUser returns the authenticated user, or renders an alert when there is none.
+func fragmentUser(w http.ResponseWriter, r *http.Request, assets *frontend.Assets) (uuid.UUID, bool) {
+	userId := GetUserId(r.Context())
+	if userId == nil {
+		renderFragmentError(w, r, assets, UnauthenticatedError)
+		return uuid.UUID{}, false
+	}
+	return *userId, true
+}
+
+// errorAlert returns the alert of a DomainError: its first rejected field, otherwise its code. The English titles and
+// details of DomainErrors are left out, the frontend translates the codes instead.
+func errorAlert(domainErr *DomainError) *frontend.Alert {
+	if len(domainErr.Fields) > 0 {
+		return frontend.FieldAlert(domainErr.Fields[0].Field, string(domainErr.Fields[0].Code))
+	}
+	return frontend.ErrorAlert("error." + string(domainErr.Code))
+}
+
+// renderFragmentError renders err as an alert, with the status code writeProblem would use.
+func renderFragmentError(w http.ResponseWriter, r *http.Request, assets *frontend.Assets, err error) {
+	domainErr := asDomainError(err)
+	if domainErr.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "error", err, "code", domainErr.Code)
+	}
+	assets.RenderFragment(w, r, domainErr.Status, "alert", errorAlert(domainErr))
+}
+
+// renderRejectedForm renders the form again after err rejected it. Rejected fields are shown next to their inputs,
+// any other error as an alert above the form.
+func renderRejectedForm(w http.ResponseWriter, r *http.Request, assets *frontend.Assets, name string, form *frontend.Form, err error) {
+	domainErr := asDomainError(err)
+	if domainErr.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "error", err, "code", domainErr.Code)
+	}
+
+	if len(domainErr.Fields) > 0 {
+		form.Errors = make(map[string]string, len(domainErr.Fields))
+		for _, field := range domainErr.Fields {
+			form.Errors[field.Field] = string(field.Code)
+		}
+	} else {
+		form.Alert = errorAlert(domainErr)
+	}
+	assets.RenderFragment(w, r, domainErr.Status, name, form)
+}
+
+// submittedForm parses the body of a form submission. The form is being edited when the route has an id.
+func submittedForm(r *http.Request, collection string) (*frontend.Form, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, bodyError(err, "invalid form data")
+	}
+
+	form := &frontend.Form{Action: collection, Values: map[string]string{}}
+	for name := range r.PostForm {
+		form.Values[name] = r.PostForm.Get(name)
+	}
+	if id := r.PathValue("id"); id != "" {
+		form.Action = collection + "/" + id
+		form.Editing = true
+		version, _ := strconv.ParseInt(r.PostForm.Get("version"), 10, 32)
+		form.Version = int32(version)
+	}
+	return form, nil
+}
+
+// pathId parses the id of the resource in the route.
+func pathId(r *http.Request) (uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return uuid.UUID{}, InvalidRequestError.WithDetail("invalid id")
+	}
+	return id, nil
+}
+
+// withParseErrors adds the form fields that could not be parsed to the fields rejected by validate.
+func withParseErrors(errs validationErrors, err error) error {
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		errs = append(errs, domainErr.Fields...)
+	}
+	return errs.err()
+}
+
+// nullableValue returns an empty string for a NULL column.
+func nullableValue(s sql.NullString) string {
+	if !s.Valid {
+		return ""
+	}
+	return s.String
+}
+
+// nullableForm returns NULL for an empty form value.
+func nullableForm(form url.Values, name string) nullString {
+	value := strings.TrimSpace(form.Get(name))
+	return nullString{sql.NullString{String: value, Valid: value != ""}}
+}
+
+// -- Subscriptions
+
+func subscriptionRequestFromForm(form url.Values) (subscriptionRequest, error) {
+	var errs validationErrors
+	req := subscriptionRequest{
+		Name:           form.Get("name"),
+		Currency:       form.Get("currency"),
+		UnsubscribeUrl: nullableForm(form, "unsubscribe_url"),
+		Description:    nullableForm(form, "description"),
+	}
+	if value := strings.TrimSpace(form.Get("monthly_cost")); value != "" {
+		cost, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			errs.add("monthly_cost", FieldInvalidNumber, "monthly_cost must be a whole number")
+		}
+		req.MonthlyCost = int32(cost)
+	}
+	if value := form.Get("category_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			errs.add("category_id", FieldInvalidID, "category_id is not a valid id")
+		}
+		req.CategoryId = uuid.NullUUID{UUID: id, Valid: err == nil}
+	}
+	return req, withParseErrors(errs, req.validate())
+}
+
+func subscriptionFormValues(s database.Subscription) map[string]string {
+	values := map[string]string{
+		"name":            s.Name,
+		"monthly_cost":    strconv.Itoa(int(s.MonthlyCost)),
+		"currency":        s.Currency,
+		"unsubscribe_url": nullableValue(s.UnsubscribeUrl),
+		"description":     nullableValue(s.Description),
+	}
+	if s.CategoryID.Valid {
+		values["category_id"] = s.CategoryID.UUID.String()
+	}
+	return values
+}
+
+func renderSubscriptions(w http.ResponseWriter, r *http.Request, db dbQuerier, assets *frontend.Assets, userId uuid.UUID, status int, alert *frontend.Alert) {
+	subscriptions, err := newSubscriptionService(db).List(r.Context(), userId)
+	if err != nil {
+		renderFragmentError(w, r, assets, err)
+		return
+	}
+	names, err := categoryNamesForUser(r.Context(), db, userId)
+	if err != nil {
+		renderFragmentError(w, r, assets, err)
+		return
+	}
+	assets.RenderFragment(w, r, status, "subscriptions_table", frontend.SubscriptionsView{
+		Subscriptions: subscriptions,
+		CategoryNames: names,
+		Alert:         alert,
+	})
+}
+
+func handleSubscriptionsFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		renderSubscriptions(w, r, db, assets, userId, http.StatusOK, nil)
+	})
+}
+
+// handleSubscriptionFormFragment renders the form for a new subscription, or for editing the subscription in the route.
+func handleSubscriptionFormFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	subscriptions, categories := newSubscriptionService(db), newCategoryService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+
+		userCategories, err := categories.List(r.Context(), userId)
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		form := &frontend.Form{Action: "/ui/subscriptions", Values: map[string]string{}, Categories: userCategories}
+
+		if r.PathValue("id") != "" {
+			id, err := pathId(r)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			subscription, err := subscriptions.Get(r.Context(), userId, id)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			form.Action = "/ui/subscriptions/" + id.String()
+			form.Editing = true
+			form.Version = subscription.Version
+			form.Values = subscriptionFormValues(subscription)
+		}
+		assets.RenderFragment(w, r, http.StatusOK, "subscription_form", form)
+	})
+}
+
+// handleSubmitSubscriptionForm creates a subscription, or updates the subscription in the route, and renders the
+// subscriptions table. A rejected form is rendered again with its errors.
+func handleSubmitSubscriptionForm(db dbQuerier, assets *frontend.Assets) http.Handler {
+	subscriptions, categories := newSubscriptionService(db), newCategoryService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		form, err := submittedForm(r, "/ui/subscriptions")
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+		if form.Categories, err = categories.List(r.Context(), userId); err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+
+		req, err := subscriptionRequestFromForm(r.PostForm)
+		if err == nil {
+			if form.Editing {
+				var id uuid.UUID
+				if id, err = pathId(r); err == nil {
+					_, err = subscriptions.Update(r.Context(), userId, id, expectVersion(form.Version), replace[database.Subscription](req))
+				}
+			} else {
+				_, err = subscriptions.Create(r.Context(), userId, req)
+			}
+		}
+		if err != nil {
+			renderRejectedForm(w, r, assets, "subscription_form", form, err)
+			return
+		}
+
+		if form.Editing {
+			renderSubscriptions(w, r, db, assets, userId, http.StatusOK, frontend.SubscriptionSaved)
+			return
+		}
+		renderSubscriptions(w, r, db, assets, userId, http.StatusCreated, frontend.SubscriptionCreated)
+	})
+}
+
+// -- Categories
+
+func categoryRequestFromForm(form url.Values) (categoryRequest, error) {
+	req := categoryRequest{
+		Name:        form.Get("name"),
+		Description: form.Get("description"),
+	}
+	return req, req.validate()
+}
+
+func renderCategories(w http.ResponseWriter, r *http.Request, db dbQuerier, assets *frontend.Assets, userId uuid.UUID, status int, alert *frontend.Alert) {
+	categories, err := newCategoryService(db).List(r.Context(), userId)
+	if err != nil {
+		renderFragmentError(w, r, assets, err)
+		return
+	}
+	assets.RenderFragment(w, r, status, "category_list", frontend.CategoriesView{Categories: categories, Alert: alert})
+}
+
+func handleCategoriesFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		renderCategories(w, r, db, assets, userId, http.StatusOK, nil)
+	})
+}
+
+// handleCategoryFormFragment renders the form for a new category, or for editing the category in the route.
+func handleCategoryFormFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	categories := newCategoryService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		form := &frontend.Form{Action: "/ui/categories", Values: map[string]string{}}
+
+		if r.PathValue("id") != "" {
+			id, err := pathId(r)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			category, err := categories.Get(r.Context(), userId, id)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			form.Action = "/ui/categories/" + id.String()
+			form.Editing = true
+			form.Version = category.Version
+			form.Values = map[string]string{"name": category.Name, "description": category.Description}
+		}
+		assets.RenderFragment(w, r, http.StatusOK, "category_form", form)
+	})
+}
+
+// handleSubmitCategoryForm creates a category, or updates the category in the route, and renders the category list.
+// A rejected form is rendered again with its errors.
+func handleSubmitCategoryForm(db dbQuerier, assets *frontend.Assets) http.Handler {
+	categories := newCategoryService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		form, err := submittedForm(r, "/ui/categories")
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+
+		req, err := categoryRequestFromForm(r.PostForm)
+		if err == nil {
+			if form.Editing {
+				var id uuid.UUID
+				if id, err = pathId(r); err == nil {
+					_, err = categories.Update(r.Context(), userId, id, expectVersion(form.Version), replace[database.Category](req))
+				}
+			} else {
+				_, err = categories.Create(r.Context(), userId, req)
+			}
+		}
+		if err != nil {
+			renderRejectedForm(w, r, assets, "category_form", form, err)
+			return
+		}
+
+		if form.Editing {
+			renderCategories(w, r, db, assets, userId, http.StatusOK, frontend.CategorySaved)
+			return
+		}
+		renderCategories(w, r, db, assets, userId, http.StatusCreated, frontend.CategoryCreated)
+	})
+}
+
+// -- Cards
+
+func cardRequestFromForm(form url.Values) (cardRequest, error) {
+	var errs validationErrors
+	req := cardRequest{Name: form.Get("name")}
+	if value := strings.TrimSpace(form.Get("expires_at")); value != "" {
+		expiresAt, err := time.Parse(dateLayout, value)
+		if err != nil {
+			errs.add("expires_at", FieldInvalidDate, "expires_at must be a date")
+		}
+		req.ExpiresAt = expiresAt
+	}
+	err := req.validate()
+	if len(errs) > 0 {
+		// A date that could not be parsed is reported once, not as missing as well
+		return req, errs.err()
+	}
+	return req, err
+}
+
+func renderCards(w http.ResponseWriter, r *http.Request, db dbQuerier, assets *frontend.Assets, userId uuid.UUID, status int, alert *frontend.Alert) {
+	cards, err := newCardService(db).List(r.Context(), userId)
+	if err != nil {
+		renderFragmentError(w, r, assets, err)
+		return
+	}
+	assets.RenderFragment(w, r, status, "card_list", frontend.CardsView{Cards: cards, Alert: alert})
+}
+
+func handleCardsFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		renderCards(w, r, db, assets, userId, http.StatusOK, nil)
+	})
+}
+
+// handleCardFormFragment renders the form for a new card, or for editing the card in the route.
+func handleCardFormFragment(db dbQuerier, assets *frontend.Assets) http.Handler {
+	cards := newCardService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		form := &frontend.Form{Action: "/ui/cards", Values: map[string]string{}}
+
+		if r.PathValue("id") != "" {
+			id, err := pathId(r)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			card, err := cards.Get(r.Context(), userId, id)
+			if err != nil {
+				renderFragmentError(w, r, assets, err)
+				return
+			}
+			form.Action = "/ui/cards/" + id.String()
+			form.Editing = true
+			form.Version = card.Version
+			form.Values = map[string]string{"name": card.Name, "expires_at": card.ExpiresAt.Format(dateLayout)}
+		}
+		assets.RenderFragment(w, r, http.StatusOK, "card_form", form)
+	})
+}
+
+// handleSubmitCardForm creates a card, or updates the card in the route, and renders the card list. A rejected form
+// is rendered again with its errors.
+func handleSubmitCardForm(db dbQuerier, assets *frontend.Assets) http.Handler {
+	cards := newCardService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, ok := fragmentUser(w, r, assets)
+		if !ok {
+			return
+		}
+		form, err := submittedForm(r, "/ui/cards")
+		if err != nil {
+			renderFragmentError(w, r, assets, err)
+			return
+		}
+
+		req, err := cardRequestFromForm(r.PostForm)
+		if err == nil {
+			if form.Editing {
+				var id uuid.UUID
+				if id, err = pathId(r); err == nil {
+					_, err = cards.Update(r.Context(), userId, id, expectVersion(form.Version), replace[database.Card](req))
+				}
+			} else {
+				_, err = cards.Create(r.Context(), userId, req)
+			}
+		}
+		if err != nil {
+			renderRejectedForm(w, r, assets, "card_form", form, err)
+			return
+		}
+
+		if form.Editing {
+			renderCards(w, r, db, assets, userId, http.StatusOK, frontend.CardSaved)
+			return
+		}
+		renderCards(w, r, db, assets, userId, http.StatusCreated, frontend.CardCreated)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestDashboardFragments(t *testing.T) {
+	handler, store, user, token := newAuthenticatedServer(t, newTestConfig())
+
+	send := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	expect := func(rec *httptest.ResponseRecorder, status int, contains ...string) {
+		t.Helper()
+		if rec.Code != status {
+			t.Errorf("expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+			t.Errorf("expected an HTML fragment, got %s", got)
+		}
+		for _, want := range contains {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("expected the fragment to contain %q, got %s", want, rec.Body.String())
+			}
+		}
+	}
+
+	t.Run("it requires authentication", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui/subscriptions", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it renders empty panels", func(t *testing.T) {
+		expect(send(http.MethodGet, "/ui/subscriptions", nil), http.StatusOK, "No subscriptions yet")
+		expect(send(http.MethodGet, "/ui/categories", nil), http.StatusOK, "No categories yet")
+		expect(send(http.MethodGet, "/ui/cards", nil), http.StatusOK, "No cards yet")
+	})
+
+	t.Run("it renders in the language of the request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ui/subscriptions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept-Language", "sv-SE, en;q=0.5")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		expect(rec, http.StatusOK, "Inga prenumerationer än", "Ny prenumeration")
+	})
+
+	t.Run("it rejects forms with the validation of the JSON API", func(t *testing.T) {
+		rec := send(http.MethodPost, "/ui/subscriptions", url.Values{"name": {" "}, "monthly_cost": {"-5"}, "unsubscribe_url": {"not a url"}})
+		expect(rec, http.StatusBadRequest, "Name is required", "Monthly cost cannot be negative", "Currency is required", "Unsubscribe URL is not a valid URL")
+
+		rec = send(http.MethodPost, "/ui/cards", url.Values{"name": {"Visa"}, "expires_at": {"next year"}})
+		expect(rec, http.StatusBadRequest, "Expiry date is not a valid date", `value="Visa"`)
+	})
+
+	var category database.Category
+	t.Run("it creates a category and lists it", func(t *testing.T) {
+		expect(send(http.MethodGet, "/ui/categories/new", nil), http.StatusOK, `hx-post="/ui/categories"`)
+		expect(send(http.MethodPost, "/ui/categories", url.Values{"name": {"Streaming"}}), http.StatusCreated, "Category created", "Streaming")
+		expect(send(http.MethodPost, "/ui/categories", url.Values{"name": {"Streaming"}}), http.StatusConflict, "It already exists")
+
+		categories, _ := store.ListCategoriesForUserId(context.Background(), user.ID)
+		category = categories[0]
+	})
+
+	t.Run("it creates a subscription in a category", func(t *testing.T) {
+		expect(send(http.MethodGet, "/ui/subscriptions/new", nil), http.StatusOK, category.ID.String())
+		rec := send(http.MethodPost, "/ui/subscriptions", url.Values{"name": {"Netflix"}, "monthly_cost": {"129"}, "currency": {"SEK"}, "category_id": {category.ID.String()}})
+		expect(rec, http.StatusCreated, "Netflix", "Streaming", "129 SEK")
+	})
+
+	t.Run("it edits a card and rejects stale versions", func(t *testing.T) {
+		expect(send(http.MethodPost, "/ui/cards", url.Values{"name": {"Visa"}, "expires_at": {"2030-01-31"}}), http.StatusCreated, "Visa", "Jan 2030")
+		cards, _ := store.ListCardsForOwner(context.Background(), user.ID)
+		card := cards[0]
+		edit := "/ui/cards/" + card.ID.String()
+
+		expect(send(http.MethodGet, edit+"/edit", nil), http.StatusOK, `hx-put="`+edit+`"`, `value="2030-01-31"`)
+		expect(send(http.MethodPut, edit, url.Values{"name": {"Mastercard"}, "expires_at": {"2031-02-28"}, "version": {"1"}}), http.StatusOK, "Card saved", "Mastercard")
+		expect(send(http.MethodPut, edit, url.Values{"name": {"Amex"}, "expires_at": {"2031-02-28"}, "version": {"1"}}), http.StatusPreconditionFailed, "class=\"alert error\"")
+	})
+
+	t.Run("it does not render resources of other users", func(t *testing.T) {
+		other, _ := store.CreateCategory(context.Background(), database.CreateCategoryParams{Name: "Private", CreatedBy: uuid.New()})
+		expect(send(http.MethodGet, "/ui/categories/"+other.ID.String()+"/edit", nil), http.StatusForbidden)
+		expect(send(http.MethodPut, "/ui/categories/"+other.ID.String(), url.Values{"name": {"Mine"}, "version": {"1"}}), http.StatusForbidden)
+		expect(send(http.MethodGet, "/ui/categories/"+uuid.NewString()+"/edit", nil), http.StatusNotFound)
+	})
+}
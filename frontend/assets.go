@@ -0,0 +1,239 @@
+package frontend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// embedded holds the pages and static files, so that the server does not depend on its working directory.
+//
+//go:embed html static
+var embedded embed.FS
+
+const (
+	// Hashed asset URLs change with their content, so they can be cached forever.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Pages and unhashed asset URLs must be revalidated, otherwise clients keep referencing stale assets.
+	revalidateCacheControl = "no-cache"
+
+	// Number of hex characters of the SHA-256 content hash used in asset URLs.
+	hashLength = 12
+)
+
+// asset is a static file together with its precompressed variants.
+type asset struct {
+	name     string // path below static/, such as "js/token.js"
+	hash     string
+	content  []byte
+	gzip     []byte
+	brotli   []byte
+	modified time.Time
+}
+
+// hashedName returns the name of the asset with its content hash inserted before the extension.
+func (a *asset) hashedName() string {
+	ext := path.Ext(a.name)
+	return strings.TrimSuffix(a.name, ext) + "." + a.hash + ext
+}
+
+/*
+Assets serves the frontend. By default it serves the files embedded in the binary: static files get content-hashed
+URLs, are cached for a year and are compressed with gzip and brotli once at startup. In dev mode the files are read
+from disk on every request instead, so that changes show up without rebuilding the binary.
+*/
+type Assets struct {
+	fsys fs.FS
+	dev  bool
+
+	byName   map[string]*asset
+	byHashed map[string]*asset
+
+	// Parsed templates by locale, nil in dev mode. See templates.go.
+	templates map[string]*localizedTemplates
+}
+
+// NewAssets returns the embedded frontend, or the frontend in devDir when it is not empty. devDir is the directory
+// containing html/ and static/, usually "frontend" when the server is started from the repository root.
+func NewAssets(devDir string) *Assets {
+	if devDir != "" {
+		return &Assets{fsys: os.DirFS(devDir), dev: true}
+	}
+
+	a := &Assets{fsys: embedded, byName: map[string]*asset{}, byHashed: map[string]*asset{}}
+	err := fs.WalkDir(embedded, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(embedded, p)
+		if err != nil {
+			return err
+		}
+		asset := newAsset(strings.TrimPrefix(p, "static/"), content)
+		a.byName[asset.name] = asset
+		a.byHashed[asset.hashedName()] = asset
+		return nil
+	})
+	if err == nil {
+		err = a.parseTemplates()
+	}
+	if err != nil {
+		// The embedded files are part of the binary, failing to read them is a programming error
+		panic(fmt.Sprintf("reading embedded frontend: %v", err))
+	}
+	return a
+}
+
+func newAsset(name string, content []byte) *asset {
+	sum := sha256.Sum256(content)
+	a := &asset{
+		name:     name,
+		hash:     hex.EncodeToString(sum[:])[:hashLength],
+		content:  content,
+		modified: time.Now(),
+	}
+	if compressible(name) {
+		a.gzip = smallerThan(content, gzipBytes(content))
+		a.brotli = smallerThan(content, brotliBytes(content))
+	}
+	return a
+}
+
+// compressible reports whether the file is text based. Images and fonts are compressed already.
+func compressible(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".js", ".css", ".html", ".json", ".svg", ".txt", ".map":
+		return true
+	}
+	return false
+}
+
+// smallerThan returns compressed if it actually saves space, otherwise nil.
+func smallerThan(original, compressed []byte) []byte {
+	if len(compressed) >= len(original) {
+		return nil
+	}
+	return compressed
+}
+
+func gzipBytes(content []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w.Write(content)
+	w.Close()
+	return buf.Bytes()
+}
+
+func brotliBytes(content []byte) []byte {
+	var buf bytes.Buffer
+	w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	w.Write(content)
+	w.Close()
+	return buf.Bytes()
+}
+
+// URL returns the URL of a static file, such as "js/token.js". Outside of dev mode the URL contains the content hash
+// of the file.
+func (a *Assets) URL(name string) string {
+	if !a.dev {
+		if asset, ok := a.byName[name]; ok {
+			return "/static/" + asset.hashedName()
+		}
+	}
+	return "/static/" + name
+}
+
+// HandleStatic serves the files below static/ with proper MIME types, preferring precompressed variants.
+func (a *Assets) HandleStatic() http.Handler {
+	return setMIMEType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+		if a.dev {
+			w.Header().Set("Cache-Control", revalidateCacheControl)
+			http.ServeFileFS(w, r, a.fsys, path.Join("static", name))
+			return
+		}
+
+		if asset, ok := a.byHashed[name]; ok {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+			serveAsset(w, r, asset)
+			return
+		}
+		// Unhashed URLs keep working, for instance for pages that are not rendered through URL
+		if asset, ok := a.byName[name]; ok {
+			w.Header().Set("Cache-Control", revalidateCacheControl)
+			serveAsset(w, r, asset)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+// serveAsset writes the smallest variant of the asset the client accepts. http.ServeContent handles conditional and
+// range requests.
+func serveAsset(w http.ResponseWriter, r *http.Request, a *asset) {
+	content, encoding := a.content, ""
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case a.brotli != nil && acceptsEncoding(accept, "br"):
+		content, encoding = a.brotli, "br"
+	case a.gzip != nil && acceptsEncoding(accept, "gzip"):
+		content, encoding = a.gzip, "gzip"
+	}
+
+	if a.gzip != nil || a.brotli != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	etag := a.hash
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+		etag += "-" + encoding
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, a.name, a.modified, bytes.NewReader(content))
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the encoding, respecting q=0.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, err := strconv.ParseFloat(value, 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (a *Assets) HandleIndex() http.Handler {
+	return a.handlePage("index.html")
+}
+
+func (a *Assets) HandleDashboard() http.Handler {
+	return a.handlePage("dashboard.html")
+}
+
+func (a *Assets) handlePage(name string) http.Handler {
+	return setMIMEType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", revalidateCacheControl)
+		a.RenderPage(w, r, http.StatusOK, name, nil)
+	}))
+}
@@ -0,0 +1,168 @@
+package frontend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func get(t *testing.T, handler http.Handler, target string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEmbeddedAssets(t *testing.T) {
+	assets := NewAssets("")
+	original, err := os.ReadFile(filepath.Join("static", "js", "token.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := assets.URL("js/token.js")
+	if url == "/static/js/token.js" || !strings.HasPrefix(url, "/static/js/token.") {
+		t.Fatalf("expected a content-hashed URL, got %s", url)
+	}
+
+	t.Run("it links pages to the hashed URLs", func(t *testing.T) {
+		rec := get(t, assets.HandleDashboard(), "/dashboard", nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `src="`+url+`"`) {
+			t.Errorf("expected the dashboard to reference %s, got %d", url, rec.Code)
+		}
+	})
+
+	tests := []struct {
+		name         string
+		target       string
+		encoding     string
+		cacheControl string
+		decode       func(io.Reader) (io.Reader, error)
+	}{
+		{
+			name:         "it serves hashed URLs with brotli and a long cache lifetime",
+			target:       url,
+			encoding:     "br",
+			cacheControl: immutableCacheControl,
+			decode:       func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		},
+		{
+			name:         "it falls back to gzip",
+			target:       url,
+			encoding:     "gzip",
+			cacheControl: immutableCacheControl,
+			decode:       func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			name:         "it serves unhashed URLs uncompressed and revalidated",
+			target:       "/static/js/token.js",
+			encoding:     "",
+			cacheControl: revalidateCacheControl,
+			decode:       func(r io.Reader) (io.Reader, error) { return r, nil },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accept := map[string]string{"br": "gzip, br", "gzip": "gzip, br;q=0"}[tt.encoding]
+			rec := get(t, assets.HandleStatic(), tt.target, map[string]string{"Accept-Encoding": accept})
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tt.encoding, got)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", tt.cacheControl, got)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/javascript" {
+				t.Errorf("expected a JavaScript Content-Type, got %q", got)
+			}
+			r, err := tt.decode(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := io.ReadAll(r); !bytes.Equal(body, original) {
+				t.Error("expected the decoded body to equal the file")
+			}
+		})
+	}
+
+	t.Run("it answers conditional requests", func(t *testing.T) {
+		first := get(t, assets.HandleStatic(), url, map[string]string{"Accept-Encoding": "br"})
+		rec := get(t, assets.HandleStatic(), url, map[string]string{"Accept-Encoding": "br", "If-None-Match": first.Header().Get("ETag")})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("expected status 304, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it does not serve unknown files", func(t *testing.T) {
+		if rec := get(t, assets.HandleStatic(), "/static/js/token.000000000000.js", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", rec.Code)
+		}
+	})
+}
+
+func TestDevAssets(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"html/layouts/base.html":   `{{define "base"}}{{block "content" .}}{{end}}{{end}}`,
+		"html/partials/alert.html": `{{define "alert"}}{{.Message}}{{end}}`,
+		"html/index.html":          `{{template "base" .}}{{define "content"}}<script src="{{asset "app.js"}}"></script>{{end}}`,
+		"static/app.js":            "console.log(1)",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assets := NewAssets(dir)
+
+	if rec := get(t, assets.HandleIndex(), "/", nil); !strings.Contains(rec.Body.String(), `src="/static/app.js"`) {
+		t.Errorf("expected unhashed URLs in dev mode, got %s", rec.Body.String())
+	}
+
+	// Changes on disk show up without restarting
+	if err := os.WriteFile(filepath.Join(dir, "static", "app.js"), []byte("console.log(2)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec := get(t, assets.HandleStatic(), "/static/app.js", nil)
+	if rec.Body.String() != "console.log(2)" || rec.Header().Get("Cache-Control") != revalidateCacheControl {
+		t.Errorf("expected the file from disk without caching, got %q and %q", rec.Body.String(), rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: "gzip, deflate, br", want: true},
+		{header: "BR;q=0.5", want: true},
+		{header: "gzip, br;q=0", want: false},
+		{header: "gzip", want: false},
+		{header: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, "br"); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, br) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package frontend
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+)
+
+// Charts
+//
+// The charts of the dashboard are rendered to SVG on the server, so that the dashboard does not need a JavaScript
+// charting library. HTMX swaps them into the page like any other fragment. Amounts are never converted between
+// currencies, so every chart shows the amounts of a single currency.
+
+// ChartValue is a labelled amount, such as the spend of a month or a category.
+type ChartValue struct {
+	Label string
+	Value int64
+}
+
+// Chart is an SVG chart that can be written with RenderChart.
+type Chart interface {
+	WriteSVG(w io.Writer) error
+}
+
+// BarChart draws one bar per value, such as the spend of every month.
+type BarChart struct {
+	Title    string
+	Currency string
+	Bars     []ChartValue
+	// Locale formats the amounts, DefaultLocale when nil
+	Locale *Locale
+}
+
+// DonutChart draws the share of every value of their total, such as the spend per category.
+type DonutChart struct {
+	Title    string
+	Currency string
+	Slices   []ChartValue
+	Locale   *Locale
+}
+
+// StackedBar is a bar of a StackedBarChart, such as a card, made of segments, such as the subscriptions paid with it.
+type StackedBar struct {
+	Label    string
+	Segments []ChartValue
+}
+
+// StackedBarChart draws one bar per StackedBar. Segments with the same label share their colour across bars.
+type StackedBarChart struct {
+	Title    string
+	Currency string
+	Bars     []StackedBar
+	Locale   *Locale
+}
+
+// RenderChart writes the chart as an SVG image. The chart is written into a buffer first, so that a failing chart
+// does not leave a half written image.
+func RenderChart(w http.ResponseWriter, r *http.Request, chart Chart) {
+	var buf bytes.Buffer
+	if err := chart.WriteSVG(&buf); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Charts contain the data of the user and change whenever their subscriptions do
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logging.FromContext(r.Context()).Error("error writing chart", "error", err)
+	}
+}
+
+// -- Layout
+
+const (
+	chartWidth  = 640
+	chartHeight = 240
+	// Space around the plot for the title, the axis and the legend
+	marginTop    = 36
+	marginRight  = 16
+	marginBottom = 28
+	marginLeft   = 56
+	legendWidth  = 200
+	legendLine   = 20
+	yTicks       = 4
+)
+
+// chartPalette is a colour-blind friendly palette. Values beyond its length reuse its colours.
+var chartPalette = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac",
+}
+
+func color(i int) string {
+	return chartPalette[i%len(chartPalette)]
+}
+
+// svg builds the markup of a chart.
+type svg struct {
+	bytes.Buffer
+	locale *Locale
+}
+
+// The style is scoped to the chart class, as it applies to the whole page when the SVG is swapped into it.
+const chartStyle = `<style>` +
+	`.chart text{font:12px system-ui,sans-serif;fill:#333}` +
+	`.chart .title{font-size:14px;font-weight:600}` +
+	`.chart .axis{fill:#777}` +
+	`.chart line{stroke:#ddd}` +
+	`</style>`
+
+func (s *svg) open(width, height int, title string) {
+	fmt.Fprintf(s, `<svg xmlns="http://www.w3.org/2000/svg" class="chart" viewBox="0 0 %d %d" width="%d" height="%d" role="img" aria-label="%s">`,
+		width, height, width, height, html.EscapeString(title))
+	s.WriteString(chartStyle)
+	s.text(0, 20, "start", "title", title)
+}
+
+func (s *svg) close() {
+	s.WriteString(`</svg>`)
+}
+
+func (s *svg) text(x, y float64, anchor, class, text string) {
+	fmt.Fprintf(s, `<text x="%.1f" y="%.1f" text-anchor="%s" class="%s">%s</text>`, x, y, anchor, class, html.EscapeString(text))
+}
+
+func (s *svg) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(s, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, x1, y1, x2, y2)
+}
+
+// rect draws a rectangle with a tooltip.
+func (s *svg) rect(x, y, width, height float64, fill, tooltip string) {
+	fmt.Fprintf(s, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s</title></rect>`,
+		x, y, width, height, fill, html.EscapeString(tooltip))
+}
+
+// legend lists the labels next to the colour they are drawn with.
+func (s *svg) legend(x, y float64, labels []string) {
+	for i, label := range labels {
+		s.rect(x, y+float64(i*legendLine)-10, 12, 12, color(i), label)
+		s.text(x+18, y+float64(i*legendLine), "start", "", label)
+	}
+}
+
+// empty writes the message shown instead of a chart without data.
+func (s *svg) empty(width, height int) {
+	s.text(float64(width)/2, float64(height)/2, "middle", "axis", s.locale.T("chart.empty"))
+}
+
+/*
+yAxis draws the horizontal grid lines and their amounts, and returns the amount at the top of the plot. The top is
+rounded up to a multiple of 1, 2 or 5 times a power of ten per tick, so that the axis shows round amounts.
+*/
+func (s *svg) yAxis(top, bottom, right float64, highest int64, currency string) int64 {
+	step := int64(1)
+	for magnitude := int64(1); step*yTicks < highest; magnitude *= 10 {
+		for _, m := range []int64{1, 2, 5} {
+			if step = m * magnitude; step*yTicks >= highest {
+				break
+			}
+		}
+	}
+
+	for i := 0; i <= yTicks; i++ {
+		y := bottom - (bottom-top)*float64(i)/yTicks
+		s.line(marginLeft, y, right, y)
+		s.text(marginLeft-6, y+4, "end", "axis", s.locale.Money(step*int64(i), currency))
+	}
+	return step * yTicks
+}
+
+// -- Charts
+
+// writeSVG writes an SVG document with the title of the chart, into which draw draws the chart itself.
+func writeSVG(w io.Writer, locale *Locale, width, height int, title string, draw func(s *svg)) error {
+	s := svg{locale: locale}
+	if s.locale == nil {
+		s.locale = DefaultLocale
+	}
+	s.open(width, height, title)
+	draw(&s)
+	s.close()
+	_, err := s.WriteTo(w)
+	return err
+}
+
+func (c BarChart) WriteSVG(w io.Writer) error {
+	return writeSVG(w, c.Locale, chartWidth, chartHeight, c.Title, func(s *svg) {
+		var highest int64
+		for _, bar := range c.Bars {
+			highest = max(highest, bar.Value)
+		}
+		if highest == 0 {
+			s.empty(chartWidth, chartHeight)
+			return
+		}
+
+		top, bottom := float64(marginTop), float64(chartHeight-marginBottom)
+		scale := (bottom - top) / float64(s.yAxis(top, bottom, chartWidth-marginRight, highest, c.Currency))
+		slot := float64(chartWidth-marginLeft-marginRight) / float64(len(c.Bars))
+		// Leave out labels that would overlap
+		every := (len(c.Bars) + 7) / 8
+
+		for i, bar := range c.Bars {
+			x := marginLeft + slot*float64(i)
+			height := float64(bar.Value) * scale
+			s.rect(x+slot*0.15, bottom-height, slot*0.7, height, color(0), bar.Label+": "+s.locale.Money(bar.Value, c.Currency))
+			if i%every == 0 {
+				s.text(x+slot/2, bottom+16, "middle", "axis", bar.Label)
+			}
+		}
+	})
+}
+
+/*
+WriteSVG draws the slices as dashes on the stroke of a circle, each dash as long as the share of the slice of the
+circumference. Unlike arcs, this needs no special case for a slice of the whole circle.
+*/
+func (c DonutChart) WriteSVG(w io.Writer) error {
+	const cx, cy, r, thickness = 110.0, 125.0, 70.0, 28.0
+	height := max(chartHeight, marginTop+legendLine*(len(c.Slices)+1))
+
+	return writeSVG(w, c.Locale, chartWidth, height, c.Title, func(s *svg) {
+		var total int64
+		for _, slice := range c.Slices {
+			total += slice.Value
+		}
+		if total <= 0 {
+			s.empty(chartWidth, height)
+			return
+		}
+
+		circumference := 2 * math.Pi * r
+		offset := 0.0
+		labels := make([]string, len(c.Slices))
+		for i, slice := range c.Slices {
+			share := float64(slice.Value) / float64(total)
+			dash := circumference * share
+			labels[i] = fmt.Sprintf("%s: %s (%.0f%%)", slice.Label, s.locale.Money(slice.Value, c.Currency), share*100)
+			fmt.Fprintf(s, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="none" stroke="%s" stroke-width="%.1f" `+
+				`stroke-dasharray="%.2f %.2f" stroke-dashoffset="%.2f" transform="rotate(-90 %.1f %.1f)"><title>%s</title></circle>`,
+				cx, cy, r, color(i), thickness, dash, circumference-dash, -offset, cx, cy, html.EscapeString(labels[i]))
+			offset += dash
+		}
+		s.text(cx, cy-4, "middle", "axis", s.locale.T("chart.total"))
+		s.text(cx, cy+14, "middle", "title", s.locale.Money(total, c.Currency))
+		s.legend(2*cx+20, marginTop+legendLine, labels)
+	})
+}
+
+func (c StackedBarChart) WriteSVG(w io.Writer) error {
+	// Segments are coloured by the order in which their labels first appear
+	var labels []string
+	colors := map[string]int{}
+	for _, bar := range c.Bars {
+		for _, segment := range bar.Segments {
+			if _, ok := colors[segment.Label]; !ok {
+				colors[segment.Label] = len(labels)
+				labels = append(labels, segment.Label)
+			}
+		}
+	}
+	height := max(chartHeight, marginTop+legendLine*(len(labels)+1))
+
+	return writeSVG(w, c.Locale, chartWidth, height, c.Title, func(s *svg) {
+		var highest int64
+		for _, bar := range c.Bars {
+			var total int64
+			for _, segment := range bar.Segments {
+				total += segment.Value
+			}
+			highest = max(highest, total)
+		}
+		if highest == 0 {
+			s.empty(chartWidth, height)
+			return
+		}
+
+		right := float64(chartWidth - legendWidth)
+		top, bottom := float64(marginTop), float64(height-marginBottom)
+		scale := (bottom - top) / float64(s.yAxis(top, bottom, right, highest, c.Currency))
+		slot := (right - marginLeft) / float64(len(c.Bars))
+
+		for i, bar := range c.Bars {
+			x := marginLeft + slot*float64(i)
+			y := bottom
+			for _, segment := range bar.Segments {
+				height := float64(segment.Value) * scale
+				y -= height
+				tooltip := bar.Label + ", " + segment.Label + ": " + s.locale.Money(segment.Value, c.Currency)
+				s.rect(x+slot*0.2, y, slot*0.6, height, color(colors[segment.Label]), tooltip)
+			}
+			s.text(x+slot/2, bottom+16, "middle", "axis", bar.Label)
+		}
+		s.legend(right+marginRight, marginTop+legendLine, labels)
+	})
+}
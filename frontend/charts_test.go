@@ -0,0 +1,71 @@
+package frontend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCharts(t *testing.T) {
+	months := make([]ChartValue, 60)
+	for i := range months {
+		months[i] = ChartValue{Label: "M", Value: int64(i * 37)}
+	}
+
+	tests := []struct {
+		name     string
+		chart    Chart
+		contains []string
+	}{
+		{
+			name:     "bar chart",
+			chart:    BarChart{Title: "Spend", Currency: "SEK", Bars: months},
+			contains: []string{"M: 2,183 SEK", "4,000 SEK"},
+		},
+		{
+			name:     "donut chart with a single slice",
+			chart:    DonutChart{Title: "Categories", Currency: "SEK", Slices: []ChartValue{{Label: "Music & Video", Value: 99}}},
+			contains: []string{"Music &amp; Video: 99 SEK (100%)", "stroke-dasharray=\"439.82 0.00\""},
+		},
+		{
+			name: "stacked bar chart",
+			chart: StackedBarChart{Title: "Cards", Currency: "SEK", Bars: []StackedBar{
+				{Label: "Visa", Segments: []ChartValue{{Label: "Netflix", Value: 129}, {Label: "Spotify", Value: 119}}},
+				{Label: "Amex", Segments: []ChartValue{{Label: "Spotify", Value: 119}}},
+			}},
+			// Spotify keeps its colour on both cards
+			contains: []string{`fill="#f28e2b"><title>Visa, Spotify`, `fill="#f28e2b"><title>Amex, Spotify`},
+		},
+		{
+			name:     "chart without data",
+			chart:    BarChart{Title: "Spend", Currency: "SEK", Bars: make([]ChartValue, 12)},
+			contains: []string{"No data yet"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.chart.WriteSVG(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			// The chart must be well-formed XML, or browsers refuse to render it
+			decoder := xml.NewDecoder(bytes.NewReader(buf.Bytes()))
+			for {
+				if _, err := decoder.Token(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("invalid SVG: %v\n%s", err, buf.String())
+				}
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected the chart to contain %q, got %s", want, buf.String())
+				}
+			}
+		})
+	}
+}
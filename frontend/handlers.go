@@ -41,31 +41,3 @@ func setMIMEType(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-// HandleStatic expose the static files by creating a http handler with proper MIME types
-func HandleStatic() http.Handler {
-	staticDir := http.Dir(filepath.Join("frontend", "static"))
-	fileServer := http.FileServer(staticDir)
-
-	return setMIMEType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Strip the /static prefix from the URL path
-		// so /static/js/helloworld.js becomes /js/helloworld.js.
-		//
-		// Without this the fileserver does not find the correct files.
-		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/static")
-
-		fileServer.ServeHTTP(w, r)
-	}))
-}
-
-func HandleIndex() http.Handler {
-	return setMIMEType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join("frontend", "html", "index.html"))
-	}))
-}
-
-func HandleDashboard() http.Handler {
-	return setMIMEType(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join("frontend", "html", "dashboard.html"))
-	}))
-}
@@ -0,0 +1,178 @@
+package frontend
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Internationalisation
+//
+// Every text shown by the frontend is looked up in the message catalog of the locale of the request, a JSON file in
+// locales/ named after its language tag. Keys are grouped by a prefix, such as "alert." or "field.", and format
+// strings use explicit argument indexes, so that a translation can reorder them. The "format." keys define how
+// amounts and dates are written. Unlike the pages, the catalogs are always embedded, also in dev mode.
+
+// localeCookie holds the language picked by the user, which takes precedence over Accept-Language.
+const localeCookie = "lang"
+
+//go:embed locales/*.json
+var catalogs embed.FS
+
+// Locale is a message catalog together with the formatting of amounts and dates of a language.
+type Locale struct {
+	// Tag is the language tag of the locale, such as "sv"
+	Tag      string
+	messages map[string]string
+}
+
+var (
+	// locales holds the locales of the catalogs by tag
+	locales = loadLocales(catalogs)
+	// DefaultLocale is used when the request does not ask for any known language. Missing keys of other catalogs
+	// fall back to it as well.
+	DefaultLocale = locales["en"]
+)
+
+// loadLocales parses the catalogs, panicking when one is invalid since they are compiled into the binary.
+func loadLocales(fsys fs.FS) map[string]*Locale {
+	names, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := make(map[string]*Locale, len(names))
+	for _, name := range names {
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			panic(err)
+		}
+		locale := &Locale{Tag: strings.TrimSuffix(path.Base(name), ".json")}
+		if err := json.Unmarshal(content, &locale.messages); err != nil {
+			panic(fmt.Sprintf("error parsing message catalog %s: %v", name, err))
+		}
+		loaded[locale.Tag] = locale
+	}
+	return loaded
+}
+
+// Locales returns the available locales ordered by tag.
+func Locales() []*Locale {
+	all := make([]*Locale, 0, len(locales))
+	for _, locale := range locales {
+		all = append(all, locale)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Tag < all[j].Tag })
+	return all
+}
+
+/*
+LocaleFor returns the locale of a request: the language picked by the user in the lang cookie, otherwise the most
+preferred language of Accept-Language that has a catalog. Regional variants use the catalog of their language, so
+"sv-FI" is served in Swedish.
+*/
+func LocaleFor(r *http.Request) *Locale {
+	if cookie, err := r.Cookie(localeCookie); err == nil {
+		if locale, ok := locales[cookie.Value]; ok {
+			return locale
+		}
+	}
+
+	best, bestQ := DefaultLocale, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		locale, ok := locales[language]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = locale, q
+		}
+	}
+	return best
+}
+
+// T returns the message with the given key formatted with args. Keys missing from the catalog are looked up in
+// DefaultLocale, and rendered as the key itself when that lacks them too.
+func (l *Locale) T(key string, args ...any) string {
+	message, ok := l.messages[key]
+	if !ok {
+		if message, ok = DefaultLocale.messages[key]; !ok {
+			return key
+		}
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Name is the name of the language of the locale in that language, such as "Svenska".
+func (l *Locale) Name() string {
+	return l.T("language.name")
+}
+
+// Money formats a whole amount in the given currency, such as "1 299 SEK".
+func (l *Locale) Money(amount int64, currency string) string {
+	digits := strconv.FormatInt(amount, 10)
+	sign := ""
+	if amount < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var grouped strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped.WriteString(l.T("format.thousands"))
+		}
+		grouped.WriteRune(digit)
+	}
+	return strings.TrimSpace(l.T("format.money", sign+grouped.String(), currency))
+}
+
+// Date formats a date, such as "Jan 2, 2006".
+func (l *Locale) Date(t time.Time) string {
+	return l.T("format.date", t.Day(), l.monthName(t.Month()), t.Year())
+}
+
+// Month formats the month of a date, such as "Jan 2006".
+func (l *Locale) Month(t time.Time) string {
+	return l.T("format.month", l.monthName(t.Month()), t.Year())
+}
+
+func (l *Locale) monthName(month time.Month) string {
+	return l.T("month." + strconv.Itoa(int(month)))
+}
+
+// FieldError describes why a form field was rejected, code being one of the validation codes of the API such as
+// "required".
+func (l *Locale) FieldError(field, code string) string {
+	key := "validation." + code
+	if !DefaultLocale.has(key) {
+		key = "validation.invalid"
+	}
+	label := field
+	if DefaultLocale.has("field." + field) {
+		label = l.T("field." + field)
+	}
+	return l.T(key, label)
+}
+
+func (l *Locale) has(key string) bool {
+	_, ok := l.messages[key]
+	return ok
+}
@@ -0,0 +1,121 @@
+package frontend
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCatalogsAreComplete(t *testing.T) {
+	if len(locales) < 2 {
+		t.Fatalf("expected the English and Swedish catalogs, got %d", len(locales))
+	}
+
+	keys := map[string]bool{}
+	for _, locale := range locales {
+		for key := range locale.messages {
+			keys[key] = true
+		}
+	}
+
+	// Keys used by the templates must be in the catalogs as well
+	used := regexp.MustCompile(`\{\{\s*t\s+"([^"]+)"`)
+	err := fs.WalkDir(embedded, "html", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(embedded, p)
+		if err != nil {
+			return err
+		}
+		for _, match := range used.FindAllStringSubmatch(string(content), -1) {
+			if !keys[match[1]] {
+				t.Errorf("%s uses %q, which is in no catalog", p, match[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verbs := regexp.MustCompile(`%(\[\d+\])?[a-z]`)
+	for _, locale := range Locales() {
+		var missing []string
+		for key := range keys {
+			message, ok := locale.messages[key]
+			if !ok {
+				missing = append(missing, key)
+				continue
+			}
+			// A translation must use the same arguments as the original
+			if got, want := len(verbs.FindAllString(message, -1)), len(verbs.FindAllString(DefaultLocale.messages[key], -1)); got != want {
+				t.Errorf("%s: %q has %d arguments, expected %d", locale.Tag, key, got, want)
+			}
+		}
+		sort.Strings(missing)
+		if len(missing) > 0 {
+			t.Errorf("the %s catalog is missing %s", locale.Tag, strings.Join(missing, ", "))
+		}
+	}
+}
+
+func TestLocaleFor(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		cookie         string
+		want           string
+	}{
+		{name: "it defaults to English", want: "en"},
+		{name: "it picks the only known language", acceptLanguage: "de-DE, sv;q=0.5", want: "sv"},
+		{name: "it uses the language of regional variants", acceptLanguage: "sv-FI", want: "sv"},
+		{name: "it honours quality values", acceptLanguage: "sv;q=0.4, en;q=0.8", want: "en"},
+		{name: "it prefers the language picked by the user", acceptLanguage: "en", cookie: "sv", want: "sv"},
+		{name: "it ignores unknown picked languages", acceptLanguage: "sv", cookie: "xx", want: "sv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: localeCookie, Value: tt.cookie})
+			}
+			if got := LocaleFor(req).Tag; got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLocaleFormatting(t *testing.T) {
+	en, sv := locales["en"], locales["sv"]
+	date := time.Date(2025, time.May, 7, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		got, want string
+	}{
+		{got: en.Money(1234567, "SEK"), want: "1,234,567 SEK"},
+		{got: sv.Money(1234567, "SEK"), want: "1 234 567 SEK"},
+		{got: en.Money(-129, "EUR"), want: "-129 EUR"},
+		{got: en.Money(-1299, "EUR"), want: "-1,299 EUR"},
+		{got: en.Date(date), want: "May 7, 2025"},
+		{got: sv.Date(date), want: "7 maj 2025"},
+		{got: sv.Month(date), want: "maj 2025"},
+		{got: sv.FieldError("name", "required"), want: "Namn måste anges"},
+		{got: sv.FieldError("billing_frequency", "unknown"), want: "billing_frequency är ogiltig"},
+		{got: sv.T("no.such.key"), want: "no.such.key"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, tt.got)
+		}
+	}
+}
@@ -1,48 +1,98 @@
 package frontend
 
 import (
-	"fmt"
-	"log"
-	"net/http"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
 )
 
+// Alert is a message rendered with the alert partial, in the language of the request.
+type Alert struct {
+	// Kind is either "error" or "success" and selects the styling
+	Kind string
+	// Key is the key of the message in the catalog, or the validation code of Field
+	Key string
+	// Field is set for alerts about a rejected field
+	Field string
+}
+
+func ErrorAlert(key string) *Alert {
+	return &Alert{Kind: "error", Key: key}
+}
+
+func SuccessAlert(key string) *Alert {
+	return &Alert{Kind: "success", Key: key}
+}
+
+// FieldAlert reports a rejected field outside of a form.
+func FieldAlert(field, code string) *Alert {
+	return &Alert{Kind: "error", Key: code, Field: field}
+}
+
+// message returns the text of the alert in the given locale.
+func (a *Alert) message(locale *Locale) string {
+	if a.Field != "" {
+		return locale.FieldError(a.Field, a.Key)
+	}
+	return locale.T(a.Key)
+}
+
 // Alert messages that can be rendered to user
-const (
+var (
 	// Alerts related to login
-	InvalidFormDataError        HtmxResponse = `<div class="alert error">Invalid form data</div>`
-	EmailAndPasswordError       HtmxResponse = `<div class="alert error">Email and password are required</div>`
-	InvalidEmailOrPasswordError HtmxResponse = `<div class="alert error">Invalid email or password</div>`
+	InvalidFormDataError        = ErrorAlert("alert.invalid_form_data")
+	EmailAndPasswordError       = ErrorAlert("alert.email_and_password_required")
+	InvalidEmailOrPasswordError = ErrorAlert("alert.invalid_email_or_password")
 
 	// Generic alerts
-	ServerError HtmxResponse = `<div class="alert success">Server error</div>`
+	ServerError = ErrorAlert("alert.server_error")
 
 	// Alerts related to registration
-	RegistrationSuccess   HtmxResponse = `<div class="alert success">Registration successful, Please switch to login tab</div>`
-	InsecurePasswordError HtmxResponse = `<div class="alert error">Insecure password, try including more special characters or using a longer password</div>`
-	DuplicateUserError    HtmxResponse = `<div class="alert error">Failed to create user, Email already exists</div>`
+	RegistrationSuccess   = SuccessAlert("alert.registration_success")
+	InsecurePasswordError = ErrorAlert("alert.insecure_password")
+	InvalidEmailError     = ErrorAlert("alert.invalid_email")
+	DuplicateUserError    = ErrorAlert("alert.duplicate_user")
+
+	// Alerts of the dashboard forms
+	SubscriptionCreated = SuccessAlert("alert.subscription_created")
+	SubscriptionSaved   = SuccessAlert("alert.subscription_saved")
+	CategoryCreated     = SuccessAlert("alert.category_created")
+	CategorySaved       = SuccessAlert("alert.category_saved")
+	CardCreated         = SuccessAlert("alert.card_created")
+	CardSaved           = SuccessAlert("alert.card_saved")
 )
 
-type HtmxResponse string
+// -- Data of the dashboard fragments
 
-// DecorateSuccess wraps the HtmxResponse content with a success alert DIV element, applying relevant styling.
-func (m *HtmxResponse) DecorateSuccess() {
-	var newMessage HtmxResponse
-	newMessage = HtmxResponse(fmt.Sprintf(`<div class="alert success">%s</div>`, *m))
-	m = &newMessage
+type SubscriptionsView struct {
+	Subscriptions []database.Subscription
+	// CategoryNames maps the category IDs of the subscriptions onto their names
+	CategoryNames map[uuid.UUID]string
+	Alert         *Alert
 }
 
-// DecorateError wraps the HtmxResponse content with an error alert DIV element, applying relevant styling.
-func (m *HtmxResponse) DecorateError() {
-	var newMessage HtmxResponse
-	newMessage = HtmxResponse(fmt.Sprintf(`<div class="alert error">%s</div>`, *m))
-	m = &newMessage
+type CategoriesView struct {
+	Categories []database.Category
+	Alert      *Alert
 }
 
-// Respond sends the HtmxResponse as an HTML response with an "OK" status and logs the process or any potential errors.
-func (m *HtmxResponse) Respond(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(*m)); err != nil {
-		log.Println("error writing htmx alert: ", err)
-	}
+type CardsView struct {
+	Cards []database.Card
+	Alert *Alert
+}
+
+/*
+Form is the data of the create and edit form partials. Values holds the submitted or stored value of every input by
+name, Errors the validation codes of rejected inputs. Version is sent back with the form, so that an edit based on a stale
+copy is rejected instead of overwriting changes made in the meantime.
+*/
+type Form struct {
+	Action  string
+	Editing bool
+	Version int32
+	Values  map[string]string
+	Errors  map[string]string
+	Alert   *Alert
+
+	// Categories are the options of the category select of the subscription form
+	Categories []database.Category
 }
@@ -89,18 +89,15 @@ async function attemptTokenRefresh() {
     }
 
     try {
+        // The refresh token authenticates the request, the expired JWT is not accepted
         const response = await fetch('/refresh', {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ refreshToken })
+            headers: { 'Authorization': `Bearer ${refreshToken}` }
         });
 
         if (response.ok) {
-            const { token: newToken, refreshToken: newRefreshToken } = await response.json();
-            localStorage.setItem('token', newToken);
-            if (newRefreshToken) {
-                localStorage.setItem('refreshToken', newRefreshToken);
-            }
+            const { content } = await response.json();
+            localStorage.setItem('token', content.token);
             // Retry the original operation
             await checkAuthWithValidation();
         } else {
@@ -0,0 +1,152 @@
+package frontend
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+)
+
+// Templates
+//
+// Pages in html/ are rendered inside the layouts of html/layouts, which define the "base" template with "title",
+// "styles", "content" and "scripts" blocks. Partials in html/partials define fragments, such as the subscriptions
+// table, that are rendered on their own for HTMX requests or included by pages and other partials. Every page and
+// partial can use the functions of templateFuncs. Since these are bound to a locale, such as "t" translating a key of
+// the message catalog, the templates are parsed once per locale.
+
+// fieldData is the data of the field_error partial.
+type fieldData struct {
+	Form  *Form
+	Field string
+}
+
+func (a *Assets) templateFuncs(locale *Locale) template.FuncMap {
+	return template.FuncMap{
+		"asset": a.URL,
+		"field": func(form *Form, name string) fieldData { return fieldData{Form: form, Field: name} },
+
+		"t":          locale.T,
+		"message":    func(alert *Alert) string { return alert.message(locale) },
+		"fieldError": locale.FieldError,
+		"date":       locale.Date,
+		"month":      locale.Month,
+		"money": func(amount any, currency string) (string, error) {
+			switch amount := amount.(type) {
+			case int:
+				return locale.Money(int64(amount), currency), nil
+			case int32:
+				return locale.Money(int64(amount), currency), nil
+			case int64:
+				return locale.Money(amount, currency), nil
+			}
+			return "", fmt.Errorf("money: unsupported amount %T", amount)
+		},
+		"locale":  func() *Locale { return locale },
+		"locales": Locales,
+	}
+}
+
+// localizedTemplates holds the templates parsed for one locale.
+type localizedTemplates struct {
+	partials *template.Template
+	pages    map[string]*template.Template
+}
+
+// parsePartials parses the layouts and partials that are shared by all pages.
+func (a *Assets) parsePartials(locale *Locale) (*template.Template, error) {
+	return template.New("").Funcs(a.templateFuncs(locale)).ParseFS(a.fsys, "html/layouts/*.html", "html/partials/*.html")
+}
+
+// parsePage parses a page from html/ together with the layouts and partials.
+func (a *Assets) parsePage(locale *Locale, name string) (*template.Template, error) {
+	partials, err := a.parsePartials(locale)
+	if err != nil {
+		return nil, err
+	}
+	return partials.ParseFS(a.fsys, path.Join("html", name))
+}
+
+// parseTemplates parses all templates for every locale up front, so that the embedded templates are only parsed once.
+func (a *Assets) parseTemplates() error {
+	names, err := fs.Glob(a.fsys, "html/*.html")
+	if err != nil {
+		return err
+	}
+
+	a.templates = make(map[string]*localizedTemplates, len(locales))
+	for _, locale := range Locales() {
+		localized := &localizedTemplates{pages: make(map[string]*template.Template, len(names))}
+		if localized.partials, err = a.parsePartials(locale); err != nil {
+			return err
+		}
+		for _, name := range names {
+			page, err := a.parsePage(locale, path.Base(name))
+			if err != nil {
+				return err
+			}
+			localized.pages[path.Base(name)] = page
+		}
+		a.templates[locale.Tag] = localized
+	}
+	return nil
+}
+
+// RenderPage writes the page with the given name, such as "dashboard.html", in the language of the request.
+func (a *Assets) RenderPage(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	locale := LocaleFor(r)
+	var page *template.Template
+	if a.dev {
+		var err error
+		if page, err = a.parsePage(locale, name); err != nil {
+			renderError(w, r, err)
+			return
+		}
+	} else if page = a.templates[locale.Tag].pages[name]; page == nil {
+		renderError(w, r, fmt.Errorf("unknown page %s", name))
+		return
+	}
+	render(w, r, status, page, name, data)
+}
+
+// RenderFragment writes the partial with the given name, such as "subscriptions_table", for an HTMX request. The
+// partial is rendered in the language of the request.
+func (a *Assets) RenderFragment(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	locale := LocaleFor(r)
+	var partials *template.Template
+	if a.dev {
+		var err error
+		if partials, err = a.parsePartials(locale); err != nil {
+			renderError(w, r, err)
+			return
+		}
+	} else {
+		partials = a.templates[locale.Tag].partials
+	}
+	render(w, r, status, partials, name, data)
+}
+
+// render executes the template into a buffer first, so that a failing template does not leave a half written page.
+func render(w http.ResponseWriter, r *http.Request, status int, tmpl *template.Template, name string, data any) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The language is negotiated from these headers, see LocaleFor
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logging.FromContext(r.Context()).Error("error writing html response", "error", err)
+	}
+}
+
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Error("error rendering template", "error", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -3,18 +3,19 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/benkoben/unsubtle-core/frontend"
 	"github.com/benkoben/unsubtle-core/internal/auth"
 	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
 	"github.com/google/uuid"
 	"github.com/wagslane/go-password-validator"
 
@@ -42,204 +43,138 @@ var (
 func handleRevoke(db dbQuerier, cfg *Config) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer res.respond(w, r)
+
 		bearer, err := auth.GetBearerToken(r.Header)
 		if err != nil {
 			// No bearer token found in headers
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			res.fail(UnauthenticatedError.Wrap(err))
 			return
 		}
 
 		userId, err := auth.ValidateJWT(bearer, cfg.JWTSecret)
 		if err != nil {
 			// Bearer token was found but is not valid
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			res.fail(UnauthenticatedError.Wrap(err))
 			return
 		}
 
 		refreshToken, err := db.RevokeRefreshToken(r.Context(), userId)
 		if err != nil {
-			log.Printf("revoke refresh token: %w", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			res.fail(InternalError.Wrap(fmt.Errorf("revoke refresh token: %w", err)))
 			return
 		}
 
-		if err := encode(w, http.StatusOK, refreshToken); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-			return
-		}
+		res.Status = http.StatusOK
+		res.Content = refreshToken
 	})
 }
 
 func handleRefresh(db dbQuerier, cfg *Config) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer res.respond(w, r)
+
+		// The refresh token is the bearer token, so that a JWT that already expired can be renewed
 		bearer, err := auth.GetBearerToken(r.Header)
 		if err != nil {
 			// No bearer token found in headers
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			res.fail(UnauthenticatedError.Wrap(err))
 			return
 		}
 
-		userId, err := auth.ValidateJWT(bearer, cfg.JWTSecret)
+		refreshToken, err := db.GetRefreshTokenByToken(r.Context(), bearer)
 		if err != nil {
-			// Bearer token was found but is not valid
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(http.StatusText(http.StatusForbidden)))
-			return
-		}
-
-		// Check if there exists a refresh token
-		refreshToken, err := db.GetRefreshToken(r.Context(), userId)
-		if err != nil {
-			// Normally this is not possible but in rare cases where
-			// an valid JWT is used but no refreshToken exists we need to handle this.
-			if err == sql.ErrNoRows {
-				// Bearer token was found but is not valid
-				w.WriteHeader(http.StatusForbidden)
-				w.Write([]byte(http.StatusText(http.StatusForbidden)))
-				return
-			} else {
-				// Should not happend under normal circumstances
-				log.Printf("retrieve refresh token: %w", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			if errors.Is(err, sql.ErrNoRows) {
+				res.fail(UnauthenticatedError.WithDetail("unknown refresh token"))
 				return
 			}
+			// Should not happend under normal circumstances
+			res.fail(InternalError.Wrap(fmt.Errorf("retrieve refresh token: %w", err)))
+			return
 		}
 
 		// validate if the existing refresh token is not revoked
 		// if the token is revoked or expired
 		if refreshToken.RevokedAt.Valid || refreshToken.ExpiresAt.Unix() < time.Now().Unix() {
-			w.WriteHeader(http.StatusForbidden)
-			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			res.fail(UnauthenticatedError.WithDetail("refresh token is revoked or expired"))
 			return
 		}
 
-		// Make JWT token, a token lives for 60 minutes.
-		jwt, err := auth.MakeJWT(userId, cfg.JWTSecret, time.Minute*60)
+		// Make JWT token
+		logging.AddAttrs(r.Context(), "user_id", refreshToken.UserID)
+		jwt, err := auth.MakeJWT(refreshToken.UserID, cfg.JWTSecret, cfg.Auth.AccessTokenLifetime)
 		if err != nil {
-			log.Printf("could not create jwt token: %w", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			res.fail(InternalError.Wrap(fmt.Errorf("could not create jwt token: %w", err)))
 			return
 		}
 
-		if err := encode(w, http.StatusOK, map[string]string{"token": jwt}); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-			return
-		}
+		res.Status = http.StatusOK
+		res.Content = map[string]string{"token": jwt}
 	})
 }
 
 func handleLogin(db dbQuerier, cfg *Config) http.Handler {
-	var res *response
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 
 		// Retrieve credentials from body
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
+		loginCredentials, err := decodeBody[userRequestData](r.Body)
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
 		// Lookup user in database
-		loginCredentials, err := decode[userRequestData](r.Body)
+		login, err := loginUser(r.Context(), db, cfg, loginCredentials)
 		if err != nil {
-			log.Printf("invalid json: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
+			res.fail(err)
 			return
 		}
 
-		res = loginUser(r.Context(), db, cfg.JWTSecret, loginCredentials)
-		return
+		res.Status = http.StatusOK
+		res.Content = login
 	})
 }
 
 // --- User handlers
-func handleCreateUser(query dbQuerier) http.Handler {
-	var res response
-
+func handleCreateUser(query dbQuerier, cfg *Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		// Parse the email and password from the request body
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
-		newUserData, err := decode[userRequestData](r.Body)
+		newUserData, err := decodeBody[userRequestData](r.Body)
 		if err != nil {
-			log.Printf("error decoding json: %v", err)
-			res.Status = http.StatusBadRequest
+			res.fail(err)
 			return
 		}
 
-		// Validate the email
-		if ok := validEmail(newUserData.Email); !ok {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr("invalid email")
-			return
-		}
-
-		// Check if the email is already registered
-		existingUser, err := query.GetUserByEmail(r.Context(), newUserData.Email)
-		if err != nil && err != sql.ErrNoRows {
-			// If any error other than ErrNoRows is returned this means something unexpected happened.
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			return
-		}
-
-		// If existingUser contains data, this means there already exists a database entry for the requested email
-		if existingUser.Email != "" {
-			res.Status = http.StatusConflict
-			res.Error = toPtr("email is already registered")
-			return
-		}
-
-		// Validate the password criteria
-		if err := passwordvalidator.Validate(newUserData.Password, minEntropy); err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(fmt.Sprintf("invalid password: %v", err))
-			return
-		}
-
-		// Hash the password
-		hash, err := auth.CreateHash(newUserData.Password)
+		user, err := createUser(r.Context(), query, cfg, newUserData)
 		if err != nil {
-			log.Printf("error hashing password: %v", err)
-			res.Status = http.StatusInternalServerError
-			return
-		}
-
-		// Save the user to the database
-		createUserParams := database.CreateUserParams{
-			Email:          newUserData.Email,
-			HashedPassword: hash,
-		}
-
-		dbResponse, err := query.CreateUser(r.Context(), createUserParams)
-		if err != nil {
-			log.Printf("error creating user: %v", err.Error())
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
 
 		res.Status = http.StatusCreated
-		res.Content = dbResponse
+		res.Content = user
 	})
 }
 
-func handleListUsers(query *database.Queries) http.Handler {
-	var res response
+func handleListUsers(query dbQuerier) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		users, err := query.ListUsers(r.Context())
 		if err != nil {
-			log.Printf("error listing users: %v", err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(InternalError.Wrap(fmt.Errorf("error listing users: %w", err)))
 			return
 		}
 
@@ -249,27 +184,24 @@ func handleListUsers(query *database.Queries) http.Handler {
 }
 
 func handleGetUser(query dbQuerier) http.Handler {
-	var res response
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 		// Parse id from URL path
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr("invalid id")
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
 		user, err := query.GetUserById(r.Context(), id)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				res.Status = http.StatusNotFound
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
+				res.fail(NotFoundError)
 				return
 			}
-			w.WriteHeader(http.StatusInternalServerError)
-			res.Status = http.StatusInternalServerError
+			res.fail(InternalError.Wrap(err))
 			return
 		}
 
@@ -281,59 +213,47 @@ func handleGetUser(query dbQuerier) http.Handler {
 	})
 }
 
-func handleUpdateUser(query *database.Queries) http.Handler {
-	var res response
+func handleUpdateUser(query dbQuerier, cfg *Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		// Parse id from URL query
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr("invalid id")
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		log.Printf("user id %s update", id.String())
-
-		newUserData, err := decode[userRequestData](r.Body)
+		newUserData, err := decodeBody[userRequestData](r.Body)
 		if err != nil {
-			res.Status = http.StatusBadRequest
+			res.fail(err)
 			return
 		}
 
-		log.Println(newUserData)
-
 		if _, err := query.GetUserById(r.Context(), id); err != nil {
 			if err == sql.ErrNoRows {
-				res.Status = http.StatusBadRequest
-				res.Error = toPtr("user not found")
+				res.fail(NotFoundError.WithDetail("user not found"))
 				return
 			}
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(InternalError.Wrap(err))
 			return
 		}
 
 		// Validate the password criteria
 		if err := passwordvalidator.Validate(newUserData.Password, minEntropy); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(fmt.Sprintf("invalid password: %v", err))
+			res.fail(InsecurePasswordError.WithDetail("%v", err))
 			return
 		}
 
 		// Hash password
-		hashedPw, err := auth.CreateHash(newUserData.Password)
+		hashedPw, err := hashPassword(newUserData.Password, cfg.Auth.BcryptCost)
 		if err != nil {
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(InternalError.Wrap(err))
 			return
 		}
 
-		log.Printf("new hash calculated %s", hashedPw)
-
 		params := database.UpdateUserParams{
 			ID:             id,
 			Email:          newUserData.Email,
@@ -343,35 +263,35 @@ func handleUpdateUser(query *database.Queries) http.Handler {
 
 		updatedUser, err := query.UpdateUser(r.Context(), params)
 		if err != nil {
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(InternalError.Wrap(err))
 			return
 		}
+
+		// Sanitize output
+		updatedUser.HashedPassword = ""
+
 		res.Status = http.StatusOK
 		res.Content = updatedUser
 	})
 }
 
 func handleDeleteUser(query dbQuerier) http.Handler {
-	var res response
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 		// Parse id from URL query
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
 		if _, err := query.DeleteUser(r.Context(), id); err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr("user not found")
-				res.Status = http.StatusNotFound
+				res.fail(NotFoundError.WithDetail("user not found"))
 			} else {
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
+				res.fail(InternalError.Wrap(err))
 			}
 			return
 		}
@@ -380,74 +300,62 @@ func handleDeleteUser(query dbQuerier) http.Handler {
 }
 
 // --- Card handlers
-func handleListCards(query *database.Queries) http.Handler {
-	var res response
+func handleListCards(db dbQuerier) http.Handler {
+	cards := newCardService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		// TODO: Authorization should be added to differentiate between all and user specific database entries.
-		dbCards, err := query.ListCardsForOwner(r.Context(), userId)
+		dbCards, err := cards.List(r.Context(), userId)
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
+		etag := collectionETag(dbCards, func(c database.Card) string {
+			return c.ID.String() + ":" + strconv.Itoa(int(c.Version))
+		})
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
+			return
+		}
+
 		res.Content = dbCards
 		res.Status = http.StatusOK
 	})
 }
 
-func handleDeleteCard(query dbQuerier) http.Handler {
-	var res response
+func handleDeleteCard(db dbQuerier) http.Handler {
+	cards := newCardService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr("invalid id")
-			res.Status = http.StatusBadRequest
-		}
-
-		card, err := query.GetCard(r.Context(), id)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
-		if card.Owner != userId {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
-		}
 
-		if _, err := query.DeleteCard(r.Context(), id); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+		if err := cards.Delete(r.Context(), userId, id, ifMatch(r)); err != nil {
+			res.fail(err)
 			return
 		}
 		res.Status = http.StatusNoContent
@@ -455,89 +363,68 @@ func handleDeleteCard(query dbQuerier) http.Handler {
 }
 
 func handleCreateCard(db dbQuerier) http.Handler {
-	var res response
+	cards := newCardService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
-		newCard, err := decode[cardRequest](r.Body)
+		newCard, err := decodeBody[cardRequest](r.Body)
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			res.Status = http.StatusBadRequest
+			res.fail(err)
 			return
 		}
 
-		if _, err := db.GetCardByName(r.Context(), database.GetCardByNameParams{
-			Name:  newCard.Name,
-			Owner: userId,
-		}); err != nil {
-			// If the card does not exist, this is a good thing. Otherwise something unexpected happened
-			if !errors.Is(err, sql.ErrNoRows) {
-				log.Printf("error getting existing card: %v", err)
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-				return
-			}
-		}
-
-		card, err := db.CreateCard(r.Context(), database.CreateCardParams{
-			Name:      newCard.Name,
-			ExpiresAt: newCard.ExpiresAt,
-			Owner:     userId,
-		})
-
+		card, err := cards.Create(r.Context(), userId, newCard)
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
 
+		w.Header().Set("ETag", versionETag(card.Version))
+		w.Header().Set("Location", "/api/cards/"+card.ID.String())
 		res.Status = http.StatusCreated
 		res.Content = card
 	})
 }
 
-func handleGetCard(query dbQuerier) http.Handler {
-	var res response
+func handleGetCard(db dbQuerier) http.Handler {
+	cards := newCardService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr("invalid id")
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
-		card, err := query.GetCard(r.Context(), id)
+		card, err := cards.Get(r.Context(), userId, id)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
-		if card.Owner != userId {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+
+		etag := versionETag(card.Version)
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
@@ -547,62 +434,85 @@ func handleGetCard(query dbQuerier) http.Handler {
 }
 
 func handleUpdateCard(db dbQuerier) http.Handler {
-	var res response
+	cards := newCardService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr("invalid id")
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		existingCard, err := db.GetCard(r.Context(), id)
+		requestBody, err := decodeBody[cardRequest](r.Body)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			log.Printf("error getting existing card: %v", err)
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
+			return
+		}
+
+		card, err := cards.Update(r.Context(), userId, id, ifMatch(r), replace[database.Card](requestBody))
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(card.Version))
+		res.Content = card
+		res.Status = http.StatusOK
+	})
+}
+
+/*
+handlePatchCard partially updates a card using a JSON Merge Patch (RFC 7396) document.
+Only the members present in the patch are changed, the merged card is validated before it is saved.
+*/
+func handlePatchCard(db dbQuerier) http.Handler {
+	cards := newCardService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		if existingCard.Owner != userId {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+
+		if !isMergePatch(r) {
+			res.fail(UnsupportedMediaTypeError.WithDetail("content type must be %s", mergePatchContentType))
 			return
 		}
 
-		requestBody, err := decode[cardRequest](r.Body)
+		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		card, err := db.UpdateCard(r.Context(), database.UpdateCardParams{
-			Name:      requestBody.Name,
-			ExpiresAt: requestBody.ExpiresAt,
-		})
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			res.fail(bodyError(err, "could not read request body"))
+			return
+		}
+
+		card, err := cards.Update(r.Context(), userId, id, ifMatch(r), patchWith(patch, cardRequestFrom))
 		if err != nil {
-			log.Printf("error updating card: %v", err)
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
 
+		w.Header().Set("ETag", versionETag(card.Version))
 		res.Content = card
 		res.Status = http.StatusOK
 	})
@@ -615,215 +525,191 @@ handleCreateCategory handles the creation of a category.
 It assumes that the request.Context userId key has been set.
 */
 func handleCreateCategory(db dbQuerier) http.Handler {
-	var res response
-
-	type categoryRequestBody = struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-	}
+	categories := newCategoryService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Parse the category Name and description from the request
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
-		requestBody, err := decode[categoryRequestBody](r.Body)
-		if err != nil {
-			log.Println("could not decode body")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			return
-		}
-
-		// Retrieve userId from Context (set by middleware)
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		if _, err := db.CheckExistingCategory(r.Context(), database.CheckExistingCategoryParams{
-			Name:      requestBody.Name,
-			CreatedBy: userId,
-		}); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-				return
-			}
-		} else {
-			res.Content = "category already exists"
-			res.Status = http.StatusConflict
+		requestBody, err := decodeBody[categoryRequest](r.Body)
+		if err != nil {
+			res.fail(err)
 			return
 		}
 
-		// Submit to database
-		category, err := db.CreateCategory(r.Context(), database.CreateCategoryParams{
-			Name:        requestBody.Name,
-			Description: requestBody.Description,
-			CreatedBy:   userId,
-		})
-
+		category, err := categories.Create(r.Context(), userId, requestBody)
 		if err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+			res.fail(err)
 			return
 		}
 
-		res.Status = http.StatusOK
+		w.Header().Set("ETag", versionETag(category.Version))
+		w.Header().Set("Location", "/api/categories/"+category.ID.String())
+		res.Status = http.StatusCreated
 		res.Content = category
 	})
 }
 
 func handleUpdateCategory(db dbQuerier) http.Handler {
-	var res response
-
-	type categoryUpdateRequestBody = struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
-	}
+	categories := newCategoryService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: try out this pattern and refactor if it works
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
-		requestBody, err := decode[categoryUpdateRequestBody](r.Body)
-		if err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			if err := encode(w, res.Status, res); err != nil {
-				log.Printf("%w: %w", ResponseFailureError, err)
-			}
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
 
-		// Get CategoryId from path
 		categoryId, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
-		existingCategory, err := db.GetCategory(r.Context(), categoryId)
+		requestBody, err := decodeBody[categoryRequest](r.Body)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				res.Status = http.StatusNotFound
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-			} else {
-				log.Printf("%w: %w", UnexpectedDbError, &err)
-				res.Status = http.StatusInternalServerError
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			}
+			res.fail(err)
 			return
 		}
 
-		authenticatedUserId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		updatedCategory, err := categories.Update(r.Context(), userId, categoryId, ifMatch(r), replace[database.Category](requestBody))
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(updatedCategory.Version))
+		res.Status = http.StatusOK
+		res.Content = updatedCategory
+	})
+}
+
+/*
+handlePatchCategory partially updates a category using a JSON Merge Patch (RFC 7396) document.
+Only the members present in the patch are changed, the merged category is validated before it is saved.
+*/
+func handlePatchCategory(db dbQuerier) http.Handler {
+	categories := newCategoryService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			// Check that the userId is not malformed
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+			res.fail(ForbiddenError)
 			return
 		}
 
-		if authenticatedUserId != existingCategory.CreatedBy {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		if !isMergePatch(r) {
+			res.fail(UnsupportedMediaTypeError.WithDetail("content type must be %s", mergePatchContentType))
 			return
 		}
 
-		// Submit changes to database
-		updatedCategory, err := db.UpdateCategory(r.Context(), database.UpdateCategoryParams{
-			ID:          categoryId,
-			Name:        requestBody.Name,
-			Description: requestBody.Description,
-		})
+		categoryId, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
+		}
+
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			res.fail(bodyError(err, "could not read request body"))
+			return
+		}
+
+		updatedCategory, err := categories.Update(r.Context(), userId, categoryId, ifMatch(r), patchWith(patch, categoryRequestFrom))
 		if err != nil {
-			log.Printf("%w: %w", UnexpectedDbError, &err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(err)
 			return
 		}
 
+		w.Header().Set("ETag", versionETag(updatedCategory.Version))
 		res.Status = http.StatusOK
 		res.Content = updatedCategory
 	})
 }
 
 func handleListCategory(db dbQuerier) http.Handler {
-	var res response
+	categories := newCategoryService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		// TODO: In future we can do role filtering
 		// - Admin = ListAllCategories
 		// - Normal user = ListByUserID
 
-		// Retrieve userId from Context (set by middleware)
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		categories, err := db.ListCategoriesForUserId(r.Context(), userId)
+		userCategories, err := categories.List(r.Context(), userId)
 		if err != nil {
-			log.Printf("%w: %w", UnexpectedDbError, &err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(err)
+			return
+		}
+
+		etag := collectionETag(userCategories, func(c database.Category) string {
+			return c.ID.String() + ":" + strconv.Itoa(int(c.Version))
+		})
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
 		res.Status = http.StatusOK
-		res.Content = categories
+		res.Content = userCategories
 	})
 }
 
 func handleGetCategory(db dbQuerier) http.Handler {
-	var res response
+	categories := newCategoryService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
-		// Retrieve the currently authenticated user from context
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		// Parse id from URL path
-		log.Println(r.PathValue("id"))
-		log.Println(r.URL.String())
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		category, err := db.GetCategory(r.Context(), id)
+		category, err := categories.Get(r.Context(), userId, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			w.WriteHeader(http.StatusInternalServerError)
+			res.fail(err)
 			return
 		}
 
-		if category.CreatedBy != userId {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		etag := versionETag(category.Version)
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
@@ -833,236 +719,125 @@ func handleGetCategory(db dbQuerier) http.Handler {
 }
 
 func handleDeleteCategory(db dbQuerier) http.Handler {
-	var res response
+	categories := newCategoryService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
-		// Retrieve the currently authenticated user from context
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			return
-		}
-		userId := val.(uuid.UUID)
+		defer res.respond(w, r)
 
-		// Parse id from URL path
-		id, err := uuid.Parse(r.PathValue("id"))
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
 
-		// Retrieve the existing category
-		category, err := db.GetCategory(r.Context(), id)
+		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			if err == sql.ErrNoRows {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			w.WriteHeader(http.StatusInternalServerError)
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		// Check if the currently authenticated user is allowed to delete
-		if category.CreatedBy != userId {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		if err := categories.Delete(r.Context(), userId, id, ifMatch(r)); err != nil {
+			res.fail(err)
 			return
 		}
-
-		// Delete the category
-		if _, err := db.DeleteCategory(r.Context(), id); err != nil {
-			if err == sql.ErrNoRows {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-			} else {
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-			}
-			return
-		}
-
 		res.Status = http.StatusNoContent
 	})
 }
 
 // --- Subscription handlers
 func handleCreateSubscription(db dbQuerier) http.Handler {
-	var res response
+	subscriptions := newSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Parse the email and password from the request body
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
-		// Retrieve userId from Context (set by middleware)
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		newSubscriptionData, err := decode[subscriptionRequest](r.Body)
+		newSubscriptionData, err := decodeBody[subscriptionRequest](r.Body)
 		if err != nil {
-			log.Printf("error decoding json: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
+			res.fail(err)
 			return
 		}
 
-		// Check if the subscriptions is already registered
-		existingSubscription, err := db.GetSubscriptionByNameAndCreator(r.Context(), database.GetSubscriptionByNameAndCreatorParams{
-			CreatedBy: userId,
-			Name:      newSubscriptionData.Name,
-		})
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			// If any error other than ErrNoRows is returned this means something unexpected happened.
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-
-			if err := encode(w, res.Status, res); err != nil {
-				log.Printf("could not encode response: %v", err)
-			}
-
-			return
-		}
-
-		// If existingSubscription contains data, this means there already exists a database entry for the requested email
-		if existingSubscription.Name != "" {
-			res.Status = http.StatusConflict
-			res.Error = toPtr("subscription is already registered")
-
-			if err := encode(w, res.Status, res); err != nil {
-				log.Printf("could not encode response: %v", err)
-			}
-
-			return
-		}
-
-		// Save the user to the database
-		createSubscriptionParams := database.CreateSubscriptionParams{
-			CreatedBy:      userId,
-			Name:           newSubscriptionData.Name,
-			MonthlyCost:    newSubscriptionData.MonthlyCost,
-			Currency:       newSubscriptionData.Currency,
-			Description:    newSubscriptionData.Description,
-			UnsubscribeUrl: newSubscriptionData.UnsubscribeUrl,
-			CategoryID:     newSubscriptionData.CategoryId,
-		}
-
-		dbResponse, err := db.CreateSubscription(r.Context(), createSubscriptionParams)
+		subscription, err := subscriptions.Create(r.Context(), userId, newSubscriptionData)
 		if err != nil {
-			log.Printf("error creating user: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			res.fail(err)
 			return
 		}
 
-		res.Content = dbResponse
+		w.Header().Set("ETag", versionETag(subscription.Version))
+		w.Header().Set("Location", "/api/subscriptions/"+subscription.ID.String())
+		res.Content = subscription
 		res.Status = http.StatusCreated
 	})
 }
 
 func handleDeleteSubscription(db dbQuerier) http.Handler {
-	var res response
+	subscriptions := newSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the currently authenticated user from context
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			return
-		}
-		userId := val.(uuid.UUID)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
-		// Parse id from URL path
-		id, err := uuid.Parse(r.PathValue("id"))
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
 
-		// Retrieve the existing category
-		subscription, err := db.GetSubscription(r.Context(), id)
+		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			w.WriteHeader(http.StatusInternalServerError)
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		// Check if the currently authenticated user is allowed to delete
-		if subscription.CreatedBy != userId {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		if err := subscriptions.Delete(r.Context(), userId, id, ifMatch(r)); err != nil {
+			res.fail(err)
 			return
 		}
-
-		// Delete the category
-		if _, err := db.DeleteSubscription(r.Context(), id); err != nil {
-			if err == sql.ErrNoRows {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-			} else {
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-			}
-			encode(w, res.Status, res)
-			return
-		}
-
 		res.Status = http.StatusNoContent
 	})
 }
 
 func handleGetSubscription(db dbQuerier) http.Handler {
-	var res response
+	subscriptions := newSubscriptionService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
-		// Retrieve the currently authenticated user from context
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		// Parse id from URL path
-		log.Println(r.PathValue("id"))
-		log.Println(r.URL.String())
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		subscription, err := db.GetSubscription(r.Context(), id)
+		subscription, err := subscriptions.Get(r.Context(), userId, id)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			w.WriteHeader(http.StatusInternalServerError)
+			res.fail(err)
 			return
 		}
 
-		if subscription.CreatedBy != userId {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		etag := versionETag(subscription.Version)
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
@@ -1072,105 +847,123 @@ func handleGetSubscription(db dbQuerier) http.Handler {
 }
 
 func handleListSubscription(db dbQuerier) http.Handler {
-	var res response
+	subscriptions := newSubscriptionService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
 		// TODO: In future we can do role filtering
 		// - Admin = ListAllCategories
 		// - Normal user = ListByUserID
 
-		// Retrieve userId from Context (set by middleware)
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		subscriptions, err := db.ListSubscriptionsForUserId(r.Context(), userId)
+		userSubscriptions, err := subscriptions.List(r.Context(), userId)
 		if err != nil {
-			log.Printf("%v: %v", UnexpectedDbError, &err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(err)
+			return
+		}
+
+		etag := collectionETag(userSubscriptions, func(s database.Subscription) string {
+			return s.ID.String() + ":" + strconv.Itoa(int(s.Version))
+		})
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
-		log.Println(subscriptions)
 
 		res.Status = http.StatusOK
-		res.Content = subscriptions
+		res.Content = userSubscriptions
 	})
 }
 
 func handleUpdateSubscription(db dbQuerier) http.Handler {
-	var res response
+	subscriptions := newSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
-		requestBody, err := decode[subscriptionRequest](r.Body)
-		if err != nil {
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			if err := encode(w, res.Status, res); err != nil {
-				log.Printf("%w: %w", ResponseFailureError, err)
-			}
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
 
-		// Get CategoryId from path
 		subscriptionId, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
-		existingSubscription, err := db.GetSubscription(r.Context(), subscriptionId)
+		requestBody, err := decodeBody[subscriptionRequest](r.Body)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Status = http.StatusNotFound
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-			} else {
-				log.Printf("%v: %v", UnexpectedDbError, &err)
-				res.Status = http.StatusInternalServerError
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			}
+			res.fail(err)
 			return
 		}
 
-		authenticatedUserId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		updatedSubscription, err := subscriptions.Update(r.Context(), userId, subscriptionId, ifMatch(r), replace[database.Subscription](requestBody))
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(updatedSubscription.Version))
+		res.Status = http.StatusOK
+		res.Content = updatedSubscription
+	})
+}
+
+/*
+handlePatchSubscription partially updates a subscription using a JSON Merge Patch (RFC 7396) document.
+Only the members present in the patch are changed, the merged subscription is validated before it is saved.
+*/
+func handlePatchSubscription(db dbQuerier) http.Handler {
+	subscriptions := newSubscriptionService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			// Will fail if the userId value cannot be cast into an uuid.UUID
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+			res.fail(ForbiddenError)
 			return
 		}
 
-		if authenticatedUserId != existingSubscription.CreatedBy {
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
+		if !isMergePatch(r) {
+			res.fail(UnsupportedMediaTypeError.WithDetail("content type must be %s", mergePatchContentType))
 			return
 		}
 
-		// Submit changes to database
-		updatedSubscription, err := db.UpdateSubscription(r.Context(), database.UpdateSubscriptionParams{
-			ID:             subscriptionId,
-			Name:           requestBody.Name,
-			MonthlyCost:    requestBody.MonthlyCost,
-			Currency:       requestBody.Currency,
-			UnsubscribeUrl: requestBody.UnsubscribeUrl,
-			Description:    requestBody.Description,
-			CategoryID:     requestBody.CategoryId,
-		})
+		subscriptionId, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			log.Printf("%v: %v", UnexpectedDbError, &err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			res.fail(bodyError(err, "could not read request body"))
+			return
+		}
+
+		updatedSubscription, err := subscriptions.Update(r.Context(), userId, subscriptionId, ifMatch(r), patchWith(patch, subscriptionRequestFrom))
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(updatedSubscription.Version))
 		res.Status = http.StatusOK
 		res.Content = updatedSubscription
 	})
@@ -1178,25 +971,31 @@ func handleUpdateSubscription(db dbQuerier) http.Handler {
 
 // --- Active subscription handlers
 func handleListActiveSubscription(db dbQuerier) http.Handler {
-	var res response
+	activeSubscriptions := newActiveSubscriptionService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
 
-		// Retrieve userId from Context (set by middleware)
-		val := r.Context().Value(userIdCtxKey)
-		if val == nil {
-			log.Println("could not retrieve userId from context")
-			res.Status = http.StatusBadRequest
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
 			return
 		}
-		userId := val.(uuid.UUID)
 
-		active_subscriptions, err := db.ListActiveSubscriptionByUserId(r.Context(), userId)
+		active_subscriptions, err := activeSubscriptions.List(r.Context(), userId)
 		if err != nil {
-			log.Printf("%v: %v", UnexpectedDbError, &err)
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
+			res.fail(err)
+			return
+		}
+
+		etag := collectionETag(active_subscriptions, func(a database.ActiveSubscription) string {
+			return a.ID.String() + ":" + strconv.Itoa(int(a.Version))
+		})
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
@@ -1206,36 +1005,35 @@ func handleListActiveSubscription(db dbQuerier) http.Handler {
 }
 
 func handleGetActiveSubscription(db dbQuerier) http.Handler {
-	var res response
+	activeSubscriptions := newActiveSubscriptionService(db)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer res.respond(w)
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
+
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr("invalid id")
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
-		active_subscription, err := db.GetActiveSubscriptionById(r.Context(), id)
+		active_subscription, err := activeSubscriptions.Get(r.Context(), userId, id)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
-		if active_subscription.UserID != userId {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+
+		etag := versionETag(active_subscription.Version)
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			res.Status = http.StatusNotModified
 			return
 		}
 
@@ -1245,93 +1043,112 @@ func handleGetActiveSubscription(db dbQuerier) http.Handler {
 }
 
 func handleUpdateActiveSubscription(db dbQuerier) http.Handler {
-	var res response
+	activeSubscriptions := newActiveSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			res.Error = toPtr("invalid id")
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		existingActiveSub, err := db.GetActiveSubscriptionById(r.Context(), id)
+		requestBody, err := decodeBody[activeSubscriptionUpdateRequest](r.Body)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr(http.StatusText(http.StatusNotFound))
-				res.Status = http.StatusNotFound
-				return
-			}
-			log.Printf("error getting existing card: %v", err)
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(err)
 			return
 		}
-		if existingActiveSub.UserID != userId {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+
+		activeSub, err := activeSubscriptions.Update(r.Context(), userId, id, ifMatch(r), replace[database.ActiveSubscription](requestBody))
+		if err != nil {
+			res.fail(err)
 			return
 		}
 
-		requestBody, err := decode[activeSubscriptionUpdateRequest](r.Body)
+		w.Header().Set("ETag", versionETag(activeSub.Version))
+		res.Content = activeSub
+		res.Status = http.StatusOK
+	})
+}
+
+/*
+handlePatchActiveSubscription partially updates an active subscription using a JSON Merge Patch (RFC 7396) document.
+Only the members present in the patch are changed, the merged active subscription is validated before it is saved.
+*/
+func handlePatchActiveSubscription(db dbQuerier) http.Handler {
+	activeSubscriptions := newActiveSubscriptionService(db)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer r.Body.Close()
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
+			return
+		}
+
+		if !isMergePatch(r) {
+			res.fail(UnsupportedMediaTypeError.WithDetail("content type must be %s", mergePatchContentType))
+			return
+		}
+
+		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			log.Println("Bad request body: ", err)
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			res.Status = http.StatusBadRequest
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
 			return
 		}
 
-		activeSub, err := db.UpdateActiveSubscription(r.Context(), database.UpdateActiveSubscriptionParams{
-			ID:               id,
-			BillingFrequency: requestBody.BillingFrequency,
-			AutoRenewEnabled: sql.NullBool{
-				Bool:  *requestBody.AutoRenewEnabled,
-				Valid: true,
-			},
-		})
+		patch, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Printf("error updating active subscription: %v", err)
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(bodyError(err, "could not read request body"))
 			return
 		}
 
+		activeSub, err := activeSubscriptions.Update(r.Context(), userId, id, ifMatch(r), patchWith(patch, activeSubscriptionUpdateRequestFrom))
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(activeSub.Version))
 		res.Content = activeSub
 		res.Status = http.StatusOK
 	})
 }
 
-func handleDeleteActiveSubscription(query dbQuerier) http.Handler {
-	var res response
+func handleDeleteActiveSubscription(db dbQuerier) http.Handler {
+	activeSubscriptions := newActiveSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
-		// Parse id from URL query
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
+			return
+		}
+
 		id, err := uuid.Parse(r.PathValue("id"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("invalid id"))
+			res.fail(InvalidRequestError.WithDetail("invalid id"))
+			return
 		}
 
-		if _, err := query.DeleteActiveSubscription(r.Context(), id); err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				res.Error = toPtr("user not found")
-				res.Status = http.StatusNotFound
-			} else {
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-			}
+		if err := activeSubscriptions.Delete(r.Context(), userId, id, ifMatch(r)); err != nil {
+			res.fail(err)
 			return
 		}
 		res.Status = http.StatusNoContent
@@ -1339,52 +1156,108 @@ func handleDeleteActiveSubscription(query dbQuerier) http.Handler {
 }
 
 func handleCreateActiveSubscription(db dbQuerier) http.Handler {
-	var res response
+	activeSubscriptions := newActiveSubscriptionService(db)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
 		defer r.Body.Close()
-		defer res.respond(w)
+		defer res.respond(w, r)
 
 		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
 		if !ok {
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			res.Status = http.StatusForbidden
+			res.fail(ForbiddenError)
 			return
 		}
 
-		newActiveSubscription, err := decode[activeSubscriptionRequest](r.Body)
+		newActiveSubscription, err := decodeBody[activeSubscriptionRequest](r.Body)
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusBadRequest))
-			res.Status = http.StatusBadRequest
+			res.fail(err)
 			return
 		}
 
-		if _, err := db.GetActiveSubscriptionByUserIdAndSubId(r.Context(), database.GetActiveSubscriptionByUserIdAndSubIdParams{UserID: userId, SubscriptionID: newActiveSubscription.SubscriptionID}); err != nil {
-			if !errors.Is(err, sql.ErrNoRows) {
-				log.Printf("error getting existing card: %v", err)
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				res.Status = http.StatusInternalServerError
-				return
-			}
+		activeSubscription, err := activeSubscriptions.Create(r.Context(), userId, newActiveSubscription)
+		if err != nil {
+			res.fail(err)
+			return
+		}
+
+		w.Header().Set("ETag", versionETag(activeSubscription.Version))
+		w.Header().Set("Location", "/api/activesubscriptions/"+activeSubscription.ID.String())
+		res.Status = http.StatusCreated
+		res.Content = activeSubscription
+	})
+}
+
+// --- Search handlers
+
+// searchResultLimit caps the number of hits returned per resource type.
+const searchResultLimit = 25
+
+/*
+handleSearch runs a full-text search over the authenticated user's subscriptions, categories and cards.
+Hits are ranked by relevance and grouped by resource type. The search terms are read from the q query parameter
+and support the websearch syntax ("quoted phrases", -exclusions and OR).
+*/
+func handleSearch(db dbQuerier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res response
+		defer res.respond(w, r)
+
+		userId, ok := r.Context().Value(userIdCtxKey).(uuid.UUID)
+		if !ok {
+			res.fail(ForbiddenError)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			res.fail(InvalidRequestError.WithDetail("missing search query"))
+			return
 		}
 
-		activeSubscription, err := db.CreateActiveSubscription(r.Context(), database.CreateActiveSubscriptionParams{
-			SubscriptionID:   newActiveSubscription.SubscriptionID,
-			UserID:           userId,
-			CardID:           newActiveSubscription.CardID,
-			UpdatedAt:        time.Now(),
-			BillingFrequency: newActiveSubscription.BillingFrequency,
-			AutoRenewEnabled: newActiveSubscription.AutoRenewEnabled,
+		subscriptions, err := db.SearchSubscriptions(r.Context(), database.SearchSubscriptionsParams{
+			Query:      query,
+			CreatedBy:  userId,
+			MaxResults: searchResultLimit,
 		})
+		if err != nil {
+			res.fail(InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err)))
+			return
+		}
 
+		categories, err := db.SearchCategories(r.Context(), database.SearchCategoriesParams{
+			Query:      query,
+			CreatedBy:  userId,
+			MaxResults: searchResultLimit,
+		})
 		if err != nil {
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			res.Status = http.StatusInternalServerError
+			res.fail(InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err)))
 			return
 		}
 
-		res.Status = http.StatusCreated
-		res.Content = activeSubscription
+		cards, err := db.SearchCards(r.Context(), database.SearchCardsParams{
+			Query:      query,
+			Owner:      userId,
+			MaxResults: searchResultLimit,
+		})
+		if err != nil {
+			res.fail(InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err)))
+			return
+		}
+
+		// Always return lists so clients do not have to distinguish between null and empty
+		results := searchResponseData{
+			Query:         query,
+			Subscriptions: []database.SearchSubscriptionsRow{},
+			Categories:    []database.SearchCategoriesRow{},
+			Cards:         []database.SearchCardsRow{},
+		}
+		results.Subscriptions = append(results.Subscriptions, subscriptions...)
+		results.Categories = append(results.Categories, categories...)
+		results.Cards = append(results.Cards, cards...)
+
+		res.Status = http.StatusOK
+		res.Content = results
 	})
 }
 
@@ -1392,15 +1265,13 @@ func handleCreateActiveSubscription(db dbQuerier) http.Handler {
 
 // Add these new handlers to your existing handlers.go file
 
-func handleLoginForm(dbStore dbQuerier, config *Config) http.Handler {
-	var htmxAlert frontend.HtmxResponse
+func handleLoginForm(dbStore dbQuerier, config *Config, assets *frontend.Assets) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer htmxAlert.Respond(w)
 		defer r.Body.Close()
 
 		err := r.ParseForm()
 		if err != nil {
-			htmxAlert = frontend.InvalidFormDataError
+			assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.InvalidFormDataError)
 			return
 		}
 
@@ -1408,7 +1279,7 @@ func handleLoginForm(dbStore dbQuerier, config *Config) http.Handler {
 		password := r.FormValue("password")
 
 		if email == "" || password == "" {
-			htmxAlert = frontend.EmailAndPasswordError
+			assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.EmailAndPasswordError)
 			return
 		}
 
@@ -1419,36 +1290,31 @@ func handleLoginForm(dbStore dbQuerier, config *Config) http.Handler {
 		}
 
 		// Call existing login logic
-		loginResp := loginUser(r.Context(), dbStore, config.JWTSecret, userData)
-		if loginResp.Error != nil {
-			fmt.Println(*loginResp.Error)
-			htmxAlert = frontend.InvalidEmailOrPasswordError
-			return
-		}
-
-		// Return JSON response for successful login
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
-		loginJSON, err := json.Marshal(loginResp.Content)
+		login, err := loginUser(r.Context(), dbStore, config, userData)
 		if err != nil {
-			htmxAlert = frontend.ServerError
+			if errors.Is(err, InvalidCredentialsError) {
+				assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.InvalidEmailOrPasswordError)
+				return
+			}
+			logging.FromContext(r.Context()).Error("error logging in", "error", err)
+			assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.ServerError)
 			return
 		}
 
-		htmxAlert = frontend.HtmxResponse(loginJSON)
+		// Return JSON response for successful login, the index page stores the tokens
+		if err := encode(w, http.StatusOK, login); err != nil {
+			logging.FromContext(r.Context()).Error("error writing login response", "error", err)
+		}
 	})
 }
 
-func handleRegisterForm(dbStore dbQuerier) http.Handler {
-	var htmxAlert frontend.HtmxResponse
+func handleRegisterForm(dbStore dbQuerier, config *Config, assets *frontend.Assets) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer htmxAlert.Respond(w)
 		defer r.Body.Close()
 
 		err := r.ParseForm()
 		if err != nil {
-			htmxAlert = frontend.InvalidFormDataError
+			assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.InvalidFormDataError)
 			return
 		}
 
@@ -1456,7 +1322,7 @@ func handleRegisterForm(dbStore dbQuerier) http.Handler {
 		password := r.FormValue("password")
 
 		if email == "" || password == "" {
-			htmxAlert = frontend.EmailAndPasswordError
+			assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.EmailAndPasswordError)
 			return
 		}
 
@@ -1467,95 +1333,84 @@ func handleRegisterForm(dbStore dbQuerier) http.Handler {
 		}
 
 		// Call existing user creation logic
-		response := createUser(r.Context(), dbStore, userData)
-		if response.Error != nil {
-			log.Printf("error creating user: %v", *response.Error)
-			// TODO: Convert error into their own types
-			if strings.Contains(*response.Error, "insecure password") || strings.Contains(*response.Error, "invalid password") {
-				htmxAlert = frontend.InsecurePasswordError
-				return
+		if _, err := createUser(r.Context(), dbStore, config, userData); err != nil {
+			alert := frontend.ServerError
+			switch domainErr := asDomainError(err); {
+			case errors.Is(domainErr, InsecurePasswordError):
+				alert = frontend.InsecurePasswordError
+			case errors.Is(domainErr, InvalidEmailError):
+				alert = frontend.InvalidEmailError
+			case errors.Is(domainErr, EmailTakenError):
+				alert = frontend.DuplicateUserError
+			default:
+				logging.FromContext(r.Context()).Error("error creating user", "error", err)
 			}
-			htmxAlert = frontend.DuplicateUserError
+			assets.RenderFragment(w, r, http.StatusOK, "alert", alert)
 			return
 		}
 
-		htmxAlert = frontend.RegistrationSuccess
+		assets.RenderFragment(w, r, http.StatusOK, "alert", frontend.RegistrationSuccess)
 	})
 }
 
-func loginUser(ctx context.Context, db dbQuerier, jwtSecret string, userData userRequestData) *response {
-	var res response
+/*
+loginUser verifies the credentials in userData and issues a JWT together with a refresh token. Unknown emails and
+wrong passwords both result in InvalidCredentialsError, so that clients cannot probe which emails are registered.
+*/
+func loginUser(ctx context.Context, db dbQuerier, cfg *Config, userData userRequestData) (_ loginResponseData, err error) {
+	defer func() { observeLogin(err) }()
+
 	registeredUser, err := db.GetUserByEmail(ctx, userData.Email)
 	if err != nil {
-		// TODO: This if error is ErrNoRows else internal error pattern is reused in quite a lot of different places
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			// User does not exist in database
-			res.Status = http.StatusForbidden
-			res.Error = toPtr(http.StatusText(http.StatusForbidden))
-			return &res
-		} else {
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			return &res
+			return loginResponseData{}, InvalidCredentialsError
 		}
+		return loginResponseData{}, InternalError.Wrap(err)
 	}
 
 	// Validate password
-	if ok := auth.IsValid(userData.Password, registeredUser.HashedPassword); !ok {
-		res.Status = http.StatusForbidden
-		res.Error = toPtr("invalid password")
-		return &res
+	if ok := passwordMatches(userData.Password, registeredUser.HashedPassword); !ok {
+		return loginResponseData{}, InvalidCredentialsError
 	}
 
-	// Make JWT token, a token lives for 60 minutes.
-	jwt, err := auth.MakeJWT(registeredUser.ID, jwtSecret, time.Minute*60)
+	// Make JWT token
+	jwt, err := auth.MakeJWT(registeredUser.ID, cfg.JWTSecret, cfg.Auth.AccessTokenLifetime)
 	if err != nil {
-		res.Status = http.StatusInternalServerError
-		res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-		return &res
+		return loginResponseData{}, InternalError.Wrap(err)
 	}
 
 	// Check if we need to create a refresh token or not
 	refreshToken, err := db.GetRefreshToken(ctx, registeredUser.ID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			newTokenString, err := auth.MakeRefreshToken()
-			if err != nil {
-				res.Status = http.StatusInternalServerError
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				return &res
-			}
-			refreshTokenOpts := database.CreateRefreshTokenParams{
-				UserID:    registeredUser.ID,
-				ExpiresAt: time.Now().Add(time.Hour * 1440), // 60 days
-				Token:     newTokenString,
-			}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return loginResponseData{}, InternalError.Wrap(err)
+		}
 
-			newToken, err := db.CreateRefreshToken(ctx, refreshTokenOpts)
-			if err != nil {
-				res.Status = http.StatusInternalServerError
-				res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-				return &res
-			}
-			refreshToken = newToken
-		} else {
-			res.Status = http.StatusInternalServerError
-			res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-			return &res
+		newTokenString, err := auth.MakeRefreshToken()
+		if err != nil {
+			return loginResponseData{}, InternalError.Wrap(err)
+		}
+		refreshTokenOpts := database.CreateRefreshTokenParams{
+			UserID:    registeredUser.ID,
+			ExpiresAt: time.Now().Add(cfg.Auth.RefreshTokenLifetime),
+			Token:     newTokenString,
+		}
+
+		refreshToken, err = db.CreateRefreshToken(ctx, refreshTokenOpts)
+		if err != nil {
+			return loginResponseData{}, InternalError.Wrap(err)
 		}
 	}
 
 	// TODO: BUG where a user cannot login when they have a revoked refresh token (because a new one is generated by cannot be inserted into the db)
 
-	// Create response body
-	res.Status = http.StatusOK
-	res.Content = loginResponseData{
+	return loginResponseData{
 		Id:           registeredUser.ID,
 		Email:        registeredUser.Email,
 		CreatedAt:    registeredUser.CreatedAt,
 		UpdatedAt:    registeredUser.UpdatedAt,
 		Token:        jwt,
 		RefreshToken: refreshToken.Token,
-	}
-	return &res
+	}, nil
 }
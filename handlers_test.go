@@ -9,148 +9,70 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/benkoben/unsubtle-core/internal/database"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type fakeDatabaseOptions struct {
-	// Enables us to test error handling
+	// Enables us to test error handling, it is returned by every query
 	raiseError error
 
-	// Controls the behaviour of all GetUserXX methods
+	// Registers ben@example.com, the email the user tests sign up with
 	userExists bool
-}
 
-type fakeDatabaseQueries struct {
-	err        error
-	userExists bool
+	// Owner of the subscription and the active subscription in the store
+	owner uuid.UUID
 }
 
-func (db fakeDatabaseQueries) GetUserById(_ context.Context, id uuid.UUID) (database.User, error) {
-	if db.err != nil {
-		return database.User{}, db.err
-	}
-
-	log.Println("Returning mocked user")
-	// Create a mock user
-	u := database.User{
-		ID:        id,
-		Email:     "example@unsubtle-unit-test.com",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	return u, nil
-}
-func (db fakeDatabaseQueries) DeleteUser(ctx context.Context, id uuid.UUID) (sql.Result, error) {
-	if db.err != nil {
-		return nil, db.err
-	}
+// The rows newHttpServer puts in the store
+const (
+	fakeUserId               = "7231ee05-b199-4364-83df-94fabb0c1a41"
+	fakeSubscriptionId       = "5b0c2d4e-8a53-4b1c-9a59-7f0b1c2d3e4f"
+	fakeActiveSubscriptionId = "0e6d1c3b-29f4-4d8a-b7c5-3a1f9e8d2c6b"
+)
 
-	return nil, nil
-}
+// fakeResourceVersion is the version of all versioned rows newHttpServer puts in the store
+const fakeResourceVersion = 3
 
-func (db fakeDatabaseQueries) CreateUser(_ context.Context, _ database.CreateUserParams) (database.CreateUserRow, error) {
-	if db.err != nil {
-		return database.CreateUserRow{}, db.err
-	}
+// newFakeStore returns a memoryStore holding the rows the handler tests work with.
+func newFakeStore(opts fakeDatabaseOptions) *memoryStore {
+	store := failingStore(opts.raiseError)
 
-	return database.CreateUserRow{
-		ID:        uuid.New(),
+	now := time.Now()
+	store.users[uuid.MustParse(fakeUserId)] = database.User{
+		ID:        uuid.MustParse(fakeUserId),
 		Email:     "example@unsubtle-unit-test.com",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}, nil
-}
-
-func (db fakeDatabaseQueries) GetUserByEmail(ctx context.Context, email string) (database.User, error) {
-	if db.err != nil {
-		return database.User{}, db.err
-	}
-
-	if db.userExists {
-		// return a mock user
-		u := database.User{
-			ID:        uuid.New(),
-			Email:     "example@unsubtle-unit-test.com",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-		return u, nil
-	}
-
-	return database.User{}, nil
-}
-
-// Refresh token interactions
-
-func (db fakeDatabaseQueries) CreateRefreshToken(context.Context, database.CreateRefreshTokenParams) (database.RefreshToken, error) {
-	if db.err != nil {
-		return database.RefreshToken{}, db.err
-	}
-	return database.RefreshToken{}, nil
-}
-
-func (db fakeDatabaseQueries) UpdateRefreshToken(context.Context, database.UpdateRefreshTokenParams) (database.RefreshToken, error){
-	if db.err != nil {
-		return database.RefreshToken{}, db.err
-	}
-	return database.RefreshToken{}, nil
-
-}
-
-func (db fakeDatabaseQueries) RevokeRefreshToken(context.Context, uuid.UUID) (database.RefreshToken, error){
-	if db.err != nil {
-		return database.RefreshToken{}, db.err
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
-	return database.RefreshToken{}, nil
-
-}
-
-func (db fakeDatabaseQueries) GetRefreshToken(context.Context, uuid.UUID) (database.RefreshToken, error){
-	if db.err != nil {
-		return database.RefreshToken{}, db.err
+	if opts.userExists {
+		user := database.User{ID: uuid.New(), Email: "ben@example.com", CreatedAt: now, UpdatedAt: now}
+		store.users[user.ID] = user
 	}
-	return database.RefreshToken{}, nil
-}
-
-// Category interactions
-func (db fakeDatabaseQueries) UpdateCategory(context.Context, database.UpdateCategoryParams) (database.Category, error) {
-	if db.err != nil {
-		return database.Category{}, db.err
+	store.subscriptions[uuid.MustParse(fakeSubscriptionId)] = database.Subscription{
+		ID:          uuid.MustParse(fakeSubscriptionId),
+		Name:        "Netflix",
+		MonthlyCost: 129,
+		Currency:    "SEK",
+		Description: sql.NullString{String: "Streaming", Valid: true},
+		CreatedBy:   opts.owner,
+		Version:     fakeResourceVersion,
 	}
-	return database.Category{}, nil
-}
-
-func (db fakeDatabaseQueries) ResetCategories(context.Context) ([]database.Category, error) {
-	if db.err != nil {
-		return nil, db.err
+	store.activeSubscriptions[uuid.MustParse(fakeActiveSubscriptionId)] = database.ActiveSubscription{
+		ID:               uuid.MustParse(fakeActiveSubscriptionId),
+		SubscriptionID:   uuid.MustParse(fakeSubscriptionId),
+		UserID:           opts.owner,
+		BillingFrequency: "monthly",
+		AutoRenewEnabled: sql.NullBool{Bool: true, Valid: true},
+		Version:          fakeResourceVersion,
 	}
-	return nil, nil
-}
-
-func (db fakeDatabaseQueries) ListCategories(context.Context) ([]database.Category, error) {
-	if db.err != nil {
-		return nil, db.err
-	}
-	return nil, nil
-}
-
-func (db fakeDatabaseQueries) GetCategory(context.Context, uuid.UUID) (database.Category, error) {
-	if db.err != nil {
-		return database.Category{}, db.err
-	}
-	return database.Category{}, nil
-}
-
-func (db fakeDatabaseQueries) CreateCategory(context.Context, database.CreateCategoryParams) (database.Category, error) {
-	if db.err != nil {
-		return database.Category{}, db.err
-	}
-	return database.Category{}, nil
+	return store
 }
 
 func TestHandlerDeleteUser(t *testing.T) {
@@ -159,7 +81,7 @@ func TestHandlerDeleteUser(t *testing.T) {
 	t.Run("StatusNotFound when the requested user ID does not exist", func(t *testing.T) {
 		srv := newHttpServer(pattern, handleDeleteUser, fakeDatabaseOptions{raiseError: sql.ErrNoRows})
 
-		request := newDeleteUserRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newDeleteUserRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		srv.Handler.ServeHTTP(response, request)
@@ -171,7 +93,7 @@ func TestHandlerDeleteUser(t *testing.T) {
 	t.Run("StatusInternalServerError when an unexpected database interaction failure", func(t *testing.T) {
 		srv := newHttpServer(pattern, handleDeleteUser, fakeDatabaseOptions{raiseError: errors.New("random error")})
 
-		request := newDeleteUserRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newDeleteUserRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		srv.Handler.ServeHTTP(response, request)
@@ -183,7 +105,7 @@ func TestHandlerDeleteUser(t *testing.T) {
 	t.Run("Successful deletion", func(t *testing.T) {
 		srv := newHttpServer(pattern, handleDeleteUser, fakeDatabaseOptions{raiseError: nil})
 
-		request := newDeleteUserRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newDeleteUserRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		srv.Handler.ServeHTTP(response, request)
@@ -199,7 +121,7 @@ func TestHandlerCreateUser(t *testing.T) {
 
 	t.Run("Mailformed json should return status bad request", func(t *testing.T) {
 		body := strings.NewReader("{\"email: \"ben@example.com\"")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -212,7 +134,7 @@ func TestHandlerCreateUser(t *testing.T) {
 
 	t.Run("Invalid email should return status bad request", func(t *testing.T) {
 		body := strings.NewReader("{\"email\": \"benexample.com\", \"password\": \"Syp9393\"}")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -225,7 +147,7 @@ func TestHandlerCreateUser(t *testing.T) {
 
 	t.Run("If the mail already exists status conflict is expected", func(t *testing.T) {
 		body := strings.NewReader("{\"email\": \"ben@example.com\", \"password\": \"Syp9393\"}")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{userExists: true})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{userExists: true})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -238,7 +160,7 @@ func TestHandlerCreateUser(t *testing.T) {
 
 	t.Run("Unexpected errors in database interactions should return internal server error", func(t *testing.T) {
 		body := strings.NewReader("{\"email\": \"ben@example.com\", \"password\": \"Syp9393\"}")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{raiseError: errors.New("something is about to go wrong")})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{raiseError: errors.New("something is about to go wrong")})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -251,7 +173,7 @@ func TestHandlerCreateUser(t *testing.T) {
 
 	t.Run("If a weak password is used then a BadRequest is expected", func(t *testing.T) {
 		body := strings.NewReader("{\"email\": \"ben@example.com\", \"password\": \"Syp9393\"}")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{raiseError: nil, userExists: false})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{raiseError: nil, userExists: false})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -265,7 +187,7 @@ func TestHandlerCreateUser(t *testing.T) {
 	t.Run("Successful user creation", func(t *testing.T) {
 
 		body := strings.NewReader("{\"email\": \"ben@example.com\", \"password\": \"Syp9393-Syp9292-Syp9191\"}")
-		srv := newHttpServer(pattern, handleCreateUser, fakeDatabaseOptions{raiseError: nil, userExists: false})
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{raiseError: nil, userExists: false})
 
 		request := newCreateUserRequest(body)
 		response := httptest.NewRecorder()
@@ -285,7 +207,7 @@ func TestHandlerGetUserById(t *testing.T) {
 
 		srv := newHttpServer(pattern, handleGetUser, fakeDatabaseOptions{})
 		// Compose request and response
-		request := newGetUserByIdRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newGetUserByIdRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		// Wanted behaviour
@@ -313,7 +235,7 @@ func TestHandlerGetUserById(t *testing.T) {
 
 		srv := newHttpServer(pattern, handleGetUser, fakeDatabaseOptions{raiseError: sql.ErrNoRows})
 		// Compose request and response
-		request := newGetUserByIdRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newGetUserByIdRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		// Send request
@@ -327,7 +249,7 @@ func TestHandlerGetUserById(t *testing.T) {
 
 		srv := newHttpServer(pattern, handleGetUser, fakeDatabaseOptions{raiseError: errors.New("this is an error")})
 		// Compose request and response
-		request := newGetUserByIdRequest("7231ee05-b199-4364-83df-94fabb0c1a41")
+		request := newGetUserByIdRequest(fakeUserId)
 		response := httptest.NewRecorder()
 
 		// Send request
@@ -338,39 +260,644 @@ func TestHandlerGetUserById(t *testing.T) {
 	})
 }
 
+func TestHandlerSearch(t *testing.T) {
+	pattern := fmt.Sprintf("%s /api/search", http.MethodGet)
+	userId := uuid.MustParse(fakeUserId)
+
+	t.Run("Missing query should return status bad request", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleSearch, fakeDatabaseOptions{})
+
+		request := newAuthenticatedRequest(newSearchRequest("   "), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Unauthenticated requests should return status forbidden", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleSearch, fakeDatabaseOptions{})
+
+		request := newSearchRequest("netflix")
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusForbidden)
+	})
+
+	t.Run("Unexpected errors in database interactions should return internal server error", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleSearch, fakeDatabaseOptions{raiseError: errors.New("search failed")})
+
+		request := newAuthenticatedRequest(newSearchRequest("netflix"), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusInternalServerError)
+	})
+
+	t.Run("Results are grouped by resource type", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleSearch, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newSearchRequest("netflix"), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got, err := decode[struct {
+			Content searchResponseData `json:"content"`
+		}](response.Body)
+		if err != nil {
+			t.Fatalf("handleSearch -> could not decode response: %v", err)
+		}
+
+		if len(got.Content.Subscriptions) != 1 || got.Content.Subscriptions[0].Name != "Netflix" {
+			t.Errorf("handleSearch -> got subscriptions %v, want a single hit", got.Content.Subscriptions)
+		}
+
+		if got.Content.Categories == nil || got.Content.Cards == nil {
+			t.Errorf("handleSearch -> empty groups should be encoded as empty lists")
+		}
+	})
+}
+
+func TestHandlerPatchSubscription(t *testing.T) {
+	pattern := fmt.Sprintf("%s /api/subscriptions/{id}", http.MethodPatch)
+	subscriptionId := fakeSubscriptionId
+	userId := uuid.MustParse(fakeUserId)
+
+	t.Run("Only the supplied members are changed", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"monthly_cost": 149}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got, err := decode[struct {
+			Content database.Subscription `json:"content"`
+		}](response.Body)
+		if err != nil {
+			t.Fatalf("handlePatchSubscription -> could not decode response: %v", err)
+		}
+
+		if got.Content.MonthlyCost != 149 {
+			t.Errorf("handlePatchSubscription -> got monthly_cost %d, want 149", got.Content.MonthlyCost)
+		}
+		if got.Content.Name != "Netflix" || got.Content.Currency != "SEK" || got.Content.Description.String != "Streaming" {
+			t.Errorf("handlePatchSubscription -> members missing from the patch were modified: %+v", got.Content)
+		}
+	})
+
+	t.Run("Nullable members are set from strings", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"description": "new text"}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got, _ := decode[struct {
+			Content database.Subscription `json:"content"`
+		}](response.Body)
+		if !got.Content.Description.Valid || got.Content.Description.String != "new text" {
+			t.Errorf("handlePatchSubscription -> got description %+v, want \"new text\"", got.Content.Description)
+		}
+	})
+
+	t.Run("Null members are removed", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"description": null}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+
+		got, _ := decode[struct {
+			Content database.Subscription `json:"content"`
+		}](response.Body)
+		if got.Content.Description.Valid {
+			t.Errorf("handlePatchSubscription -> description was not removed")
+		}
+	})
+
+	t.Run("The merged result is validated", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"name": null}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Unknown members should return status bad request", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"monthly_costs": 149}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Unsupported content types are rejected", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"monthly_cost": 149}`), userId)
+		request.Header.Set("Content-Type", "text/plain")
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("Subscriptions owned by someone else cannot be patched", func(t *testing.T) {
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: uuid.New()})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+subscriptionId, `{"monthly_cost": 149}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusForbidden)
+	})
+}
+
+func TestSubscriptionPreconditions(t *testing.T) {
+	userId := uuid.MustParse(fakeUserId)
+	subscriptionPath := "/api/subscriptions/" + fakeSubscriptionId
+
+	t.Run("GET returns the version as ETag", func(t *testing.T) {
+		srv := newHttpServer("GET /api/subscriptions/{id}", handleGetSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodGet, subscriptionPath, nil), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if got := response.Header().Get("ETag"); got != versionETag(fakeResourceVersion) {
+			t.Errorf("got ETag %s, want %s", got, versionETag(fakeResourceVersion))
+		}
+	})
+
+	t.Run("GET with a matching If-None-Match returns not modified", func(t *testing.T) {
+		srv := newHttpServer("GET /api/subscriptions/{id}", handleGetSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodGet, subscriptionPath, nil), userId)
+		request.Header.Set("If-None-Match", versionETag(fakeResourceVersion))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusNotModified)
+		if response.Body.Len() != 0 {
+			t.Errorf("not modified responses should not have a body")
+		}
+	})
+
+	t.Run("PATCH without If-Match returns precondition required", func(t *testing.T) {
+		srv := newHttpServer("PATCH /api/subscriptions/{id}", handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest(subscriptionPath, `{"monthly_cost": 149}`), userId)
+		request.Header.Del("If-Match")
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusPreconditionRequired)
+	})
+
+	t.Run("PATCH with a stale ETag returns precondition failed", func(t *testing.T) {
+		srv := newHttpServer("PATCH /api/subscriptions/{id}", handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest(subscriptionPath, `{"monthly_cost": 149}`), userId)
+		request.Header.Set("If-Match", versionETag(fakeResourceVersion-1))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusPreconditionFailed)
+	})
+
+	t.Run("PATCH returns the new version as ETag", func(t *testing.T) {
+		srv := newHttpServer("PATCH /api/subscriptions/{id}", handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest(subscriptionPath, `{"monthly_cost": 149}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+		if got := response.Header().Get("ETag"); got != versionETag(fakeResourceVersion+1) {
+			t.Errorf("got ETag %s, want %s", got, versionETag(fakeResourceVersion+1))
+		}
+	})
+
+	t.Run("DELETE with a matching If-Match succeeds", func(t *testing.T) {
+		srv := newHttpServer("DELETE /api/subscriptions/{id}", handleDeleteSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodDelete, subscriptionPath, nil), userId)
+		request.Header.Set("If-Match", versionETag(fakeResourceVersion))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusNoContent)
+	})
+
+	t.Run("DELETE with a weak ETag returns precondition failed", func(t *testing.T) {
+		srv := newHttpServer("DELETE /api/subscriptions/{id}", handleDeleteSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodDelete, subscriptionPath, nil), userId)
+		request.Header.Set("If-Match", "W/"+versionETag(fakeResourceVersion))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusPreconditionFailed)
+	})
+}
+
+func TestHandlerUpdateActiveSubscription(t *testing.T) {
+	pattern := fmt.Sprintf("%s /api/activesubscriptions/{id}", http.MethodPut)
+	userId := uuid.MustParse(fakeUserId)
+
+	t.Run("Omitting auto_renew_enabled should not panic", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleUpdateActiveSubscription, fakeDatabaseOptions{owner: userId})
+
+		body := strings.NewReader(`{"billing_frequency": "yearly"}`)
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodPut, "/api/activesubscriptions/"+fakeActiveSubscriptionId, body), userId)
+		request.Header.Set("If-Match", versionETag(fakeResourceVersion))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusOK)
+	})
+}
+
+func TestHandlerDeleteActiveSubscription(t *testing.T) {
+	pattern := fmt.Sprintf("%s /api/activesubscriptions/{id}", http.MethodDelete)
+	userId := uuid.MustParse(fakeUserId)
+	activeSubscriptionPath := "/api/activesubscriptions/" + fakeActiveSubscriptionId
+
+	t.Run("DELETE without If-Match returns precondition required", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleDeleteActiveSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodDelete, activeSubscriptionPath, nil), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusPreconditionRequired)
+	})
+
+	t.Run("DELETE with a stale ETag returns precondition failed", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleDeleteActiveSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodDelete, activeSubscriptionPath, nil), userId)
+		request.Header.Set("If-Match", versionETag(fakeResourceVersion-1))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusPreconditionFailed)
+	})
+
+	t.Run("DELETE with a matching If-Match succeeds", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleDeleteActiveSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodDelete, activeSubscriptionPath, nil), userId)
+		request.Header.Set("If-Match", versionETag(fakeResourceVersion))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusNoContent)
+	})
+}
+
+func TestHandlerCreateCategory(t *testing.T) {
+	pattern := fmt.Sprintf("%s /api/categories", http.MethodPost)
+	userId := uuid.MustParse(fakeUserId)
+
+	t.Run("Created categories return status created and their location", func(t *testing.T) {
+		srv := newHttpServer(pattern, handleCreateCategory, fakeDatabaseOptions{})
+
+		body := strings.NewReader(`{"name": "Streaming", "description": "Video"}`)
+		request := newAuthenticatedRequest(httptest.NewRequest(http.MethodPost, "/api/categories", body), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusCreated)
+
+		got, err := decode[struct {
+			Content database.Category `json:"content"`
+		}](response.Body)
+		if err != nil {
+			t.Fatalf("handleCreateCategory -> could not decode response: %v", err)
+		}
+		if location := response.Header().Get("Location"); location != "/api/categories/"+got.Content.ID.String() {
+			t.Errorf("handleCreateCategory -> got Location %q, want the path of category %s", location, got.Content.ID)
+		}
+		if etag := response.Header().Get("ETag"); etag != versionETag(got.Content.Version) {
+			t.Errorf("handleCreateCategory -> got ETag %q, want %q", etag, versionETag(got.Content.Version))
+		}
+	})
+}
+
+func TestIdempotentMiddleware(t *testing.T) {
+	userId := uuid.MustParse(fakeUserId)
+
+	// newIdempotentServer returns a server that counts how often the wrapped handler was called.
+	newIdempotentServer := func(status int) (*http.Server, *int) {
+		calls := 0
+		db := newMemoryStore()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("ETag", versionETag(1))
+			w.Header().Set("Location", fmt.Sprintf("/api/cards/%d", calls))
+			encode(w, status, response{Status: status, Content: fmt.Sprintf("call %d: %s", calls, body)})
+		})
+
+		mux := http.NewServeMux()
+		mux.Handle("POST /api/cards", idempotent(handler, db))
+		return &http.Server{Handler: mux}, &calls
+	}
+
+	newRequest := func(key, body string) *http.Request {
+		req := newAuthenticatedRequest(httptest.NewRequest(http.MethodPost, "/api/cards", strings.NewReader(body)), userId)
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		return req
+	}
+
+	t.Run("Repeated requests replay the stored response", func(t *testing.T) {
+		srv, calls := newIdempotentServer(http.StatusCreated)
+
+		first := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(first, newRequest("key-1", `{"name": "Visa"}`))
+		second := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(second, newRequest("key-1", `{"name": "Visa"}`))
+
+		assertStatusCode(t, second.Code, http.StatusCreated)
+		if *calls != 1 {
+			t.Errorf("handler was called %d times, want 1", *calls)
+		}
+		if first.Body.String() != second.Body.String() {
+			t.Errorf("got replayed body %q, want %q", second.Body.String(), first.Body.String())
+		}
+		if second.Header().Get(idempotencyReplayedHeader) != "true" {
+			t.Errorf("replayed responses should set the %s header", idempotencyReplayedHeader)
+		}
+		for _, name := range []string{"ETag", "Location"} {
+			if got, want := second.Header().Get(name), first.Header().Get(name); got != want {
+				t.Errorf("got replayed %s %q, want %q", name, got, want)
+			}
+		}
+	})
+
+	t.Run("Reusing a key for a different request returns unprocessable entity", func(t *testing.T) {
+		srv, calls := newIdempotentServer(http.StatusCreated)
+
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), newRequest("key-1", `{"name": "Visa"}`))
+		response := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(response, newRequest("key-1", `{"name": "Mastercard"}`))
+
+		assertStatusCode(t, response.Code, http.StatusUnprocessableEntity)
+		if *calls != 1 {
+			t.Errorf("handler was called %d times, want 1", *calls)
+		}
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		srv, calls := newIdempotentServer(http.StatusInternalServerError)
+
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), newRequest("key-1", `{"name": "Visa"}`))
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), newRequest("key-1", `{"name": "Visa"}`))
+
+		if *calls != 2 {
+			t.Errorf("handler was called %d times, want 2", *calls)
+		}
+	})
+
+	t.Run("Requests without a key are not deduplicated", func(t *testing.T) {
+		srv, calls := newIdempotentServer(http.StatusCreated)
+
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), newRequest("", `{"name": "Visa"}`))
+		srv.Handler.ServeHTTP(httptest.NewRecorder(), newRequest("", `{"name": "Visa"}`))
+
+		if *calls != 2 {
+			t.Errorf("handler was called %d times, want 2", *calls)
+		}
+	})
+
+	t.Run("Requests that are still in flight return conflict", func(t *testing.T) {
+		db := newMemoryStore()
+		request := newRequest("key-1", `{"name": "Visa"}`)
+
+		// Reserve the key without completing it, as a concurrent request with the same key would
+		db.ReserveIdempotencyKey(context.Background(), database.ReserveIdempotencyKeyParams{
+			Scope:       userId.String(),
+			Key:         "key-1",
+			Fingerprint: requestFingerprint(request, []byte(`{"name": "Visa"}`)),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		})
+
+		mux := http.NewServeMux()
+		mux.Handle("POST /api/cards", idempotent(http.NotFoundHandler(), db))
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+
+		assertStatusCode(t, response.Code, http.StatusConflict)
+	})
+
+	t.Run("Only routes without credentials are idempotent", func(t *testing.T) {
+		handler, store, _, token := newAuthenticatedServer(t, newTestConfig())
+
+		send := func(target, contentType, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set(idempotencyKeyHeader, "key-"+target)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		register := `{"email": "ben@example.com", "password": "Syp9393-Syp9292-Syp9191"}`
+		send("/api/register", "application/json", register)
+		if replayed := send("/api/register", "application/json", register); replayed.Header().Get(idempotencyReplayedHeader) != "" {
+			t.Error("expected registering not to be replayed")
+		}
+		if len(store.idempotencyKeys) != 0 {
+			t.Errorf("expected no request of an authentication route to be stored, got %d", len(store.idempotencyKeys))
+		}
+
+		send("/ui/categories", "application/x-www-form-urlencoded", "name=Streaming")
+		if replayed := send("/ui/categories", "application/x-www-form-urlencoded", "name=Streaming"); replayed.Header().Get(idempotencyReplayedHeader) != "true" {
+			t.Errorf("expected the form to be replayed, got status %d", replayed.Code)
+		}
+	})
+}
+
 // -- helpers
 
 // newHttpServer is used to create a server with a single route configured. Which is useful for testing handlers.
+func TestProblemResponses(t *testing.T) {
+	userId := uuid.MustParse(fakeUserId)
+
+	t.Run("Validation failures list every rejected field", func(t *testing.T) {
+		pattern := fmt.Sprintf("%s /api/subscriptions/{id}", http.MethodPatch)
+		srv := newHttpServer(pattern, handlePatchSubscription, fakeDatabaseOptions{owner: userId})
+
+		request := newAuthenticatedRequest(newPatchRequest("/api/subscriptions/"+fakeSubscriptionId, `{"name": " ", "monthly_cost": -1}`), userId)
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		got := assertProblem(t, response, http.StatusBadRequest, CodeValidationFailed)
+		fields := map[string]bool{}
+		for _, fieldErr := range got.Errors {
+			fields[fieldErr.Field] = true
+		}
+		if !fields["name"] || !fields["monthly_cost"] {
+			t.Errorf("got field errors %+v, want name and monthly_cost", got.Errors)
+		}
+	})
+
+	t.Run("Domain errors are reported by their code", func(t *testing.T) {
+		pattern := fmt.Sprintf("%s /api/users/", http.MethodPost)
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{userExists: true})
+
+		request := newCreateUserRequest(strings.NewReader(`{"email": "ben@example.com", "password": "Syp9393"}`))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		got := assertProblem(t, response, http.StatusConflict, CodeEmailTaken)
+		if got.Instance != request.URL.Path {
+			t.Errorf("got problem instance %q, want %q", got.Instance, request.URL.Path)
+		}
+	})
+
+	t.Run("Violated unique constraints are reported as conflicts", func(t *testing.T) {
+		pattern := fmt.Sprintf("%s /api/users/", http.MethodPost)
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{raiseError: uniqueViolationError("users_email_key")})
+
+		request := newCreateUserRequest(strings.NewReader(`{"email": "ben@example.com", "password": "Syp9393-Syp9292-Syp9191"}`))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		assertProblem(t, response, http.StatusConflict, CodeEmailTaken)
+	})
+
+	t.Run("Internal errors do not leak their cause", func(t *testing.T) {
+		pattern := fmt.Sprintf("%s /api/users/", http.MethodPost)
+		srv := newHttpServer(pattern, withConfig(handleCreateUser), fakeDatabaseOptions{raiseError: errors.New("something is about to go wrong")})
+
+		request := newCreateUserRequest(strings.NewReader(`{"email": "ben@example.com", "password": "Syp9393"}`))
+		response := httptest.NewRecorder()
+
+		srv.Handler.ServeHTTP(response, request)
+
+		if strings.Contains(response.Body.String(), "something is about to go wrong") {
+			t.Errorf("response body %q contains the cause of the error", response.Body.String())
+		}
+		assertProblem(t, response, http.StatusInternalServerError, CodeInternal)
+	})
+
+	t.Run("Derived errors match their template", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", NotFoundError.WithDetail("user not found").Wrap(sql.ErrNoRows))
+
+		if !errors.Is(err, NotFoundError) {
+			t.Errorf("errors.Is(%v, NotFoundError) = false, want true", err)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("errors.Is(%v, sql.ErrNoRows) = false, want true", err)
+		}
+		if errors.Is(err, ConflictError) {
+			t.Errorf("errors.Is(%v, ConflictError) = true, want false", err)
+		}
+	})
+}
+
 func newHttpServer(pattern string, handler func(dbQuerier) http.Handler, dbOptions fakeDatabaseOptions) *http.Server {
 	// TODO: An improvement would be to use the real server implementation to get more testing coverate.
-	db := fakeDatabaseQueries{
-		err:        dbOptions.raiseError,
-		userExists: dbOptions.userExists,
-	}
-
 	mux := http.NewServeMux()
-	mux.Handle(pattern, handler(db))
+	mux.Handle(pattern, handler(newFakeStore(dbOptions)))
 	srv := http.Server{
 		Handler: mux,
 	}
 	return &srv
 }
 
+// newTestConfig returns the default configuration with a JWT secret and the cheapest bcrypt cost, which keeps tests
+// that hash passwords fast.
+func newTestConfig() *Config {
+	cfg := defaultConfig()
+	cfg.JWTSecret = "secret"
+	cfg.Auth.BcryptCost = bcrypt.MinCost
+	return cfg
+}
+
+// withConfig binds newTestConfig to a handler that needs the configuration, so that it can be passed to newHttpServer.
+func withConfig(handler func(dbQuerier, *Config) http.Handler) func(dbQuerier) http.Handler {
+	return func(db dbQuerier) http.Handler {
+		return handler(db, newTestConfig())
+	}
+}
+
 func newGetUserByIdRequest(id string) *http.Request {
-	req, _ := http.NewRequest(http.MethodGet, "/api/users/"+id, nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/users/"+id, nil)
 	return req
 }
 
 func newCreateUserRequest(body io.Reader) *http.Request {
-	req, _ := http.NewRequest(http.MethodPost, "/api/users/", body)
+	req := httptest.NewRequest(http.MethodPost, "/api/users/", body)
 	return req
 }
 
 func newDeleteUserRequest(id string) *http.Request {
-	req, _ := http.NewRequest(http.MethodDelete, "/api/users/"+id, nil)
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/"+id, nil)
+	return req
+}
+
+func newPatchRequest(target, patch string) *http.Request {
+	req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(patch))
+	req.Header.Set("Content-Type", mergePatchContentType)
+	req.Header.Set("If-Match", versionETag(fakeResourceVersion))
+	return req
+}
+
+func newSearchRequest(query string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/search?q="+url.QueryEscape(query), nil)
 	return req
 }
 
+// newAuthenticatedRequest mimics the authenticate middleware by storing userId in the request context.
+func newAuthenticatedRequest(req *http.Request, userId uuid.UUID) *http.Request {
+	return req.WithContext(WithUserId(req.Context(), userId))
+}
+
 func assertStatusCode(t testing.TB, got, want int) {
 	t.Helper()
 
@@ -378,3 +905,25 @@ func assertStatusCode(t testing.TB, got, want int) {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
+
+// assertProblem checks that response is an RFC 7807 problem document with the given status and code.
+func assertProblem(t testing.TB, response *httptest.ResponseRecorder, status int, code ErrorCode) problem {
+	t.Helper()
+
+	assertStatusCode(t, response.Code, status)
+	if got := response.Header().Get("Content-Type"); got != problemContentType {
+		t.Errorf("got Content-Type %q, want %q", got, problemContentType)
+	}
+
+	got, err := decode[problem](response.Body)
+	if err != nil {
+		t.Fatalf("could not decode problem document: %v", err)
+	}
+	if got.Code != code || got.Status != status {
+		t.Errorf("got problem %+v, want code %q and status %d", got, code, status)
+	}
+	if !strings.HasPrefix(got.Instance, "/") {
+		t.Errorf("got problem instance %q, want the path of the request", got.Instance)
+	}
+	return got
+}
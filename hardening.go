@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// -- Hardening
+//
+// The server bounds what a client can make it hold on to: reading the request headers, the whole request and writing
+// the response are limited in time by newHTTPServer, and the size of the headers and the body in bytes. A client that
+// sends its headers byte by byte, or a body that never ends, is cut off rather than tying up a connection. Every
+// response carries the security headers of secureHeaders.
+
+// newHTTPServer returns a server for addr with the timeouts and header limit of the configuration.
+func newHTTPServer(config *Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.Service.ReadHeaderTimeout,
+		ReadTimeout:       config.Service.ReadTimeout,
+		WriteTimeout:      config.Service.WriteTimeout,
+		IdleTimeout:       config.Service.IdleTimeout,
+		MaxHeaderBytes:    config.Service.MaxHeaderBytes,
+		// Errors of the connections, such as failed TLS handshakes, end up in the structured log
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// limitRequestBodies fails reading a request body beyond maxBytes, see bodyError.
+func limitRequestBodies(next http.Handler, maxBytes int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyError maps an error reading or decoding the request body onto a DomainError, with 413 Content Too Large for
+// bodies beyond the limit of limitRequestBodies.
+func bodyError(err error, detail string) *DomainError {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return RequestTooLargeError.WithDetail("the request body must not be larger than %d bytes", tooLarge.Limit).Wrap(err)
+	}
+	return InvalidRequestError.WithDetail("%s", detail).Wrap(err)
+}
+
+/*
+contentSecurityPolicy allows the frontend to load htmx from unpkg and everything else from the server itself. The
+pages still use inline scripts, event handler attributes and style blocks, hence 'unsafe-inline'. htmx only issues
+requests to the server, which connect-src enforces, and the pages cannot be framed.
+*/
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://unpkg.com; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// hstsMaxAge makes browsers use HTTPS for two years once they have seen the header.
+const hstsMaxAge = "max-age=63072000; includeSubDomains"
+
+/*
+secureHeaders sets the security headers of every response. Strict-Transport-Security is only sent over TLS, which
+browsers require anyway. Behind a proxy that terminates TLS, the proxy is responsible for it.
+*/
+func secureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if r.TLS != nil {
+			header.Set("Strict-Transport-Security", hstsMaxAge)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
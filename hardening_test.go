@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestHardening(t *testing.T) {
+	config := newTestConfig()
+	config.Service.MaxBodyBytes = 1 << 10
+	handler := NewServerHandler(config, newMemoryStore(), newHealth())
+
+	post := func(t *testing.T, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it rejects oversized bodies", func(t *testing.T) {
+		body := `{"email": "large@example.com", "password": "` + strings.Repeat("a", 2<<10) + `"}`
+		assertProblem(t, post(t, "/api/register", body), http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+	})
+
+	t.Run("it rejects oversized bodies of idempotent requests", func(t *testing.T) {
+		token, err := auth.MakeJWT(uuid.New(), config.JWTSecret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/cards", strings.NewReader(strings.Repeat(" ", 2<<10)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(idempotencyKeyHeader, "b5c3e0f6-0c8e-4d36-9b43-2f1f7c0f6a11")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assertProblem(t, rec, http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+	})
+
+	t.Run("it rejects unknown members", func(t *testing.T) {
+		body := `{"email": "admin@example.com", "password": "Syp9393-Syp9292-Syp9191", "is_admin": true}`
+		assertProblem(t, post(t, "/api/register", body), http.StatusBadRequest, CodeInvalidRequest)
+	})
+
+	t.Run("it sets the security headers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		for name, want := range map[string]string{
+			"Content-Security-Policy": contentSecurityPolicy,
+			"X-Content-Type-Options":  "nosniff",
+			"Referrer-Policy":         "strict-origin-when-cross-origin",
+		} {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("expected %s %q, got %q", name, want, got)
+			}
+		}
+		if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("expected no HSTS without TLS, got %q", got)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.TLS = &tls.ConnectionState{}
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Strict-Transport-Security"); got != hstsMaxAge {
+			t.Errorf("expected HSTS over TLS, got %q", got)
+		}
+	})
+}
+
+func TestServerLimits(t *testing.T) {
+	config := newTestConfig()
+	config.Service.ReadHeaderTimeout = 100 * time.Millisecond
+	config.Service.MaxHeaderBytes = 1 << 10
+
+	// listen serves the API with the limits of the configuration on a real connection
+	listen := func(t *testing.T) string {
+		t.Helper()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := newHTTPServer(config, listener.Addr().String(), NewServerHandler(config, newMemoryStore(), newHealth()))
+		go server.Serve(listener)
+		t.Cleanup(func() { server.Close() })
+		return listener.Addr().String()
+	}
+	addr := listen(t)
+
+	t.Run("it rejects oversized headers", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/healthz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Padding", strings.Repeat("a", 8<<10))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+			t.Errorf("expected status 431, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("it closes connections that send their headers too slowly", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Start a request but never finish its headers, like a slowloris client
+		if _, err := io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: localhost\r\nX-Slow: "); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = bufio.NewReader(conn).ReadByte()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.Fatal("expected the server to close the connection before the client gave up")
+		}
+		if err == nil {
+			t.Error("expected the connection to be closed without a response")
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+)
+
+// -- Health
+//
+// GET /healthz reports that the process is alive, GET /readyz whether it should receive traffic and GET /version
+// which build is running. Readiness runs the checks added with addCheck, such as a ping of the database, and fails
+// when a background worker has stopped or once the server is shutting down, so that load balancers stop sending
+// requests before the listener is closed. Unlike the API, these endpoints respond without the response envelope.
+
+// readinessCheckTimeout bounds every readiness check, probes must not hang on an unresponsive database.
+const readinessCheckTimeout = 2 * time.Second
+
+const (
+	checkOk       = "ok"
+	checkFailed   = "failed"
+	checkStopped  = "stopped"
+	checkDraining = "shutting down"
+)
+
+type healthCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// health holds the state that readiness is derived from.
+type health struct {
+	checks []healthCheck
+
+	mu sync.Mutex
+	// workers maps the names of the background workers to whether they are running
+	workers map[string]bool
+
+	draining atomic.Bool
+}
+
+func newHealth() *health {
+	return &health{workers: map[string]bool{}}
+}
+
+// addCheck adds a check to readiness. Checks are added before the server starts serving.
+func (h *health) addCheck(name string, check func(context.Context) error) {
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// goWorker runs work in a new goroutine. The server is not ready once work has returned.
+func (h *health) goWorker(name string, work func()) {
+	h.setWorker(name, true)
+	go func() {
+		defer h.setWorker(name, false)
+		work()
+	}()
+}
+
+func (h *health) setWorker(name string, running bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.workers[name] = running
+}
+
+// drain makes readiness fail from now on, before the server shuts down.
+func (h *health) drain() {
+	h.draining.Store(true)
+}
+
+// readiness is the body of GET /readyz.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Checks maps every check and background worker to its result, such as "ok".
+	Checks map[string]string `json:"checks"`
+}
+
+// readiness runs the checks. Their errors are logged rather than reported, since the probes may be public.
+func (h *health) readiness(ctx context.Context) readiness {
+	result := readiness{Ready: true, Checks: map[string]string{}}
+	fail := func(name, status string) {
+		result.Ready = false
+		result.Checks[name] = status
+	}
+
+	if h.draining.Load() {
+		fail("server", checkDraining)
+	}
+	for _, c := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+		err := c.check(checkCtx)
+		cancel()
+		if err != nil {
+			logging.FromContext(ctx).Warn("readiness check failed", "check", c.name, "error", err)
+			fail(c.name, checkFailed)
+			continue
+		}
+		result.Checks[c.name] = checkOk
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, running := range h.workers {
+		if !running {
+			fail(name, checkStopped)
+			continue
+		}
+		result.Checks[name] = checkOk
+	}
+	return result
+}
+
+// handleHealthz reports that the process is alive and serving requests. It checks nothing else, so that the
+// process is not restarted for a failing dependency.
+func handleHealthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encode(w, http.StatusOK, map[string]string{"status": checkOk})
+	})
+}
+
+// handleReadyz reports whether the server should receive traffic, with 503 Service Unavailable when it should not.
+func handleReadyz(h *health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := h.readiness(r.Context())
+		status := http.StatusOK
+		if !result.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		encode(w, status, result)
+	})
+}
+
+// buildTime is the time the binary was built. The Go toolchain does not record it, release builds set it with
+// -ldflags "-X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)".
+var buildTime string
+
+// buildInfo describes the build of the binary.
+type buildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	// Modified reports whether the working tree had uncommitted changes when the binary was built
+	Modified  bool   `json:"modified"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// readBuildInfo returns the build of the binary as recorded by the Go toolchain. The commit is only known for
+// binaries built from a git checkout with go build, not with go run or go test.
+func readBuildInfo() buildInfo {
+	build := buildInfo{Version: "unknown", BuildTime: buildTime}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+
+	build.Version, build.GoVersion = info.Main.Version, info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Commit = setting.Value
+		case "vcs.time":
+			build.CommitTime = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// handleVersion reports the build of the binary.
+func handleVersion() http.Handler {
+	build := readBuildInfo()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encode(w, http.StatusOK, build)
+	})
+}
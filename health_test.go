@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	get := func(t *testing.T, h *health, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		NewServerHandler(newTestConfig(), newMemoryStore(), h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	ready := func(t *testing.T, h *health) (int, readiness) {
+		t.Helper()
+		rec := get(t, h, "/readyz")
+		var result readiness
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("could not decode readiness: %v", err)
+		}
+		return rec.Code, result
+	}
+
+	t.Run("it is alive without checks", func(t *testing.T) {
+		if rec := get(t, newHealth(), "/healthz"); rec.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it is ready when every check passes", func(t *testing.T) {
+		h := newHealth()
+		h.addCheck("database", func(context.Context) error { return nil })
+		stop := make(chan struct{})
+		defer close(stop)
+		h.goWorker("cleanup", func() { <-stop })
+
+		status, result := ready(t, h)
+		if status != http.StatusOK || !result.Ready || result.Checks["database"] != checkOk || result.Checks["cleanup"] != checkOk {
+			t.Errorf("expected to be ready, got %d %+v", status, result)
+		}
+	})
+
+	t.Run("it is not ready when a check fails", func(t *testing.T) {
+		h := newHealth()
+		h.addCheck("database", func(context.Context) error { return errors.New("dial tcp 10.0.0.5:5432: connection refused") })
+
+		rec := get(t, h, "/readyz")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %d", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "10.0.0.5") {
+			t.Errorf("expected the error not to be reported, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("it is not ready when a worker has stopped", func(t *testing.T) {
+		h := newHealth()
+		done := make(chan struct{})
+		h.goWorker("cleanup", func() { close(done) })
+		<-done
+
+		// The worker is marked as stopped right after it returns
+		status, result := ready(t, h)
+		for i := 0; i < 100 && result.Checks["cleanup"] != checkStopped; i++ {
+			time.Sleep(time.Millisecond)
+			status, result = ready(t, h)
+		}
+		if status != http.StatusServiceUnavailable || result.Checks["cleanup"] != checkStopped {
+			t.Errorf("expected the stopped worker to fail readiness, got %d %+v", status, result)
+		}
+	})
+
+	t.Run("it is not ready once the server drains", func(t *testing.T) {
+		h := newHealth()
+		h.drain()
+
+		status, result := ready(t, h)
+		if status != http.StatusServiceUnavailable || result.Checks["server"] != checkDraining {
+			t.Errorf("expected draining to fail readiness, got %d %+v", status, result)
+		}
+		// The process is still alive though
+		if rec := get(t, h, "/healthz"); rec.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it reports the build", func(t *testing.T) {
+		rec := get(t, newHealth(), "/version")
+		var build buildInfo
+		if err := json.NewDecoder(rec.Body).Decode(&build); err != nil {
+			t.Fatal(err)
+		}
+		if build.GoVersion != runtime.Version() {
+			t.Errorf("expected Go version %s, got %+v", runtime.Version(), build)
+		}
+	})
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/benkoben/unsubtle-core/internal/auth"
 	"github.com/benkoben/unsubtle-core/internal/database"
 	passwordvalidator "github.com/wagslane/go-password-validator"
 	"io"
-	"log"
+	"mime"
 	"net/http"
 	"net/mail"
 )
@@ -36,49 +37,125 @@ func decode[T any](r io.Reader) (T, error) {
 	return v, nil
 }
 
+// decodeBody decodes a JSON request body into a new T. Unlike decode, members that T does not know about are
+// rejected, like those of a merge patch. The returned error is a DomainError, see bodyError.
+func decodeBody[T any](body io.Reader) (T, error) {
+	var v T
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&v); err != nil {
+		return v, bodyError(fmt.Errorf("decoding JSON: %w", err), "invalid JSON")
+	}
+	return v, nil
+}
+
 func toPtr[T any](v T) *T {
 	return &v
 }
 
-func createUser(ctx context.Context, db dbQuerier, userData userRequestData) *response {
-	var res response
+// mergePatchContentType is the media type of a JSON Merge Patch document as defined by RFC 7396.
+const mergePatchContentType = "application/merge-patch+json"
+
+// isMergePatch reports whether the request body is declared as a JSON Merge Patch document.
+// Plain application/json is accepted as well, since most HTTP clients send it by default.
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == mergePatchContentType || mediaType == "application/json"
+}
+
+/*
+applyMergePatch applies an RFC 7396 JSON Merge Patch to the JSON representation of original and decodes the result
+into a new T. Members set to null in the patch are removed, which resets the corresponding field to its zero value.
+Members that T does not know about are rejected so that typos do not silently turn into no-ops.
+*/
+func applyMergePatch[T any](original T, patch []byte) (T, error) {
+	var merged T
+
+	doc, err := json.Marshal(original)
+	if err != nil {
+		return merged, fmt.Errorf("encoding original document: %w", err)
+	}
+
+	var target, patchDoc any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return merged, fmt.Errorf("decoding original document: %w", err)
+	}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return merged, fmt.Errorf("decoding merge patch: %w", err)
+	}
+	if _, ok := patchDoc.(map[string]any); !ok {
+		return merged, fmt.Errorf("merge patch must be a JSON object")
+	}
+
+	result, err := json.Marshal(mergePatch(target, patchDoc))
+	if err != nil {
+		return merged, fmt.Errorf("encoding merged document: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&merged); err != nil {
+		return merged, fmt.Errorf("decoding merged document: %w", err)
+	}
+	return merged, nil
+}
+
+// mergePatch implements the MergePatch(Target, Patch) algorithm from RFC 7396, section 2.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+/*
+createUser registers a new user. It is shared by the JSON API and the registration form, the returned DomainErrors
+let both of them tell invalid input apart from server errors.
+*/
+func createUser(ctx context.Context, db dbQuerier, cfg *Config, userData userRequestData) (database.CreateUserRow, error) {
 	// Validate the email
 	if ok := validEmail(userData.Email); !ok {
-		res.Status = http.StatusBadRequest
-		res.Error = toPtr("invalid email")
-		return &res
+		return database.CreateUserRow{}, InvalidEmailError
 	}
 
 	// Check if the email is already registered
 	existingUser, err := db.GetUserByEmail(ctx, userData.Email)
-	if err != nil && err != sql.ErrNoRows {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		// If any error other than ErrNoRows is returned this means something unexpected happened.
-		res.Status = http.StatusInternalServerError
-		res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-		return &res
+		return database.CreateUserRow{}, InternalError.Wrap(err)
 	}
 
 	// If existingUser contains data, this means there already exists a database entry for the requested email
 	if existingUser.Email != "" {
-		res.Status = http.StatusConflict
-		res.Error = toPtr("email is already registered")
-		return &res
+		return database.CreateUserRow{}, EmailTakenError
 	}
 
 	// Validate the password criteria
 	if err := passwordvalidator.Validate(userData.Password, minEntropy); err != nil {
-		res.Status = http.StatusBadRequest
-		res.Error = toPtr(fmt.Sprintf("invalid password: %v", err))
-		return &res
+		return database.CreateUserRow{}, InsecurePasswordError.WithDetail("%v", err)
 	}
 
 	// Hash the password
-	hash, err := auth.CreateHash(userData.Password)
+	hash, err := hashPassword(userData.Password, cfg.Auth.BcryptCost)
 	if err != nil {
-		log.Printf("error hashing password: %v", err)
-		res.Status = http.StatusInternalServerError
-		res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-		return &res
+		return database.CreateUserRow{}, InternalError.Wrap(fmt.Errorf("hashing password: %w", err))
 	}
 
 	// Save the user to the database
@@ -89,13 +166,8 @@ func createUser(ctx context.Context, db dbQuerier, userData userRequestData) *re
 
 	dbResponse, err := db.CreateUser(ctx, createUserParams)
 	if err != nil {
-		log.Printf("error creating user: %v", err)
-		res.Status = http.StatusInternalServerError
-		res.Error = toPtr(http.StatusText(http.StatusInternalServerError))
-		return &res
+		return database.CreateUserRow{}, InternalError.Wrap(fmt.Errorf("creating user: %w", err))
 	}
 
-	res.Status = http.StatusCreated
-	res.Content = dbResponse
-	return &res
+	return dbResponse, nil
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	// idempotencyKeyTTL is how long a stored response can be replayed.
+	idempotencyKeyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength guards the database from arbitrarily large keys.
+	maxIdempotencyKeyLength = 255
+	// idempotencyCleanupInterval is how often expired keys are removed from the database.
+	idempotencyCleanupInterval = time.Hour
+)
+
+// capturingResponseWriter passes a response through to the client while keeping a copy of it.
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *capturingResponseWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *capturingResponseWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// storedHeader returns the value of the header name of a response as a nullable column.
+func storedHeader(header http.Header, name string) sql.NullString {
+	value := header.Get(name)
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyScope namespaces keys per authenticated user, so that clients cannot replay each other's responses.
+// Unauthenticated requests share the anonymous scope, where the fingerprint check prevents replays to other callers.
+func idempotencyScope(ctx context.Context) string {
+	if userId, ok := ctx.Value(userIdCtxKey).(uuid.UUID); ok {
+		return userId.String()
+	}
+	return "anonymous"
+}
+
+/*
+idempotent makes POST requests safe to retry. When a request carries an Idempotency-Key header, the first response
+for that key is stored for idempotencyKeyTTL and replayed for every repeated request with the same key. Its ETag and
+Location headers are replayed as well, so that a client can continue with the resource it created. Repeating a
+key with a different request body results in 422 Unprocessable Entity, and repeating a key while the original
+request is still being processed results in 409 Conflict. Server errors are not stored so that they can be retried.
+Requests without the header are passed through unchanged.
+*/
+func idempotent(next http.Handler, db dbQuerier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, InvalidRequestError.WithDetail("%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeProblem(w, r, bodyError(err, "could not read request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(r.Context())
+		fingerprint := requestFingerprint(r, body)
+
+		if _, err := db.ReserveIdempotencyKey(r.Context(), database.ReserveIdempotencyKeyParams{
+			Scope:       scope,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+		}); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				writeProblem(w, r, InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err)))
+				return
+			}
+
+			// The key is already in use, replay the stored response if the request is the same one.
+			stored, err := db.GetIdempotencyKey(r.Context(), database.GetIdempotencyKeyParams{Scope: scope, Key: key})
+			if err != nil {
+				writeProblem(w, r, InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err)))
+				return
+			}
+
+			switch {
+			case stored.Fingerprint != fingerprint:
+				writeProblem(w, r, IdempotencyKeyReusedError)
+			case !stored.StatusCode.Valid:
+				writeProblem(w, r, RequestInProgressError)
+			default:
+				for name, value := range map[string]sql.NullString{"Content-Type": stored.ContentType, "ETag": stored.Etag, "Location": stored.Location} {
+					if value.Valid {
+						w.Header().Set(name, value.String)
+					}
+				}
+				w.Header().Set(idempotencyReplayedHeader, "true")
+				w.WriteHeader(int(stored.StatusCode.Int32))
+				w.Write(stored.ResponseBody)
+			}
+			return
+		}
+
+		capture := &capturingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(capture, r)
+
+		// The request should be processed the same way it would have been without the header, therefore the
+		// bookkeeping below does not use the request context which may already have been cancelled.
+		ctx := context.WithoutCancel(r.Context())
+		if capture.status == 0 || capture.status >= http.StatusInternalServerError {
+			if err := db.DeleteIdempotencyKey(ctx, database.DeleteIdempotencyKeyParams{Scope: scope, Key: key}); err != nil {
+				logging.FromContext(ctx).Error("could not release idempotency key", "error", err)
+			}
+			return
+		}
+
+		if err := db.CompleteIdempotencyKey(ctx, database.CompleteIdempotencyKeyParams{
+			Scope:        scope,
+			Key:          key,
+			StatusCode:   sql.NullInt32{Int32: int32(capture.status), Valid: true},
+			ContentType:  storedHeader(w.Header(), "Content-Type"),
+			ResponseBody: capture.body.Bytes(),
+			Etag:         storedHeader(w.Header(), "ETag"),
+			Location:     storedHeader(w.Header(), "Location"),
+		}); err != nil {
+			logging.FromContext(ctx).Error("could not store idempotent response", "error", err)
+		}
+	})
+}
+
+// cleanupIdempotencyKeys periodically removes expired idempotency keys until ctx is cancelled.
+func cleanupIdempotencyKeys(ctx context.Context, db *database.Queries, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := db.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				slog.Error("could not remove expired idempotency keys", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				slog.Info("removed expired idempotency keys", "deleted", deleted)
+			}
+		}
+	}
+}
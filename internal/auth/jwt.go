@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -49,7 +48,6 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 		return []byte(tokenSecret), nil
 	})
 	if err != nil {
-		log.Println("received invalid token (not signed with trusted secret): ", err)
 		return uuid.UUID{}, err
 	}
 
@@ -77,7 +75,6 @@ func GetBearerToken(headers http.Header) (string, error) {
 	}
 
 	bearer := strings.Fields(headerVal)
-	log.Println(bearer)
 	if len(bearer) < 2 || bearer[0] != "Bearer" {
 		return "", errors.New("invalid bearer token format")
 	}
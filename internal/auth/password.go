@@ -5,10 +5,11 @@ import (
 )
 
 const (
-	cost = 15
+	// DefaultCost is the bcrypt cost used unless the server is configured otherwise.
+	DefaultCost = 15
 )
 
-func CreateHash(password string) (string, error) {
+func CreateHash(password string, cost int) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		return "", err
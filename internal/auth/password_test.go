@@ -21,7 +21,7 @@ func TestCheckPasswordHash(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		hash, err := CreateHash(tt.password)
+		hash, err := CreateHash(tt.password, DefaultCost)
 		if err != nil && tt.wantErr == false {
 			t.Errorf("%s -> HashPassword(%s) got error but none was expected", tt.name, tt.password)
 		}
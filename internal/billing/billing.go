@@ -0,0 +1,51 @@
+package billing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period is the interval between two charges of an active subscription.
+type Period struct {
+	years, months, days int
+}
+
+// periods maps the billing frequencies of active subscriptions to their interval.
+var periods = map[string]Period{
+	"weekly":    {days: 7},
+	"monthly":   {months: 1},
+	"quarterly": {months: 3},
+	"yearly":    {years: 1},
+	"annually":  {years: 1},
+}
+
+// ParsePeriod returns the period of a billing frequency such as "monthly". Frequencies are case-insensitive.
+func ParsePeriod(frequency string) (Period, error) {
+	period, ok := periods[strings.ToLower(strings.TrimSpace(frequency))]
+	if !ok {
+		return Period{}, fmt.Errorf("unknown billing frequency %q", frequency)
+	}
+	return period, nil
+}
+
+// Charge converts the monthly cost of a subscription into the amount charged once per period.
+func (p Period) Charge(monthlyCost int32) int64 {
+	if p.days > 0 {
+		return int64(monthlyCost) * 12 * int64(p.days) / 365
+	}
+	return int64(monthlyCost) * int64(p.years*12+p.months)
+}
+
+// Nth returns the date of the nth charge after start. Charges are computed from start rather than from the previous
+// charge, so that a subscription started on the 31st is charged at the end of every month.
+func (p Period) Nth(start time.Time, n int) time.Time {
+	next := start.AddDate(p.years*n, p.months*n, p.days*n)
+	if p.months > 0 || p.years > 0 {
+		// AddDate normalizes overflowing days into the next month, e.g. January 31 + 1 month = March 3.
+		if want := (int(start.Month())-1+p.months*n+p.years*12*n)%12 + 1; int(next.Month()) != want {
+			next = next.AddDate(0, 0, -next.Day())
+		}
+	}
+	return next
+}
@@ -0,0 +1,46 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestPeriod(t *testing.T) {
+	monthly, _ := ParsePeriod("Monthly")
+	yearly, _ := ParsePeriod("yearly")
+
+	t.Run("it charges at the end of short months", func(t *testing.T) {
+		cases := map[int]string{0: "2025-01-31", 1: "2025-02-28", 2: "2025-03-31", 3: "2025-04-30"}
+		for n, want := range cases {
+			if got := monthly.Nth(date("2025-01-31"), n).Format(time.DateOnly); got != want {
+				t.Errorf("charge %d: expected %s, got %s", n, want, got)
+			}
+		}
+		if got := yearly.Nth(date("2024-02-29"), 1).Format(time.DateOnly); got != "2025-02-28" {
+			t.Errorf("expected a leap day to be charged on 2025-02-28, got %s", got)
+		}
+	})
+
+	t.Run("it converts the monthly cost into the charged amount", func(t *testing.T) {
+		if got := yearly.Charge(100); got != 1200 {
+			t.Errorf("expected a yearly charge of 1200, got %d", got)
+		}
+		if got := monthly.Charge(100); got != 100 {
+			t.Errorf("expected a monthly charge of 100, got %d", got)
+		}
+	})
+
+	t.Run("it rejects unknown frequencies", func(t *testing.T) {
+		if _, err := ParsePeriod("fortnightly"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -13,6 +13,17 @@ import (
 	"github.com/google/uuid"
 )
 
+const countActiveSubscriptions = `-- name: CountActiveSubscriptions :one
+SELECT count(*) FROM active_subscriptions
+`
+
+func (q *Queries) CountActiveSubscriptions(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countActiveSubscriptions)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createActiveSubscription = `-- name: CreateActiveSubscription :one
 INSERT INTO active_subscriptions (subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled)
 VALUES (
@@ -24,7 +35,7 @@ VALUES (
         $5,
         $6
     )
-RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 `
 
 type CreateActiveSubscriptionParams struct {
@@ -55,17 +66,23 @@ func (q *Queries) CreateActiveSubscription(ctx context.Context, arg CreateActive
 		&i.UpdatedAt,
 		&i.BillingFrequency,
 		&i.AutoRenewEnabled,
+		&i.Version,
 	)
 	return i, err
 }
 
 const deleteActiveSubscription = `-- name: DeleteActiveSubscription :execresult
 DELETE FROM active_subscriptions
-WHERE id = $1
+WHERE id = $1 AND version = $2
 `
 
-func (q *Queries) DeleteActiveSubscription(ctx context.Context, id uuid.UUID) (sql.Result, error) {
-	return q.db.ExecContext(ctx, deleteActiveSubscription, id)
+type DeleteActiveSubscriptionParams struct {
+	ID      uuid.UUID `json:"id"`
+	Version int32     `json:"version"`
+}
+
+func (q *Queries) DeleteActiveSubscription(ctx context.Context, arg DeleteActiveSubscriptionParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteActiveSubscription, arg.ID, arg.Version)
 }
 
 const disableAutoRenew = `-- name: DisableAutoRenew :exec
@@ -91,7 +108,7 @@ func (q *Queries) EnableAutoRenew(ctx context.Context, id uuid.UUID) error {
 }
 
 const getActiveSubscriptionById = `-- name: GetActiveSubscriptionById :one
-SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 FROM active_subscriptions
 WHERE id = $1
 `
@@ -108,39 +125,13 @@ func (q *Queries) GetActiveSubscriptionById(ctx context.Context, id uuid.UUID) (
 		&i.UpdatedAt,
 		&i.BillingFrequency,
 		&i.AutoRenewEnabled,
-	)
-	return i, err
-}
-
-const getActiveSubscriptionByUserIdAndSubId = `-- name: GetActiveSubscriptionByUserIdAndSubId :one
-SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
-FROM active_subscriptions
-WHERE user_id = $1 AND subscription_id = $2
-`
-
-type GetActiveSubscriptionByUserIdAndSubIdParams struct {
-	UserID         uuid.UUID `json:"user_id"`
-	SubscriptionID uuid.UUID `json:"subscription_id"`
-}
-
-func (q *Queries) GetActiveSubscriptionByUserIdAndSubId(ctx context.Context, arg GetActiveSubscriptionByUserIdAndSubIdParams) (ActiveSubscription, error) {
-	row := q.db.QueryRowContext(ctx, getActiveSubscriptionByUserIdAndSubId, arg.UserID, arg.SubscriptionID)
-	var i ActiveSubscription
-	err := row.Scan(
-		&i.ID,
-		&i.SubscriptionID,
-		&i.UserID,
-		&i.CardID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.BillingFrequency,
-		&i.AutoRenewEnabled,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listActiveSubscriptionByUserId = `-- name: ListActiveSubscriptionByUserId :many
-SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 FROM active_subscriptions
 WHERE user_id = $1
 `
@@ -163,6 +154,7 @@ func (q *Queries) ListActiveSubscriptionByUserId(ctx context.Context, userID uui
 			&i.UpdatedAt,
 			&i.BillingFrequency,
 			&i.AutoRenewEnabled,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -178,7 +170,7 @@ func (q *Queries) ListActiveSubscriptionByUserId(ctx context.Context, userID uui
 }
 
 const listActiveSubscriptions = `-- name: ListActiveSubscriptions :many
-SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+SELECT id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 FROM active_subscriptions
 ORDER BY created_at ASC
 `
@@ -201,6 +193,7 @@ func (q *Queries) ListActiveSubscriptions(ctx context.Context) ([]ActiveSubscrip
 			&i.UpdatedAt,
 			&i.BillingFrequency,
 			&i.AutoRenewEnabled,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -218,7 +211,7 @@ func (q *Queries) ListActiveSubscriptions(ctx context.Context) ([]ActiveSubscrip
 const resetActiveSubscriptions = `-- name: ResetActiveSubscriptions :many
 DELETE
 FROM active_subscriptions
-RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 `
 
 func (q *Queries) ResetActiveSubscriptions(ctx context.Context) ([]ActiveSubscription, error) {
@@ -239,6 +232,7 @@ func (q *Queries) ResetActiveSubscriptions(ctx context.Context) ([]ActiveSubscri
 			&i.UpdatedAt,
 			&i.BillingFrequency,
 			&i.AutoRenewEnabled,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -257,19 +251,77 @@ const updateActiveSubscription = `-- name: UpdateActiveSubscription :one
 UPDATE active_subscriptions
 SET billing_frequency  = $2,
     auto_renew_enabled = $3,
-    updated_at = NOW()
-WHERE id = $1
-RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled
+    updated_at = NOW(),
+    version = version + 1
+WHERE id = $1 AND version = $4
+RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
 `
 
 type UpdateActiveSubscriptionParams struct {
 	ID               uuid.UUID    `json:"id"`
 	BillingFrequency string       `json:"billing_frequency"`
 	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
+	Version          int32        `json:"version"`
 }
 
 func (q *Queries) UpdateActiveSubscription(ctx context.Context, arg UpdateActiveSubscriptionParams) (ActiveSubscription, error) {
-	row := q.db.QueryRowContext(ctx, updateActiveSubscription, arg.ID, arg.BillingFrequency, arg.AutoRenewEnabled)
+	row := q.db.QueryRowContext(ctx, updateActiveSubscription,
+		arg.ID,
+		arg.BillingFrequency,
+		arg.AutoRenewEnabled,
+		arg.Version,
+	)
+	var i ActiveSubscription
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&i.UserID,
+		&i.CardID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.BillingFrequency,
+		&i.AutoRenewEnabled,
+		&i.Version,
+	)
+	return i, err
+}
+
+const upsertActiveSubscription = `-- name: UpsertActiveSubscription :one
+INSERT INTO active_subscriptions (subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled)
+VALUES (
+        $1,
+        $2,
+        $3,
+        NOW(),
+        NOW(),
+        $4,
+        $5
+    )
+ON CONFLICT (user_id, subscription_id) DO UPDATE
+SET card_id            = EXCLUDED.card_id,
+    billing_frequency  = EXCLUDED.billing_frequency,
+    auto_renew_enabled = EXCLUDED.auto_renew_enabled,
+    updated_at         = NOW(),
+    version            = active_subscriptions.version + 1
+RETURNING id, subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled, version
+`
+
+type UpsertActiveSubscriptionParams struct {
+	SubscriptionID   uuid.UUID    `json:"subscription_id"`
+	UserID           uuid.UUID    `json:"user_id"`
+	CardID           uuid.UUID    `json:"card_id"`
+	BillingFrequency string       `json:"billing_frequency"`
+	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
+}
+
+func (q *Queries) UpsertActiveSubscription(ctx context.Context, arg UpsertActiveSubscriptionParams) (ActiveSubscription, error) {
+	row := q.db.QueryRowContext(ctx, upsertActiveSubscription,
+		arg.SubscriptionID,
+		arg.UserID,
+		arg.CardID,
+		arg.BillingFrequency,
+		arg.AutoRenewEnabled,
+	)
 	var i ActiveSubscription
 	err := row.Scan(
 		&i.ID,
@@ -280,6 +332,7 @@ func (q *Queries) UpdateActiveSubscription(ctx context.Context, arg UpdateActive
 		&i.UpdatedAt,
 		&i.BillingFrequency,
 		&i.AutoRenewEnabled,
+		&i.Version,
 	)
 	return i, err
 }
@@ -20,7 +20,7 @@ VALUES (NOW(),
         $1,
         $2,
         $3)
-RETURNING id, created_at, updated_at, name, owner, expires_at
+RETURNING id, created_at, updated_at, name, owner, expires_at, version
 `
 
 type CreateCardParams struct {
@@ -36,6 +36,7 @@ type CreateCardRow struct {
 	Name      string    `json:"name"`
 	Owner     uuid.UUID `json:"owner"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Version   int32     `json:"version"`
 }
 
 func (q *Queries) CreateCard(ctx context.Context, arg CreateCardParams) (CreateCardRow, error) {
@@ -48,6 +49,7 @@ func (q *Queries) CreateCard(ctx context.Context, arg CreateCardParams) (CreateC
 		&i.Name,
 		&i.Owner,
 		&i.ExpiresAt,
+		&i.Version,
 	)
 	return i, err
 }
@@ -55,15 +57,20 @@ func (q *Queries) CreateCard(ctx context.Context, arg CreateCardParams) (CreateC
 const deleteCard = `-- name: DeleteCard :execresult
 DELETE
 FROM cards
-WHERE id = $1
+WHERE id = $1 AND version = $2
 `
 
-func (q *Queries) DeleteCard(ctx context.Context, id uuid.UUID) (sql.Result, error) {
-	return q.db.ExecContext(ctx, deleteCard, id)
+type DeleteCardParams struct {
+	ID      uuid.UUID `json:"id"`
+	Version int32     `json:"version"`
+}
+
+func (q *Queries) DeleteCard(ctx context.Context, arg DeleteCardParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteCard, arg.ID, arg.Version)
 }
 
 const getCard = `-- name: GetCard :one
-SELECT id, name, owner, created_at, updated_at, expires_at
+SELECT id, name, owner, created_at, updated_at, expires_at, version
 FROM cards
 WHERE id = $1
 `
@@ -78,37 +85,13 @@ func (q *Queries) GetCard(ctx context.Context, id uuid.UUID) (Card, error) {
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
-	)
-	return i, err
-}
-
-const getCardByName = `-- name: GetCardByName :one
-SELECT id, name, owner, created_at, updated_at, expires_at
-FROM cards
-WHERE name = $1 AND owner = $2
-`
-
-type GetCardByNameParams struct {
-	Name  string    `json:"name"`
-	Owner uuid.UUID `json:"owner"`
-}
-
-func (q *Queries) GetCardByName(ctx context.Context, arg GetCardByNameParams) (Card, error) {
-	row := q.db.QueryRowContext(ctx, getCardByName, arg.Name, arg.Owner)
-	var i Card
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Owner,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.ExpiresAt,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listCards = `-- name: ListCards :many
-SELECT id, name, owner, created_at, updated_at, expires_at
+SELECT id, name, owner, created_at, updated_at, expires_at, version
 FROM cards
 ORDER BY created_at ASC
 `
@@ -129,6 +112,7 @@ func (q *Queries) ListCards(ctx context.Context) ([]Card, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.ExpiresAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -144,7 +128,7 @@ func (q *Queries) ListCards(ctx context.Context) ([]Card, error) {
 }
 
 const listCardsForOwner = `-- name: ListCardsForOwner :many
-SELECT id, name, owner, created_at, updated_at, expires_at
+SELECT id, name, owner, created_at, updated_at, expires_at, version
 FROM cards
 WHERE owner = $1
 `
@@ -165,6 +149,7 @@ func (q *Queries) ListCardsForOwner(ctx context.Context, owner uuid.UUID) ([]Car
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.ExpiresAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -182,7 +167,7 @@ func (q *Queries) ListCardsForOwner(ctx context.Context, owner uuid.UUID) ([]Car
 const resetCards = `-- name: ResetCards :many
 DELETE
 FROM cards
-RETURNING id, name, owner, created_at, updated_at, expires_at
+RETURNING id, name, owner, created_at, updated_at, expires_at, version
 `
 
 func (q *Queries) ResetCards(ctx context.Context) ([]Card, error) {
@@ -201,6 +186,7 @@ func (q *Queries) ResetCards(ctx context.Context) ([]Card, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.ExpiresAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -219,9 +205,10 @@ const updateCard = `-- name: UpdateCard :one
 UPDATE cards
 SET name       = $2,
     expires_at = $3,
-    updated_at = $4
-WHERE id = $1
-RETURNING id, name, owner, created_at, updated_at, expires_at
+    updated_at = $4,
+    version    = version + 1
+WHERE id = $1 AND version = $5
+RETURNING id, name, owner, created_at, updated_at, expires_at, version
 `
 
 type UpdateCardParams struct {
@@ -229,6 +216,7 @@ type UpdateCardParams struct {
 	Name      string    `json:"name"`
 	ExpiresAt time.Time `json:"expires_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
 }
 
 func (q *Queries) UpdateCard(ctx context.Context, arg UpdateCardParams) (Card, error) {
@@ -237,7 +225,43 @@ func (q *Queries) UpdateCard(ctx context.Context, arg UpdateCardParams) (Card, e
 		arg.Name,
 		arg.ExpiresAt,
 		arg.UpdatedAt,
+		arg.Version,
+	)
+	var i Card
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Owner,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ExpiresAt,
+		&i.Version,
 	)
+	return i, err
+}
+
+const upsertCard = `-- name: UpsertCard :one
+INSERT INTO cards (created_at, updated_at, name, owner, expires_at)
+VALUES (NOW(),
+        NOW(),
+        $1,
+        $2,
+        $3)
+ON CONFLICT (owner, name) DO UPDATE
+SET expires_at = EXCLUDED.expires_at,
+    updated_at = NOW(),
+    version    = cards.version + 1
+RETURNING id, name, owner, created_at, updated_at, expires_at, version
+`
+
+type UpsertCardParams struct {
+	Name      string    `json:"name"`
+	Owner     uuid.UUID `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) UpsertCard(ctx context.Context, arg UpsertCardParams) (Card, error) {
+	row := q.db.QueryRowContext(ctx, upsertCard, arg.Name, arg.Owner, arg.ExpiresAt)
 	var i Card
 	err := row.Scan(
 		&i.ID,
@@ -246,6 +270,7 @@ func (q *Queries) UpdateCard(ctx context.Context, arg UpdateCardParams) (Card, e
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
+		&i.Version,
 	)
 	return i, err
 }
@@ -12,30 +12,6 @@ import (
 	"github.com/google/uuid"
 )
 
-const checkExistingCategory = `-- name: CheckExistingCategory :one
-SELECT id, created_at, updated_at, name, description, created_by FROM categories
-WHERE name = $1 AND created_by = $2
-`
-
-type CheckExistingCategoryParams struct {
-	Name      string    `json:"name"`
-	CreatedBy uuid.UUID `json:"created_by"`
-}
-
-func (q *Queries) CheckExistingCategory(ctx context.Context, arg CheckExistingCategoryParams) (Category, error) {
-	row := q.db.QueryRowContext(ctx, checkExistingCategory, arg.Name, arg.CreatedBy)
-	var i Category
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Name,
-		&i.Description,
-		&i.CreatedBy,
-	)
-	return i, err
-}
-
 const createCategory = `-- name: CreateCategory :one
 INSERT INTO categories (created_at, updated_at, name, description, created_by)
 VALUES (
@@ -45,7 +21,7 @@ $1,
 $2,
 $3
 )
-RETURNING id, created_at, updated_at, name, description, created_by
+RETURNING id, created_at, updated_at, name, description, created_by, version
 `
 
 type CreateCategoryParams struct {
@@ -64,21 +40,27 @@ func (q *Queries) CreateCategory(ctx context.Context, arg CreateCategoryParams)
 		&i.Name,
 		&i.Description,
 		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
 
 const deleteCategory = `-- name: DeleteCategory :execresult
 DELETE FROM categories
-WHERE id = $1
+WHERE id = $1 AND version = $2
 `
 
-func (q *Queries) DeleteCategory(ctx context.Context, id uuid.UUID) (sql.Result, error) {
-	return q.db.ExecContext(ctx, deleteCategory, id)
+type DeleteCategoryParams struct {
+	ID      uuid.UUID `json:"id"`
+	Version int32     `json:"version"`
+}
+
+func (q *Queries) DeleteCategory(ctx context.Context, arg DeleteCategoryParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteCategory, arg.ID, arg.Version)
 }
 
 const getCategory = `-- name: GetCategory :one
-SELECT id, created_at, updated_at, name, description, created_by FROM categories
+SELECT id, created_at, updated_at, name, description, created_by, version FROM categories
 WHERE id = $1
 `
 
@@ -92,12 +74,13 @@ func (q *Queries) GetCategory(ctx context.Context, id uuid.UUID) (Category, erro
 		&i.Name,
 		&i.Description,
 		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listCategories = `-- name: ListCategories :many
-SELECT id, created_at, updated_at, name, description, created_by FROM categories
+SELECT id, created_at, updated_at, name, description, created_by, version FROM categories
 ORDER BY name ASC
 `
 
@@ -117,6 +100,7 @@ func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
 			&i.Name,
 			&i.Description,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -132,7 +116,7 @@ func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
 }
 
 const listCategoriesForUserId = `-- name: ListCategoriesForUserId :many
-SELECT id, created_at, updated_at, name, description, created_by FROM categories
+SELECT id, created_at, updated_at, name, description, created_by, version FROM categories
 WHERE created_by = $1
 ORDER BY name ASC
 `
@@ -153,6 +137,7 @@ func (q *Queries) ListCategoriesForUserId(ctx context.Context, createdBy uuid.UU
 			&i.Name,
 			&i.Description,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -169,7 +154,7 @@ func (q *Queries) ListCategoriesForUserId(ctx context.Context, createdBy uuid.UU
 
 const resetCategories = `-- name: ResetCategories :many
 DELETE FROM categories
-RETURNING id, created_at, updated_at, name, description, created_by
+RETURNING id, created_at, updated_at, name, description, created_by, version
 `
 
 func (q *Queries) ResetCategories(ctx context.Context) ([]Category, error) {
@@ -188,6 +173,7 @@ func (q *Queries) ResetCategories(ctx context.Context) ([]Category, error) {
 			&i.Name,
 			&i.Description,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -204,19 +190,60 @@ func (q *Queries) ResetCategories(ctx context.Context) ([]Category, error) {
 
 const updateCategory = `-- name: UpdateCategory :one
 UPDATE categories
-SET name = $2, description = $3, updated_at=NOW()
-WHERE id = $1
-RETURNING id, created_at, updated_at, name, description, created_by
+SET name = $2, description = $3, updated_at=NOW(), version = version + 1
+WHERE id = $1 AND version = $4
+RETURNING id, created_at, updated_at, name, description, created_by, version
 `
 
 type UpdateCategoryParams struct {
 	ID          uuid.UUID `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
+	Version     int32     `json:"version"`
 }
 
 func (q *Queries) UpdateCategory(ctx context.Context, arg UpdateCategoryParams) (Category, error) {
-	row := q.db.QueryRowContext(ctx, updateCategory, arg.ID, arg.Name, arg.Description)
+	row := q.db.QueryRowContext(ctx, updateCategory,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Version,
+	)
+	var i Category
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Name,
+		&i.Description,
+		&i.CreatedBy,
+		&i.Version,
+	)
+	return i, err
+}
+
+const upsertCategory = `-- name: UpsertCategory :one
+INSERT INTO categories (created_at, updated_at, name, description, created_by)
+VALUES (
+NOW(),
+NOW(),
+$1,
+$2,
+$3
+)
+ON CONFLICT (created_by, name) DO UPDATE
+SET description = EXCLUDED.description, updated_at = NOW(), version = categories.version + 1
+RETURNING id, created_at, updated_at, name, description, created_by, version
+`
+
+type UpsertCategoryParams struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedBy   uuid.UUID `json:"created_by"`
+}
+
+func (q *Queries) UpsertCategory(ctx context.Context, arg UpsertCategoryParams) (Category, error) {
+	row := q.db.QueryRowContext(ctx, upsertCategory, arg.Name, arg.Description, arg.CreatedBy)
 	var i Category
 	err := row.Scan(
 		&i.ID,
@@ -225,6 +252,7 @@ func (q *Queries) UpdateCategory(ctx context.Context, arg UpdateCategoryParams)
 		&i.Name,
 		&i.Description,
 		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
@@ -0,0 +1,149 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: idempotency_keys.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $3, content_type = $4, response_body = $5, etag = $6, location = $7
+WHERE scope = $1 AND key = $2
+`
+
+type CompleteIdempotencyKeyParams struct {
+	Scope        string         `json:"scope"`
+	Key          string         `json:"key"`
+	StatusCode   sql.NullInt32  `json:"status_code"`
+	ContentType  sql.NullString `json:"content_type"`
+	ResponseBody []byte         `json:"response_body"`
+	Etag         sql.NullString `json:"etag"`
+	Location     sql.NullString `json:"location"`
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, completeIdempotencyKey,
+		arg.Scope,
+		arg.Key,
+		arg.StatusCode,
+		arg.ContentType,
+		arg.ResponseBody,
+		arg.Etag,
+		arg.Location,
+	)
+	return err
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredIdempotencyKeys)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND key = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	Scope string `json:"scope"`
+	Key   string `json:"key"`
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.ExecContext(ctx, deleteIdempotencyKey, arg.Scope, arg.Key)
+	return err
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT scope, key, fingerprint, status_code, content_type, etag, location, response_body, created_at, expires_at FROM idempotency_keys
+WHERE scope = $1 AND key = $2 AND expires_at >= NOW()
+`
+
+type GetIdempotencyKeyParams struct {
+	Scope string `json:"scope"`
+	Key   string `json:"key"`
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, getIdempotencyKey, arg.Scope, arg.Key)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Scope,
+		&i.Key,
+		&i.Fingerprint,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.Etag,
+		&i.Location,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const reserveIdempotencyKey = `-- name: ReserveIdempotencyKey :one
+INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+VALUES (
+$1,
+$2,
+$3,
+NOW(),
+$4
+)
+ON CONFLICT (scope, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    status_code = NULL,
+    content_type = NULL,
+    response_body = NULL,
+    etag = NULL,
+    location = NULL,
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < NOW()
+RETURNING scope, key, fingerprint, status_code, content_type, etag, location, response_body, created_at, expires_at
+`
+
+type ReserveIdempotencyKeyParams struct {
+	Scope       string    `json:"scope"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (q *Queries) ReserveIdempotencyKey(ctx context.Context, arg ReserveIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRowContext(ctx, reserveIdempotencyKey,
+		arg.Scope,
+		arg.Key,
+		arg.Fingerprint,
+		arg.ExpiresAt,
+	)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Scope,
+		&i.Key,
+		&i.Fingerprint,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.Etag,
+		&i.Location,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
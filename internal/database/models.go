@@ -20,6 +20,19 @@ type ActiveSubscription struct {
 	UpdatedAt        time.Time    `json:"updated_at"`
 	BillingFrequency string       `json:"billing_frequency"`
 	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
+	Version          int32        `json:"version"`
+}
+
+type ActiveSubscriptionsDuplicate struct {
+	ID               uuid.UUID    `json:"id"`
+	SubscriptionID   uuid.UUID    `json:"subscription_id"`
+	UserID           uuid.UUID    `json:"user_id"`
+	CardID           uuid.UUID    `json:"card_id"`
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+	BillingFrequency string       `json:"billing_frequency"`
+	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
+	Version          int32        `json:"version"`
 }
 
 type ActiveTrail struct {
@@ -38,6 +51,7 @@ type Card struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Version   int32     `json:"version"`
 }
 
 type Category struct {
@@ -47,6 +61,26 @@ type Category struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	CreatedBy   uuid.UUID `json:"created_by"`
+	Version     int32     `json:"version"`
+}
+
+type IdempotencyKey struct {
+	Scope        string         `json:"scope"`
+	Key          string         `json:"key"`
+	Fingerprint  string         `json:"fingerprint"`
+	StatusCode   sql.NullInt32  `json:"status_code"`
+	ContentType  sql.NullString `json:"content_type"`
+	Etag         sql.NullString `json:"etag"`
+	Location     sql.NullString `json:"location"`
+	ResponseBody []byte         `json:"response_body"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+type RateLimit struct {
+	Key       string    `json:"key"`
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type RefreshToken struct {
@@ -69,6 +103,7 @@ type Subscription struct {
 	Description    sql.NullString `json:"description"`
 	CategoryID     uuid.NullUUID  `json:"category_id"`
 	CreatedBy      uuid.UUID      `json:"created_by"`
+	Version        int32          `json:"version"`
 }
 
 type User struct {
@@ -77,4 +112,5 @@ type User struct {
 	HashedPassword string    `json:"hashed_password,omitempty"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	IsAdmin        bool      `json:"is_admin"`
 }
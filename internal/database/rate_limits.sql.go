@@ -0,0 +1,69 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: rate_limits.sql
+
+package database
+
+import (
+	"context"
+	"time"
+)
+
+const deleteStaleRateLimits = `-- name: DeleteStaleRateLimits :execrows
+DELETE FROM rate_limits
+WHERE updated_at < $1
+`
+
+// Buckets that have not been used since before are full again and can be removed.
+func (q *Queries) DeleteStaleRateLimits(ctx context.Context, before time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStaleRateLimits, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getRateLimitTokens = `-- name: GetRateLimitTokens :one
+SELECT LEAST($1::float8, tokens + EXTRACT(EPOCH FROM NOW() - updated_at)::float8 * $2::float8)::float8 AS tokens
+FROM rate_limits
+WHERE key = $3
+`
+
+type GetRateLimitTokensParams struct {
+	Capacity   float64 `json:"capacity"`
+	RefillRate float64 `json:"refill_rate"`
+	Key        string  `json:"key"`
+}
+
+func (q *Queries) GetRateLimitTokens(ctx context.Context, arg GetRateLimitTokensParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, getRateLimitTokens, arg.Capacity, arg.RefillRate, arg.Key)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limits AS bucket (key, tokens, updated_at)
+VALUES ($1, ($2::float8) - 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST($2::float8, bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at)::float8 * $3::float8) - 1,
+    updated_at = NOW()
+WHERE LEAST($2::float8, bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at)::float8 * $3::float8) >= 1
+RETURNING tokens
+`
+
+type TakeRateLimitTokenParams struct {
+	Key        string  `json:"key"`
+	Capacity   float64 `json:"capacity"`
+	RefillRate float64 `json:"refill_rate"`
+}
+
+// Takes a token from the bucket of the key, which holds up to capacity tokens and is refilled with refill_rate tokens
+// per second. Returns the tokens left, or no row when the bucket is empty.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, takeRateLimitToken, arg.Key, arg.Capacity, arg.RefillRate)
+	var tokens float64
+	err := row.Scan(&tokens)
+	return tokens, err
+}
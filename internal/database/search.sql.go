@@ -0,0 +1,157 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: search.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const searchCards = `-- name: SearchCards :many
+SELECT id, name, ts_rank(card_search_vector(name), websearch_to_tsquery('simple', $1::text))::real AS rank
+FROM cards
+WHERE owner = $2
+  AND card_search_vector(name) @@ websearch_to_tsquery('simple', $1::text)
+ORDER BY rank DESC, name ASC
+LIMIT $3
+`
+
+type SearchCardsParams struct {
+	Query      string    `json:"query"`
+	Owner      uuid.UUID `json:"owner"`
+	MaxResults int32     `json:"max_results"`
+}
+
+type SearchCardsRow struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Rank float32   `json:"rank"`
+}
+
+func (q *Queries) SearchCards(ctx context.Context, arg SearchCardsParams) ([]SearchCardsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchCards, arg.Query, arg.Owner, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchCardsRow
+	for rows.Next() {
+		var i SearchCardsRow
+		if err := rows.Scan(&i.ID, &i.Name, &i.Rank); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchCategories = `-- name: SearchCategories :many
+SELECT id, name, description, ts_rank(category_search_vector(name, description), websearch_to_tsquery('simple', $1::text))::real AS rank
+FROM categories
+WHERE created_by = $2
+  AND category_search_vector(name, description) @@ websearch_to_tsquery('simple', $1::text)
+ORDER BY rank DESC, name ASC
+LIMIT $3
+`
+
+type SearchCategoriesParams struct {
+	Query      string    `json:"query"`
+	CreatedBy  uuid.UUID `json:"created_by"`
+	MaxResults int32     `json:"max_results"`
+}
+
+type SearchCategoriesRow struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rank        float32   `json:"rank"`
+}
+
+func (q *Queries) SearchCategories(ctx context.Context, arg SearchCategoriesParams) ([]SearchCategoriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchCategories, arg.Query, arg.CreatedBy, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchCategoriesRow
+	for rows.Next() {
+		var i SearchCategoriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchSubscriptions = `-- name: SearchSubscriptions :many
+SELECT id, name, description, ts_rank(subscription_search_vector(name, description), websearch_to_tsquery('simple', $1::text))::real AS rank
+FROM subscriptions
+WHERE created_by = $2
+  AND subscription_search_vector(name, description) @@ websearch_to_tsquery('simple', $1::text)
+ORDER BY rank DESC, name ASC
+LIMIT $3
+`
+
+type SearchSubscriptionsParams struct {
+	Query      string    `json:"query"`
+	CreatedBy  uuid.UUID `json:"created_by"`
+	MaxResults int32     `json:"max_results"`
+}
+
+type SearchSubscriptionsRow struct {
+	ID          uuid.UUID      `json:"id"`
+	Name        string         `json:"name"`
+	Description sql.NullString `json:"description"`
+	Rank        float32        `json:"rank"`
+}
+
+func (q *Queries) SearchSubscriptions(ctx context.Context, arg SearchSubscriptionsParams) ([]SearchSubscriptionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchSubscriptions, arg.Query, arg.CreatedBy, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchSubscriptionsRow
+	for rows.Next() {
+		var i SearchSubscriptionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
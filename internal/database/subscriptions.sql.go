@@ -25,7 +25,7 @@ $5,
 $6,
 $7
 )
-RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by
+RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version
 `
 
 type CreateSubscriptionParams struct {
@@ -60,21 +60,27 @@ func (q *Queries) CreateSubscription(ctx context.Context, arg CreateSubscription
 		&i.Description,
 		&i.CategoryID,
 		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
 
 const deleteSubscription = `-- name: DeleteSubscription :execresult
 DELETE FROM subscriptions
-WHERE id = $1
+WHERE id = $1 AND version = $2
 `
 
-func (q *Queries) DeleteSubscription(ctx context.Context, id uuid.UUID) (sql.Result, error) {
-	return q.db.ExecContext(ctx, deleteSubscription, id)
+type DeleteSubscriptionParams struct {
+	ID      uuid.UUID `json:"id"`
+	Version int32     `json:"version"`
+}
+
+func (q *Queries) DeleteSubscription(ctx context.Context, arg DeleteSubscriptionParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteSubscription, arg.ID, arg.Version)
 }
 
 const getSubscription = `-- name: GetSubscription :one
-SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by FROM subscriptions
+SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version FROM subscriptions
 WHERE id = $1
 `
 
@@ -92,40 +98,13 @@ func (q *Queries) GetSubscription(ctx context.Context, id uuid.UUID) (Subscripti
 		&i.Description,
 		&i.CategoryID,
 		&i.CreatedBy,
-	)
-	return i, err
-}
-
-const getSubscriptionByNameAndCreator = `-- name: GetSubscriptionByNameAndCreator :one
-SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by FROM subscriptions
-WHERE created_by = $1 AND name = $2
-`
-
-type GetSubscriptionByNameAndCreatorParams struct {
-	CreatedBy uuid.UUID `json:"created_by"`
-	Name      string    `json:"name"`
-}
-
-func (q *Queries) GetSubscriptionByNameAndCreator(ctx context.Context, arg GetSubscriptionByNameAndCreatorParams) (Subscription, error) {
-	row := q.db.QueryRowContext(ctx, getSubscriptionByNameAndCreator, arg.CreatedBy, arg.Name)
-	var i Subscription
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.MonthlyCost,
-		&i.Currency,
-		&i.UnsubscribeUrl,
-		&i.Description,
-		&i.CategoryID,
-		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
 
 const listSubscriptions = `-- name: ListSubscriptions :many
-SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by FROM subscriptions
+SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version FROM subscriptions
 ORDER BY name ASC
 `
 
@@ -149,6 +128,7 @@ func (q *Queries) ListSubscriptions(ctx context.Context) ([]Subscription, error)
 			&i.Description,
 			&i.CategoryID,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -164,7 +144,7 @@ func (q *Queries) ListSubscriptions(ctx context.Context) ([]Subscription, error)
 }
 
 const listSubscriptionsForUserId = `-- name: ListSubscriptionsForUserId :many
-SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by FROM subscriptions
+SELECT id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version FROM subscriptions
 WHERE created_by = $1
 ORDER BY name ASC
 `
@@ -189,6 +169,7 @@ func (q *Queries) ListSubscriptionsForUserId(ctx context.Context, createdBy uuid
 			&i.Description,
 			&i.CategoryID,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -205,7 +186,7 @@ func (q *Queries) ListSubscriptionsForUserId(ctx context.Context, createdBy uuid
 
 const resetSubscriptions = `-- name: ResetSubscriptions :many
 DELETE FROM subscriptions
-RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by
+RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version
 `
 
 func (q *Queries) ResetSubscriptions(ctx context.Context) ([]Subscription, error) {
@@ -228,6 +209,7 @@ func (q *Queries) ResetSubscriptions(ctx context.Context) ([]Subscription, error
 			&i.Description,
 			&i.CategoryID,
 			&i.CreatedBy,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -244,9 +226,9 @@ func (q *Queries) ResetSubscriptions(ctx context.Context) ([]Subscription, error
 
 const updateSubscription = `-- name: UpdateSubscription :one
 UPDATE subscriptions
-SET name = $2, monthly_cost = $3, currency=$4, unsubscribe_url=$5, description=$6, category_id=$7, updated_at=NOW()
-WHERE id = $1
-RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by
+SET name = $2, monthly_cost = $3, currency=$4, unsubscribe_url=$5, description=$6, category_id=$7, updated_at=NOW(), version = version + 1
+WHERE id = $1 AND version = $8
+RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version
 `
 
 type UpdateSubscriptionParams struct {
@@ -257,6 +239,7 @@ type UpdateSubscriptionParams struct {
 	UnsubscribeUrl sql.NullString `json:"unsubscribe_url"`
 	Description    sql.NullString `json:"description"`
 	CategoryID     uuid.NullUUID  `json:"category_id"`
+	Version        int32          `json:"version"`
 }
 
 func (q *Queries) UpdateSubscription(ctx context.Context, arg UpdateSubscriptionParams) (Subscription, error) {
@@ -268,6 +251,63 @@ func (q *Queries) UpdateSubscription(ctx context.Context, arg UpdateSubscription
 		arg.UnsubscribeUrl,
 		arg.Description,
 		arg.CategoryID,
+		arg.Version,
+	)
+	var i Subscription
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.MonthlyCost,
+		&i.Currency,
+		&i.UnsubscribeUrl,
+		&i.Description,
+		&i.CategoryID,
+		&i.CreatedBy,
+		&i.Version,
+	)
+	return i, err
+}
+
+const upsertSubscription = `-- name: UpsertSubscription :one
+INSERT INTO subscriptions (created_at, updated_at, name, monthly_cost, currency, unsubscribe_url, description, category_id, created_by)
+VALUES (
+NOW(),
+NOW(),
+$1,
+$2,
+$3,
+$4,
+$5,
+$6,
+$7
+)
+ON CONFLICT (created_by, name) DO UPDATE
+SET monthly_cost = EXCLUDED.monthly_cost, currency = EXCLUDED.currency, unsubscribe_url = EXCLUDED.unsubscribe_url,
+    description = EXCLUDED.description, category_id = EXCLUDED.category_id, updated_at = NOW(), version = subscriptions.version + 1
+RETURNING id, name, created_at, updated_at, monthly_cost, currency, unsubscribe_url, description, category_id, created_by, version
+`
+
+type UpsertSubscriptionParams struct {
+	Name           string         `json:"name"`
+	MonthlyCost    int32          `json:"monthly_cost"`
+	Currency       string         `json:"currency"`
+	UnsubscribeUrl sql.NullString `json:"unsubscribe_url"`
+	Description    sql.NullString `json:"description"`
+	CategoryID     uuid.NullUUID  `json:"category_id"`
+	CreatedBy      uuid.UUID      `json:"created_by"`
+}
+
+func (q *Queries) UpsertSubscription(ctx context.Context, arg UpsertSubscriptionParams) (Subscription, error) {
+	row := q.db.QueryRowContext(ctx, upsertSubscription,
+		arg.Name,
+		arg.MonthlyCost,
+		arg.Currency,
+		arg.UnsubscribeUrl,
+		arg.Description,
+		arg.CategoryID,
+		arg.CreatedBy,
 	)
 	var i Subscription
 	err := row.Scan(
@@ -281,6 +321,7 @@ func (q *Queries) UpdateSubscription(ctx context.Context, arg UpdateSubscription
 		&i.Description,
 		&i.CategoryID,
 		&i.CreatedBy,
+		&i.Version,
 	)
 	return i, err
 }
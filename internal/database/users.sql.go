@@ -13,6 +13,17 @@ import (
 	"github.com/google/uuid"
 )
 
+const countUsers = `-- name: CountUsers :one
+SELECT count(*) FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (created_at, updated_at, email, hashed_password)
 VALUES (
@@ -58,7 +69,7 @@ func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (sql.Result, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, hashed_password, created_at, updated_at FROM users
+SELECT id, email, hashed_password, created_at, updated_at, is_admin FROM users
 WHERE email = $1
 `
 
@@ -71,12 +82,13 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.HashedPassword,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
 
 const getUserById = `-- name: GetUserById :one
-SELECT id, email, hashed_password, created_at, updated_at FROM users
+SELECT id, email, hashed_password, created_at, updated_at, is_admin FROM users
 WHERE id = $1
 `
 
@@ -89,6 +101,7 @@ func (q *Queries) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.HashedPassword,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
@@ -135,7 +148,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]ListUsersRow, error) {
 
 const resetUsers = `-- name: ResetUsers :many
 DELETE FROM users
-RETURNING id, email, hashed_password, created_at, updated_at
+RETURNING id, email, hashed_password, created_at, updated_at, is_admin
 `
 
 func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
@@ -153,6 +166,7 @@ func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
 			&i.HashedPassword,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.IsAdmin,
 		); err != nil {
 			return nil, err
 		}
@@ -167,11 +181,37 @@ func (q *Queries) ResetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
+const setUserAdmin = `-- name: SetUserAdmin :one
+UPDATE users
+SET is_admin = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, email, hashed_password, created_at, updated_at, is_admin
+`
+
+type SetUserAdminParams struct {
+	ID      uuid.UUID `json:"id"`
+	IsAdmin bool      `json:"is_admin"`
+}
+
+func (q *Queries) SetUserAdmin(ctx context.Context, arg SetUserAdminParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserAdmin, arg.ID, arg.IsAdmin)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.HashedPassword,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.IsAdmin,
+	)
+	return i, err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET email = $2, hashed_password = $3, updated_at = $4
 WHERE id = $1
-RETURNING id, email, hashed_password, created_at, updated_at
+RETURNING id, email, hashed_password, created_at, updated_at, is_admin
 `
 
 type UpdateUserParams struct {
@@ -195,6 +235,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.HashedPassword,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.IsAdmin,
 	)
 	return i, err
 }
@@ -0,0 +1,88 @@
+/*
+Package logging configures the structured logger of the server: JSON lines written with log/slog, from which secrets
+and personal data are redacted whatever logs them, and a logger per request that is carried in the context.
+*/
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Redacted replaces the values of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute keys whose values are never logged, such as "password" or "refresh_token".
+var sensitiveKeys = []string{"password", "hash", "token", "secret", "authorization", "cookie", "connection_string"}
+
+// New returns a logger writing JSON lines to w, with the sensitive attributes redacted, see Redact.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: Redact,
+	}))
+}
+
+/*
+Redact is a slog.HandlerOptions.ReplaceAttr function. It replaces the value of attributes whose key contains one of
+the sensitive keys, in any group, and masks the local part of email addresses. Types that contain secrets should
+implement slog.LogValuer as well, since their fields are only redacted when they are logged as a group.
+*/
+func Redact(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+	if key == "email" && a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	}
+	return a
+}
+
+// MaskEmail keeps the first character and the domain of an email address, "jane@example.com" becomes
+// "j***@example.com". Anything that is not an email address is redacted completely.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return Redacted
+	}
+	return local[:1] + "***@" + domain
+}
+
+// -- Request loggers
+
+// Packages should define keys as an unexported type to avoid collisions.
+type loggerKey string
+
+const loggerCtxKey loggerKey = "logger"
+
+// requestLogger is stored in the context by pointer, so that middleware further down the chain can add attributes
+// that the access log, written by middleware further up, includes as well.
+type requestLogger struct {
+	logger *slog.Logger
+}
+
+// WithLogger returns a context carrying logger, which FromContext returns.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey, &requestLogger{logger: logger})
+}
+
+// FromContext returns the logger of the request, or the default logger outside of requests.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerCtxKey).(*requestLogger); ok {
+		return l.logger
+	}
+	return slog.Default()
+}
+
+// AddAttrs adds attributes to the logger of the request for the rest of the request, such as the ID of the user once
+// they are authenticated. It does nothing outside of requests.
+func AddAttrs(ctx context.Context, args ...any) {
+	if l, ok := ctx.Value(loggerCtxKey).(*requestLogger); ok {
+		l.logger = l.logger.With(args...)
+	}
+}
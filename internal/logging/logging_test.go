@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	logger.Info("login",
+		"email", "jane.doe@example.com",
+		"password", "Syp9393-Syp9292-Syp9191",
+		slog.Group("response", "refresh_token", "8f1c0a", "id", 42),
+		"Authorization", "Bearer eyJhbGciOi",
+		"hashed_password", "$2a$10$abc",
+	)
+
+	for _, secret := range []string{"jane.doe", "Syp9393", "8f1c0a", "eyJhbGciOi", "$2a$10$abc"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("expected %q to be redacted, got %s", secret, buf.String())
+		}
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %s", buf.String())
+	}
+	if entry["email"] != "j***@example.com" {
+		t.Errorf("expected the email to be masked, got %v", entry["email"])
+	}
+	if response := entry["response"].(map[string]any); response["id"] != float64(42) {
+		t.Errorf("expected other attributes to be kept, got %v", response)
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	tests := map[string]string{
+		"jane@example.com": "j***@example.com",
+		"@example.com":     Redacted,
+		"not an email":     Redacted,
+	}
+	for email, want := range tests {
+		if got := MaskEmail(email); got != want {
+			t.Errorf("MaskEmail(%q) = %q, expected %q", email, got, want)
+		}
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), New(&buf, slog.LevelInfo).With("request_id", "abc"))
+
+	AddAttrs(ctx, "user_id", "42")
+	FromContext(ctx).Info("served")
+
+	for _, want := range []string{`"request_id":"abc"`, `"user_id":"42"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %s in %s", want, buf.String())
+		}
+	}
+
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("expected the default logger outside of requests")
+	}
+	// Adding attributes outside of a request must not panic
+	AddAttrs(context.Background(), "user_id", "42")
+}
@@ -1,6 +1,126 @@
 package main
 
-// TODO: Read up on how loggers work
-type Logger interface {
-	Log()
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+	"github.com/google/uuid"
+)
+
+// -- Logging
+//
+// The server logs JSON lines, see the logging package. Every request is given an ID, taken from the X-Request-ID
+// header when the client or a proxy in front of the server sets a valid one, which is returned in the response and
+// added to every line logged with the logger of the request. Handlers log with logging.FromContext(r.Context()).
+
+const (
+	requestIdHeader = "X-Request-ID"
+	// maxRequestIdLength bounds request IDs taken from clients, which end up in every line logged for the request
+	maxRequestIdLength = 128
+)
+
+type requestIdKey string
+
+const requestIdCtxKey requestIdKey = "requestId"
+
+// WithRequestId returns a context carrying the ID of the request.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey, requestId)
+}
+
+// GetRequestId returns the ID of the request, or an empty string outside of requests.
+func GetRequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdCtxKey).(string)
+	return requestId
+}
+
+// validRequestId reports whether a request ID set by the client can be used as is. Only IDs made of letters, digits
+// and a few separators are accepted, so that a client cannot forge log lines or headers with them.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestId gives every request an ID and a logger that includes it.
+func withRequestId(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+
+		ctx := WithRequestId(r.Context(), requestId)
+		ctx = logging.WithLogger(ctx, logger.With("request_id", requestId))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// statusRecorder records the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+/*
+logRequests writes a line per request once it has been served. Only the path of the URL is logged, since query
+strings may contain search terms and other personal data. Server errors are logged at the error level, their cause
+has already been logged by writeProblem.
+*/
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).LogAttrs(r.Context(), level, "request served",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(logging.New(&buf, slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	handler, store, user, token := newAuthenticatedServer(t, newTestConfig())
+	if _, err := store.SetUserAdmin(context.Background(), database.SetUserAdminParams{ID: user.ID, IsAdmin: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// serve returns the response and the access log line of a request
+	serve := func(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, map[string]any) {
+		t.Helper()
+		buf.Reset()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+			t.Fatalf("expected a JSON access log, got %s", buf.String())
+		}
+		return rec, entry
+	}
+
+	t.Run("it propagates the request ID of the client", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/subscriptions?q=netflix", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(requestIdHeader, "edge-1234")
+
+		rec, entry := serve(t, req)
+		if got := rec.Header().Get(requestIdHeader); got != "edge-1234" {
+			t.Errorf("expected the request ID to be returned, got %q", got)
+		}
+		if entry["request_id"] != "edge-1234" || entry["user_id"] != user.ID.String() {
+			t.Errorf("expected the access log to name the request and the user, got %v", entry)
+		}
+		if entry["path"] != "/api/subscriptions" || entry["status"] != float64(http.StatusOK) {
+			t.Errorf("expected the path without query and the status, got %v", entry)
+		}
+		if strings.Contains(buf.String(), token) || strings.Contains(buf.String(), "netflix") {
+			t.Errorf("expected no token or query in the logs, got %s", buf.String())
+		}
+	})
+
+	t.Run("it replaces invalid request IDs", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+		req.Header.Set(requestIdHeader, "forged\"}\n{\"level\":\"ERROR\"")
+
+		rec, entry := serve(t, req)
+		requestId := rec.Header().Get(requestIdHeader)
+		if requestId == "" || strings.Contains(requestId, "forged") {
+			t.Errorf("expected a generated request ID, got %q", requestId)
+		}
+		if entry["request_id"] != requestId || entry["status"] != float64(http.StatusUnauthorized) {
+			t.Errorf("expected the generated request ID and status 401 in the access log, got %v", entry)
+		}
+	})
+
+	t.Run("it does not log passwords", func(t *testing.T) {
+		body := `{"email": "renamed@example.com", "password": "Kup1212-Kup3434-Kup5656"}`
+		req := httptest.NewRequest(http.MethodPut, "/api/users/"+user.ID.String(), strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		serve(t, req)
+		for _, secret := range []string{"Kup1212", "renamed@", "$2a$"} {
+			if strings.Contains(buf.String(), secret) {
+				t.Errorf("expected %q not to be logged, got %s", secret, buf.String())
+			}
+		}
+	})
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -24,95 +28,278 @@ const (
 	gracefulShutdownTimeout = 10 * time.Second
 )
 
-// TODO: Implement support for parsing os.Args in run() to allow quick change of the app's behaviour. I.e. debug mode or changing logging levels.
+// command is a subcommand of the server binary, such as serve or migrate.
+type command struct {
+	usage   string
+	summary string
+	run     func(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error
+}
+
+var commands = map[string]command{
+	"serve":          {"serve [flags]", "run the HTTP server, the default when no command is given", runServe},
+	"migrate":        {"migrate up|down|status [flags]", "apply, roll back or list the database migrations", runMigrate},
+	"create-admin":   {"create-admin -email EMAIL [flags]", "create an admin user, or promote an existing user", runCreateAdmin},
+	"reset-password": {"reset-password -email EMAIL [flags]", "set a new random password and revoke the refresh token", runResetPassword},
+	"seed-demo":      {"seed-demo [-email EMAIL] [flags]", "create a demo user with example data", runSeedDemo},
+	"export-user":    {"export-user -email EMAIL [-out FILE] [flags]", "export all data of a user as JSON", runExportUser},
+	"import-user":    {"import-user -email EMAIL -in FILE [flags]", "import an export of export-user into a user", runImportUser},
+}
 
-// run prepares and runs the server. Preperation is done by parsing environment variables and flags used to initialize
-// various external datasources such as databases and logfiles. These parameters can be mocked and used to call run() from unit tests.
-func run(ctx context.Context, w io.Writer, getenv func(string) string) error {
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+// run runs the command named by the first argument, or the server when no command is given. Every command reads its
+// configuration from flags, environment variables and an optional config file, see loadConfig. These parameters can
+// be mocked and used to call run() from unit tests.
+func run(ctx context.Context, args []string, w io.Writer, getenv func(string) string) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	// Handle environment variables
 	godotenv.Load()
-	dbConnString := getenv("DB_CONNECTION_STRING")
-	host := getenv("SVC_HOST")
-	port := getenv("SVC_PORT")
-	jwtSecret := getenv("JWT_SECRET")
 
-	// Validate inputs
-	if dbConnString == "" {
-		return fmt.Errorf("DB_CONNECTION_STRING not set")
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Fprint(w, usage())
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n\n%s", name, usage())
+	}
+
+	err := cmd.run(ctx, w, args, getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
 	}
+	return err
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("usage: unsubtle-core [COMMAND] [flags]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "  %-46s %s\n", commands[name].usage, commands[name].summary)
+	}
+	b.WriteString("\nRun unsubtle-core COMMAND -h for the flags of a command.\n")
+	return b.String()
+}
 
-    if jwtSecret == "" {
-        return fmt.Errorf("JWT_SECRET not set")
-    }
+// newFlagSet returns the flag set of a command. The configuration flags are added to it by loadConfig.
+func newFlagSet(name string, w io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	return fs
+}
 
-	if host == "" {
-		host = defaultHost
+// openDatabase opens the connection pool described by the configuration.
+func openDatabase(config *Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", config.Database.ConnectionString)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database connection: %w", err)
 	}
+	db.SetMaxOpenConns(config.Database.MaxOpenConns)
+	db.SetMaxIdleConns(config.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(config.Database.ConnMaxLifetime)
+	return db, nil
+}
 
-	if port == "" {
-		port = defaultPort
+// runServe prepares and runs the server until ctx is cancelled, which run does on an interrupt or SIGTERM.
+func runServe(ctx context.Context, w io.Writer, args []string, getenv func(string) string) error {
+	fs := newFlagSet("serve", w)
+	config, err := loadConfig(fs, args, getenv, (*Config).validate)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
 	}
+	// Everything is logged as JSON lines, the log package included
+	slog.SetDefault(logging.New(os.Stderr, config.LogLevel))
 
-	// Build configuration
-	config := Config{
-		Database: &DatabaseConfig{dbConnString},
-		Service:  &ServiceConfig{host, port},
-        JWTSecret: jwtSecret,
+	shutdownTracing, err := setupTracing(ctx, config, w)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		// The spans of the last requests are exported even though ctx is done by now
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), gracefulShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("could not export the remaining spans", "error", err)
+		}
+	}()
 
 	// Initialize database
-	db, err := sql.Open("postgres", config.Database.ConnectionString)
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// Queries are timed for the metrics by the name sqlc gave them
+	dbStore := newSQLStore(db)
+
+	// Refuse to serve a schema this binary does not know, optionally migrating it first
+	migrator, err := newMigrator(db, migrationsFS())
 	if err != nil {
-		log.Fatalf("could not open database connection: %s", err)
+		return err
+	}
+	if err := prepareSchema(ctx, migrator, config.Database.MigrateOnStart); err != nil {
+		return err
+	}
+
+	// Readiness follows the database, its schema and the background workers
+	health := newHealth()
+	health.addCheck("database", db.PingContext)
+	health.addCheck("migrations", func(ctx context.Context) error {
+		return checkSchemaReady(ctx, migrator)
+	})
+
+	// Remove idempotency keys that can no longer be replayed
+	health.goWorker("idempotency_cleanup", func() {
+		cleanupIdempotencyKeys(ctx, dbStore.Queries, idempotencyCleanupInterval)
+	})
+
+	// Buckets of the shared rate limits are removed once they are full again
+	if config.RateLimit.Backend == rateLimitBackendPostgres {
+		health.goWorker("rate_limit_cleanup", func() {
+			cleanupRateLimits(ctx, dbStore.Queries, config.RateLimit, rateLimitSweepInterval)
+		})
 	}
-	dbStore := database.New(db)
 
 	// Initialize server
-	server := &http.Server{
-		Handler: NewServerHandler(
-			&config,
-			dbStore,
-		),
-		Addr: config.Service.Address(),
+	server := newHTTPServer(config, config.Service.Address(), NewServerHandler(
+		config,
+		dbStore,
+		health,
+	))
+
+	// The certificate is reloaded when its files change or on SIGHUP, without restarting the listeners
+	var certificates *certReloader
+	if config.TLS.Enabled() {
+		if certificates, err = newCertReloader(config.TLS.CertFile, config.TLS.KeyFile); err != nil {
+			return err
+		}
+		server.TLSConfig = serverTLSConfig(certificates)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		health.goWorker("certificate_reload", func() {
+			certificates.watch(ctx, certificateCheckInterval, hup)
+		})
+	}
+
+	// The admin listener serves the metrics apart from the API, so that they need not be exposed with it
+	var adminServer *http.Server
+	if config.Admin.Address != "" {
+		adminServer = newHTTPServer(config, config.Admin.Address, NewAdminHandler(config, dbStore))
+		if config.Admin.ClientCAFile != "" {
+			if adminServer.TLSConfig, err = adminTLSConfig(certificates, config.Admin.ClientCAFile); err != nil {
+				return err
+			}
+		}
 	}
 
+	// Errors of the listeners other than being shut down end the server
+	serveErrs := make(chan error, 3)
+
 	// Entrypoint for new connections. Keeps on running for as long as the server is not closed.
 	go func() {
-		log.Printf("Listening on %s\n", server.Addr)
-		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-			log.Fatalf("HTTP server error: %v", err)
+		slog.Info("listening", "address", server.Addr, "tls", config.TLS.Enabled())
+		serve := server.ListenAndServe
+		if config.TLS.Enabled() {
+			// The certificate is taken from server.TLSConfig
+			serve = func() error { return server.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); !errors.Is(err, http.ErrServerClosed) {
+			serveErrs <- fmt.Errorf("HTTP server error: %w", err)
+			return
 		}
-		log.Printf("Stopped accepting new connections")
-		return
+		slog.Info("stopped accepting new connections")
 	}()
 
-	// Gracefully shutdown the server whenever an os.Interrupt occurs, otherwise keep blocking code execution.
-	// We give the server graceFulShutdownTimeout amount of seconds to shutdown.
-	<-ctx.Done()
+	// Plain HTTP requests are sent to the HTTPS listener
+	var redirectServer *http.Server
+	if config.TLS.RedirectAddress != "" {
+		redirectServer = newHTTPServer(config, config.TLS.RedirectAddress, handleRedirectToHTTPS(config.Service.Port))
+		go func() {
+			slog.Info("redirecting to HTTPS", "address", redirectServer.Addr)
+			if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErrs <- fmt.Errorf("redirect listener error: %w", err)
+			}
+		}()
+	}
+
+	if adminServer != nil {
+		serveAdmin := adminServer.ListenAndServe
+		if adminServer.TLSConfig != nil {
+			serveAdmin = func() error { return adminServer.ListenAndServeTLS("", "") }
+		}
+		go func() {
+			slog.Info("admin listener listening", "address", adminServer.Addr, "mtls", config.Admin.ClientCAFile != "")
+			if err := serveAdmin(); !errors.Is(err, http.ErrServerClosed) {
+				serveErrs <- fmt.Errorf("admin listener error: %w", err)
+			}
+		}()
+	}
 
-	// Signal received and stop blocking.
-	log.Println("Shutting down server")
+	// Keep serving until a signal is received or a listener fails
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-serveErrs:
+	}
+
+	// Fail readiness first and give load balancers the drain delay to notice, so that they stop sending new requests
+	// before the listener is closed. Shutdown then waits for the requests in flight. ctx is already cancelled here,
+	// so the shutdown has a deadline of its own.
+	health.drain()
+	if serveErr == nil {
+		slog.Info("shutting down server", "drain_delay", config.Service.DrainDelay)
+		time.Sleep(config.Service.DrainDelay)
+	}
 
-	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, gracefulShutdownTimeout)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.WithoutCancel(ctx), gracefulShutdownTimeout)
 	defer shutdownCancel()
 
+	var errs []error
+	if serveErr != nil {
+		errs = append(errs, serveErr)
+	}
 	// This will cause ListenAndServe to immediately return ErrServerClosed but keep waiting for all
-	// connections to be gracefully handler for gracefulShutdownTimeout amount of seconds.
+	// connections to be gracefully handled until shutdownCtx expires.
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("Could not close server: %s", err)
+		errs = append(errs, fmt.Errorf("could not close server: %w", err))
+	}
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("could not close redirect listener: %w", err))
+		}
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("could not close admin listener: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 
 	// We are now safe to exit the program!
-	log.Println("Server gracefully stopped")
+	slog.Info("server gracefully stopped")
 	return nil
 }
 
 func main() {
 	ctx := context.Background()
-	if err := run(ctx, os.Stdout, os.Getenv); err != nil {
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Getenv); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
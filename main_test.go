@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEnv returns a getenv function that looks up variables in env.
+func fakeEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	required := map[string]string{
+		"DB_CONNECTION_STRING": "postgres://localhost/unsubtle",
+		"JWT_SECRET":           "secret",
+		"SVC_PORT":             "0",
+	}
+	with := func(overrides map[string]string) map[string]string {
+		env := map[string]string{}
+		for k, v := range required {
+			env[k] = v
+		}
+		for k, v := range overrides {
+			env[k] = v
+		}
+		return env
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr []string
+	}{
+		{
+			name:    "it requires a connection string and a JWT secret",
+			env:     map[string]string{},
+			wantErr: []string{"DB_CONNECTION_STRING not set", "JWT_SECRET not set"},
+		},
+		{
+			name:    "it rejects malformed values",
+			env:     with(map[string]string{"BCRYPT_COST": "high", "ACCESS_TOKEN_LIFETIME": "1 hour"}),
+			wantErr: []string{"BCRYPT_COST: \"high\" is not an integer", "ACCESS_TOKEN_LIFETIME: \"1 hour\" is not a duration"},
+		},
+		{
+			name:    "it rejects values out of range",
+			args:    []string{"-bcrypt-cost", "40", "-db-max-open-conns", "2", "-db-max-idle-conns", "5", "-admin-address", "9090", "-tracing-exporter", "jaeger", "-read-header-timeout", "30s", "-max-body-bytes", "0", "-admin-client-ca-file", "ca.pem", "-tls-redirect-address", ":80", "-rate-limit-backend", "redis", "-rate-limit-auth-requests", "0"},
+			env:     with(nil),
+			wantErr: []string{"bcrypt-cost must be between", "cannot exceed db-max-open-conns", `admin-address "9090"`, `tracing-exporter "jaeger"`, "read-header-timeout cannot exceed read-timeout", "max-body-bytes must be positive", "admin-client-ca-file requires", "tls-redirect-address requires", `rate-limit-backend "redis"`, "rate limits must be positive"},
+		},
+		{
+			name:    "it rejects invalid CORS settings and half configured TLS",
+			env:     with(map[string]string{"CORS_ALLOWED_ORIGINS": "https://app.example.com, example.com, *", "CORS_ALLOW_CREDENTIALS": "true", "CORS_ALLOWED_METHODS": "GET, TRACE", "TLS_CERT_FILE": "cert.pem"}),
+			wantErr: []string{`CORS origin "example.com"`, "cors-allow-credentials cannot be combined", `CORS method "TRACE"`, "tls-cert-file and tls-key-file must be set together"},
+		},
+		{
+			name:    "it rejects unknown flags",
+			args:    []string{"-verbose"},
+			env:     with(nil),
+			wantErr: []string{"flag provided but not defined: -verbose"},
+		},
+		{
+			// A valid configuration gets as far as checking the database schema, which the cancelled context aborts
+			name:    "it lets flags override invalid environment variables",
+			args:    []string{"-bcrypt-cost", "10"},
+			env:     with(map[string]string{"BCRYPT_COST": "99"}),
+			wantErr: []string{"reading the database schema version", "context canceled"},
+		},
+		{
+			name:    "it prints the usage on -h",
+			args:    []string{"-h"},
+			env:     map[string]string{},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A cancelled context makes run stop before it reaches the database
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var out bytes.Buffer
+			err := run(ctx, tt.args, &out, fakeEnv(tt.env))
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if tt.wantErr != nil && err == nil {
+				t.Fatalf("expected an error containing %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got %s", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	yamlFile := writeConfigFile(t, "config.yaml", `
+log_level: warn
+jwt_secret: from-file
+service:
+  port: "9000"
+database:
+  connection_string: postgres://file/unsubtle
+  max_open_conns: 50
+  conn_max_lifetime: 5m
+auth:
+  bcrypt_cost: 12
+cors:
+  allowed_origins: [https://a.example.com, https://b.example.com]
+`)
+	tomlFile := writeConfigFile(t, "config.toml", `
+jwt_secret = "from-file"
+
+[database]
+connection_string = "postgres://file/unsubtle"
+
+[auth]
+access_token_lifetime = "15m"
+`)
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "it uses defaults for optional settings",
+			env:  map[string]string{"DB_CONNECTION_STRING": "postgres://env/unsubtle", "JWT_SECRET": "secret"},
+			check: func(t *testing.T, cfg *Config) {
+				want := defaultConfig()
+				want.Database.ConnectionString = "postgres://env/unsubtle"
+				want.JWTSecret = "secret"
+				if !reflect.DeepEqual(cfg, want) {
+					t.Errorf("expected %+v, got %+v", want, cfg)
+				}
+			},
+		},
+		{
+			name: "it reads a YAML config file",
+			args: []string{"-config", yamlFile},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.LogLevel != slog.LevelWarn || cfg.Service.Port != "9000" || cfg.Database.MaxOpenConns != 50 ||
+					cfg.Database.ConnMaxLifetime != 5*time.Minute || cfg.Auth.BcryptCost != 12 {
+					t.Errorf("config file was not applied: %+v", cfg)
+				}
+				if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(cfg.CORS.AllowedOrigins, want) {
+					t.Errorf("expected origins %v, got %v", want, cfg.CORS.AllowedOrigins)
+				}
+			},
+		},
+		{
+			name: "it reads a TOML config file named by CONFIG_FILE",
+			env:  map[string]string{"CONFIG_FILE": tomlFile},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Auth.AccessTokenLifetime != 15*time.Minute || cfg.JWTSecret != "from-file" {
+					t.Errorf("config file was not applied: %+v", cfg.Auth)
+				}
+			},
+		},
+		{
+			name: "it prefers flags over the environment over the config file",
+			args: []string{"-config", yamlFile, "-port", "7000"},
+			env:  map[string]string{"SVC_PORT": "8000", "DB_MAX_OPEN_CONNS": "60", "JWT_SECRET": "from-env"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Service.Port != "7000" {
+					t.Errorf("expected the port flag to win, got %s", cfg.Service.Port)
+				}
+				if cfg.Database.MaxOpenConns != 60 || cfg.JWTSecret != "from-env" {
+					t.Errorf("expected the environment to override the file, got %d and %s", cfg.Database.MaxOpenConns, cfg.JWTSecret)
+				}
+				if cfg.Auth.BcryptCost != 12 {
+					t.Errorf("expected the file to override the default, got %d", cfg.Auth.BcryptCost)
+				}
+			},
+		},
+		{
+			name: "it lowers the log level in debug mode",
+			args: []string{"-config", yamlFile, "-debug"},
+			check: func(t *testing.T, cfg *Config) {
+				if !cfg.Debug || cfg.LogLevel != slog.LevelDebug {
+					t.Errorf("expected debug mode with log level debug, got %v and %s", cfg.Debug, cfg.LogLevel)
+				}
+			},
+		},
+		{
+			name:    "it rejects unknown settings in the config file",
+			args:    []string{"-config", writeConfigFile(t, "typo.yaml", "database:\n  max_open_connections: 5\n")},
+			wantErr: "unknown settings database.max_open_connections",
+		},
+		{
+			name:    "it rejects unsupported config file formats",
+			args:    []string{"-config", writeConfigFile(t, "config.json", "{}")},
+			wantErr: `unsupported format ".json"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			cfg, err := loadConfig(fs, tt.args, fakeEnv(tt.env), (*Config).validate)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
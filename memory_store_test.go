@@ -0,0 +1,945 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+/*
+memoryStore is a stateful, in-memory implementation of dbQuerier and the only fake database of the tests. It behaves
+like the database: created resources can be read, updated and deleted again, and versioned updates are conditional.
+This makes it possible to run end-to-end tests against NewServerHandler, while the handler tests fill it with the rows
+they need, see newFakeStore.
+*/
+type memoryStore struct {
+	mu sync.Mutex
+
+	// err, when set, is returned by every query to test how a failing database is handled
+	err error
+
+	users               map[uuid.UUID]database.User
+	refreshTokens       map[uuid.UUID]database.RefreshToken
+	subscriptions       map[uuid.UUID]database.Subscription
+	categories          map[uuid.UUID]database.Category
+	cards               map[uuid.UUID]database.Card
+	activeSubscriptions map[uuid.UUID]database.ActiveSubscription
+	idempotencyKeys     map[[2]string]database.IdempotencyKey
+	rateLimits          map[string]memoryRateLimit
+}
+
+// memoryRateLimit is a row of the rate_limits table.
+type memoryRateLimit struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:               map[uuid.UUID]database.User{},
+		refreshTokens:       map[uuid.UUID]database.RefreshToken{},
+		subscriptions:       map[uuid.UUID]database.Subscription{},
+		categories:          map[uuid.UUID]database.Category{},
+		cards:               map[uuid.UUID]database.Card{},
+		activeSubscriptions: map[uuid.UUID]database.ActiveSubscription{},
+		idempotencyKeys:     map[[2]string]database.IdempotencyKey{},
+		rateLimits:          map[string]memoryRateLimit{},
+	}
+}
+
+// failingStore returns an empty memoryStore whose queries all return err.
+func failingStore(err error) *memoryStore {
+	store := newMemoryStore()
+	store.err = err
+	return store
+}
+
+/*
+newAuthenticatedServer serves NewServerHandler with config from a memoryStore that holds a single user, and returns a
+JWT of that user. Tests change config before calling it to configure the server.
+*/
+func newAuthenticatedServer(t *testing.T, config *Config) (http.Handler, *memoryStore, database.CreateUserRow, string) {
+	t.Helper()
+	store := newMemoryStore()
+	handler := NewServerHandler(config, store, newHealth())
+
+	user, err := createUser(context.Background(), store, config, userRequestData{Email: "user@example.com", Password: "Syp9393-Syp9292-Syp9191"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, config.JWTSecret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler, store, user, token
+}
+
+// -- Transactions
+
+/*
+WithTx runs fn against the store and restores the tables as they were before when fn fails, like a rolled back
+transaction. Unlike the database it does not isolate concurrent transactions: a rollback also undoes what others wrote
+in the meantime, which the tests do not rely on.
+*/
+func (s *memoryStore) WithTx(_ context.Context, fn func(dbQuerier) error) error {
+	s.mu.Lock()
+	snapshot := memoryStore{
+		users:               maps.Clone(s.users),
+		refreshTokens:       maps.Clone(s.refreshTokens),
+		subscriptions:       maps.Clone(s.subscriptions),
+		categories:          maps.Clone(s.categories),
+		cards:               maps.Clone(s.cards),
+		activeSubscriptions: maps.Clone(s.activeSubscriptions),
+		idempotencyKeys:     maps.Clone(s.idempotencyKeys),
+		rateLimits:          maps.Clone(s.rateLimits),
+	}
+	s.mu.Unlock()
+
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.users, s.refreshTokens, s.subscriptions, s.categories = snapshot.users, snapshot.refreshTokens, snapshot.subscriptions, snapshot.categories
+		s.cards, s.activeSubscriptions, s.idempotencyKeys, s.rateLimits = snapshot.cards, snapshot.activeSubscriptions, snapshot.idempotencyKeys, snapshot.rateLimits
+		return err
+	}
+	return nil
+}
+
+// -- Unique constraints
+
+// uniqueViolationError returns the error Postgres reports when a write violates the unique constraint.
+func uniqueViolationError(constraint string) error {
+	return &pq.Error{Code: codeUniqueViolation, Constraint: constraint, Message: `duplicate key value violates unique constraint "` + constraint + `"`}
+}
+
+// taken reports whether a row other than id matches same, pass uuid.Nil for rows that are being created.
+func taken[T any](rows map[uuid.UUID]T, id uuid.UUID, same func(T) bool) bool {
+	for rowId, row := range rows {
+		if rowId != id && same(row) {
+			return true
+		}
+	}
+	return false
+}
+
+// -- Users
+
+func (s *memoryStore) GetUserById(_ context.Context, id uuid.UUID) (database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.User{}, s.err
+	}
+	user, ok := s.users[id]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (s *memoryStore) CreateUser(_ context.Context, arg database.CreateUserParams) (database.CreateUserRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.CreateUserRow{}, s.err
+	}
+	if taken(s.users, uuid.Nil, func(user database.User) bool { return user.Email == arg.Email }) {
+		return database.CreateUserRow{}, uniqueViolationError("users_email_key")
+	}
+	now := time.Now()
+	user := database.User{ID: uuid.New(), Email: arg.Email, HashedPassword: arg.HashedPassword, CreatedAt: now, UpdatedAt: now}
+	s.users[user.ID] = user
+	return database.CreateUserRow{ID: user.ID, Email: user.Email, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+func (s *memoryStore) GetUserByEmail(_ context.Context, email string) (database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.User{}, s.err
+	}
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteUser(_ context.Context, id uuid.UUID) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if _, ok := s.users[id]; !ok {
+		return driver.RowsAffected(0), nil
+	}
+	delete(s.users, id)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memoryStore) ListUsers(context.Context) ([]database.ListUsersRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var users []database.ListUsersRow
+	for _, user := range s.users {
+		users = append(users, database.ListUsersRow{ID: user.ID, Email: user.Email, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt})
+	}
+	return users, nil
+}
+
+func (s *memoryStore) UpdateUser(_ context.Context, arg database.UpdateUserParams) (database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.User{}, s.err
+	}
+	user, ok := s.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	if taken(s.users, arg.ID, func(user database.User) bool { return user.Email == arg.Email }) {
+		return database.User{}, uniqueViolationError("users_email_key")
+	}
+	user.Email, user.HashedPassword, user.UpdatedAt = arg.Email, arg.HashedPassword, arg.UpdatedAt
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *memoryStore) SetUserAdmin(_ context.Context, arg database.SetUserAdminParams) (database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.User{}, s.err
+	}
+	user, ok := s.users[arg.ID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	user.IsAdmin, user.UpdatedAt = arg.IsAdmin, time.Now()
+	s.users[user.ID] = user
+	return user, nil
+}
+
+// -- Refresh tokens
+
+func (s *memoryStore) CreateRefreshToken(_ context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.RefreshToken{}, s.err
+	}
+	now := time.Now()
+	token := database.RefreshToken{UserID: arg.UserID, Token: arg.Token, ExpiresAt: arg.ExpiresAt, RevokedAt: arg.RevokedAt, CreatedAt: now, UpdatedAt: now}
+	s.refreshTokens[arg.UserID] = token
+	return token, nil
+}
+
+func (s *memoryStore) UpdateRefreshToken(_ context.Context, arg database.UpdateRefreshTokenParams) (database.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.RefreshToken{}, s.err
+	}
+	token, ok := s.refreshTokens[arg.UserID]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	token.ExpiresAt, token.UpdatedAt = arg.ExpiresAt, time.Now()
+	s.refreshTokens[arg.UserID] = token
+	return token, nil
+}
+
+func (s *memoryStore) GetRefreshTokenByToken(_ context.Context, value string) (database.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.RefreshToken{}, s.err
+	}
+	for _, token := range s.refreshTokens {
+		if token.Token == value {
+			return token, nil
+		}
+	}
+	return database.RefreshToken{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) RevokeRefreshToken(_ context.Context, userId uuid.UUID) (database.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.RefreshToken{}, s.err
+	}
+	token, ok := s.refreshTokens[userId]
+	if !ok {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	token.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	s.refreshTokens[userId] = token
+	return token, nil
+}
+
+func (s *memoryStore) GetRefreshToken(_ context.Context, userId uuid.UUID) (database.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.RefreshToken{}, s.err
+	}
+	token, ok := s.refreshTokens[userId]
+	if !ok || token.RevokedAt.Valid {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return token, nil
+}
+
+// -- Subscriptions
+
+// subscriptionNamed reports whether a subscription other than id of createdBy is called name.
+func (s *memoryStore) subscriptionNamed(id, createdBy uuid.UUID, name string) bool {
+	return taken(s.subscriptions, id, func(subscription database.Subscription) bool {
+		return subscription.CreatedBy == createdBy && subscription.Name == name
+	})
+}
+
+func (s *memoryStore) CreateSubscription(_ context.Context, arg database.CreateSubscriptionParams) (database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Subscription{}, s.err
+	}
+	if s.subscriptionNamed(uuid.Nil, arg.CreatedBy, arg.Name) {
+		return database.Subscription{}, uniqueViolationError("subscriptions_created_by_name_key")
+	}
+	now := time.Now()
+	subscription := database.Subscription{
+		ID:             uuid.New(),
+		Name:           arg.Name,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		MonthlyCost:    arg.MonthlyCost,
+		Currency:       arg.Currency,
+		UnsubscribeUrl: arg.UnsubscribeUrl,
+		Description:    arg.Description,
+		CategoryID:     arg.CategoryID,
+		CreatedBy:      arg.CreatedBy,
+		Version:        1,
+	}
+	s.subscriptions[subscription.ID] = subscription
+	return subscription, nil
+}
+
+func (s *memoryStore) DeleteSubscription(_ context.Context, arg database.DeleteSubscriptionParams) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if subscription, ok := s.subscriptions[arg.ID]; !ok || subscription.Version != arg.Version {
+		return driver.RowsAffected(0), nil
+	}
+	delete(s.subscriptions, arg.ID)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memoryStore) GetSubscription(_ context.Context, id uuid.UUID) (database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Subscription{}, s.err
+	}
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return database.Subscription{}, sql.ErrNoRows
+	}
+	return subscription, nil
+}
+
+func (s *memoryStore) ListSubscriptions(ctx context.Context) ([]database.Subscription, error) {
+	return s.ListSubscriptionsForUserId(ctx, uuid.Nil)
+}
+
+// ListSubscriptionsForUserId lists the subscriptions created by createdBy, or all subscriptions for uuid.Nil.
+func (s *memoryStore) ListSubscriptionsForUserId(_ context.Context, createdBy uuid.UUID) ([]database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var subscriptions []database.Subscription
+	for _, subscription := range s.subscriptions {
+		if createdBy == uuid.Nil || subscription.CreatedBy == createdBy {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return subscriptions, nil
+}
+
+func (s *memoryStore) ResetSubscriptions(context.Context) ([]database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	clear(s.subscriptions)
+	return nil, nil
+}
+
+func (s *memoryStore) UpdateSubscription(_ context.Context, arg database.UpdateSubscriptionParams) (database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Subscription{}, s.err
+	}
+	subscription, ok := s.subscriptions[arg.ID]
+	if !ok || subscription.Version != arg.Version {
+		return database.Subscription{}, sql.ErrNoRows
+	}
+	if s.subscriptionNamed(arg.ID, subscription.CreatedBy, arg.Name) {
+		return database.Subscription{}, uniqueViolationError("subscriptions_created_by_name_key")
+	}
+	subscription.Name = arg.Name
+	subscription.MonthlyCost = arg.MonthlyCost
+	subscription.Currency = arg.Currency
+	subscription.UnsubscribeUrl = arg.UnsubscribeUrl
+	subscription.Description = arg.Description
+	subscription.CategoryID = arg.CategoryID
+	subscription.UpdatedAt = time.Now()
+	subscription.Version++
+	s.subscriptions[arg.ID] = subscription
+	return subscription, nil
+}
+
+func (s *memoryStore) UpsertSubscription(_ context.Context, arg database.UpsertSubscriptionParams) (database.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Subscription{}, s.err
+	}
+	now := time.Now()
+	subscription := database.Subscription{ID: uuid.New(), Name: arg.Name, CreatedAt: now, CreatedBy: arg.CreatedBy}
+	for _, existing := range s.subscriptions {
+		if existing.CreatedBy == arg.CreatedBy && existing.Name == arg.Name {
+			subscription = existing
+		}
+	}
+	subscription.MonthlyCost, subscription.Currency = arg.MonthlyCost, arg.Currency
+	subscription.UnsubscribeUrl, subscription.Description, subscription.CategoryID = arg.UnsubscribeUrl, arg.Description, arg.CategoryID
+	subscription.UpdatedAt = now
+	subscription.Version++
+	s.subscriptions[subscription.ID] = subscription
+	return subscription, nil
+}
+
+// -- Categories
+
+func (s *memoryStore) UpdateCategory(_ context.Context, arg database.UpdateCategoryParams) (database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Category{}, s.err
+	}
+	category, ok := s.categories[arg.ID]
+	if !ok || category.Version != arg.Version {
+		return database.Category{}, sql.ErrNoRows
+	}
+	if s.categoryNamed(arg.ID, category.CreatedBy, arg.Name) {
+		return database.Category{}, uniqueViolationError("categories_created_by_name_key")
+	}
+	category.Name, category.Description, category.UpdatedAt = arg.Name, arg.Description, time.Now()
+	category.Version++
+	s.categories[arg.ID] = category
+	return category, nil
+}
+
+func (s *memoryStore) ResetCategories(context.Context) ([]database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	clear(s.categories)
+	return nil, nil
+}
+
+func (s *memoryStore) ListCategories(ctx context.Context) ([]database.Category, error) {
+	return s.ListCategoriesForUserId(ctx, uuid.Nil)
+}
+
+func (s *memoryStore) GetCategory(_ context.Context, id uuid.UUID) (database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Category{}, s.err
+	}
+	category, ok := s.categories[id]
+	if !ok {
+		return database.Category{}, sql.ErrNoRows
+	}
+	return category, nil
+}
+
+// categoryNamed reports whether a category other than id of createdBy is called name.
+func (s *memoryStore) categoryNamed(id, createdBy uuid.UUID, name string) bool {
+	return taken(s.categories, id, func(category database.Category) bool {
+		return category.CreatedBy == createdBy && category.Name == name
+	})
+}
+
+func (s *memoryStore) CreateCategory(_ context.Context, arg database.CreateCategoryParams) (database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Category{}, s.err
+	}
+	if s.categoryNamed(uuid.Nil, arg.CreatedBy, arg.Name) {
+		return database.Category{}, uniqueViolationError("categories_created_by_name_key")
+	}
+	now := time.Now()
+	category := database.Category{ID: uuid.New(), Name: arg.Name, Description: arg.Description, CreatedBy: arg.CreatedBy, CreatedAt: now, UpdatedAt: now, Version: 1}
+	s.categories[category.ID] = category
+	return category, nil
+}
+
+func (s *memoryStore) UpsertCategory(_ context.Context, arg database.UpsertCategoryParams) (database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Category{}, s.err
+	}
+	now := time.Now()
+	category := database.Category{ID: uuid.New(), Name: arg.Name, CreatedBy: arg.CreatedBy, CreatedAt: now}
+	for _, existing := range s.categories {
+		if existing.CreatedBy == arg.CreatedBy && existing.Name == arg.Name {
+			category = existing
+		}
+	}
+	category.Description, category.UpdatedAt = arg.Description, now
+	category.Version++
+	s.categories[category.ID] = category
+	return category, nil
+}
+
+// ListCategoriesForUserId lists the categories created by createdBy, or all categories for uuid.Nil.
+func (s *memoryStore) ListCategoriesForUserId(_ context.Context, createdBy uuid.UUID) ([]database.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var categories []database.Category
+	for _, category := range s.categories {
+		if createdBy == uuid.Nil || category.CreatedBy == createdBy {
+			categories = append(categories, category)
+		}
+	}
+	return categories, nil
+}
+
+func (s *memoryStore) DeleteCategory(_ context.Context, arg database.DeleteCategoryParams) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if category, ok := s.categories[arg.ID]; !ok || category.Version != arg.Version {
+		return driver.RowsAffected(0), nil
+	}
+	delete(s.categories, arg.ID)
+	return driver.RowsAffected(1), nil
+}
+
+// -- Cards
+
+// cardNamed reports whether a card other than id of owner is called name.
+func (s *memoryStore) cardNamed(id, owner uuid.UUID, name string) bool {
+	return taken(s.cards, id, func(card database.Card) bool {
+		return card.Owner == owner && card.Name == name
+	})
+}
+
+func (s *memoryStore) CreateCard(_ context.Context, arg database.CreateCardParams) (database.CreateCardRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.CreateCardRow{}, s.err
+	}
+	if s.cardNamed(uuid.Nil, arg.Owner, arg.Name) {
+		return database.CreateCardRow{}, uniqueViolationError("cards_owner_name_key")
+	}
+	now := time.Now()
+	card := database.Card{ID: uuid.New(), Name: arg.Name, Owner: arg.Owner, ExpiresAt: arg.ExpiresAt, CreatedAt: now, UpdatedAt: now, Version: 1}
+	s.cards[card.ID] = card
+	return database.CreateCardRow{ID: card.ID, Name: card.Name, Owner: card.Owner, ExpiresAt: card.ExpiresAt, CreatedAt: now, UpdatedAt: now, Version: card.Version}, nil
+}
+
+func (s *memoryStore) UpdateCard(_ context.Context, arg database.UpdateCardParams) (database.Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Card{}, s.err
+	}
+	card, ok := s.cards[arg.ID]
+	if !ok || card.Version != arg.Version {
+		return database.Card{}, sql.ErrNoRows
+	}
+	if s.cardNamed(arg.ID, card.Owner, arg.Name) {
+		return database.Card{}, uniqueViolationError("cards_owner_name_key")
+	}
+	card.Name, card.ExpiresAt, card.UpdatedAt = arg.Name, arg.ExpiresAt, arg.UpdatedAt
+	card.Version++
+	s.cards[arg.ID] = card
+	return card, nil
+}
+
+func (s *memoryStore) GetCard(_ context.Context, id uuid.UUID) (database.Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Card{}, s.err
+	}
+	card, ok := s.cards[id]
+	if !ok {
+		return database.Card{}, sql.ErrNoRows
+	}
+	return card, nil
+}
+
+func (s *memoryStore) ListCards(ctx context.Context) ([]database.Card, error) {
+	return s.ListCardsForOwner(ctx, uuid.Nil)
+}
+
+// ListCardsForOwner lists the cards of owner, or all cards for uuid.Nil.
+func (s *memoryStore) ListCardsForOwner(_ context.Context, owner uuid.UUID) ([]database.Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var cards []database.Card
+	for _, card := range s.cards {
+		if owner == uuid.Nil || card.Owner == owner {
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+func (s *memoryStore) DeleteCard(_ context.Context, arg database.DeleteCardParams) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if card, ok := s.cards[arg.ID]; !ok || card.Version != arg.Version {
+		return driver.RowsAffected(0), nil
+	}
+	delete(s.cards, arg.ID)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memoryStore) UpsertCard(_ context.Context, arg database.UpsertCardParams) (database.Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.Card{}, s.err
+	}
+	now := time.Now()
+	card := database.Card{ID: uuid.New(), Name: arg.Name, Owner: arg.Owner, CreatedAt: now}
+	for _, existing := range s.cards {
+		if existing.Owner == arg.Owner && existing.Name == arg.Name {
+			card = existing
+		}
+	}
+	card.ExpiresAt, card.UpdatedAt = arg.ExpiresAt, now
+	card.Version++
+	s.cards[card.ID] = card
+	return card, nil
+}
+
+// -- Active subscriptions
+
+func (s *memoryStore) ListActiveSubscriptionByUserId(_ context.Context, userId uuid.UUID) ([]database.ActiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var activeSubscriptions []database.ActiveSubscription
+	for _, activeSubscription := range s.activeSubscriptions {
+		if activeSubscription.UserID == userId {
+			activeSubscriptions = append(activeSubscriptions, activeSubscription)
+		}
+	}
+	return activeSubscriptions, nil
+}
+
+func (s *memoryStore) GetActiveSubscriptionById(_ context.Context, id uuid.UUID) (database.ActiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.ActiveSubscription{}, s.err
+	}
+	activeSubscription, ok := s.activeSubscriptions[id]
+	if !ok {
+		return database.ActiveSubscription{}, sql.ErrNoRows
+	}
+	return activeSubscription, nil
+}
+
+func (s *memoryStore) UpdateActiveSubscription(_ context.Context, arg database.UpdateActiveSubscriptionParams) (database.ActiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.ActiveSubscription{}, s.err
+	}
+	activeSubscription, ok := s.activeSubscriptions[arg.ID]
+	if !ok || activeSubscription.Version != arg.Version {
+		return database.ActiveSubscription{}, sql.ErrNoRows
+	}
+	activeSubscription.BillingFrequency, activeSubscription.AutoRenewEnabled = arg.BillingFrequency, arg.AutoRenewEnabled
+	activeSubscription.UpdatedAt = time.Now()
+	activeSubscription.Version++
+	s.activeSubscriptions[arg.ID] = activeSubscription
+	return activeSubscription, nil
+}
+
+func (s *memoryStore) DeleteActiveSubscription(_ context.Context, arg database.DeleteActiveSubscriptionParams) (sql.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if activeSubscription, ok := s.activeSubscriptions[arg.ID]; !ok || activeSubscription.Version != arg.Version {
+		return driver.RowsAffected(0), nil
+	}
+	delete(s.activeSubscriptions, arg.ID)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *memoryStore) CreateActiveSubscription(_ context.Context, arg database.CreateActiveSubscriptionParams) (database.ActiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.ActiveSubscription{}, s.err
+	}
+	if taken(s.activeSubscriptions, uuid.Nil, func(activeSubscription database.ActiveSubscription) bool {
+		return activeSubscription.UserID == arg.UserID && activeSubscription.SubscriptionID == arg.SubscriptionID
+	}) {
+		return database.ActiveSubscription{}, uniqueViolationError("active_subscriptions_user_id_subscription_id_key")
+	}
+	activeSubscription := database.ActiveSubscription{
+		ID:               uuid.New(),
+		SubscriptionID:   arg.SubscriptionID,
+		UserID:           arg.UserID,
+		CardID:           arg.CardID,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        arg.UpdatedAt,
+		BillingFrequency: arg.BillingFrequency,
+		AutoRenewEnabled: arg.AutoRenewEnabled,
+		Version:          1,
+	}
+	s.activeSubscriptions[activeSubscription.ID] = activeSubscription
+	return activeSubscription, nil
+}
+
+func (s *memoryStore) UpsertActiveSubscription(_ context.Context, arg database.UpsertActiveSubscriptionParams) (database.ActiveSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.ActiveSubscription{}, s.err
+	}
+	now := time.Now()
+	activeSubscription := database.ActiveSubscription{ID: uuid.New(), SubscriptionID: arg.SubscriptionID, UserID: arg.UserID, CreatedAt: now}
+	for _, existing := range s.activeSubscriptions {
+		if existing.UserID == arg.UserID && existing.SubscriptionID == arg.SubscriptionID {
+			activeSubscription = existing
+		}
+	}
+	activeSubscription.CardID, activeSubscription.BillingFrequency, activeSubscription.AutoRenewEnabled = arg.CardID, arg.BillingFrequency, arg.AutoRenewEnabled
+	activeSubscription.UpdatedAt = now
+	activeSubscription.Version++
+	s.activeSubscriptions[activeSubscription.ID] = activeSubscription
+	return activeSubscription, nil
+}
+
+// -- Search, a case insensitive substring match stands in for full-text search
+
+func (s *memoryStore) SearchSubscriptions(_ context.Context, arg database.SearchSubscriptionsParams) ([]database.SearchSubscriptionsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var rows []database.SearchSubscriptionsRow
+	for _, subscription := range s.subscriptions {
+		if subscription.CreatedBy == arg.CreatedBy && containsFold(subscription.Name+" "+subscription.Description.String, arg.Query) {
+			rows = append(rows, database.SearchSubscriptionsRow{ID: subscription.ID, Name: subscription.Name, Description: subscription.Description, Rank: 1})
+		}
+	}
+	return rows, nil
+}
+
+func (s *memoryStore) SearchCategories(_ context.Context, arg database.SearchCategoriesParams) ([]database.SearchCategoriesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var rows []database.SearchCategoriesRow
+	for _, category := range s.categories {
+		if category.CreatedBy == arg.CreatedBy && containsFold(category.Name+" "+category.Description, arg.Query) {
+			rows = append(rows, database.SearchCategoriesRow{ID: category.ID, Name: category.Name, Description: category.Description, Rank: 1})
+		}
+	}
+	return rows, nil
+}
+
+func (s *memoryStore) SearchCards(_ context.Context, arg database.SearchCardsParams) ([]database.SearchCardsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var rows []database.SearchCardsRow
+	for _, card := range s.cards {
+		if card.Owner == arg.Owner && containsFold(card.Name, arg.Query) {
+			rows = append(rows, database.SearchCardsRow{ID: card.ID, Name: card.Name, Rank: 1})
+		}
+	}
+	return rows, nil
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// -- Idempotency keys
+
+func (s *memoryStore) ReserveIdempotencyKey(_ context.Context, arg database.ReserveIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.IdempotencyKey{}, s.err
+	}
+	id := [2]string{arg.Scope, arg.Key}
+	if existing, ok := s.idempotencyKeys[id]; ok && existing.ExpiresAt.After(time.Now()) {
+		return database.IdempotencyKey{}, sql.ErrNoRows
+	}
+	key := database.IdempotencyKey{Scope: arg.Scope, Key: arg.Key, Fingerprint: arg.Fingerprint, CreatedAt: time.Now(), ExpiresAt: arg.ExpiresAt}
+	s.idempotencyKeys[id] = key
+	return key, nil
+}
+
+func (s *memoryStore) GetIdempotencyKey(_ context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return database.IdempotencyKey{}, s.err
+	}
+	key, ok := s.idempotencyKeys[[2]string{arg.Scope, arg.Key}]
+	if !ok || key.ExpiresAt.Before(time.Now()) {
+		return database.IdempotencyKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (s *memoryStore) CompleteIdempotencyKey(_ context.Context, arg database.CompleteIdempotencyKeyParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	id := [2]string{arg.Scope, arg.Key}
+	key, ok := s.idempotencyKeys[id]
+	if !ok {
+		return nil
+	}
+	key.StatusCode, key.ContentType, key.ResponseBody = arg.StatusCode, arg.ContentType, arg.ResponseBody
+	key.Etag, key.Location = arg.Etag, arg.Location
+	s.idempotencyKeys[id] = key
+	return nil
+}
+
+func (s *memoryStore) DeleteIdempotencyKey(_ context.Context, arg database.DeleteIdempotencyKeyParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.idempotencyKeys, [2]string{arg.Scope, arg.Key})
+	return nil
+}
+
+// -- Metrics
+
+func (s *memoryStore) CountUsers(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	return int64(len(s.users)), nil
+}
+
+func (s *memoryStore) CountActiveSubscriptions(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	return int64(len(s.activeSubscriptions)), nil
+}
+
+// -- Rate limits
+
+// refilledTokens returns the tokens of the bucket of key at now, like the queries of the rate_limits table.
+func (s *memoryStore) refilledTokens(key string, capacity, refillRate float64, now time.Time) (float64, bool) {
+	bucket, ok := s.rateLimits[key]
+	if !ok {
+		return capacity, false
+	}
+	return min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*refillRate), true
+}
+
+func (s *memoryStore) TakeRateLimitToken(_ context.Context, arg database.TakeRateLimitTokenParams) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	now := time.Now()
+	tokens, _ := s.refilledTokens(arg.Key, arg.Capacity, arg.RefillRate, now)
+	if tokens < 1 {
+		return 0, sql.ErrNoRows
+	}
+	s.rateLimits[arg.Key] = memoryRateLimit{tokens: tokens - 1, updatedAt: now}
+	return tokens - 1, nil
+}
+
+func (s *memoryStore) GetRateLimitTokens(_ context.Context, arg database.GetRateLimitTokensParams) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	tokens, ok := s.refilledTokens(arg.Key, arg.Capacity, arg.RefillRate, time.Now())
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return tokens, nil
+}
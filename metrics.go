@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// -- Metrics
+//
+// GET /metrics reports the metrics below in the Prometheus text format. It is either served by the admin listener,
+// when admin.address is set, or by the main listener behind admin.metrics_token. Without either it is not served, so
+// that the metrics are never public by accident. Request metrics are labelled with the route pattern of addRoutes
+// rather than the path, which would give every ID its own series.
+
+const metricsNamespace = "unsubtle"
+
+// businessMetricsTimeout bounds the queries of the business gauges, which run on every scrape.
+const businessMetricsTimeout = 5 * time.Second
+
+// metricsRegistry holds the metrics of the process. The business gauges are added per handler, see handleMetrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern and status code.",
+	}, []string{"route", "code"})
+	httpRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+	dbQueryDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database queries by sqlc query name.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query"})
+	bcryptDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "bcrypt_duration_seconds",
+		Help:      "Time taken to hash passwords and to compare them with a hash.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+	logins = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result: success, failure for invalid credentials, or error.",
+	}, []string{"result"})
+	rateLimitedRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected with 429 Too Many Requests by rate limit: default or auth.",
+	}, []string{"limit"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// handleMetrics serves the metrics of the process together with the business gauges computed from db.
+func handleMetrics(db dbQuerier) http.Handler {
+	business := prometheus.NewRegistry()
+	business.MustRegister(businessCollector{db: db})
+	return promhttp.HandlerFor(prometheus.Gatherers{metricsRegistry, business}, promhttp.HandlerOpts{
+		// A failing business gauge must not hide the other metrics
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+// requireMetricsToken rejects requests that do not carry token as their bearer token. An empty token allows every
+// request, which is how the admin listener serves the metrics without one.
+func requireMetricsToken(next http.Handler, token string) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, err := auth.GetBearerToken(r.Header)
+		if err != nil || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			writeProblem(w, r, UnauthenticatedError.WithDetail("a valid metrics token is required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+instrumentRequests counts and times requests by the route pattern that served them. It must wrap the mux directly,
+since the mux sets r.Pattern on the request it is given. Requests that match no route share the "unmatched" label.
+*/
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// businessCollector reports gauges of the data of all users, queried on every scrape.
+type businessCollector struct {
+	db dbQuerier
+}
+
+var (
+	usersDesc               = prometheus.NewDesc(metricsNamespace+"_users", "Registered users.", nil, nil)
+	activeSubscriptionsDesc = prometheus.NewDesc(metricsNamespace+"_active_subscriptions", "Active subscriptions of all users.", nil, nil)
+)
+
+func (c businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- activeSubscriptionsDesc
+}
+
+func (c businessCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), businessMetricsTimeout)
+	defer cancel()
+
+	gauges := []struct {
+		desc  *prometheus.Desc
+		count func(context.Context) (int64, error)
+	}{
+		{usersDesc, c.db.CountUsers},
+		{activeSubscriptionsDesc, c.db.CountActiveSubscriptions},
+	}
+	for _, gauge := range gauges {
+		count, err := gauge.count(ctx)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(gauge.desc, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(gauge.desc, prometheus.GaugeValue, float64(count))
+	}
+}
+
+// -- Database
+
+// instrumentedDB times and traces the queries run through db by the name sqlc gave them.
+type instrumentedDB struct {
+	db database.DBTX
+}
+
+// instrumentDB wraps db, to be passed to database.New.
+func instrumentDB(db database.DBTX) database.DBTX {
+	return instrumentedDB{db: db}
+}
+
+/*
+queryName returns the name of a query generated by sqlc, which starts every query with a comment like
+"-- name: GetUserById :one". Other queries are reported as "other".
+*/
+func queryName(query string) string {
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		if name, _, ok := strings.Cut(rest, " "); ok {
+			return name
+		}
+	}
+	return "other"
+}
+
+// observeQuery starts a span for the query, a child of the span of the request. The returned function ends the
+// span and records the duration of the query.
+func observeQuery(ctx context.Context, query string) (context.Context, func(error)) {
+	name := queryName(query)
+	start := time.Now()
+	ctx, span := tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(name)),
+	)
+	return ctx, func(err error) {
+		dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (i instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, done := observeQuery(ctx, query)
+	result, err := i.db.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (i instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return i.db.PrepareContext(ctx, query)
+}
+
+// QueryContext observes the query until its first rows are available, reading the rows is not included.
+func (i instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, done := observeQuery(ctx, query)
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+// QueryRowContext observes the query without its error, which is only returned once the row is scanned.
+func (i instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, done := observeQuery(ctx, query)
+	row := i.db.QueryRowContext(ctx, query, args...)
+	done(nil)
+	return row
+}
+
+// -- Passwords
+
+// hashPassword hashes a new password with auth.CreateHash, timing it.
+func hashPassword(password string, cost int) (string, error) {
+	defer observeBcrypt("hash", time.Now())
+	return auth.CreateHash(password, cost)
+}
+
+// passwordMatches compares a password with its hash with auth.IsValid, timing it.
+func passwordMatches(password, hash string) bool {
+	defer observeBcrypt("compare", time.Now())
+	return auth.IsValid(password, hash)
+}
+
+func observeBcrypt(operation string, start time.Time) {
+	bcryptDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeLogin counts a login attempt by the error loginUser returned.
+func observeLogin(err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, InvalidCredentialsError):
+		result = "failure"
+	case err != nil:
+		result = "error"
+	}
+	logins.WithLabelValues(result).Inc()
+}
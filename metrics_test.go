@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"-- name: GetUserById :one\nSELECT id FROM users WHERE id = $1": "GetUserById",
+		"SELECT 1": "other",
+	}
+	for query, want := range tests {
+		if got := queryName(query); got != want {
+			t.Errorf("queryName(%q) = %q, expected %q", query, got, want)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	config := newTestConfig()
+	config.Admin.MetricsToken = "scrape"
+	handler, store, user, token := newAuthenticatedServer(t, config)
+
+	login := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email": "`+user.Email+`", "password": "wrong"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), login)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/subscriptions/"+user.ID.String(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	scrape := func(t *testing.T, handler http.Handler, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it requires the metrics token", func(t *testing.T) {
+		for _, token := range []string{"", "guess", "scrape-and-more"} {
+			if rec := scrape(t, handler, token); rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected status 401 for token %q, got %d", token, rec.Code)
+			}
+		}
+	})
+
+	t.Run("it reports the metrics", func(t *testing.T) {
+		rec := scrape(t, handler, "scrape")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		for _, want := range []string{
+			// Requests are labelled with the pattern, not the path
+			`unsubtle_http_requests_total{code="404",route="GET /api/subscriptions/{id}"}`,
+			`unsubtle_http_request_duration_seconds_count{route="POST /api/login"}`,
+			`unsubtle_logins_total{result="failure"}`,
+			`unsubtle_bcrypt_duration_seconds_count{operation="hash"}`,
+			"unsubtle_users 1",
+			"unsubtle_active_subscriptions 0",
+			"go_goroutines",
+		} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("expected the metrics to contain %s", want)
+			}
+		}
+	})
+
+	t.Run("it is not served without a token", func(t *testing.T) {
+		if rec := scrape(t, NewServerHandler(newTestConfig(), store, newHealth()), ""); strings.Contains(rec.Body.String(), "unsubtle_") {
+			t.Error("expected the metrics not to be served")
+		}
+	})
+
+	t.Run("it is served by the admin listener instead", func(t *testing.T) {
+		config := newTestConfig()
+		config.Admin.Address = "localhost:9090"
+		if rec := scrape(t, NewServerHandler(config, store, newHealth()), ""); strings.Contains(rec.Body.String(), "unsubtle_") {
+			t.Error("expected the API not to serve the metrics")
+		}
+		if rec := scrape(t, NewAdminHandler(config, store), ""); rec.Code != http.StatusOK {
+			t.Errorf("expected the admin listener to serve the metrics, got status %d", rec.Code)
+		}
+	})
+}
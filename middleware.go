@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"errors"
 	"net/http"
 
 	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/logging"
 	"github.com/google/uuid"
 )
 
@@ -20,32 +22,68 @@ func WithUserId(ctx context.Context, userId uuid.UUID) context.Context {
 }
 
 func GetUserId(ctx context.Context) *uuid.UUID {
-	userId, ok := ctx.Value(userIdCtxKey).(*uuid.UUID)
+	userId, ok := ctx.Value(userIdCtxKey).(uuid.UUID)
 	if !ok {
 		// Log this issue
 		return nil
 	}
-	return userId
+	return &userId
 }
 
 func authenticate(next http.Handler, jwtSecret string) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The rate limit of unauthenticated requests may have validated the token already
+		if validated := GetUserId(r.Context()); validated != nil {
+			logging.AddAttrs(r.Context(), "user_id", *validated)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Get the bearer token from request header
 		token, err := auth.GetBearerToken(r.Header)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusForbidden)
+			writeProblem(w, r, UnauthenticatedError.Wrap(err))
 			return
 		}
 
 		// Validate the bearer token
 		userId, err := auth.ValidateJWT(token, jwtSecret)
 		if err != nil {
-			w.WriteHeader(http.StatusForbidden)
+			logging.FromContext(r.Context()).Debug("received invalid token", "error", err)
+			writeProblem(w, r, UnauthenticatedError.Wrap(err))
 			return
 		}
 
+		// Every line logged for the rest of the request, including the access log, names the user
+		logging.AddAttrs(r.Context(), "user_id", userId)
 		next.ServeHTTP(w, r.WithContext(WithUserId(r.Context(), userId)))
 	})
 }
+
+// requireAdmin only passes requests of admin users on to next. It must run after authenticate.
+func requireAdmin(next http.Handler, query dbQuerier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId := GetUserId(r.Context())
+		if userId == nil {
+			writeProblem(w, r, ForbiddenError)
+			return
+		}
+
+		user, err := query.GetUserById(r.Context(), *userId)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeProblem(w, r, ForbiddenError)
+			return
+		}
+		if err != nil {
+			writeProblem(w, r, InternalError.Wrap(err))
+			return
+		}
+		if !user.IsAdmin {
+			writeProblem(w, r, ForbiddenError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
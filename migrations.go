@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// embeddedMigrations holds the goose migrations of sql/schema, so that a binary always carries the schema it was
+// built against.
+//
+//go:embed sql/schema/*.sql
+var embeddedMigrations embed.FS
+
+// migrationsFS returns the embedded migrations with the sql/schema prefix stripped.
+func migrationsFS() fs.FS {
+	fsys, err := fs.Sub(embeddedMigrations, "sql/schema")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+/*
+newMigrator returns a goose provider for the migrations in fsys. Migrations hold a Postgres advisory lock while they
+run, so replicas that start at the same time apply them one after the other instead of racing.
+*/
+func newMigrator(db *sql.DB, fsys fs.FS) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("creating migration lock: %w", err)
+	}
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("loading migrations: %w", err)
+	}
+	return provider, nil
+}
+
+// prepareSchema applies pending migrations when migrate is set and verifies that the binary knows the schema of the
+// database. It refuses a schema that is newer than the latest embedded migration, since queries may no longer match it.
+func prepareSchema(ctx context.Context, migrator *goose.Provider, migrate bool) error {
+	if migrate {
+		results, err := migrator.Up(ctx)
+		for _, result := range results {
+			slog.Info("applied migration", "migration", result.Source.Path, "duration", result.Duration)
+		}
+		if err != nil {
+			return fmt.Errorf("applying migrations: %w", err)
+		}
+	}
+
+	current, known, err := migrator.GetVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("reading the database schema version: %w", err)
+	}
+	return checkSchemaVersion(current, known)
+}
+
+// checkSchemaReady fails unless the schema of the database is at the latest migration known to the binary, which is
+// the version the queries of the server are written for.
+func checkSchemaReady(ctx context.Context, migrator *goose.Provider) error {
+	current, known, err := migrator.GetVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("reading the database schema version: %w", err)
+	}
+	if current != known {
+		return fmt.Errorf("database schema version %d is not at the latest migration %d", current, known)
+	}
+	return nil
+}
+
+// checkSchemaVersion compares the schema version of the database with the latest version known to the binary.
+func checkSchemaVersion(current, known int64) error {
+	switch {
+	case current > known:
+		return fmt.Errorf("database schema version %d is newer than the latest migration %d known to this binary, upgrade the binary", current, known)
+	case current < known:
+		slog.Warn("database schema is behind the latest migration, run migrate up or start with -migrate", "version", current, "latest", known)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	onDisk, err := os.ReadDir("sql/schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := fs.ReadDir(migrationsFS(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(embedded) != len(onDisk) {
+		t.Fatalf("expected %d embedded migrations, got %d", len(onDisk), len(embedded))
+	}
+	for i, entry := range embedded {
+		if entry.Name() != onDisk[i].Name() {
+			t.Errorf("expected migration %s, got %s", onDisk[i].Name(), entry.Name())
+		}
+		content, err := fs.ReadFile(migrationsFS(), entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), "-- +goose Up") || !strings.Contains(string(content), "-- +goose Down") {
+			t.Errorf("expected %s to have goose Up and Down sections", entry.Name())
+		}
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		current, known int64
+		wantErr        bool
+	}{
+		{name: "it accepts the known schema", current: 11, known: 11},
+		{name: "it accepts an older schema", current: 9, known: 11},
+		{name: "it refuses a newer schema", current: 12, known: 11, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchemaVersion(tt.current, tt.known)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
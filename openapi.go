@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// OpenAPI specification
+//
+// The document served at GET /openapi.json is generated from apiOperations when the server starts. Paths and
+// operations are maintained by hand below, whereas the schemas of request and response bodies are derived from the Go
+// types with reflection, so the document cannot drift from the JSON that is actually produced. TestOpenAPICoversRoutes
+// fails when a route registered in addRoutes is missing from apiOperations.
+
+const openAPIVersion = "3.1.0"
+
+// apiOperation documents a single route registered in addRoutes.
+type apiOperation struct {
+	// Pattern is the exact pattern the route is registered with, e.g. "GET /api/cards/{id}".
+	Pattern string
+	Summary string
+	Tag     string
+
+	// Request is a value of the request body type, nil when the operation does not take a body.
+	Request any
+	// Response is a value of the type returned in the content member of the response envelope. It is nil when the
+	// operation does not return content.
+	Response any
+	// Status is the status code of a successful response.
+	Status int
+
+	// Query lists the query parameters of the operation.
+	Query []openAPIParameter
+
+	// Form operations take a form encoded body and respond with an HTML fragment instead of JSON.
+	Form          bool
+	HTML          bool
+	SVG           bool
+	Authenticated bool
+	// Idempotent operations accept an Idempotency-Key header, see idempotent.
+	Idempotent bool
+	// Conditional operations require an If-Match header, see checkIfMatch.
+	Conditional bool
+	// MergePatch operations take an RFC 7396 merge patch of Request.
+	MergePatch bool
+	// Bare operations respond with Response itself rather than the response envelope.
+	Bare bool
+}
+
+// chartCurrencyParameter selects the currency of a dashboard chart.
+var chartCurrencyParameter = openAPIParameter{
+	Name: "currency", In: "query", Schema: &jsonSchema{Type: "string"},
+	Description: "The currency of the amounts in the chart, by default the currency of most active subscriptions.",
+}
+
+var apiOperations = []apiOperation{
+	// -- Frontend
+	{Pattern: "GET /", Summary: "Index page", Tag: "frontend", HTML: true, Status: http.StatusOK},
+	{Pattern: "GET /dashboard", Summary: "Dashboard page", Tag: "frontend", HTML: true, Status: http.StatusOK},
+	{Pattern: "GET /static/", Summary: "Static assets", Tag: "frontend", HTML: true, Status: http.StatusOK},
+
+	// -- Dashboard fragments
+	{Pattern: "GET /ui/subscriptions", Summary: "Subscriptions table", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/subscriptions/new", Summary: "Form for a new subscription", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/subscriptions/{id}/edit", Summary: "Form for editing a subscription", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "POST /ui/subscriptions", Summary: "Create a subscription and render the subscriptions table", Tag: "frontend", Request: subscriptionRequest{}, Form: true, HTML: true, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "PUT /ui/subscriptions/{id}", Summary: "Update a subscription and render the subscriptions table", Tag: "frontend", Request: subscriptionRequest{}, Form: true, HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/categories", Summary: "Category list", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/categories/new", Summary: "Form for a new category", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/categories/{id}/edit", Summary: "Form for editing a category", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "POST /ui/categories", Summary: "Create a category and render the category list", Tag: "frontend", Request: categoryRequest{}, Form: true, HTML: true, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "PUT /ui/categories/{id}", Summary: "Update a category and render the category list", Tag: "frontend", Request: categoryRequest{}, Form: true, HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/cards", Summary: "Card list", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/cards/new", Summary: "Form for a new card", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /ui/cards/{id}/edit", Summary: "Form for editing a card", Tag: "frontend", HTML: true, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "POST /ui/cards", Summary: "Create a card and render the card list", Tag: "frontend", Request: cardRequest{}, Form: true, HTML: true, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "PUT /ui/cards/{id}", Summary: "Update a card and render the card list", Tag: "frontend", Request: cardRequest{}, Form: true, HTML: true, Authenticated: true, Status: http.StatusOK},
+
+	// -- Dashboard charts
+	{Pattern: "GET /dashboard/charts/spend.svg", Summary: "Bar chart of the spend per month", Tag: "frontend", SVG: true, Authenticated: true, Status: http.StatusOK, Query: []openAPIParameter{
+		{Name: "months", In: "query", Description: "The number of months up to and including the current one, 12 by default.", Schema: &jsonSchema{Type: "integer"}},
+		chartCurrencyParameter,
+	}},
+	{Pattern: "GET /dashboard/charts/categories.svg", Summary: "Donut chart of the monthly spend per category", Tag: "frontend", SVG: true, Authenticated: true, Status: http.StatusOK, Query: []openAPIParameter{chartCurrencyParameter}},
+	{Pattern: "GET /dashboard/charts/cards.svg", Summary: "Stacked bar chart of the monthly spend per card and subscription", Tag: "frontend", SVG: true, Authenticated: true, Status: http.StatusOK, Query: []openAPIParameter{chartCurrencyParameter}},
+
+	{Pattern: "GET /openapi.json", Summary: "This OpenAPI document", Tag: "meta", Status: http.StatusOK},
+
+	// -- Health
+	{Pattern: "GET /healthz", Summary: "Report that the process is alive", Tag: "meta", Response: map[string]string{}, Bare: true, Status: http.StatusOK},
+	{Pattern: "GET /readyz", Summary: "Report whether the server should receive traffic, 503 when it should not", Tag: "meta", Response: readiness{}, Bare: true, Status: http.StatusOK},
+	{Pattern: "GET /version", Summary: "Build information of the server", Tag: "meta", Response: buildInfo{}, Bare: true, Status: http.StatusOK},
+
+	// -- Authentication
+	{Pattern: "POST /login", Summary: "Log in with an email and password", Tag: "authentication", Request: userRequestData{}, Form: true, HTML: true, Status: http.StatusOK},
+	{Pattern: "POST /register", Summary: "Register a new user", Tag: "authentication", Request: userRequestData{}, Form: true, HTML: true, Status: http.StatusOK},
+	{Pattern: "POST /refresh", Summary: "Issue a new JWT, authenticated with the refresh token as bearer token", Tag: "authentication", Response: map[string]string{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "POST /revoke", Summary: "Revoke the refresh token of the user", Tag: "authentication", Response: database.RefreshToken{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "POST /api/login", Summary: "Log in with an email and password", Tag: "authentication", Request: userRequestData{}, Response: loginResponseData{}, Status: http.StatusOK},
+	{Pattern: "POST /api/register", Summary: "Register a new user", Tag: "authentication", Request: userRequestData{}, Response: database.CreateUserRow{}, Status: http.StatusCreated},
+
+	// -- Users
+	{Pattern: "GET /api/users", Summary: "List users (admins only)", Tag: "users", Response: []database.ListUsersRow{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /api/users/{id}", Summary: "Get a user (admins only)", Tag: "users", Response: database.User{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "PUT /api/users/{id}", Summary: "Update a user (admins only)", Tag: "users", Request: userRequestData{}, Response: database.User{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "DELETE /api/users/{id}", Summary: "Delete a user (admins only)", Tag: "users", Authenticated: true, Status: http.StatusNoContent},
+
+	// -- Categories
+	{Pattern: "POST /api/categories", Summary: "Create a category", Tag: "categories", Request: categoryRequest{}, Response: database.Category{}, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "PUT /api/categories/{id}", Summary: "Replace a category", Tag: "categories", Request: categoryRequest{}, Response: database.Category{}, Authenticated: true, Conditional: true, Status: http.StatusOK},
+	{Pattern: "PATCH /api/categories/{id}", Summary: "Partially update a category", Tag: "categories", Request: categoryRequest{}, Response: database.Category{}, Authenticated: true, Conditional: true, MergePatch: true, Status: http.StatusOK},
+	{Pattern: "GET /api/categories", Summary: "List the categories of the user", Tag: "categories", Response: []database.Category{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /api/categories/{id}", Summary: "Get a category", Tag: "categories", Response: database.Category{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "DELETE /api/categories/{id}", Summary: "Delete a category", Tag: "categories", Authenticated: true, Conditional: true, Status: http.StatusNoContent},
+
+	// -- Subscriptions
+	{Pattern: "POST /api/subscriptions", Summary: "Create a subscription", Tag: "subscriptions", Request: subscriptionRequest{}, Response: database.Subscription{}, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "PUT /api/subscriptions/{id}", Summary: "Replace a subscription", Tag: "subscriptions", Request: subscriptionRequest{}, Response: database.Subscription{}, Authenticated: true, Conditional: true, Status: http.StatusOK},
+	{Pattern: "PATCH /api/subscriptions/{id}", Summary: "Partially update a subscription", Tag: "subscriptions", Request: subscriptionRequest{}, Response: database.Subscription{}, Authenticated: true, Conditional: true, MergePatch: true, Status: http.StatusOK},
+	{Pattern: "GET /api/subscriptions", Summary: "List the subscriptions of the user", Tag: "subscriptions", Response: []database.Subscription{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /api/subscriptions/{id}", Summary: "Get a subscription", Tag: "subscriptions", Response: database.Subscription{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "DELETE /api/subscriptions/{id}", Summary: "Delete a subscription", Tag: "subscriptions", Authenticated: true, Conditional: true, Status: http.StatusNoContent},
+
+	// -- Cards
+	{Pattern: "POST /api/cards", Summary: "Register a card", Tag: "cards", Request: cardRequest{}, Response: database.CreateCardRow{}, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "GET /api/cards/{id}", Summary: "Get a card", Tag: "cards", Response: database.Card{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /api/cards", Summary: "List the cards of the user", Tag: "cards", Response: []database.Card{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "PUT /api/cards/{id}", Summary: "Replace a card", Tag: "cards", Request: cardRequest{}, Response: database.Card{}, Authenticated: true, Conditional: true, Status: http.StatusOK},
+	{Pattern: "PATCH /api/cards/{id}", Summary: "Partially update a card", Tag: "cards", Request: cardRequest{}, Response: database.Card{}, Authenticated: true, Conditional: true, MergePatch: true, Status: http.StatusOK},
+	{Pattern: "DELETE /api/cards/{id}", Summary: "Delete a card", Tag: "cards", Authenticated: true, Conditional: true, Status: http.StatusNoContent},
+
+	// -- ActiveSubscriptions
+	{Pattern: "POST /api/activesubscriptions", Summary: "Activate a subscription", Tag: "activesubscriptions", Request: activeSubscriptionRequest{}, Response: database.ActiveSubscription{}, Authenticated: true, Idempotent: true, Status: http.StatusCreated},
+	{Pattern: "GET /api/activesubscriptions/{id}", Summary: "Get an active subscription", Tag: "activesubscriptions", Response: database.ActiveSubscription{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "GET /api/activesubscriptions", Summary: "List the active subscriptions of the user", Tag: "activesubscriptions", Response: []database.ActiveSubscription{}, Authenticated: true, Status: http.StatusOK},
+	{Pattern: "PUT /api/activesubscriptions/{id}", Summary: "Replace an active subscription", Tag: "activesubscriptions", Request: activeSubscriptionUpdateRequest{}, Response: database.ActiveSubscription{}, Authenticated: true, Conditional: true, Status: http.StatusOK},
+	{Pattern: "PATCH /api/activesubscriptions/{id}", Summary: "Partially update an active subscription", Tag: "activesubscriptions", Request: activeSubscriptionUpdateRequest{}, Response: database.ActiveSubscription{}, Authenticated: true, Conditional: true, MergePatch: true, Status: http.StatusOK},
+	{Pattern: "DELETE /api/activesubscriptions/{id}", Summary: "Deactivate a subscription", Tag: "activesubscriptions", Authenticated: true, Conditional: true, Status: http.StatusNoContent},
+
+	// -- Search
+	{Pattern: "GET /api/search", Summary: "Search subscriptions, categories and cards", Tag: "search", Response: searchResponseData{}, Authenticated: true, Status: http.StatusOK, Query: []openAPIParameter{
+		{Name: "q", In: "query", Required: true, Description: "The search terms.", Schema: &jsonSchema{Type: "string"}},
+	}},
+}
+
+// -- Document model, only the parts of OpenAPI 3.1 that are used are modelled.
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*jsonSchema           `json:"schemas"`
+	Responses       map[string]*openAPIResponse      `json:"responses"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Tags        []string                    `json:"tags"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *jsonSchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref         string                      `json:"$ref,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *jsonSchema `json:"schema"`
+}
+
+// jsonSchema is a JSON Schema 2020-12 document, the schema dialect of OpenAPI 3.1.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 any                    `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	AllOf                []*jsonSchema          `json:"allOf,omitempty"`
+}
+
+func schemaRef(name string) *jsonSchema {
+	return &jsonSchema{Ref: "#/components/schemas/" + name}
+}
+
+// -- Schema generation
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	uuidType       = reflect.TypeFor[uuid.UUID]()
+	nullUUIDType   = reflect.TypeFor[uuid.NullUUID]()
+	nullStringType = reflect.TypeFor[nullString]()
+)
+
+// schemaRegistry collects the named schemas that are referenced from the document.
+type schemaRegistry map[string]*jsonSchema
+
+/*
+schemaOf returns the schema of the JSON encoding of t. Named struct types are added to the registry and referenced,
+so every Go type is described once. Types that implement json.Marshaler or encoding.TextMarshaler must be listed
+explicitly, since their encoding cannot be derived from their fields.
+*/
+func (reg schemaRegistry) schemaOf(t reflect.Type) *jsonSchema {
+	switch t {
+	case timeType:
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &jsonSchema{Type: "string", Format: "uuid"}
+	case nullUUIDType:
+		return &jsonSchema{Type: []string{"string", "null"}, Format: "uuid"}
+	case nullStringType:
+		return &jsonSchema{Type: []string{"string", "null"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := reg.schemaOf(t.Elem())
+		if typ, ok := schema.Type.(string); ok {
+			schema.Type = []string{typ, "null"}
+		}
+		return schema
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: reg.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: reg.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return reg.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := reg[name]; !ok {
+			// Register a placeholder first so that recursive types terminate.
+			reg[name] = &jsonSchema{}
+			*reg[name] = *reg.structSchema(t)
+		}
+		return schemaRef(name)
+	default:
+		// Interfaces can hold any JSON value.
+		return &jsonSchema{}
+	}
+}
+
+func (reg schemaRegistry) structSchema(t reflect.Type) *jsonSchema {
+	schema := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = reg.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// schemaName exports the name of t, so that unexported request types get a conventional component name.
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// -- Document generation
+
+var pathParameterPattern = regexp.MustCompile(`\{([a-zA-Z_]+)\}`)
+
+// openAPIPath converts the path of a mux pattern into an OpenAPI path. Patterns ending in a slash match a whole
+// subtree, which is documented as a trailing path parameter.
+func openAPIPath(path string) string {
+	if path != "/" && strings.HasSuffix(path, "/") {
+		return path + "{path}"
+	}
+	return path
+}
+
+// operationID derives a unique, stable identifier from a mux pattern, e.g. "GET /api/cards/{id}" becomes
+// "getApiCardsById".
+func operationID(method, path string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '.' }) {
+		if param, ok := strings.CutPrefix(segment, "{"); ok {
+			segment = "by_" + strings.TrimSuffix(param, "}")
+		}
+		for _, word := range strings.Split(segment, "_") {
+			if word != "" {
+				id.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			}
+		}
+	}
+	if path == "/" {
+		id.WriteString("Index")
+	}
+	return id.String()
+}
+
+// newOpenAPIDocument builds the OpenAPI document describing operations.
+func newOpenAPIDocument(operations []apiOperation) (*openAPIDocument, error) {
+	schemas := schemaRegistry{}
+	schemas.schemaOf(reflect.TypeFor[problem]())
+	schemas["Response"] = &jsonSchema{
+		Type:        "object",
+		Description: "Envelope of every successful JSON response.",
+		Properties: map[string]*jsonSchema{
+			"content": {Description: "The requested data, its schema depends on the operation."},
+			"status":  {Type: "integer", Description: "The HTTP status code of the response."},
+		},
+		Required: []string{"status"},
+	}
+
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "unsubtle-core",
+			Description: "The data layer of the unsubtle app.",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: schemas,
+			Responses: map[string]*openAPIResponse{
+				"Problem": {
+					Description: "The request failed, see RFC 7807.",
+					Content:     map[string]openAPIMediaType{problemContentType: {Schema: schemaRef("Problem")}},
+				},
+			},
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	for _, op := range operations {
+		method, path, ok := strings.Cut(op.Pattern, " ")
+		if !ok {
+			return nil, fmt.Errorf("operation %q: pattern must start with a method", op.Pattern)
+		}
+		path = openAPIPath(path)
+
+		operation := &openAPIOperation{
+			OperationID: operationID(method, path),
+			Summary:     op.Summary,
+			Tags:        []string{op.Tag},
+			Responses: map[string]*openAPIResponse{
+				"default": {Ref: "#/components/responses/Problem"},
+			},
+		}
+
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+			param := openAPIParameter{Name: match[1], In: "path", Required: true, Schema: &jsonSchema{Type: "string"}}
+			if match[1] == "id" {
+				param.Schema.Format = "uuid"
+			}
+			operation.Parameters = append(operation.Parameters, param)
+		}
+		operation.Parameters = append(operation.Parameters, op.Query...)
+		if op.Idempotent {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name: idempotencyKeyHeader, In: "header", Schema: &jsonSchema{Type: "string"},
+				Description: "Makes the request safe to retry, the first response for a key is replayed for 24 hours.",
+			})
+		}
+		if op.Conditional {
+			operation.Parameters = append(operation.Parameters, openAPIParameter{
+				Name: "If-Match", In: "header", Required: true, Schema: &jsonSchema{Type: "string"},
+				Description: "The ETag of the version of the resource that is modified.",
+			})
+		}
+
+		if op.Request != nil {
+			schema := schemas.schemaOf(reflect.TypeOf(op.Request))
+			contentType := "application/json"
+			switch {
+			case op.Form:
+				contentType = "application/x-www-form-urlencoded"
+			case op.MergePatch:
+				contentType = mergePatchContentType
+			}
+			operation.RequestBody = &openAPIRequestBody{
+				Required: true,
+				Content:  map[string]openAPIMediaType{contentType: {Schema: schema}},
+			}
+		}
+
+		success := &openAPIResponse{Description: http.StatusText(op.Status)}
+		switch {
+		case op.HTML:
+			success.Content = map[string]openAPIMediaType{"text/html": {Schema: &jsonSchema{Type: "string"}}}
+		case op.SVG:
+			success.Content = map[string]openAPIMediaType{"image/svg+xml": {Schema: &jsonSchema{Type: "string"}}}
+		case op.Pattern == "GET /openapi.json":
+			success.Content = map[string]openAPIMediaType{"application/json": {Schema: &jsonSchema{Type: "object"}}}
+		case op.Bare:
+			success.Content = map[string]openAPIMediaType{"application/json": {Schema: schemas.schemaOf(reflect.TypeOf(op.Response))}}
+		case op.Response != nil:
+			envelope := &jsonSchema{AllOf: []*jsonSchema{
+				schemaRef("Response"),
+				{Properties: map[string]*jsonSchema{"content": schemas.schemaOf(reflect.TypeOf(op.Response))}},
+			}}
+			success.Content = map[string]openAPIMediaType{"application/json": {Schema: envelope}}
+		}
+		operation.Responses[fmt.Sprint(op.Status)] = success
+
+		if op.Authenticated {
+			operation.Security = []map[string][]string{{"bearerAuth": {}}}
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+		if _, exists := doc.Paths[path][strings.ToLower(method)]; exists {
+			return nil, fmt.Errorf("operation %q is documented twice", op.Pattern)
+		}
+		doc.Paths[path][strings.ToLower(method)] = operation
+	}
+
+	return doc, nil
+}
+
+// handleOpenAPI serves the OpenAPI document. The document is generated once, when the route is registered.
+func handleOpenAPI() http.Handler {
+	doc, err := newOpenAPIDocument(apiOperations)
+	if err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI operations: %v", err))
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("could not encode OpenAPI document: %v", err))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
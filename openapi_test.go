@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingMux records the patterns of all registered routes.
+type recordingMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *recordingMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	mux := &recordingMux{ServeMux: http.NewServeMux()}
+	addRoutes(mux, newTestConfig(), newSQLStore(nil), newHealth(), newRateLimits(newTestConfig().RateLimit, newSQLStore(nil)))
+
+	doc, err := newOpenAPIDocument(apiOperations)
+	if err != nil {
+		t.Fatalf("newOpenAPIDocument() returned an error: %v", err)
+	}
+
+	registered := map[string]bool{}
+	for _, pattern := range mux.patterns {
+		registered[pattern] = true
+
+		method, path, _ := strings.Cut(pattern, " ")
+		if doc.Paths[openAPIPath(path)][strings.ToLower(method)] == nil {
+			t.Errorf("route %q is registered in addRoutes but missing from the OpenAPI document", pattern)
+		}
+	}
+
+	for _, op := range apiOperations {
+		if !registered[op.Pattern] {
+			t.Errorf("operation %q is documented but not registered in addRoutes", op.Pattern)
+		}
+	}
+}
+
+func TestHandlerOpenAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /openapi.json", handleOpenAPI())
+
+	request := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	response := httptest.NewRecorder()
+
+	mux.ServeHTTP(response, request)
+
+	assertStatusCode(t, response.Code, http.StatusOK)
+
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&doc); err != nil {
+		t.Fatalf("could not decode OpenAPI document: %v", err)
+	}
+
+	if doc.OpenAPI != openAPIVersion {
+		t.Errorf("got openapi %q, want %q", doc.OpenAPI, openAPIVersion)
+	}
+	for _, name := range []string{"Response", "Problem", "Subscription", "SubscriptionRequest", "Card", "ActiveSubscription"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %q is missing from the OpenAPI document", name)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// ListActiveSubscriptions returns the active subscriptions of the user.
+func (c *Client) ListActiveSubscriptions(ctx context.Context) ([]database.ActiveSubscription, error) {
+	var activeSubscriptions []database.ActiveSubscription
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/activesubscriptions"}, &activeSubscriptions)
+	return activeSubscriptions, err
+}
+
+// GetActiveSubscription returns a single active subscription.
+func (c *Client) GetActiveSubscription(ctx context.Context, id uuid.UUID) (database.ActiveSubscription, error) {
+	var activeSubscription database.ActiveSubscription
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/activesubscriptions/" + id.String()}, &activeSubscription)
+	return activeSubscription, err
+}
+
+// CreateActiveSubscription activates a subscription. It fails with CodeConflict when the subscription is already
+// active.
+func (c *Client) CreateActiveSubscription(ctx context.Context, req ActiveSubscriptionRequest) (database.ActiveSubscription, error) {
+	var activeSubscription database.ActiveSubscription
+	err := c.do(ctx, call{method: http.MethodPost, path: "/api/activesubscriptions", body: req}, &activeSubscription)
+	return activeSubscription, err
+}
+
+// UpdateActiveSubscription replaces the billing settings of an active subscription. The update fails with
+// CodePreconditionFailed when the active subscription is no longer at version.
+func (c *Client) UpdateActiveSubscription(ctx context.Context, id uuid.UUID, version int32, req ActiveSubscriptionUpdateRequest) (database.ActiveSubscription, error) {
+	var activeSubscription database.ActiveSubscription
+	err := c.do(ctx, call{method: http.MethodPut, path: "/api/activesubscriptions/" + id.String(), body: req, ifMatch: version}, &activeSubscription)
+	return activeSubscription, err
+}
+
+// PatchActiveSubscription partially updates an active subscription, see Patch. The update fails with
+// CodePreconditionFailed when the active subscription is no longer at version.
+func (c *Client) PatchActiveSubscription(ctx context.Context, id uuid.UUID, version int32, patch Patch) (database.ActiveSubscription, error) {
+	var activeSubscription database.ActiveSubscription
+	err := c.do(ctx, call{
+		method:      http.MethodPatch,
+		path:        "/api/activesubscriptions/" + id.String(),
+		body:        patch,
+		contentType: mergePatchContentType,
+		ifMatch:     version,
+	}, &activeSubscription)
+	return activeSubscription, err
+}
+
+// DeleteActiveSubscription deactivates a subscription. It fails with CodePreconditionFailed when the active
+// subscription is no longer at version.
+func (c *Client) DeleteActiveSubscription(ctx context.Context, id uuid.UUID, version int32) error {
+	return c.do(ctx, call{method: http.MethodDelete, path: "/api/activesubscriptions/" + id.String(), ifMatch: version}, nil)
+}
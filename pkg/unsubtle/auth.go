@@ -0,0 +1,155 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Tokens are the credentials of an authenticated client.
+type Tokens struct {
+	// Token is the short lived JWT sent as bearer token.
+	Token string `json:"token"`
+	// RefreshToken is used to renew Token, also after Token expired.
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LoginResponse is returned by Login.
+type LoginResponse struct {
+	ID           uuid.UUID `json:"id"`
+	Email        string    `json:"email"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Login authenticates the client. Subsequent calls are made on behalf of the logged in user.
+func (c *Client) Login(ctx context.Context, email, password string) (LoginResponse, error) {
+	var login LoginResponse
+	if err := c.do(ctx, call{
+		method:    http.MethodPost,
+		path:      "/api/login",
+		body:      credentials{Email: email, Password: password},
+		anonymous: true,
+	}, &login); err != nil {
+		return LoginResponse{}, err
+	}
+
+	c.setTokens(Tokens{Token: login.Token, RefreshToken: login.RefreshToken})
+	return login, nil
+}
+
+// Register creates a new user. It does not log in, use Login afterwards.
+func (c *Client) Register(ctx context.Context, email, password string) (database.CreateUserRow, error) {
+	var user database.CreateUserRow
+	err := c.do(ctx, call{
+		method:    http.MethodPost,
+		path:      "/api/register",
+		body:      credentials{Email: email, Password: password},
+		anonymous: true,
+	}, &user)
+	return user, err
+}
+
+// Refresh renews the JWT of the client with its refresh token. Authenticated calls do this automatically when the JWT
+// is about to expire or has expired.
+func (c *Client) Refresh(ctx context.Context) error {
+	_, err := c.renew(ctx, c.Tokens().Token)
+	return err
+}
+
+// Revoke revokes the refresh token of the user. The client remains usable until its JWT expires.
+func (c *Client) Revoke(ctx context.Context) (database.RefreshToken, error) {
+	var token database.RefreshToken
+	err := c.do(ctx, call{method: http.MethodPost, path: "/revoke"}, &token)
+	return token, err
+}
+
+// Tokens returns the current credentials of the client, e.g. to store them for a later run.
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+func (c *Client) setTokens(tokens Tokens) {
+	c.mu.Lock()
+	c.tokens = tokens
+	onRenew := c.onRenew
+	c.mu.Unlock()
+
+	if onRenew != nil {
+		onRenew(tokens)
+	}
+}
+
+func (c *Client) refreshToken() string {
+	return c.Tokens().RefreshToken
+}
+
+// validToken returns a JWT for an authenticated call, renewing it first when it is about to expire or has expired.
+func (c *Client) validToken(ctx context.Context) (string, error) {
+	tokens := c.Tokens()
+	if tokens.Token == "" {
+		return "", ErrNotAuthenticated
+	}
+	if tokens.RefreshToken != "" && expiresWithin(tokens.Token, renewBefore) {
+		return c.renew(ctx, tokens.Token)
+	}
+	return tokens.Token, nil
+}
+
+/*
+renew replaces stale with a new JWT from the refresh endpoint, which authenticates the client with its refresh token
+so that stale may already have expired. When another goroutine already replaced stale while this one was waiting, its
+result is used instead of renewing twice.
+*/
+func (c *Client) renew(ctx context.Context, stale string) (string, error) {
+	c.renewMu.Lock()
+	defer c.renewMu.Unlock()
+
+	tokens := c.Tokens()
+	if tokens.Token != stale {
+		return tokens.Token, nil
+	}
+	if tokens.RefreshToken == "" {
+		return "", ErrNotAuthenticated
+	}
+
+	var renewed struct {
+		Token string `json:"token"`
+	}
+	req := call{method: http.MethodPost, path: "/refresh"}
+	res, err := c.send(ctx, req, nil, tokens.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := decodeResponse(res, &renewed); err != nil {
+		return "", err
+	}
+
+	tokens.Token = renewed.Token
+	c.setTokens(tokens)
+	return renewed.Token, nil
+}
+
+// expiresWithin reports whether token expires within d. Tokens that cannot be parsed are treated as expiring.
+// The signature is not verified, the server does that.
+func expiresWithin(token string, d time.Duration) bool {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return true
+	}
+	return time.Until(claims.ExpiresAt.Time) < d
+}
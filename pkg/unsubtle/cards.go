@@ -0,0 +1,56 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// ListCards returns the cards of the user.
+func (c *Client) ListCards(ctx context.Context) ([]database.Card, error) {
+	var cards []database.Card
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/cards"}, &cards)
+	return cards, err
+}
+
+// GetCard returns a single card.
+func (c *Client) GetCard(ctx context.Context, id uuid.UUID) (database.Card, error) {
+	var card database.Card
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/cards/" + id.String()}, &card)
+	return card, err
+}
+
+// CreateCard registers a card.
+func (c *Client) CreateCard(ctx context.Context, req CardRequest) (database.CreateCardRow, error) {
+	var card database.CreateCardRow
+	err := c.do(ctx, call{method: http.MethodPost, path: "/api/cards", body: req}, &card)
+	return card, err
+}
+
+// UpdateCard replaces a card. The update fails with CodePreconditionFailed when the card is no longer at version.
+func (c *Client) UpdateCard(ctx context.Context, id uuid.UUID, version int32, req CardRequest) (database.Card, error) {
+	var card database.Card
+	err := c.do(ctx, call{method: http.MethodPut, path: "/api/cards/" + id.String(), body: req, ifMatch: version}, &card)
+	return card, err
+}
+
+// PatchCard partially updates a card, see Patch. The update fails with CodePreconditionFailed when the card is no
+// longer at version.
+func (c *Client) PatchCard(ctx context.Context, id uuid.UUID, version int32, patch Patch) (database.Card, error) {
+	var card database.Card
+	err := c.do(ctx, call{
+		method:      http.MethodPatch,
+		path:        "/api/cards/" + id.String(),
+		body:        patch,
+		contentType: mergePatchContentType,
+		ifMatch:     version,
+	}, &card)
+	return card, err
+}
+
+// DeleteCard deletes a card. It fails with CodePreconditionFailed when the card is no longer at version.
+func (c *Client) DeleteCard(ctx context.Context, id uuid.UUID, version int32) error {
+	return c.do(ctx, call{method: http.MethodDelete, path: "/api/cards/" + id.String(), ifMatch: version}, nil)
+}
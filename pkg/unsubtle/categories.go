@@ -0,0 +1,57 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// ListCategories returns the categories of the user.
+func (c *Client) ListCategories(ctx context.Context) ([]database.Category, error) {
+	var categories []database.Category
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/categories"}, &categories)
+	return categories, err
+}
+
+// GetCategory returns a single category.
+func (c *Client) GetCategory(ctx context.Context, id uuid.UUID) (database.Category, error) {
+	var category database.Category
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/categories/" + id.String()}, &category)
+	return category, err
+}
+
+// CreateCategory creates a category.
+func (c *Client) CreateCategory(ctx context.Context, req CategoryRequest) (database.Category, error) {
+	var category database.Category
+	err := c.do(ctx, call{method: http.MethodPost, path: "/api/categories", body: req}, &category)
+	return category, err
+}
+
+// UpdateCategory replaces a category. The update fails with CodePreconditionFailed when the category is no longer at
+// version.
+func (c *Client) UpdateCategory(ctx context.Context, id uuid.UUID, version int32, req CategoryRequest) (database.Category, error) {
+	var category database.Category
+	err := c.do(ctx, call{method: http.MethodPut, path: "/api/categories/" + id.String(), body: req, ifMatch: version}, &category)
+	return category, err
+}
+
+// PatchCategory partially updates a category, see Patch. The update fails with CodePreconditionFailed when the
+// category is no longer at version.
+func (c *Client) PatchCategory(ctx context.Context, id uuid.UUID, version int32, patch Patch) (database.Category, error) {
+	var category database.Category
+	err := c.do(ctx, call{
+		method:      http.MethodPatch,
+		path:        "/api/categories/" + id.String(),
+		body:        patch,
+		contentType: mergePatchContentType,
+		ifMatch:     version,
+	}, &category)
+	return category, err
+}
+
+// DeleteCategory deletes a category. It fails with CodePreconditionFailed when the category is no longer at version.
+func (c *Client) DeleteCategory(ctx context.Context, id uuid.UUID, version int32) error {
+	return c.do(ctx, call{method: http.MethodDelete, path: "/api/categories/" + id.String(), ifMatch: version}, nil)
+}
@@ -0,0 +1,222 @@
+/*
+Package unsubtle is a Go client for the unsubtle-core API.
+
+A Client is created with New and authenticated with Login, or with previously issued tokens passed to WithTokens.
+Authenticated calls renew the JWT with the refresh token shortly before it expires, or when it has already expired,
+so programs do not need to log in again until the refresh token expires or is revoked.
+
+Resources are returned as the model types of the internal/database package, which are the types the server encodes.
+Since that package is internal, programs outside this module can use the returned values and their fields but cannot
+name their types, e.g. to declare a variable or a function parameter of such a type.
+
+	client, err := unsubtle.New("https://unsubtle.example.com")
+	if err != nil {
+		return err
+	}
+	if _, err := client.Login(ctx, email, password); err != nil {
+		return err
+	}
+	subscriptions, err := client.ListSubscriptions(ctx)
+
+Failed requests return an *APIError describing the RFC 7807 problem document sent by the server.
+
+The client covers users, subscriptions, categories, cards, active subscriptions and search. Trials have no methods
+since the server does not serve them yet: the active_trails table exists but no route reads or writes it.
+*/
+package unsubtle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renewBefore is how long before its expiry a JWT is renewed.
+const renewBefore = time.Minute
+
+// Client is a client of the unsubtle-core API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	// mu guards tokens, renewMu serializes renewals so that concurrent calls renew the JWT only once.
+	mu      sync.Mutex
+	renewMu sync.Mutex
+	tokens  Tokens
+	onRenew func(Tokens)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to send requests. http.DefaultClient is used by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTokens authenticates the client with tokens issued earlier, e.g. tokens that were stored by a previous run.
+func WithTokens(tokens Tokens) Option {
+	return func(c *Client) {
+		c.tokens = tokens
+	}
+}
+
+// WithTokenListener registers a function that is called whenever the client obtains new tokens, either by logging in
+// or by renewing the JWT. It can be used to persist the tokens.
+func WithTokenListener(onRenew func(Tokens)) Option {
+	return func(c *Client) {
+		c.onRenew = onRenew
+	}
+}
+
+// New returns a client for the API served at baseURL.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL %q must be absolute", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{baseURL: u, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// call describes a single API request.
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	body   any
+	// contentType of the body, defaults to application/json
+	contentType string
+	// ifMatch is the version the request is conditional on, zero when the request is not conditional
+	ifMatch int32
+	// anonymous requests are sent without a bearer token
+	anonymous bool
+}
+
+// envelope is the response envelope that wraps the content of every successful response.
+type envelope struct {
+	Content json.RawMessage `json:"content"`
+	Status  int             `json:"status"`
+}
+
+/*
+do sends req and decodes the content of the response envelope into out, which may be nil. Authenticated requests are
+retried once with a renewed JWT when the server rejects the token.
+*/
+func (c *Client) do(ctx context.Context, req call, out any) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return fmt.Errorf("encoding request body: %w", err)
+		}
+	}
+
+	token := ""
+	if !req.anonymous {
+		var err error
+		if token, err = c.validToken(ctx); err != nil {
+			return err
+		}
+	}
+
+	res, err := c.send(ctx, req, body, token)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && !req.anonymous && c.refreshToken() != "" {
+		res.Body.Close()
+		if token, err = c.renew(ctx, token); err != nil {
+			return err
+		}
+		if res, err = c.send(ctx, req, body, token); err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	return decodeResponse(res, out)
+}
+
+// decodeResponse decodes the content of the response envelope into out, which may be nil. Error responses are
+// returned as *APIError.
+func decodeResponse(res *http.Response, out any) error {
+	if res.StatusCode >= http.StatusBadRequest {
+		return newAPIError(res)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	var env envelope
+	if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if err := json.Unmarshal(env.Content, out); err != nil {
+		return fmt.Errorf("decoding response content: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, req call, body []byte, token string) (*http.Response, error) {
+	u := c.baseURL.JoinPath(req.path)
+	u.RawQuery = req.query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), reader)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		contentType := req.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if req.ifMatch != 0 {
+		httpReq.Header.Set("If-Match", etag(req.ifMatch))
+	}
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.method, req.path, err)
+	}
+	return res, nil
+}
+
+// etag formats a resource version as the strong entity tag the server uses for it.
+func etag(version int32) string {
+	return `"` + strconv.FormatInt(int64(version), 10) + `"`
+}
+
+// ErrNotAuthenticated is returned by authenticated calls when the client has neither logged in nor been given tokens.
+var ErrNotAuthenticated = errors.New("unsubtle: client is not authenticated")
@@ -0,0 +1,77 @@
+package unsubtle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Error codes reported by the API. Branch on these rather than on the title or detail of an APIError.
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeInvalidEmail         = "invalid_email"
+	CodeInsecurePassword     = "insecure_password"
+	CodeEmailTaken           = "email_taken"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePreconditionRequired = "precondition_required"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRequestInProgress    = "request_in_progress"
+	CodeInternal             = "internal"
+)
+
+// FieldError describes why a single member of a request was rejected.
+type FieldError struct {
+	Field string `json:"field"`
+	// Code identifies why the member was rejected, such as "required"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is returned when the API responds with an error status. It holds the RFC 7807 problem document.
+type APIError struct {
+	StatusCode int          `json:"status"`
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Detail     string       `json:"detail"`
+	Instance   string       `json:"instance"`
+	Code       string       `json:"code"`
+	Errors     []FieldError `json:"errors"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("unsubtle: %d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, field := range e.Errors {
+		msg += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+	}
+	return msg
+}
+
+// newAPIError decodes the problem document of res. Responses without a problem document are described by their
+// status code only.
+func newAPIError(res *http.Response) *APIError {
+	apiErr := &APIError{}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = &APIError{Title: http.StatusText(res.StatusCode)}
+	}
+	apiErr.StatusCode = res.StatusCode
+	return apiErr
+}
+
+// IsCode reports whether err is, or wraps, an *APIError with the given code.
+func IsCode(err error, code string) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
@@ -0,0 +1,53 @@
+package unsubtle
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The request types below mirror the JSON the server expects, see requests.go in the server.
+
+// SubscriptionRequest creates or replaces a subscription. UnsubscribeUrl and Description are left empty when nil.
+type SubscriptionRequest struct {
+	Name           string        `json:"name"`
+	MonthlyCost    int32         `json:"monthly_cost"`
+	Currency       string        `json:"currency"`
+	UnsubscribeUrl *string       `json:"unsubscribe_url,omitempty"`
+	Description    *string       `json:"description,omitempty"`
+	CategoryId     uuid.NullUUID `json:"category_id,omitempty"`
+}
+
+// CategoryRequest creates or replaces a category.
+type CategoryRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// CardRequest registers or replaces a card.
+type CardRequest struct {
+	Name      string    `json:"name"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ActiveSubscriptionRequest activates a subscription, billed to a card.
+type ActiveSubscriptionRequest struct {
+	SubscriptionID   uuid.UUID    `json:"subscription_id"`
+	CardID           uuid.UUID    `json:"card_id"`
+	BillingFrequency string       `json:"billing_frequency"`
+	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
+}
+
+// ActiveSubscriptionUpdateRequest replaces the billing settings of an active subscription.
+type ActiveSubscriptionUpdateRequest struct {
+	BillingFrequency string `json:"billing_frequency"`
+	AutoRenewEnabled *bool  `json:"auto_renew_enabled"`
+}
+
+// Patch is an RFC 7396 JSON Merge Patch. Members that are set replace the current value, members set to nil are
+// cleared and members that are left out are not changed.
+type Patch map[string]any
+
+// mergePatchContentType is the media type of a Patch.
+const mergePatchContentType = "application/merge-patch+json"
@@ -0,0 +1,24 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+)
+
+// SearchResults are the hits of a search, grouped by resource type and ranked by relevance.
+type SearchResults struct {
+	Query         string                            `json:"query"`
+	Subscriptions []database.SearchSubscriptionsRow `json:"subscriptions"`
+	Categories    []database.SearchCategoriesRow    `json:"categories"`
+	Cards         []database.SearchCardsRow         `json:"cards"`
+}
+
+// Search runs a full-text search over the subscriptions, categories and cards of the user.
+func (c *Client) Search(ctx context.Context, query string) (SearchResults, error) {
+	var results SearchResults
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/search", query: url.Values{"q": {query}}}, &results)
+	return results, err
+}
@@ -0,0 +1,58 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// ListSubscriptions returns the subscriptions of the user.
+func (c *Client) ListSubscriptions(ctx context.Context) ([]database.Subscription, error) {
+	var subscriptions []database.Subscription
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/subscriptions"}, &subscriptions)
+	return subscriptions, err
+}
+
+// GetSubscription returns a single subscription.
+func (c *Client) GetSubscription(ctx context.Context, id uuid.UUID) (database.Subscription, error) {
+	var subscription database.Subscription
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/subscriptions/" + id.String()}, &subscription)
+	return subscription, err
+}
+
+// CreateSubscription creates a subscription.
+func (c *Client) CreateSubscription(ctx context.Context, req SubscriptionRequest) (database.Subscription, error) {
+	var subscription database.Subscription
+	err := c.do(ctx, call{method: http.MethodPost, path: "/api/subscriptions", body: req}, &subscription)
+	return subscription, err
+}
+
+// UpdateSubscription replaces a subscription. The update fails with CodePreconditionFailed when the subscription is
+// no longer at version.
+func (c *Client) UpdateSubscription(ctx context.Context, id uuid.UUID, version int32, req SubscriptionRequest) (database.Subscription, error) {
+	var subscription database.Subscription
+	err := c.do(ctx, call{method: http.MethodPut, path: "/api/subscriptions/" + id.String(), body: req, ifMatch: version}, &subscription)
+	return subscription, err
+}
+
+// PatchSubscription partially updates a subscription, see Patch. The update fails with CodePreconditionFailed when
+// the subscription is no longer at version.
+func (c *Client) PatchSubscription(ctx context.Context, id uuid.UUID, version int32, patch Patch) (database.Subscription, error) {
+	var subscription database.Subscription
+	err := c.do(ctx, call{
+		method:      http.MethodPatch,
+		path:        "/api/subscriptions/" + id.String(),
+		body:        patch,
+		contentType: mergePatchContentType,
+		ifMatch:     version,
+	}, &subscription)
+	return subscription, err
+}
+
+// DeleteSubscription deletes a subscription. It fails with CodePreconditionFailed when the subscription is no longer
+// at version.
+func (c *Client) DeleteSubscription(ctx context.Context, id uuid.UUID, version int32) error {
+	return c.do(ctx, call{method: http.MethodDelete, path: "/api/subscriptions/" + id.String(), ifMatch: version}, nil)
+}
@@ -0,0 +1,35 @@
+package unsubtle
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// ListUsers returns all users. The user routes are only open to admins.
+func (c *Client) ListUsers(ctx context.Context) ([]database.ListUsersRow, error) {
+	var users []database.ListUsersRow
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/users"}, &users)
+	return users, err
+}
+
+// GetUser returns a single user.
+func (c *Client) GetUser(ctx context.Context, id uuid.UUID) (database.User, error) {
+	var user database.User
+	err := c.do(ctx, call{method: http.MethodGet, path: "/api/users/" + id.String()}, &user)
+	return user, err
+}
+
+// UpdateUser changes the email and password of a user.
+func (c *Client) UpdateUser(ctx context.Context, id uuid.UUID, email, password string) (database.User, error) {
+	var user database.User
+	err := c.do(ctx, call{method: http.MethodPut, path: "/api/users/" + id.String(), body: credentials{Email: email, Password: password}}, &user)
+	return user, err
+}
+
+// DeleteUser deletes a user.
+func (c *Client) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, call{method: http.MethodDelete, path: "/api/users/" + id.String()}, nil)
+}
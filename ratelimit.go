@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/benkoben/unsubtle-core/internal/logging"
+)
+
+// -- Rate limiting
+//
+// Requests are limited with token buckets: a bucket holds up to a number of requests, every request takes a token
+// and the bucket is refilled with that many tokens per period. Authenticated requests are counted per user, others
+// per client IP, including those refused for a missing, invalid or expired JWT. Logging in, registering and refreshing a token count against a bucket of their own with a stricter
+// limit, against password guessing. A request that finds its bucket empty is answered with 429 Too Many Requests and
+// Retry-After, and every limited response carries the RateLimit-* headers of the IETF draft "RateLimit header fields
+// for HTTP".
+//
+// The buckets are kept in memory by default, so every replica limits on its own. With the postgres backend they are
+// shared by all replicas through the rate_limits table.
+
+const (
+	rateLimitBackendMemory   = "memory"
+	rateLimitBackendPostgres = "postgres"
+	rateLimitBackendOff      = "off"
+)
+
+// rateLimitSweepInterval is how often the memory backend forgets the buckets that are full again, and how often the
+// postgres backend removes them from the table.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimit is the policy of a bucket.
+type rateLimit struct {
+	// name tells the buckets of the policy apart from those of other policies for the same client
+	name     string
+	requests int
+	period   time.Duration
+}
+
+func (l rateLimit) capacity() float64 {
+	return float64(l.requests)
+}
+
+// refillRate is the number of tokens added per second.
+func (l rateLimit) refillRate() float64 {
+	return float64(l.requests) / l.period.Seconds()
+}
+
+// rateLimiter is a backend holding the buckets.
+type rateLimiter interface {
+	// take takes a token from the bucket of key. It returns the tokens left in the bucket and whether a token was
+	// taken.
+	take(ctx context.Context, key string, limit rateLimit) (tokens float64, ok bool, err error)
+}
+
+// memoryRateLimiter holds the buckets of this process.
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is full again, after which it can be forgotten
+	full time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{buckets: map[string]*tokenBucket{}, now: time.Now}
+}
+
+func (l *memoryRateLimiter) take(_ context.Context, key string, limit rateLimit) (float64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: limit.capacity(), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(limit.capacity(), bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.refillRate())
+	bucket.updated = now
+
+	ok := bucket.tokens >= 1
+	if ok {
+		bucket.tokens--
+	}
+	bucket.full = now.Add(time.Duration((limit.capacity() - bucket.tokens) / limit.refillRate() * float64(time.Second)))
+	return bucket.tokens, ok, nil
+}
+
+// sweep forgets the buckets that are full, since a new bucket is full as well.
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if !now.Before(bucket.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// postgresRateLimiter holds the buckets in the database, so that the limits hold across replicas.
+type postgresRateLimiter struct {
+	db dbQuerier
+}
+
+func (l postgresRateLimiter) take(ctx context.Context, key string, limit rateLimit) (float64, bool, error) {
+	tokens, err := l.db.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key:        key,
+		Capacity:   limit.capacity(),
+		RefillRate: limit.refillRate(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// The bucket is empty, the tokens left tell when to try again
+		tokens, err = l.db.GetRateLimitTokens(ctx, database.GetRateLimitTokensParams{
+			Key:        key,
+			Capacity:   limit.capacity(),
+			RefillRate: limit.refillRate(),
+		})
+		return tokens, false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return tokens, true, nil
+}
+
+// cleanupRateLimits periodically removes the buckets that are full again from the database until ctx is cancelled.
+// A bucket is full at the latest after the longest period of the limits.
+func cleanupRateLimits(ctx context.Context, db *database.Queries, config *RateLimitConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := db.DeleteStaleRateLimits(ctx, time.Now().Add(-max(config.Period, config.AuthPeriod)))
+			if err != nil {
+				slog.Error("could not remove stale rate limits", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				slog.Debug("removed stale rate limits", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// rateLimits applies the limits of the configuration to the routes.
+type rateLimits struct {
+	// limiter is nil when rate limiting is off
+	limiter      rateLimiter
+	defaultLimit rateLimit
+	authLimit    rateLimit
+	// clientIPHeader is the header in which a proxy in front of the server passes the client IP
+	clientIPHeader string
+}
+
+func newRateLimits(config *RateLimitConfig, db dbQuerier) *rateLimits {
+	limits := &rateLimits{
+		defaultLimit:   rateLimit{name: "default", requests: config.Requests, period: config.Period},
+		authLimit:      rateLimit{name: "auth", requests: config.AuthRequests, period: config.AuthPeriod},
+		clientIPHeader: config.ClientIPHeader,
+	}
+	switch config.Backend {
+	case rateLimitBackendMemory:
+		limits.limiter = newMemoryRateLimiter()
+	case rateLimitBackendPostgres:
+		limits.limiter = postgresRateLimiter{db: db}
+	}
+	return limits
+}
+
+// api limits the requests of next with the default limit.
+func (rl *rateLimits) api(next http.Handler) http.Handler {
+	return rl.limit(next, rl.defaultLimit)
+}
+
+// auth limits the requests of next with the stricter limit of the authentication routes.
+func (rl *rateLimits) auth(next http.Handler) http.Handler {
+	return rl.limit(next, rl.authLimit)
+}
+
+/*
+unauthenticated limits the requests to next without a valid JWT per client IP with the default limit. It wraps
+authenticate, which refuses those requests before api can count them. Requests with a valid JWT are counted per user
+by api only, so that users behind the same IP do not share a bucket. Their user ID is put on the context, where
+authenticate finds it instead of validating the JWT again.
+*/
+func (rl *rateLimits) unauthenticated(next http.Handler, jwtSecret string) http.Handler {
+	if rl.limiter == nil {
+		return next
+	}
+	limited := rl.limit(next, rl.defaultLimit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, err := auth.GetBearerToken(r.Header); err == nil {
+			if userId, err := auth.ValidateJWT(token, jwtSecret); err == nil {
+				next.ServeHTTP(w, r.WithContext(WithUserId(r.Context(), userId)))
+				return
+			}
+		}
+		limited.ServeHTTP(w, r)
+	})
+}
+
+/*
+limit takes a token for every request from the bucket of the client. Requests are counted per user once they are
+authenticated, so next must be wrapped by authenticate rather than the other way around, and per client IP otherwise.
+
+When the backend fails, the request is served anyway: an outage of the limits should not take the API down with it.
+*/
+func (rl *rateLimits) limit(next http.Handler, limit rateLimit) http.Handler {
+	if rl.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens, ok, err := rl.limiter.take(r.Context(), limit.name+":"+rl.client(r), limit)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("could not check the rate limit", "limit", limit.name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.requests, int(limit.period.Seconds())))
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(int(tokens)))
+		header.Set("RateLimit-Reset", strconv.Itoa(secondsUntil(limit.capacity()-tokens, limit)))
+		if !ok {
+			rateLimitedRequests.WithLabelValues(limit.name).Inc()
+			retryAfter := secondsUntil(1-tokens, limit)
+			header.Set("Retry-After", strconv.Itoa(retryAfter))
+			writeProblem(w, r, TooManyRequestsError.WithDetail("try again in %d seconds", retryAfter))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// secondsUntil returns the whole number of seconds until tokens are added to a bucket of limit.
+func secondsUntil(tokens float64, limit rateLimit) int {
+	return int(math.Ceil(max(tokens, 0) / limit.refillRate()))
+}
+
+// client identifies whom a request is counted for: the authenticated user, or else the client IP.
+func (rl *rateLimits) client(r *http.Request) string {
+	if userId := GetUserId(r.Context()); userId != nil {
+		return "user:" + userId.String()
+	}
+	return "ip:" + rl.clientIP(r)
+}
+
+/*
+clientIP returns the IP address of the client. Behind a proxy, every request comes from the proxy, which passes the
+client IP in clientIPHeader instead. The last address of the header is used, since that is the one the proxy added;
+the ones before it are sent by the client and can be forged.
+*/
+func (rl *rateLimits) clientIP(r *http.Request) string {
+	if rl.clientIPHeader != "" {
+		if values := r.Header.Values(rl.clientIPHeader); len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
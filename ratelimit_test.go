@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/google/uuid"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	now := time.Date(2025, 5, 7, 12, 0, 0, 0, time.UTC)
+	limiter := newMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := rateLimit{name: "test", requests: 2, period: time.Minute}
+
+	take := func(t *testing.T, key string, wantOk bool) float64 {
+		t.Helper()
+		tokens, ok, err := limiter.take(context.Background(), key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != wantOk {
+			t.Fatalf("expected a token to be taken: %t, got %t with %v tokens left", wantOk, ok, tokens)
+		}
+		return tokens
+	}
+
+	t.Run("it allows a burst up to the capacity", func(t *testing.T) {
+		take(t, "a", true)
+		take(t, "a", true)
+		if tokens := take(t, "a", false); tokens != 0 {
+			t.Errorf("expected an empty bucket, got %v tokens", tokens)
+		}
+		// Other keys have buckets of their own
+		take(t, "b", true)
+	})
+
+	t.Run("it refills the bucket over the period", func(t *testing.T) {
+		now = now.Add(30 * time.Second)
+		take(t, "a", true)
+		take(t, "a", false)
+	})
+
+	t.Run("it forgets buckets that are full again", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		take(t, "a", true)
+		if _, ok := limiter.buckets["b"]; ok {
+			t.Error("expected the full bucket to be forgotten")
+		}
+	})
+}
+
+func TestRateLimits(t *testing.T) {
+	config := newTestConfig()
+	config.RateLimit.Requests = 3
+	config.RateLimit.AuthRequests = 2
+	handler, store, user, _ := newAuthenticatedServer(t, config)
+	// get requests the subscriptions of the user with id
+	get := func(t *testing.T, handler http.Handler, id uuid.UUID) *httptest.ResponseRecorder {
+		t.Helper()
+		token, err := auth.MakeJWT(id, config.JWTSecret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	// login tries to log in from the client IP remoteAddr
+	login := func(t *testing.T, remoteAddr string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email": "`+user.Email+`", "password": "guess"}`))
+		req.RemoteAddr = remoteAddr
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it limits the requests of a user", func(t *testing.T) {
+		for i := range 3 {
+			if rec := get(t, handler, user.ID); rec.Code != http.StatusOK {
+				t.Fatalf("expected request %d to be served, got %d", i+1, rec.Code)
+			}
+		}
+		rec := get(t, handler, user.ID)
+		assertProblem(t, rec, http.StatusTooManyRequests, CodeRateLimited)
+
+		for name, want := range map[string]string{
+			"Retry-After":         "20",
+			"RateLimit-Limit":     "3",
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "60",
+			"RateLimit-Policy":    "3;w=60",
+		} {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("expected %s %q, got %q", name, want, got)
+			}
+		}
+
+		// Other users are counted on their own
+		if rec := get(t, handler, uuid.New()); rec.Code == http.StatusTooManyRequests {
+			t.Error("expected another user not to be limited")
+		}
+	})
+
+	t.Run("it limits requests with an invalid JWT per client IP", func(t *testing.T) {
+		expired, err := auth.MakeJWT(user.ID, config.JWTSecret, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// get requests the subscriptions with the expired JWT from the client IP remoteAddr
+		get := func(remoteAddr string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("Authorization", "Bearer "+expired)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		for range 3 {
+			if rec := get("192.0.2.20:1234"); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected the request to be refused, got %d", rec.Code)
+			}
+		}
+		assertProblem(t, get("192.0.2.20:5678"), http.StatusTooManyRequests, CodeRateLimited)
+
+		if rec := get("192.0.2.21:1234"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected another client not to be limited, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it hands the user of a valid JWT on to authenticate", func(t *testing.T) {
+		token, err := auth.MakeJWT(user.ID, config.JWTSecret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got *uuid.UUID
+		limited := newRateLimits(config.RateLimit, store).unauthenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = GetUserId(r.Context())
+			// authenticate takes the user from the context without validating the JWT again
+			authenticate(http.NotFoundHandler(), "another secret").ServeHTTP(w, r)
+		}), config.JWTSecret)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/subscriptions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+
+		if got == nil || *got != user.ID {
+			t.Errorf("expected the user %s on the context, got %v", user.ID, got)
+		}
+		assertStatusCode(t, rec.Code, http.StatusNotFound)
+	})
+
+	t.Run("it limits logins per client IP more strictly", func(t *testing.T) {
+		for range 2 {
+			if rec := login(t, "192.0.2.10:1234", nil); rec.Code != http.StatusUnauthorized {
+				t.Fatalf("expected the login to be refused, got %d", rec.Code)
+			}
+		}
+		assertProblem(t, login(t, "192.0.2.10:5678", nil), http.StatusTooManyRequests, CodeRateLimited)
+
+		if rec := login(t, "192.0.2.11:1234", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected another client not to be limited, got %d", rec.Code)
+		}
+	})
+
+	t.Run("it takes the client IP from the proxy", func(t *testing.T) {
+		config := newTestConfig()
+		config.RateLimit.AuthRequests = 1
+		config.RateLimit.ClientIPHeader = "X-Forwarded-For"
+		handler := NewServerHandler(config, newMemoryStore(), newHealth())
+		login := func(forwardedFor string) int {
+			req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{}`))
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		login("198.51.100.1")
+		// The client cannot escape its limit by forging the addresses before the one added by the proxy
+		if code := login("203.0.113.7, 198.51.100.1"); code != http.StatusTooManyRequests {
+			t.Errorf("expected the client to be limited, got %d", code)
+		}
+		if code := login("198.51.100.2"); code == http.StatusTooManyRequests {
+			t.Error("expected another client behind the proxy not to be limited")
+		}
+	})
+
+	t.Run("it shares the limits of the replicas through the database", func(t *testing.T) {
+		config := newTestConfig()
+		config.RateLimit.Backend = rateLimitBackendPostgres
+		config.RateLimit.Requests = 2
+		replicas := []http.Handler{NewServerHandler(config, store, newHealth()), NewServerHandler(config, store, newHealth())}
+
+		get(t, replicas[0], user.ID)
+		get(t, replicas[1], user.ID)
+		assertProblem(t, get(t, replicas[0], user.ID), http.StatusTooManyRequests, CodeRateLimited)
+	})
+
+	t.Run("it serves requests when the backend fails", func(t *testing.T) {
+		limits := &rateLimits{limiter: postgresRateLimiter{db: failingStore(errors.New("connection refused"))}, defaultLimit: rateLimit{name: "default", requests: 1, period: time.Minute}}
+		served := false
+		rec := httptest.NewRecorder()
+		limits.api(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true })).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cards", nil))
+		if !served {
+			t.Error("expected the request to be served")
+		}
+	})
+}
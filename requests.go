@@ -2,8 +2,14 @@ package main
 
 import (
 	"database/sql"
-	"github.com/google/uuid"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
 )
 
 // -- Data that is expected by various requests
@@ -12,6 +18,11 @@ type userRequestData struct {
 	Password string `json:"password"`
 }
 
+// LogValue leaves the password out of the logs.
+func (u userRequestData) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("email", u.Email))
+}
+
 type loginResponseData struct {
 	Id           uuid.UUID `json:"id"`
 	Email        string    `json:"email,omitempty"`
@@ -21,13 +32,43 @@ type loginResponseData struct {
 	RefreshToken string    `json:"refresh_token"`
 }
 
+// LogValue leaves the tokens out of the logs.
+func (l loginResponseData) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("id", l.Id.String()), slog.String("email", l.Email))
+}
+
 type subscriptionRequest struct {
-	Name           string         `json:"name"`
-	MonthlyCost    int32          `json:"monthly_cost"`
-	Currency       string         `json:"currency"`
-	UnsubscribeUrl sql.NullString `json:"unsubscribe_url,omitempty"`
-	Description    sql.NullString `json:"description,omitempty"`
-	CategoryId     uuid.NullUUID  `json:"category_id,omitempty"`
+	Name           string        `json:"name"`
+	MonthlyCost    int32         `json:"monthly_cost"`
+	Currency       string        `json:"currency"`
+	UnsubscribeUrl nullString    `json:"unsubscribe_url,omitempty"`
+	Description    nullString    `json:"description,omitempty"`
+	CategoryId     uuid.NullUUID `json:"category_id,omitempty"`
+}
+
+// nullString is a sql.NullString that is encoded in JSON as a string, or null when it is not valid, like uuid.NullUUID.
+// Plain sql.NullString would be encoded as an object, which breaks merge patches of its member.
+type nullString struct {
+	sql.NullString
+}
+
+func (n nullString) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.String)
+}
+
+func (n *nullString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*n = nullString{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.String); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
 }
 
 type cardRequest struct {
@@ -35,6 +76,11 @@ type cardRequest struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
+type categoryRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
 type activeSubscriptionUpdateRequest struct {
 	BillingFrequency string `json:"billing_frequency"`
 	AutoRenewEnabled *bool  `json:"auto_renew_enabled"`
@@ -46,3 +92,137 @@ type activeSubscriptionRequest struct {
 	BillingFrequency string       `json:"billing_frequency"`
 	AutoRenewEnabled sql.NullBool `json:"auto_renew_enabled"`
 }
+
+// -- Validation of request data
+//
+// The validate methods are shared by PUT and PATCH handlers. For PATCH they run against the merged document,
+// which guarantees that a partial update can never leave a resource in a state that a full update would reject.
+// All rejected fields are reported at once, wrapped in ValidationFailedError.
+
+// validationErrors collects the rejected fields of a request.
+type validationErrors []FieldError
+
+func (v *validationErrors) add(field string, code FieldCode, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+// err returns nil when no field was rejected.
+func (v validationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return ValidationFailedError.WithFields(v...)
+}
+
+func (s subscriptionRequest) validate() error {
+	var errs validationErrors
+	if strings.TrimSpace(s.Name) == "" {
+		errs.add("name", FieldRequired, "name is required")
+	}
+	if s.MonthlyCost < 0 {
+		errs.add("monthly_cost", FieldNegative, "monthly_cost cannot be negative")
+	}
+	if strings.TrimSpace(s.Currency) == "" {
+		errs.add("currency", FieldRequired, "currency is required")
+	}
+	if s.UnsubscribeUrl.Valid {
+		if _, err := url.ParseRequestURI(s.UnsubscribeUrl.String); err != nil {
+			errs.add("unsubscribe_url", FieldInvalidURL, "unsubscribe_url is not a valid URL")
+		}
+	}
+	return errs.err()
+}
+
+func (c cardRequest) validate() error {
+	var errs validationErrors
+	if strings.TrimSpace(c.Name) == "" {
+		errs.add("name", FieldRequired, "name is required")
+	}
+	if c.ExpiresAt.IsZero() {
+		errs.add("expires_at", FieldRequired, "expires_at is required")
+	}
+	return errs.err()
+}
+
+func (c categoryRequest) validate() error {
+	var errs validationErrors
+	if strings.TrimSpace(c.Name) == "" {
+		errs.add("name", FieldRequired, "name is required")
+	}
+	return errs.err()
+}
+
+func (a activeSubscriptionUpdateRequest) validate() error {
+	var errs validationErrors
+	if strings.TrimSpace(a.BillingFrequency) == "" {
+		errs.add("billing_frequency", FieldRequired, "billing_frequency is required")
+	}
+	return errs.err()
+}
+
+func (a activeSubscriptionRequest) validate() error {
+	var errs validationErrors
+	if a.SubscriptionID == uuid.Nil {
+		errs.add("subscription_id", FieldRequired, "subscription_id is required")
+	}
+	if a.CardID == uuid.Nil {
+		errs.add("card_id", FieldRequired, "card_id is required")
+	}
+	if strings.TrimSpace(a.BillingFrequency) == "" {
+		errs.add("billing_frequency", FieldRequired, "billing_frequency is required")
+	}
+	return errs.err()
+}
+
+// -- Conversion of database entries into request data, used as the target document of merge patches
+
+func subscriptionRequestFrom(s database.Subscription) subscriptionRequest {
+	return subscriptionRequest{
+		Name:           s.Name,
+		MonthlyCost:    s.MonthlyCost,
+		Currency:       s.Currency,
+		UnsubscribeUrl: nullString{s.UnsubscribeUrl},
+		Description:    nullString{s.Description},
+		CategoryId:     s.CategoryID,
+	}
+}
+
+func cardRequestFrom(c database.Card) cardRequest {
+	return cardRequest{
+		Name:      c.Name,
+		ExpiresAt: c.ExpiresAt,
+	}
+}
+
+func categoryRequestFrom(c database.Category) categoryRequest {
+	return categoryRequest{
+		Name:        c.Name,
+		Description: c.Description,
+	}
+}
+
+func activeSubscriptionUpdateRequestFrom(a database.ActiveSubscription) activeSubscriptionUpdateRequest {
+	req := activeSubscriptionUpdateRequest{
+		BillingFrequency: a.BillingFrequency,
+	}
+	if a.AutoRenewEnabled.Valid {
+		req.AutoRenewEnabled = toPtr(a.AutoRenewEnabled.Bool)
+	}
+	return req
+}
+
+// autoRenew converts the optional auto_renew_enabled field into its nullable database representation.
+func (a activeSubscriptionUpdateRequest) autoRenew() sql.NullBool {
+	if a.AutoRenewEnabled == nil {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: *a.AutoRenewEnabled, Valid: true}
+}
+
+// searchResponseData groups ranked search hits by resource type.
+type searchResponseData struct {
+	Query         string                            `json:"query"`
+	Subscriptions []database.SearchSubscriptionsRow `json:"subscriptions"`
+	Categories    []database.SearchCategoriesRow    `json:"categories"`
+	Cards         []database.SearchCardsRow         `json:"cards"`
+}
@@ -1,19 +1,105 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const problemContentType = "application/problem+json"
+
 type response struct {
-	Content any     `json:"content,omitempty"`
-	Status  int     `json:"status"`
-	Error   *string `json:"error,omitempty"`
+	Content any `json:"content,omitempty"`
+	Status  int `json:"status"`
+
+	// err is reported as a problem document instead of Content, see fail.
+	err error
 }
 
-func (res *response) respond(w http.ResponseWriter) error {
+// fail marks the response as failed. The status code and body are derived from err when the response is sent.
+func (res *response) fail(err error) {
+	res.err = err
+}
+
+func (res *response) respond(w http.ResponseWriter, r *http.Request) error {
+	if res.err != nil {
+		return writeProblem(w, r, res.err)
+	}
+
+	// These status codes do not allow a response body
+	if res.Status == http.StatusNoContent || res.Status == http.StatusNotModified {
+		w.WriteHeader(res.Status)
+		return nil
+	}
+
 	if err := encode(w, res.Status, res); err != nil {
 		return fmt.Errorf("%w: %w", ResponseFailureError, err)
 	}
 	return nil
 }
+
+// problem is an RFC 7807 problem details document. Instance is the path of the request the problem occurred in.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     ErrorCode    `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// asDomainError maps err onto the DomainError that is reported to the client.
+// A violated unique constraint is reported as a conflict even when it was wrapped, see conflictError. Other errors
+// that are not DomainErrors are not meant to be seen by clients and are reported as InternalError.
+func asDomainError(err error) *DomainError {
+	var domainErr *DomainError
+	switch conflict, ok := conflictError(err); {
+	case ok:
+		return conflict
+	case errors.As(err, &domainErr):
+		return domainErr
+	case errors.Is(err, sql.ErrNoRows):
+		return NotFoundError.Wrap(err)
+	default:
+		return InternalError.Wrap(err)
+	}
+}
+
+/*
+writeProblem reports err to the client as an application/problem+json document. This is the only place where errors
+are translated into HTTP status codes. The cause of server errors is logged with the logger of r, but never included
+in the response.
+*/
+func writeProblem(w http.ResponseWriter, r *http.Request, err error) error {
+	domainErr := asDomainError(err)
+	if domainErr.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "error", err, "code", domainErr.Code)
+		trace.SpanFromContext(r.Context()).RecordError(err)
+	}
+
+	body, err := json.Marshal(problem{
+		Type:     "/problems/" + string(domainErr.Code),
+		Title:    domainErr.Title,
+		Status:   domainErr.Status,
+		Detail:   domainErr.Detail,
+		Instance: r.URL.Path,
+		Code:     domainErr.Code,
+		Errors:   domainErr.Fields,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", MarhalResponseBodyError, err)
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(domainErr.Status)
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("%w: %w", ResponseFailureError, err)
+	}
+	return nil
+}
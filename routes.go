@@ -2,62 +2,123 @@ package main
 
 import (
 	"github.com/benkoben/unsubtle-core/frontend"
-	"github.com/benkoben/unsubtle-core/internal/database"
 	"net/http"
 )
 
-// addRoutes accepts a pointer to a mux together all possible dependencies that we can think of using when defining the routes
+// routeRegistrar is implemented by *http.ServeMux. Tests use it to record which routes are registered.
+type routeRegistrar interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// addRoutes accepts a mux together all possible dependencies that we can think of using when defining the routes.
+// Every route must be documented in apiOperations as well.
 func addRoutes(
-	mux *http.ServeMux,
+	mux routeRegistrar,
 	config *Config,
-	dbStore *database.Queries,
+	dbStore dbQuerier,
+	health *health,
+	limits *rateLimits,
 	// --- More different stores can be added below if necessary
 ) {
-	// Serve static HTML files
-	mux.Handle("GET /", frontend.HandleIndex())
-	mux.Handle("GET /dashboard", frontend.HandleDashboard())
-	mux.Handle("GET /static/", frontend.HandleStatic())
+	// authenticated requires a valid JWT and limits the requests of the user, or of the client IP when the JWT is not
+	// valid
+	authenticated := func(next http.Handler) http.Handler {
+		return limits.unauthenticated(authenticate(limits.api(next), config.JWTSecret), config.JWTSecret)
+	}
+
+	// Serve the frontend, embedded in the binary unless a dev directory is configured
+	assets := frontend.NewAssets(config.Frontend.DevDir)
+	mux.Handle("GET /", assets.HandleIndex())
+	mux.Handle("GET /dashboard", assets.HandleDashboard())
+	mux.Handle("GET /static/", assets.HandleStatic())
+
+	// HTMX fragments of the dashboard panels and their forms
+	mux.Handle("GET /ui/subscriptions", authenticated(handleSubscriptionsFragment(dbStore, assets)))
+	mux.Handle("GET /ui/subscriptions/new", authenticated(handleSubscriptionFormFragment(dbStore, assets)))
+	mux.Handle("GET /ui/subscriptions/{id}/edit", authenticated(handleSubscriptionFormFragment(dbStore, assets)))
+	mux.Handle("POST /ui/subscriptions", authenticated(idempotent(handleSubmitSubscriptionForm(dbStore, assets), dbStore)))
+	mux.Handle("PUT /ui/subscriptions/{id}", authenticated(handleSubmitSubscriptionForm(dbStore, assets)))
+	mux.Handle("GET /ui/categories", authenticated(handleCategoriesFragment(dbStore, assets)))
+	mux.Handle("GET /ui/categories/new", authenticated(handleCategoryFormFragment(dbStore, assets)))
+	mux.Handle("GET /ui/categories/{id}/edit", authenticated(handleCategoryFormFragment(dbStore, assets)))
+	mux.Handle("POST /ui/categories", authenticated(idempotent(handleSubmitCategoryForm(dbStore, assets), dbStore)))
+	mux.Handle("PUT /ui/categories/{id}", authenticated(handleSubmitCategoryForm(dbStore, assets)))
+	mux.Handle("GET /ui/cards", authenticated(handleCardsFragment(dbStore, assets)))
+	mux.Handle("GET /ui/cards/new", authenticated(handleCardFormFragment(dbStore, assets)))
+	mux.Handle("GET /ui/cards/{id}/edit", authenticated(handleCardFormFragment(dbStore, assets)))
+	mux.Handle("POST /ui/cards", authenticated(idempotent(handleSubmitCardForm(dbStore, assets), dbStore)))
+	mux.Handle("PUT /ui/cards/{id}", authenticated(handleSubmitCardForm(dbStore, assets)))
+
+	// SVG charts of the dashboard
+	mux.Handle("GET /dashboard/charts/spend.svg", authenticated(handleSpendChart(dbStore, assets)))
+	mux.Handle("GET /dashboard/charts/categories.svg", authenticated(handleCategoryChart(dbStore, assets)))
+	mux.Handle("GET /dashboard/charts/cards.svg", authenticated(handleCardChart(dbStore, assets)))
+
+	// Probes of load balancers and orchestrators
+	mux.Handle("GET /healthz", handleHealthz())
+	mux.Handle("GET /readyz", handleReadyz(health))
+	mux.Handle("GET /version", handleVersion())
+
+	// The OpenAPI document describing the routes below
+	mux.Handle("GET /openapi.json", handleOpenAPI())
 
 	// API requests are defined below
 	//
 	// -- Authentication handlers
-	mux.Handle("POST /login", handleLoginForm(dbStore, config))
-	mux.Handle("POST /register", handleRegisterForm(dbStore))
-	mux.Handle("POST /refresh", authenticate(handleRefresh(dbStore, config), config.JWTSecret))
-	mux.Handle("POST /revoke", authenticate(handleRevoke(dbStore, config), config.JWTSecret))
+	//
+	// POST handlers are wrapped with idempotent() so that clients can safely retry them using an Idempotency-Key header,
+	// except for the ones below: their requests carry passwords and their responses carry tokens, neither of which may
+	// be stored with an idempotency key. Logging in, registering and refreshing are limited more strictly than the rest
+	// of the API, against guessing passwords.
+	mux.Handle("POST /login", limits.auth(handleLoginForm(dbStore, config, assets)))
+	mux.Handle("POST /register", limits.auth(handleRegisterForm(dbStore, config, assets)))
+	mux.Handle("POST /refresh", limits.auth(handleRefresh(dbStore, config)))
+	mux.Handle("POST /revoke", authenticated(handleRevoke(dbStore, config)))
+	// JSON counterparts of the form handlers above, used by API clients
+	mux.Handle("POST /api/login", limits.auth(handleLogin(dbStore, config)))
+	mux.Handle("POST /api/register", limits.auth(handleCreateUser(dbStore, config)))
 
 	// -- Users
-	// TODO: Authorization (These handlers should only be available to admin users)
-	mux.Handle("GET /api/users", authenticate(handleListUsers(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/users/{id}", authenticate(handleGetUser(dbStore), config.JWTSecret))
-	mux.Handle("PUT /api/users/{id}", authenticate(handleUpdateUser(dbStore), config.JWTSecret))
-	mux.Handle("DELETE /api/users/{id}", authenticate(handleDeleteUser(dbStore), config.JWTSecret))
+	// Only admins manage accounts, see the create-admin command
+	mux.Handle("GET /api/users", authenticated(requireAdmin(handleListUsers(dbStore), dbStore)))
+	mux.Handle("GET /api/users/{id}", authenticated(requireAdmin(handleGetUser(dbStore), dbStore)))
+	mux.Handle("PUT /api/users/{id}", authenticated(requireAdmin(handleUpdateUser(dbStore, config), dbStore)))
+	mux.Handle("DELETE /api/users/{id}", authenticated(requireAdmin(handleDeleteUser(dbStore), dbStore)))
 
 	// -- Categories
-	mux.Handle("POST /api/categories", authenticate(handleCreateCategory(dbStore), config.JWTSecret))
-	mux.Handle("PUT /api/categories/{id}", authenticate(handleUpdateCategory(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/categories", authenticate(handleListCategory(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/categories/{id}", authenticate(handleGetCategory(dbStore), config.JWTSecret))
-	mux.Handle("DELETE /api/categories/{id}", authenticate(handleDeleteCategory(dbStore), config.JWTSecret))
+	mux.Handle("POST /api/categories", authenticated(idempotent(handleCreateCategory(dbStore), dbStore)))
+	mux.Handle("PUT /api/categories/{id}", authenticated(handleUpdateCategory(dbStore)))
+	mux.Handle("PATCH /api/categories/{id}", authenticated(handlePatchCategory(dbStore)))
+	mux.Handle("GET /api/categories", authenticated(handleListCategory(dbStore)))
+	mux.Handle("GET /api/categories/{id}", authenticated(handleGetCategory(dbStore)))
+	mux.Handle("DELETE /api/categories/{id}", authenticated(handleDeleteCategory(dbStore)))
 
 	// -- Subscriptions
-	mux.Handle("POST /api/subscriptions", authenticate(handleCreateSubscription(dbStore), config.JWTSecret))
-	mux.Handle("PUT /api/subscriptions/{id}", authenticate(handleUpdateSubscription(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/subscriptions", authenticate(handleListSubscription(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/subscriptions/{id}", authenticate(handleGetSubscription(dbStore), config.JWTSecret))
-	mux.Handle("DELETE /api/subscriptions/{id}", authenticate(handleDeleteSubscription(dbStore), config.JWTSecret))
+	mux.Handle("POST /api/subscriptions", authenticated(idempotent(handleCreateSubscription(dbStore), dbStore)))
+	mux.Handle("PUT /api/subscriptions/{id}", authenticated(handleUpdateSubscription(dbStore)))
+	mux.Handle("PATCH /api/subscriptions/{id}", authenticated(handlePatchSubscription(dbStore)))
+	mux.Handle("GET /api/subscriptions", authenticated(handleListSubscription(dbStore)))
+	mux.Handle("GET /api/subscriptions/{id}", authenticated(handleGetSubscription(dbStore)))
+	mux.Handle("DELETE /api/subscriptions/{id}", authenticated(handleDeleteSubscription(dbStore)))
 
 	// -- Cards
-	mux.Handle("POST /api/cards", authenticate(handleCreateCard(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/cards/{id}", authenticate(handleGetCard(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/cards", authenticate(handleListCards(dbStore), config.JWTSecret))
-	mux.Handle("PUT /api/cards/{id}", authenticate(handleUpdateCard(dbStore), config.JWTSecret))
-	mux.Handle("DELETE /api/cards/{id}", authenticate(handleDeleteCard(dbStore), config.JWTSecret))
+	mux.Handle("POST /api/cards", authenticated(idempotent(handleCreateCard(dbStore), dbStore)))
+	mux.Handle("GET /api/cards/{id}", authenticated(handleGetCard(dbStore)))
+	mux.Handle("GET /api/cards", authenticated(handleListCards(dbStore)))
+	mux.Handle("PUT /api/cards/{id}", authenticated(handleUpdateCard(dbStore)))
+	mux.Handle("PATCH /api/cards/{id}", authenticated(handlePatchCard(dbStore)))
+	mux.Handle("DELETE /api/cards/{id}", authenticated(handleDeleteCard(dbStore)))
 
 	// -- ActiveSubscriptions
-	mux.Handle("GET /api/activesubscriptions/{id}", authenticate(handleGetActiveSubscription(dbStore), config.JWTSecret))
-	mux.Handle("GET /api/activesubscriptions", authenticate(handleListActiveSubscription(dbStore), config.JWTSecret))
-	mux.Handle("PUT /api/activesubscriptions/{id}", authenticate(handleUpdateActiveSubscription(dbStore), config.JWTSecret))
+	mux.Handle("POST /api/activesubscriptions", authenticated(idempotent(handleCreateActiveSubscription(dbStore), dbStore)))
+	mux.Handle("GET /api/activesubscriptions/{id}", authenticated(handleGetActiveSubscription(dbStore)))
+	mux.Handle("GET /api/activesubscriptions", authenticated(handleListActiveSubscription(dbStore)))
+	mux.Handle("PUT /api/activesubscriptions/{id}", authenticated(handleUpdateActiveSubscription(dbStore)))
+	mux.Handle("PATCH /api/activesubscriptions/{id}", authenticated(handlePatchActiveSubscription(dbStore)))
+	mux.Handle("DELETE /api/activesubscriptions/{id}", authenticated(handleDeleteActiveSubscription(dbStore)))
 
 	// -- ActiveTrails
+
+	// -- Search
+	mux.Handle("GET /api/search", authenticated(handleSearch(dbStore)))
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/auth"
+	"github.com/benkoben/unsubtle-core/pkg/unsubtle"
+)
+
+var _ dbQuerier = (*memoryStore)(nil)
+
+const sdkTestSecret = "sdk-test-secret"
+
+// newSDKServer starts the server on top of store and returns its URL.
+func newSDKServer(t *testing.T, store *memoryStore) string {
+	t.Helper()
+
+	config := newTestConfig()
+	config.JWTSecret = sdkTestSecret
+	server := httptest.NewServer(NewServerHandler(config, store, newHealth()))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// newSDKClient returns an SDK client for the server at url that is registered and logged in.
+func newSDKClient(t *testing.T, url string, opts ...unsubtle.Option) *unsubtle.Client {
+	t.Helper()
+
+	client, err := unsubtle.New(url, opts...)
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+
+	ctx := context.Background()
+	if _, err := client.Register(ctx, "sdk@example.com", "Syp9393-Syp9292-Syp9191"); err != nil {
+		t.Fatalf("registering: %s", err)
+	}
+	if _, err := client.Login(ctx, "sdk@example.com", "Syp9393-Syp9292-Syp9191"); err != nil {
+		t.Fatalf("logging in: %s", err)
+	}
+	return client
+}
+
+func TestSDK(t *testing.T) {
+	ctx := context.Background()
+
+	store := newMemoryStore()
+	url := newSDKServer(t, store)
+	client := newSDKClient(t, url)
+
+	t.Run("it manages subscriptions end to end", func(t *testing.T) {
+		description := "Streaming"
+		created, err := client.CreateSubscription(ctx, unsubtle.SubscriptionRequest{Name: "Netflix", MonthlyCost: 129, Currency: "SEK", Description: &description})
+		if err != nil {
+			t.Fatalf("creating subscription: %s", err)
+		}
+
+		updated, err := client.UpdateSubscription(ctx, created.ID, created.Version, unsubtle.SubscriptionRequest{Name: "Netflix", MonthlyCost: 149, Currency: "SEK", Description: &description})
+		if err != nil {
+			t.Fatalf("updating subscription: %s", err)
+		}
+		if updated.MonthlyCost != 149 || updated.Version != created.Version+1 {
+			t.Errorf("expected cost 149 at version %d, got %d at version %d", created.Version+1, updated.MonthlyCost, updated.Version)
+		}
+
+		patched, err := client.PatchSubscription(ctx, created.ID, updated.Version, unsubtle.Patch{"monthly_cost": 99})
+		if err != nil {
+			t.Fatalf("patching subscription: %s", err)
+		}
+		if patched.MonthlyCost != 99 || patched.Name != "Netflix" || patched.Description.String != "Streaming" {
+			t.Errorf("expected patch to only change the cost, got %+v", patched)
+		}
+
+		subscriptions, err := client.ListSubscriptions(ctx)
+		if err != nil {
+			t.Fatalf("listing subscriptions: %s", err)
+		}
+		if len(subscriptions) != 1 {
+			t.Errorf("expected 1 subscription, got %d", len(subscriptions))
+		}
+
+		if err := client.DeleteSubscription(ctx, created.ID, patched.Version); err != nil {
+			t.Fatalf("deleting subscription: %s", err)
+		}
+		if _, err := client.GetSubscription(ctx, created.ID); !unsubtle.IsCode(err, unsubtle.CodeNotFound) {
+			t.Errorf("expected %s after delete, got %v", unsubtle.CodeNotFound, err)
+		}
+	})
+
+	t.Run("it returns problems as typed errors", func(t *testing.T) {
+		created, err := client.CreateCategory(ctx, unsubtle.CategoryRequest{Name: "Streaming", Description: "Video"})
+		if err != nil {
+			t.Fatalf("creating category: %s", err)
+		}
+
+		_, err = client.UpdateCategory(ctx, created.ID, created.Version+1, unsubtle.CategoryRequest{Name: "Music", Description: "Audio"})
+		if !unsubtle.IsCode(err, unsubtle.CodePreconditionFailed) {
+			t.Errorf("expected %s for a stale version, got %v", unsubtle.CodePreconditionFailed, err)
+		}
+
+		_, err = client.UpdateCategory(ctx, created.ID, created.Version, unsubtle.CategoryRequest{})
+		apiErr, ok := err.(*unsubtle.APIError)
+		if !ok || apiErr.Code != unsubtle.CodeValidationFailed || len(apiErr.Errors) == 0 {
+			t.Errorf("expected a %s error with field errors, got %v", unsubtle.CodeValidationFailed, err)
+		}
+
+		if _, err := client.Login(ctx, "sdk@example.com", "wrong"); !unsubtle.IsCode(err, unsubtle.CodeInvalidCredentials) {
+			t.Errorf("expected %s for a wrong password, got %v", unsubtle.CodeInvalidCredentials, err)
+		}
+	})
+
+	t.Run("it renews a token that is about to expire", func(t *testing.T) {
+		user, err := store.GetUserByEmail(ctx, "sdk@example.com")
+		if err != nil {
+			t.Fatalf("looking up user: %s", err)
+		}
+		expiring, err := auth.MakeJWT(user.ID, sdkTestSecret, 30*time.Second)
+		if err != nil {
+			t.Fatalf("creating jwt: %s", err)
+		}
+
+		var renewed []unsubtle.Tokens
+		renewing, err := unsubtle.New(url,
+			unsubtle.WithTokens(unsubtle.Tokens{Token: expiring, RefreshToken: client.Tokens().RefreshToken}),
+			unsubtle.WithTokenListener(func(tokens unsubtle.Tokens) { renewed = append(renewed, tokens) }),
+		)
+		if err != nil {
+			t.Fatalf("creating client: %s", err)
+		}
+
+		if _, err := renewing.ListCards(ctx); err != nil {
+			t.Fatalf("listing cards: %s", err)
+		}
+		if renewing.Tokens().Token == expiring {
+			t.Error("expected the expiring token to be renewed")
+		}
+		if len(renewed) != 1 || renewed[0] != renewing.Tokens() {
+			t.Errorf("expected the listener to receive the renewed tokens once, got %d calls", len(renewed))
+		}
+	})
+
+	t.Run("it renews a token that has already expired", func(t *testing.T) {
+		user, err := store.GetUserByEmail(ctx, "sdk@example.com")
+		if err != nil {
+			t.Fatalf("looking up user: %s", err)
+		}
+		expired, err := auth.MakeJWT(user.ID, sdkTestSecret, -time.Minute)
+		if err != nil {
+			t.Fatalf("creating jwt: %s", err)
+		}
+
+		renewing, err := unsubtle.New(url, unsubtle.WithTokens(unsubtle.Tokens{Token: expired, RefreshToken: client.Tokens().RefreshToken}))
+		if err != nil {
+			t.Fatalf("creating client: %s", err)
+		}
+		if _, err := renewing.ListCards(ctx); err != nil {
+			t.Fatalf("listing cards: %s", err)
+		}
+		if renewing.Tokens().Token == expired {
+			t.Error("expected the expired token to be renewed")
+		}
+	})
+
+	t.Run("it renews a token the server rejects", func(t *testing.T) {
+		user, err := store.GetUserByEmail(ctx, "sdk@example.com")
+		if err != nil {
+			t.Fatalf("looking up user: %s", err)
+		}
+		rejected, err := auth.MakeJWT(user.ID, "another secret", time.Hour)
+		if err != nil {
+			t.Fatalf("creating jwt: %s", err)
+		}
+
+		renewing, err := unsubtle.New(url, unsubtle.WithTokens(unsubtle.Tokens{Token: rejected, RefreshToken: client.Tokens().RefreshToken}))
+		if err != nil {
+			t.Fatalf("creating client: %s", err)
+		}
+		if _, err := renewing.ListCards(ctx); err != nil {
+			t.Fatalf("listing cards: %s", err)
+		}
+		if renewing.Tokens().Token == rejected {
+			t.Error("expected the rejected token to be renewed")
+		}
+
+		stale, err := unsubtle.New(url, unsubtle.WithTokens(unsubtle.Tokens{Token: rejected, RefreshToken: "unknown"}))
+		if err != nil {
+			t.Fatalf("creating client: %s", err)
+		}
+		if _, err := stale.ListCards(ctx); !unsubtle.IsCode(err, unsubtle.CodeUnauthenticated) {
+			t.Errorf("expected %s for an unknown refresh token, got %v", unsubtle.CodeUnauthenticated, err)
+		}
+	})
+
+	t.Run("it requires authentication", func(t *testing.T) {
+		anonymous, err := unsubtle.New(url)
+		if err != nil {
+			t.Fatalf("creating client: %s", err)
+		}
+		if _, err := anonymous.ListCards(ctx); err != unsubtle.ErrNotAuthenticated {
+			t.Errorf("expected %v, got %v", unsubtle.ErrNotAuthenticated, err)
+		}
+	})
+}
@@ -1,24 +1,46 @@
 package main
 
 import (
-	"github.com/benkoben/unsubtle-core/internal/database"
+	"log/slog"
 	"net/http"
 )
 
 func NewServerHandler(
 	config *Config,
-	dbStore *database.Queries,
+	dbStore dbQuerier,
+	health *health,
 ) http.Handler {
 	// Prepare the mux
 	mux := http.NewServeMux()
 
 	// Add routes to the mux, provide the necessary dependencies
-	addRoutes(mux, config, dbStore)
+	addRoutes(mux, config, dbStore, health, newRateLimits(config.RateLimit, dbStore))
+
+	// The metrics are not part of the API, without an admin listener they are served here behind their token
+	if config.Admin.Address == "" && config.Admin.MetricsToken != "" {
+		mux.Handle("GET /metrics", requireMetricsToken(handleMetrics(dbStore), config.Admin.MetricsToken))
+	}
 
 	var handler http.Handler = mux
 	// The NewServer constructor is responsible for all the top-level HTTP stuff that applies to all endpoints, like CORS, auth middleware, and logging
-	// TODO: Implement global middleware here
-	// handler = logging.Middleware(logger, handler)
-	// handler = checkAuthHeaders(handler)
+	// Middleware wraps the handler inside out, the last one added runs first
+	handler = limitRequestBodies(handler, int64(config.Service.MaxBodyBytes))
+	handler = handleCORS(handler, config.CORS)
+	handler = secureHeaders(handler)
+	handler = instrumentRequests(handler)
+	handler = logRequests(handler)
+	handler = traceRequests(handler)
+	handler = withRequestId(slog.Default(), handler)
 	return handler
 }
+
+// NewAdminHandler serves the operational endpoints on the admin listener, which is meant to be reachable by the
+// monitoring only.
+func NewAdminHandler(
+	config *Config,
+	dbStore dbQuerier,
+) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", requireMetricsToken(handleMetrics(dbStore), config.Admin.MetricsToken))
+	return withRequestId(slog.Default(), mux)
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+)
+
+// -- Services
+//
+// The services hold the business rules of the resources: who may read and change them, what makes a request valid
+// and which queries make up an operation. The JSON handlers and the HTMX fragments only translate between HTTP and
+// the services, so both follow the same rules. Operations that read before they write run as a unit of work with
+// dbQuerier.WithTx, and every error a service returns is a DomainError or is reported as InternalError. Names are
+// kept unique by the constraints of the database rather than by the services, asDomainError reports a violation as a
+// conflict.
+
+// change derives the new state of a resource from its current state, see replace and patchWith.
+type change[E, R any] func(existing E) (R, error)
+
+// replace replaces the resource with req, as PUT does.
+func replace[E, R any](req R) change[E, R] {
+	return func(E) (R, error) {
+		return req, nil
+	}
+}
+
+// patchWith applies a JSON Merge Patch to the resource, as PATCH does. from converts the resource into the request
+// document the patch is applied to.
+func patchWith[E, R any](patch []byte, from func(E) R) change[E, R] {
+	return func(existing E) (R, error) {
+		req, err := applyMergePatch(from(existing), patch)
+		if err != nil {
+			return req, InvalidRequestError.WithDetail("%v", err)
+		}
+		return req, nil
+	}
+}
+
+// lookupError maps the error of reading a resource, a missing row is reported as NotFoundError.
+func lookupError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return NotFoundError
+	}
+	return InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err))
+}
+
+// updateError maps the error of a conditional UPDATE. No row means that it no longer matches the expected version,
+// the resource was modified after it was read.
+func updateError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return PreconditionFailedError
+	}
+	if err != nil {
+		return InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err))
+	}
+	return nil
+}
+
+// deleteError maps the outcome of a conditional DELETE, where no deleted row means that the resource was modified
+// after it was read.
+func deleteError(result sql.Result, err error) error {
+	if err != nil {
+		return lookupError(err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return PreconditionFailedError
+	}
+	return nil
+}
+
+// -- Cards
+
+// CardService manages the payment cards of users.
+type CardService struct {
+	db dbQuerier
+}
+
+func newCardService(db dbQuerier) *CardService {
+	return &CardService{db: db}
+}
+
+// ownedCard reads the card id, which must be owned by userId.
+func ownedCard(ctx context.Context, db dbQuerier, userId, id uuid.UUID) (database.Card, error) {
+	card, err := db.GetCard(ctx, id)
+	if err != nil {
+		return card, lookupError(err)
+	}
+	if card.Owner != userId {
+		return card, ForbiddenError
+	}
+	return card, nil
+}
+
+func (s *CardService) List(ctx context.Context, userId uuid.UUID) ([]database.Card, error) {
+	cards, err := s.db.ListCardsForOwner(ctx, userId)
+	if err != nil {
+		return nil, InternalError.Wrap(err)
+	}
+	return cards, nil
+}
+
+func (s *CardService) Get(ctx context.Context, userId, id uuid.UUID) (database.Card, error) {
+	return ownedCard(ctx, s.db, userId, id)
+}
+
+// Create registers a card for userId, the names of the cards of a user are unique.
+func (s *CardService) Create(ctx context.Context, userId uuid.UUID, req cardRequest) (database.CreateCardRow, error) {
+	if err := req.validate(); err != nil {
+		return database.CreateCardRow{}, err
+	}
+	card, err := s.db.CreateCard(ctx, database.CreateCardParams{
+		Name:      req.Name,
+		ExpiresAt: req.ExpiresAt,
+		Owner:     userId,
+	})
+	if err != nil {
+		return card, InternalError.Wrap(fmt.Errorf("error creating card: %w", err))
+	}
+	return card, nil
+}
+
+// Update applies change to the card id of userId once check accepts its version. The changed card is validated before
+// it is saved.
+func (s *CardService) Update(ctx context.Context, userId, id uuid.UUID, check versionCheck, change change[database.Card, cardRequest]) (database.Card, error) {
+	var card database.Card
+	err := s.db.WithTx(ctx, func(db dbQuerier) error {
+		existing, err := ownedCard(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(existing.Version)
+		if err != nil {
+			return err
+		}
+		req, err := change(existing)
+		if err != nil {
+			return err
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		card, err = db.UpdateCard(ctx, database.UpdateCardParams{
+			ID:        id,
+			Name:      req.Name,
+			ExpiresAt: req.ExpiresAt,
+			UpdatedAt: time.Now(),
+			Version:   version,
+		})
+		return updateError(err)
+	})
+	return card, err
+}
+
+// Delete deletes the card id of userId once check accepts its version. The active subscriptions paid with the card
+// are deleted with it.
+func (s *CardService) Delete(ctx context.Context, userId, id uuid.UUID, check versionCheck) error {
+	return s.db.WithTx(ctx, func(db dbQuerier) error {
+		card, err := ownedCard(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(card.Version)
+		if err != nil {
+			return err
+		}
+		return deleteError(db.DeleteCard(ctx, database.DeleteCardParams{ID: id, Version: version}))
+	})
+}
+
+// -- Categories
+
+// CategoryService manages the categories users sort their subscriptions into.
+type CategoryService struct {
+	db dbQuerier
+}
+
+func newCategoryService(db dbQuerier) *CategoryService {
+	return &CategoryService{db: db}
+}
+
+// ownedCategory reads the category id, which must be created by userId.
+func ownedCategory(ctx context.Context, db dbQuerier, userId, id uuid.UUID) (database.Category, error) {
+	category, err := db.GetCategory(ctx, id)
+	if err != nil {
+		return category, lookupError(err)
+	}
+	if category.CreatedBy != userId {
+		return category, ForbiddenError
+	}
+	return category, nil
+}
+
+func (s *CategoryService) List(ctx context.Context, userId uuid.UUID) ([]database.Category, error) {
+	categories, err := s.db.ListCategoriesForUserId(ctx, userId)
+	if err != nil {
+		return nil, InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err))
+	}
+	return categories, nil
+}
+
+func (s *CategoryService) Get(ctx context.Context, userId, id uuid.UUID) (database.Category, error) {
+	return ownedCategory(ctx, s.db, userId, id)
+}
+
+// Create creates a category for userId, the names of the categories of a user are unique.
+func (s *CategoryService) Create(ctx context.Context, userId uuid.UUID, req categoryRequest) (database.Category, error) {
+	if err := req.validate(); err != nil {
+		return database.Category{}, err
+	}
+	category, err := s.db.CreateCategory(ctx, database.CreateCategoryParams{
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedBy:   userId,
+	})
+	if err != nil {
+		return category, InternalError.Wrap(err)
+	}
+	return category, nil
+}
+
+// Update applies change to the category id of userId once check accepts its version. The changed category is
+// validated before it is saved.
+func (s *CategoryService) Update(ctx context.Context, userId, id uuid.UUID, check versionCheck, change change[database.Category, categoryRequest]) (database.Category, error) {
+	var category database.Category
+	err := s.db.WithTx(ctx, func(db dbQuerier) error {
+		existing, err := ownedCategory(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(existing.Version)
+		if err != nil {
+			return err
+		}
+		req, err := change(existing)
+		if err != nil {
+			return err
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		category, err = db.UpdateCategory(ctx, database.UpdateCategoryParams{
+			ID:          id,
+			Name:        req.Name,
+			Description: req.Description,
+			Version:     version,
+		})
+		return updateError(err)
+	})
+	return category, err
+}
+
+// Delete deletes the category id of userId once check accepts its version. The subscriptions in the category are
+// deleted with it.
+func (s *CategoryService) Delete(ctx context.Context, userId, id uuid.UUID, check versionCheck) error {
+	return s.db.WithTx(ctx, func(db dbQuerier) error {
+		category, err := ownedCategory(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(category.Version)
+		if err != nil {
+			return err
+		}
+		return deleteError(db.DeleteCategory(ctx, database.DeleteCategoryParams{ID: id, Version: version}))
+	})
+}
+
+// -- Subscriptions
+
+// SubscriptionService manages the subscriptions users keep track of.
+type SubscriptionService struct {
+	db dbQuerier
+}
+
+func newSubscriptionService(db dbQuerier) *SubscriptionService {
+	return &SubscriptionService{db: db}
+}
+
+// ownedSubscription reads the subscription id, which must be created by userId.
+func ownedSubscription(ctx context.Context, db dbQuerier, userId, id uuid.UUID) (database.Subscription, error) {
+	subscription, err := db.GetSubscription(ctx, id)
+	if err != nil {
+		return subscription, lookupError(err)
+	}
+	if subscription.CreatedBy != userId {
+		return subscription, ForbiddenError
+	}
+	return subscription, nil
+}
+
+func (s *SubscriptionService) List(ctx context.Context, userId uuid.UUID) ([]database.Subscription, error) {
+	subscriptions, err := s.db.ListSubscriptionsForUserId(ctx, userId)
+	if err != nil {
+		return nil, InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err))
+	}
+	return subscriptions, nil
+}
+
+func (s *SubscriptionService) Get(ctx context.Context, userId, id uuid.UUID) (database.Subscription, error) {
+	return ownedSubscription(ctx, s.db, userId, id)
+}
+
+// Create registers a subscription for userId, the names of the subscriptions of a user are unique.
+func (s *SubscriptionService) Create(ctx context.Context, userId uuid.UUID, req subscriptionRequest) (database.Subscription, error) {
+	if err := req.validate(); err != nil {
+		return database.Subscription{}, err
+	}
+	subscription, err := s.db.CreateSubscription(ctx, database.CreateSubscriptionParams{
+		CreatedBy:      userId,
+		Name:           req.Name,
+		MonthlyCost:    req.MonthlyCost,
+		Currency:       req.Currency,
+		Description:    req.Description.NullString,
+		UnsubscribeUrl: req.UnsubscribeUrl.NullString,
+		CategoryID:     req.CategoryId,
+	})
+	if err != nil {
+		return subscription, InternalError.Wrap(fmt.Errorf("error creating subscription: %w", err))
+	}
+	return subscription, nil
+}
+
+// Update applies change to the subscription id of userId once check accepts its version. The changed subscription is
+// validated before it is saved.
+func (s *SubscriptionService) Update(ctx context.Context, userId, id uuid.UUID, check versionCheck, change change[database.Subscription, subscriptionRequest]) (database.Subscription, error) {
+	var subscription database.Subscription
+	err := s.db.WithTx(ctx, func(db dbQuerier) error {
+		existing, err := ownedSubscription(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(existing.Version)
+		if err != nil {
+			return err
+		}
+		req, err := change(existing)
+		if err != nil {
+			return err
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		subscription, err = db.UpdateSubscription(ctx, database.UpdateSubscriptionParams{
+			ID:             id,
+			Name:           req.Name,
+			MonthlyCost:    req.MonthlyCost,
+			Currency:       req.Currency,
+			UnsubscribeUrl: req.UnsubscribeUrl.NullString,
+			Description:    req.Description.NullString,
+			CategoryID:     req.CategoryId,
+			Version:        version,
+		})
+		return updateError(err)
+	})
+	return subscription, err
+}
+
+// Delete deletes the subscription id of userId once check accepts its version. Its activations are deleted with it.
+func (s *SubscriptionService) Delete(ctx context.Context, userId, id uuid.UUID, check versionCheck) error {
+	return s.db.WithTx(ctx, func(db dbQuerier) error {
+		subscription, err := ownedSubscription(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(subscription.Version)
+		if err != nil {
+			return err
+		}
+		return deleteError(db.DeleteSubscription(ctx, database.DeleteSubscriptionParams{ID: id, Version: version}))
+	})
+}
+
+// -- Active subscriptions
+
+// ActiveSubscriptionService manages the subscriptions users pay for, and with which card.
+type ActiveSubscriptionService struct {
+	db dbQuerier
+}
+
+func newActiveSubscriptionService(db dbQuerier) *ActiveSubscriptionService {
+	return &ActiveSubscriptionService{db: db}
+}
+
+// ownedActiveSubscription reads the active subscription id, which must belong to userId.
+func ownedActiveSubscription(ctx context.Context, db dbQuerier, userId, id uuid.UUID) (database.ActiveSubscription, error) {
+	activeSubscription, err := db.GetActiveSubscriptionById(ctx, id)
+	if err != nil {
+		return activeSubscription, lookupError(err)
+	}
+	if activeSubscription.UserID != userId {
+		return activeSubscription, ForbiddenError
+	}
+	return activeSubscription, nil
+}
+
+func (s *ActiveSubscriptionService) List(ctx context.Context, userId uuid.UUID) ([]database.ActiveSubscription, error) {
+	activeSubscriptions, err := s.db.ListActiveSubscriptionByUserId(ctx, userId)
+	if err != nil {
+		return nil, InternalError.Wrap(fmt.Errorf("%w: %w", UnexpectedDbError, err))
+	}
+	return activeSubscriptions, nil
+}
+
+func (s *ActiveSubscriptionService) Get(ctx context.Context, userId, id uuid.UUID) (database.ActiveSubscription, error) {
+	return ownedActiveSubscription(ctx, s.db, userId, id)
+}
+
+/*
+Create activates a subscription of userId, paid with a card of userId. A subscription is activated at most once per
+user.
+*/
+func (s *ActiveSubscriptionService) Create(ctx context.Context, userId uuid.UUID, req activeSubscriptionRequest) (database.ActiveSubscription, error) {
+	if err := req.validate(); err != nil {
+		return database.ActiveSubscription{}, err
+	}
+	var activeSubscription database.ActiveSubscription
+	err := s.db.WithTx(ctx, func(db dbQuerier) error {
+		if _, err := ownedSubscription(ctx, db, userId, req.SubscriptionID); err != nil {
+			return err
+		}
+		if _, err := ownedCard(ctx, db, userId, req.CardID); err != nil {
+			return err
+		}
+
+		var err error
+		activeSubscription, err = db.CreateActiveSubscription(ctx, database.CreateActiveSubscriptionParams{
+			SubscriptionID:   req.SubscriptionID,
+			UserID:           userId,
+			CardID:           req.CardID,
+			UpdatedAt:        time.Now(),
+			BillingFrequency: req.BillingFrequency,
+			AutoRenewEnabled: req.AutoRenewEnabled,
+		})
+		if err != nil {
+			return InternalError.Wrap(err)
+		}
+		return nil
+	})
+	return activeSubscription, err
+}
+
+// Update applies change to the active subscription id of userId once check accepts its version. The changed active
+// subscription is validated before it is saved.
+func (s *ActiveSubscriptionService) Update(ctx context.Context, userId, id uuid.UUID, check versionCheck, change change[database.ActiveSubscription, activeSubscriptionUpdateRequest]) (database.ActiveSubscription, error) {
+	var activeSubscription database.ActiveSubscription
+	err := s.db.WithTx(ctx, func(db dbQuerier) error {
+		existing, err := ownedActiveSubscription(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(existing.Version)
+		if err != nil {
+			return err
+		}
+		req, err := change(existing)
+		if err != nil {
+			return err
+		}
+		if err := req.validate(); err != nil {
+			return err
+		}
+
+		activeSubscription, err = db.UpdateActiveSubscription(ctx, database.UpdateActiveSubscriptionParams{
+			ID:               id,
+			BillingFrequency: req.BillingFrequency,
+			AutoRenewEnabled: req.autoRenew(),
+			Version:          version,
+		})
+		return updateError(err)
+	})
+	return activeSubscription, err
+}
+
+// Delete deactivates the active subscription id of userId once check accepts its version.
+func (s *ActiveSubscriptionService) Delete(ctx context.Context, userId, id uuid.UUID, check versionCheck) error {
+	return s.db.WithTx(ctx, func(db dbQuerier) error {
+		activeSubscription, err := ownedActiveSubscription(ctx, db, userId, id)
+		if err != nil {
+			return err
+		}
+		version, err := check(activeSubscription.Version)
+		if err != nil {
+			return err
+		}
+		return deleteError(db.DeleteActiveSubscription(ctx, database.DeleteActiveSubscriptionParams{ID: id, Version: version}))
+	})
+}
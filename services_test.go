@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// txCounter counts the transactions run against the store it wraps.
+type txCounter struct {
+	*memoryStore
+	transactions int
+}
+
+func (c *txCounter) WithTx(ctx context.Context, fn func(dbQuerier) error) error {
+	c.transactions++
+	return c.memoryStore.WithTx(ctx, fn)
+}
+
+// assertDomainError fails t unless err is reported to clients as want.
+func assertDomainError(t *testing.T, err error, want *DomainError) {
+	t.Helper()
+	if err == nil || !errors.Is(asDomainError(err), want) {
+		t.Fatalf("expected %s, got %v", want.Code, err)
+	}
+}
+
+func TestCardService(t *testing.T) {
+	ctx := context.Background()
+	store := &txCounter{memoryStore: newMemoryStore()}
+	cards := newCardService(store)
+	owner, stranger := uuid.New(), uuid.New()
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	created, err := cards.Create(ctx, owner, cardRequest{Name: "Visa", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("it creates and updates cards in a transaction", func(t *testing.T) {
+		before := store.transactions
+		if _, err := cards.Update(ctx, owner, created.ID, expectVersion(created.Version), replace[database.Card](cardRequest{Name: "Visa Gold", ExpiresAt: expiresAt})); err != nil {
+			t.Fatal(err)
+		}
+		if store.transactions != before+1 {
+			t.Errorf("expected the update to run in a transaction, got %d transactions", store.transactions-before)
+		}
+	})
+
+	t.Run("it rejects a second card with the same name", func(t *testing.T) {
+		_, err := cards.Create(ctx, owner, cardRequest{Name: "Visa Gold", ExpiresAt: expiresAt})
+		assertDomainError(t, err, ConflictError)
+
+		// Other users keep their own names
+		if _, err := cards.Create(ctx, stranger, cardRequest{Name: "Visa Gold", ExpiresAt: expiresAt}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("it validates cards", func(t *testing.T) {
+		_, err := cards.Create(ctx, owner, cardRequest{Name: " "})
+		assertDomainError(t, err, ValidationFailedError)
+
+		_, err = cards.Update(ctx, owner, created.ID, expectVersion(2), patchWith([]byte(`{"expires_at": null}`), cardRequestFrom))
+		assertDomainError(t, err, ValidationFailedError)
+	})
+
+	t.Run("it keeps the cards of other users to their owner", func(t *testing.T) {
+		_, err := cards.Get(ctx, stranger, created.ID)
+		assertDomainError(t, err, ForbiddenError)
+
+		err = cards.Delete(ctx, stranger, created.ID, expectVersion(2))
+		assertDomainError(t, err, ForbiddenError)
+
+		_, err = cards.Get(ctx, owner, uuid.New())
+		assertDomainError(t, err, NotFoundError)
+	})
+
+	t.Run("it only changes the expected version", func(t *testing.T) {
+		_, err := cards.Update(ctx, owner, created.ID, expectVersion(created.Version), patchWith([]byte(`{"name": "Stale"}`), cardRequestFrom))
+		assertDomainError(t, err, PreconditionFailedError)
+
+		err = cards.Delete(ctx, owner, created.ID, expectVersion(created.Version))
+		assertDomainError(t, err, PreconditionFailedError)
+
+		card, err := cards.Get(ctx, owner, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if card.Name != "Visa Gold" {
+			t.Errorf("expected the card to be left alone, got name %q", card.Name)
+		}
+
+		if err := cards.Delete(ctx, owner, created.ID, expectVersion(card.Version)); err != nil {
+			t.Fatal(err)
+		}
+		_, err = cards.Get(ctx, owner, created.ID)
+		assertDomainError(t, err, NotFoundError)
+	})
+}
+
+func TestActiveSubscriptionService(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	activeSubscriptions := newActiveSubscriptionService(store)
+	user, stranger := uuid.New(), uuid.New()
+
+	subscription, err := newSubscriptionService(store).Create(ctx, user, subscriptionRequest{Name: "Netflix", MonthlyCost: 129, Currency: "SEK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := newCardService(store).Create(ctx, user, cardRequest{Name: "Visa", ExpiresAt: time.Now().AddDate(1, 0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangersCard, err := newCardService(store).Create(ctx, stranger, cardRequest{Name: "Amex", ExpiresAt: time.Now().AddDate(1, 0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := activeSubscriptionRequest{SubscriptionID: subscription.ID, CardID: card.ID, BillingFrequency: "monthly"}
+
+	t.Run("it validates the request", func(t *testing.T) {
+		_, err := activeSubscriptions.Create(ctx, user, activeSubscriptionRequest{SubscriptionID: subscription.ID, CardID: card.ID})
+		assertDomainError(t, err, ValidationFailedError)
+	})
+
+	t.Run("it pays with cards of the user only", func(t *testing.T) {
+		_, err := activeSubscriptions.Create(ctx, user, activeSubscriptionRequest{SubscriptionID: subscription.ID, CardID: strangersCard.ID, BillingFrequency: "monthly"})
+		assertDomainError(t, err, ForbiddenError)
+
+		_, err = activeSubscriptions.Create(ctx, user, activeSubscriptionRequest{SubscriptionID: subscription.ID, CardID: uuid.New(), BillingFrequency: "monthly"})
+		assertDomainError(t, err, NotFoundError)
+	})
+
+	t.Run("it activates a subscription once", func(t *testing.T) {
+		activeSubscription, err := activeSubscriptions.Create(ctx, user, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = activeSubscriptions.Create(ctx, user, request)
+		assertDomainError(t, err, ConflictError)
+
+		// Other users cannot end it
+		err = activeSubscriptions.Delete(ctx, stranger, activeSubscription.ID, expectVersion(activeSubscription.Version))
+		assertDomainError(t, err, ForbiddenError)
+		err = activeSubscriptions.Delete(ctx, user, activeSubscription.ID, expectVersion(activeSubscription.Version+1))
+		assertDomainError(t, err, PreconditionFailedError)
+		if err := activeSubscriptions.Delete(ctx, user, activeSubscription.ID, expectVersion(activeSubscription.Version)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := activeSubscriptions.Create(ctx, user, request); err != nil {
+			t.Errorf("expected the subscription to be activated again, got %v", err)
+		}
+	})
+}
+
+func TestServiceErrors(t *testing.T) {
+	ctx := context.Background()
+	userId := uuid.New()
+
+	t.Run("it reports failing queries as internal errors", func(t *testing.T) {
+		subscriptions := newSubscriptionService(failingStore(errors.New("connection refused")))
+		_, err := subscriptions.Create(ctx, userId, subscriptionRequest{Name: "Netflix", Currency: "SEK"})
+		assertDomainError(t, err, InternalError)
+	})
+
+	t.Run("it reports violated unique constraints as conflicts", func(t *testing.T) {
+		cards := newCardService(failingStore(uniqueViolationError("cards_owner_name_key")))
+		_, err := cards.Create(ctx, userId, cardRequest{Name: "Visa", ExpiresAt: time.Now().AddDate(1, 0, 0)})
+		assertDomainError(t, err, ConflictError)
+		if detail := asDomainError(err).Detail; detail != "card is already registered" {
+			t.Errorf("expected the detail of the constraint, got %q", detail)
+		}
+
+		categories := newCategoryService(failingStore(&pq.Error{Code: codeUniqueViolation, Constraint: "unknown_key"}))
+		_, err = categories.Create(ctx, userId, categoryRequest{Name: "Streaming"})
+		assertDomainError(t, err, ConflictError)
+
+		categories = newCategoryService(failingStore(&pq.Error{Code: "23503"}))
+		_, err = categories.Create(ctx, userId, categoryRequest{Name: "Streaming"})
+		assertDomainError(t, err, InternalError)
+	})
+
+	t.Run("it reports missing resources as not found", func(t *testing.T) {
+		categories := newCategoryService(failingStore(sql.ErrNoRows))
+		err := categories.Delete(ctx, userId, uuid.New(), expectVersion(fakeResourceVersion))
+		assertDomainError(t, err, NotFoundError)
+	})
+
+	t.Run("it rejects patches that are not JSON", func(t *testing.T) {
+		subscriptions := newSubscriptionService(newFakeStore(fakeDatabaseOptions{owner: userId}))
+		_, err := subscriptions.Update(ctx, userId, uuid.MustParse(fakeSubscriptionId), expectVersion(fakeResourceVersion), patchWith([]byte(`{`), subscriptionRequestFrom))
+		assertDomainError(t, err, InvalidRequestError)
+	})
+
+	t.Run("it rolls back failed transactions", func(t *testing.T) {
+		store := newMemoryStore()
+		failed := errors.New("failed")
+		err := store.WithTx(ctx, func(db dbQuerier) error {
+			if _, err := db.CreateCard(ctx, database.CreateCardParams{Name: "Visa", Owner: userId}); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Fatalf("expected the error of the transaction, got %v", err)
+		}
+		if cards, _ := store.ListCardsForOwner(ctx, userId); len(cards) != 0 {
+			t.Errorf("expected the card to be rolled back, got %d cards", len(cards))
+		}
+	})
+}
@@ -11,6 +11,25 @@ VALUES (
     )
 RETURNING *;
 
+-- name: UpsertActiveSubscription :one
+INSERT INTO active_subscriptions (subscription_id, user_id, card_id, created_at, updated_at, billing_frequency, auto_renew_enabled)
+VALUES (
+        $1,
+        $2,
+        $3,
+        NOW(),
+        NOW(),
+        $4,
+        $5
+    )
+ON CONFLICT (user_id, subscription_id) DO UPDATE
+SET card_id            = EXCLUDED.card_id,
+    billing_frequency  = EXCLUDED.billing_frequency,
+    auto_renew_enabled = EXCLUDED.auto_renew_enabled,
+    updated_at         = NOW(),
+    version            = active_subscriptions.version + 1
+RETURNING *;
+
 -- name: EnableAutoRenew :exec
 UPDATE active_subscriptions
 SET auto_renew_enabled = true
@@ -36,11 +55,6 @@ SELECT *
 FROM active_subscriptions
 WHERE user_id = $1;
 
--- name: GetActiveSubscriptionByUserIdAndSubId :one
-SELECT *
-FROM active_subscriptions
-WHERE user_id = $1 AND subscription_id = $2;
-
 -- name: GetActiveSubscriptionById :one
 SELECT *
 FROM active_subscriptions
@@ -48,12 +62,16 @@ WHERE id = $1;
 
 -- name: DeleteActiveSubscription :execresult
 DELETE FROM active_subscriptions
-WHERE id = $1;
+WHERE id = $1 AND version = $2;
 
 -- name: UpdateActiveSubscription :one
 UPDATE active_subscriptions
 SET billing_frequency  = $2,
     auto_renew_enabled = $3,
-    updated_at = NOW()
-WHERE id = $1
+    updated_at = NOW(),
+    version = version + 1
+WHERE id = $1 AND version = $4
 RETURNING *;
+
+-- name: CountActiveSubscriptions :one
+SELECT count(*) FROM active_subscriptions;
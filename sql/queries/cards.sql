@@ -5,7 +5,20 @@ VALUES (NOW(),
         $1,
         $2,
         $3)
-RETURNING id, created_at, updated_at, name, owner, expires_at;
+RETURNING id, created_at, updated_at, name, owner, expires_at, version;
+
+-- name: UpsertCard :one
+INSERT INTO cards (created_at, updated_at, name, owner, expires_at)
+VALUES (NOW(),
+        NOW(),
+        $1,
+        $2,
+        $3)
+ON CONFLICT (owner, name) DO UPDATE
+SET expires_at = EXCLUDED.expires_at,
+    updated_at = NOW(),
+    version    = cards.version + 1
+RETURNING *;
 
 -- name: ResetCards :many
 DELETE
@@ -27,20 +40,16 @@ SELECT *
 FROM cards
 WHERE id = $1;
 
--- name: GetCardByName :one
-SELECT *
-FROM cards
-WHERE name = $1 AND owner = $2;
-
 -- name: UpdateCard :one
 UPDATE cards
 SET name       = $2,
     expires_at = $3,
-    updated_at = $4
-WHERE id = $1
+    updated_at = $4,
+    version    = version + 1
+WHERE id = $1 AND version = $5
 RETURNING *;
 
 -- name: DeleteCard :execresult
 DELETE
 FROM cards
-WHERE id = $1;
+WHERE id = $1 AND version = $2;
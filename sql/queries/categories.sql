@@ -9,6 +9,19 @@ $3
 )
 RETURNING *;
 
+-- name: UpsertCategory :one
+INSERT INTO categories (created_at, updated_at, name, description, created_by)
+VALUES (
+NOW(),
+NOW(),
+$1,
+$2,
+$3
+)
+ON CONFLICT (created_by, name) DO UPDATE
+SET description = EXCLUDED.description, updated_at = NOW(), version = categories.version + 1
+RETURNING *;
+
 -- name: ListCategories :many
 SELECT * FROM categories
 ORDER BY name ASC;
@@ -22,13 +35,9 @@ ORDER BY name ASC;
 SELECT * FROM categories
 WHERE id = $1;
 
--- name: CheckExistingCategory :one
-SELECT * FROM categories
-WHERE name = $1 AND created_by = $2;
-
 -- name: DeleteCategory :execresult
 DELETE FROM categories
-WHERE id = $1;
+WHERE id = $1 AND version = $2;
 
 -- name: ResetCategories :many
 DELETE FROM categories
@@ -36,6 +45,6 @@ RETURNING *;
 
 -- name: UpdateCategory :one
 UPDATE categories
-SET name = $2, description = $3, updated_at=NOW()
-WHERE id = $1
+SET name = $2, description = $3, updated_at=NOW(), version = version + 1
+WHERE id = $1 AND version = $4
 RETURNING *;
@@ -0,0 +1,20 @@
+-- name: TakeRateLimitToken :one
+-- Takes a token from the bucket of the key, which holds up to capacity tokens and is refilled with refill_rate tokens
+-- per second. Returns the tokens left, or no row when the bucket is empty.
+INSERT INTO rate_limits AS bucket (key, tokens, updated_at)
+VALUES (@key, (@capacity::float8) - 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET tokens = LEAST(@capacity::float8, bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at)::float8 * @refill_rate::float8) - 1,
+    updated_at = NOW()
+WHERE LEAST(@capacity::float8, bucket.tokens + EXTRACT(EPOCH FROM NOW() - bucket.updated_at)::float8 * @refill_rate::float8) >= 1
+RETURNING tokens;
+
+-- name: GetRateLimitTokens :one
+SELECT LEAST(@capacity::float8, tokens + EXTRACT(EPOCH FROM NOW() - updated_at)::float8 * @refill_rate::float8)::float8 AS tokens
+FROM rate_limits
+WHERE key = @key;
+
+-- name: DeleteStaleRateLimits :execrows
+-- Buckets that have not been used since before are full again and can be removed.
+DELETE FROM rate_limits
+WHERE updated_at < @before;
@@ -25,3 +25,7 @@ RETURNING *;
 -- name: GetRefreshToken :one
 SELECT * FROM refresh_tokens
 WHERE user_id = $1 AND revoked_at is NULL;
+
+-- name: GetRefreshTokenByToken :one
+SELECT * FROM refresh_tokens
+WHERE token = $1;
@@ -0,0 +1,23 @@
+-- name: SearchSubscriptions :many
+SELECT id, name, description, ts_rank(subscription_search_vector(name, description), websearch_to_tsquery('simple', sqlc.arg(query)::text))::real AS rank
+FROM subscriptions
+WHERE created_by = sqlc.arg(created_by)
+  AND subscription_search_vector(name, description) @@ websearch_to_tsquery('simple', sqlc.arg(query)::text)
+ORDER BY rank DESC, name ASC
+LIMIT sqlc.arg(max_results);
+
+-- name: SearchCategories :many
+SELECT id, name, description, ts_rank(category_search_vector(name, description), websearch_to_tsquery('simple', sqlc.arg(query)::text))::real AS rank
+FROM categories
+WHERE created_by = sqlc.arg(created_by)
+  AND category_search_vector(name, description) @@ websearch_to_tsquery('simple', sqlc.arg(query)::text)
+ORDER BY rank DESC, name ASC
+LIMIT sqlc.arg(max_results);
+
+-- name: SearchCards :many
+SELECT id, name, ts_rank(card_search_vector(name), websearch_to_tsquery('simple', sqlc.arg(query)::text))::real AS rank
+FROM cards
+WHERE owner = sqlc.arg(owner)
+  AND card_search_vector(name) @@ websearch_to_tsquery('simple', sqlc.arg(query)::text)
+ORDER BY rank DESC, name ASC
+LIMIT sqlc.arg(max_results);
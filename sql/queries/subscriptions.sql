@@ -13,6 +13,24 @@ $7
 )
 RETURNING *;
 
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (created_at, updated_at, name, monthly_cost, currency, unsubscribe_url, description, category_id, created_by)
+VALUES (
+NOW(),
+NOW(),
+$1,
+$2,
+$3,
+$4,
+$5,
+$6,
+$7
+)
+ON CONFLICT (created_by, name) DO UPDATE
+SET monthly_cost = EXCLUDED.monthly_cost, currency = EXCLUDED.currency, unsubscribe_url = EXCLUDED.unsubscribe_url,
+    description = EXCLUDED.description, category_id = EXCLUDED.category_id, updated_at = NOW(), version = subscriptions.version + 1
+RETURNING *;
+
 -- name: ListSubscriptions :many
 SELECT * FROM subscriptions
 ORDER BY name ASC;
@@ -26,13 +44,9 @@ ORDER BY name ASC;
 SELECT * FROM subscriptions
 WHERE id = $1;
 
--- name: GetSubscriptionByNameAndCreator :one
-SELECT * FROM subscriptions
-WHERE created_by = $1 AND name = $2;
-
 -- name: DeleteSubscription :execresult
 DELETE FROM subscriptions
-WHERE id = $1;
+WHERE id = $1 AND version = $2;
 
 -- name: ResetSubscriptions :many
 DELETE FROM subscriptions
@@ -40,6 +54,6 @@ RETURNING *;
 
 -- name: UpdateSubscription :one
 UPDATE subscriptions
-SET name = $2, monthly_cost = $3, currency=$4, unsubscribe_url=$5, description=$6, category_id=$7, updated_at=NOW()
-WHERE id = $1
+SET name = $2, monthly_cost = $3, currency=$4, unsubscribe_url=$5, description=$6, category_id=$7, updated_at=NOW(), version = version + 1
+WHERE id = $1 AND version = $8
 RETURNING *;
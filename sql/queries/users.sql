@@ -33,3 +33,12 @@ RETURNING *;
 -- name: DeleteUser :execresult
 DELETE FROM users
 WHERE id = $1;
+
+-- name: SetUserAdmin :one
+UPDATE users
+SET is_admin = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountUsers :one
+SELECT count(*) FROM users;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- The search vectors are computed by functions rather than stored in generated columns, which every query selecting
+-- whole rows would read without using them. The GIN indexes are built on the function calls so that searches using
+-- the same calls are served by the indexes.
+-- +goose StatementBegin
+CREATE FUNCTION subscription_search_vector(name TEXT, description TEXT) RETURNS tsvector
+LANGUAGE SQL IMMUTABLE
+AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+$$;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION category_search_vector(name TEXT, description TEXT) RETURNS tsvector
+LANGUAGE SQL IMMUTABLE
+AS $$
+    SELECT setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+           setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+$$;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION card_search_vector(name TEXT) RETURNS tsvector
+LANGUAGE SQL IMMUTABLE
+AS $$
+    SELECT to_tsvector('simple', coalesce(name, ''))
+$$;
+-- +goose StatementEnd
+
+CREATE INDEX idx_subscriptions_search_vector ON subscriptions USING GIN (subscription_search_vector(name, description));
+CREATE INDEX idx_categories_search_vector ON categories USING GIN (category_search_vector(name, description));
+CREATE INDEX idx_cards_search_vector ON cards USING GIN (card_search_vector(name));
+
+-- +goose Down
+DROP INDEX idx_cards_search_vector;
+DROP INDEX idx_categories_search_vector;
+DROP INDEX idx_subscriptions_search_vector;
+DROP FUNCTION card_search_vector;
+DROP FUNCTION category_search_vector;
+DROP FUNCTION subscription_search_vector;
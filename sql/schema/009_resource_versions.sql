@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE categories ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE cards ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE active_subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE active_subscriptions DROP COLUMN version;
+ALTER TABLE cards DROP COLUMN version;
+ALTER TABLE categories DROP COLUMN version;
+ALTER TABLE subscriptions DROP COLUMN version;
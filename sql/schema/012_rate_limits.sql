@@ -0,0 +1,13 @@
+-- +goose Up
+-- The buckets of the rate limits shared by all replicas. They can be rebuilt at any time, so the table is not
+-- written to the WAL. updated_at stores the instant, so that comparing it with the cutoff of DeleteStaleRateLimits,
+-- which comes from the server, does not depend on the time zone of the session or of the server.
+CREATE UNLOGGED TABLE rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_rate_limits_updated_at ON rate_limits (updated_at);
+
+-- +goose Down
+DROP TABLE rate_limits;
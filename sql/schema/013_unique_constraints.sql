@@ -0,0 +1,52 @@
+-- +goose Up
+-- Names were kept unique per user by checking for an existing row before inserting, which concurrent requests could
+-- race past. Duplicates that slipped through are renamed before the database enforces uniqueness itself: the oldest
+-- row keeps its name and the others get their id appended, which cannot collide with another name of the user since
+-- ids are unique. The renames are not undone by Down.
+--
+-- A subscription that was activated more than once keeps its oldest activation. The other activations are moved to
+-- active_subscriptions_duplicates rather than dropped, so they can be inspected, and Down moves them back.
+UPDATE subscriptions SET name = duplicates.name || ' (' || duplicates.id || ')'
+FROM (
+    SELECT id, name, row_number() OVER (PARTITION BY created_by, name ORDER BY created_at, id) AS n
+    FROM subscriptions
+) AS duplicates
+WHERE subscriptions.id = duplicates.id AND duplicates.n > 1;
+
+UPDATE categories SET name = duplicates.name || ' (' || duplicates.id || ')'
+FROM (
+    SELECT id, name, row_number() OVER (PARTITION BY created_by, name ORDER BY created_at, id) AS n
+    FROM categories
+) AS duplicates
+WHERE categories.id = duplicates.id AND duplicates.n > 1;
+
+UPDATE cards SET name = duplicates.name || ' (' || duplicates.id || ')'
+FROM (
+    SELECT id, name, row_number() OVER (PARTITION BY owner, name ORDER BY created_at, id) AS n
+    FROM cards
+) AS duplicates
+WHERE cards.id = duplicates.id AND duplicates.n > 1;
+
+CREATE TABLE active_subscriptions_duplicates AS
+SELECT * FROM active_subscriptions
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (PARTITION BY user_id, subscription_id ORDER BY created_at, id) AS n
+        FROM active_subscriptions
+    ) AS duplicates
+    WHERE duplicates.n > 1
+);
+DELETE FROM active_subscriptions WHERE id IN (SELECT id FROM active_subscriptions_duplicates);
+
+ALTER TABLE subscriptions ADD CONSTRAINT subscriptions_created_by_name_key UNIQUE (created_by, name);
+ALTER TABLE categories ADD CONSTRAINT categories_created_by_name_key UNIQUE (created_by, name);
+ALTER TABLE cards ADD CONSTRAINT cards_owner_name_key UNIQUE (owner, name);
+ALTER TABLE active_subscriptions ADD CONSTRAINT active_subscriptions_user_id_subscription_id_key UNIQUE (user_id, subscription_id);
+
+-- +goose Down
+ALTER TABLE active_subscriptions DROP CONSTRAINT active_subscriptions_user_id_subscription_id_key;
+ALTER TABLE cards DROP CONSTRAINT cards_owner_name_key;
+ALTER TABLE categories DROP CONSTRAINT categories_created_by_name_key;
+ALTER TABLE subscriptions DROP CONSTRAINT subscriptions_created_by_name_key;
+INSERT INTO active_subscriptions SELECT * FROM active_subscriptions_duplicates;
+DROP TABLE active_subscriptions_duplicates;
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/benkoben/unsubtle-core/internal/database"
+)
+
+// -- Transactions
+//
+// Operations that take several queries, such as checking a precondition before writing, are run as a unit of work
+// with dbQuerier.WithTx: either all of their writes are committed or none are. Transactions run at the default READ
+// COMMITTED level and lock nothing, so a concurrent request may change a row between a check and the write. That is
+// caught by the database rather than the transaction: the version a conditional write matches on and the unique
+// constraints of 013_unique_constraints.sql reject writes that a race would otherwise let through.
+
+// sqlStore is the dbQuerier of the server, backed by the connection pool of the database.
+type sqlStore struct {
+	*database.Queries
+	db *sql.DB
+}
+
+// newSQLStore returns a store whose queries are timed and traced by the name sqlc gave them.
+func newSQLStore(db *sql.DB) *sqlStore {
+	return &sqlStore{Queries: database.New(instrumentDB(db)), db: db}
+}
+
+/*
+WithTx runs fn in a transaction, which is committed when fn returns nil and rolled back otherwise.
+
+The queries of fn are instrumented like all others. database.Queries.WithTx would hand the bare *sql.Tx to the queries,
+so the transaction is wrapped by instrumentDB instead.
+*/
+func (s *sqlStore) WithTx(ctx context.Context, fn func(dbQuerier) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rolling back a committed transaction is a no-op
+	defer tx.Rollback()
+
+	if err := fn(txStore{Queries: database.New(instrumentDB(tx))}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// txStore runs the queries of a transaction.
+type txStore struct {
+	*database.Queries
+}
+
+// WithTx runs fn in the transaction that is already open, it is committed or rolled back as a whole.
+func (s txStore) WithTx(_ context.Context, fn func(dbQuerier) error) error {
+	return fn(s)
+}
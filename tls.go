@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -- TLS
+//
+// With tls.cert_file and tls.key_file set, the server terminates TLS itself. The certificate is served by a
+// certReloader, which loads it again when either file changes or the process receives SIGHUP, e.g. after a renewal
+// by certbot. Open connections keep the certificate of their handshake, new connections get the new one, so nothing
+// is dropped. tls.redirect_address adds a plain HTTP listener that redirects to HTTPS, and admin.client_ca_file
+// requires the clients of the admin listener to present a certificate signed by that CA.
+
+// certificateCheckInterval is how often the certificate files are checked for changes.
+const certificateCheckInterval = 10 * time.Second
+
+// certReloader holds the certificate of the server and reloads it from its files.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu sync.Mutex
+	// modified are the modification times of the files when the certificate was loaded
+	modified [2]time.Time
+}
+
+// newCertReloader loads the certificate, it fails when the files do not hold a valid pair.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the certificate from its files. The previous certificate is kept when that fails, such as when the
+// files are read halfway through a renewal.
+func (c *certReloader) reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modified, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load the TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	c.modified = modified
+	return nil
+}
+
+func (c *certReloader) modTimes() ([2]time.Time, error) {
+	var modified [2]time.Time
+	for i, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modified, fmt.Errorf("could not read the TLS certificate: %w", err)
+		}
+		modified[i] = info.ModTime()
+	}
+	return modified, nil
+}
+
+// changed reports whether either file was modified since the certificate was loaded.
+func (c *certReloader) changed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	modified, err := c.modTimes()
+	return err == nil && modified != c.modified
+}
+
+// getCertificate serves the current certificate, see tls.Config.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// watch reloads the certificate when its files have changed, which is checked every interval, or on every signal
+// received from reload, until ctx is done. A certificate that fails to load is logged and retried on the next check.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+		}
+		if err := c.reload(); err != nil {
+			slog.Error("could not reload the TLS certificate", "error", err)
+			continue
+		}
+		slog.Info("reloaded the TLS certificate", "cert_file", c.certFile, "not_after", c.cert.Load().Leaf.NotAfter)
+	}
+}
+
+// serverTLSConfig serves the certificate of certificates.
+func serverTLSConfig(certificates *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certificates.getCertificate,
+	}
+}
+
+// adminTLSConfig serves the certificate of certificates and requires a client certificate signed by a CA of the PEM
+// file clientCAFile.
+func adminTLSConfig(certificates *certReloader, clientCAFile string) (*tls.Config, error) {
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("could not read the client CA: no PEM encoded certificates found")
+	}
+
+	config := serverTLSConfig(certificates)
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = clientCAs
+	return config, nil
+}
+
+// handleRedirectToHTTPS redirects every request to the same URL over HTTPS on port. The method and body are kept,
+// since 308 Permanent Redirect does not allow clients to change them.
+func handleRedirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "the Host header is required", http.StatusBadRequest)
+			return
+		}
+		// The default port of HTTPS is left out of the URL
+		host = strings.TrimSuffix(net.JoinHostPort(host, port), ":443")
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate for localhost, signed by parent or self signed when parent is nil.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key}
+}
+
+func (c *testCertificate) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCertificate) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeFiles writes the certificate and key to certFile and keyFile, modified at modTime.
+func (c *testCertificate) writeFiles(t *testing.T, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	for name, data := range map[string][]byte{certFile: c.certPEM(), keyFile: c.keyPEM(t)} {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveTLS serves handler over TLS with config on a random port of localhost and returns its address.
+func serveTLS(t *testing.T, handler http.Handler, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler, TLSConfig: config}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCertificate(t, "ca", nil)
+	first := newTestCertificate(t, "first", ca)
+	first.writeFiles(t, certFile, keyFile, time.Now().Add(-time.Minute))
+
+	certificates, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() string {
+		cert, _ := certificates.getCertificate(nil)
+		return cert.Leaf.Subject.CommonName
+	}
+	// waitFor polls until the certificate with the common name name is served
+	waitFor := func(t *testing.T, name string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); served() != name; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected certificate %q to be served, got %q", name, served())
+			}
+		}
+	}
+
+	addr := serveTLS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), serverTLSConfig(certificates))
+	// peer requests the server with client and returns the common name of the certificate of the connection
+	peer := func(t *testing.T, client *http.Client) string {
+		t.Helper()
+		res, err := client.Get("https://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	newClient := func() *http.Client {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	}
+
+	hup := make(chan os.Signal, 1)
+	ctx := t.Context()
+	go certificates.watch(ctx, 10*time.Millisecond, hup)
+
+	t.Run("it reloads a changed certificate without dropping connections", func(t *testing.T) {
+		client := newClient()
+		if got := peer(t, client); got != "first" {
+			t.Fatalf("expected the first certificate, got %q", got)
+		}
+
+		newTestCertificate(t, "second", ca).writeFiles(t, certFile, keyFile, time.Now())
+		waitFor(t, "second")
+
+		// The open connection is reused with the certificate of its handshake
+		if got := peer(t, client); got != "first" {
+			t.Errorf("expected the open connection to be kept, got certificate %q", got)
+		}
+		if got := peer(t, newClient()); got != "second" {
+			t.Errorf("expected new connections to get the new certificate, got %q", got)
+		}
+	})
+
+	t.Run("it keeps the certificate when the new one is invalid", func(t *testing.T) {
+		if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := certificates.reload(); err == nil {
+			t.Error("expected the invalid key to be reported")
+		}
+		if got := served(); got != "second" {
+			t.Errorf("expected the previous certificate to be kept, got %q", got)
+		}
+	})
+
+	t.Run("it reloads on SIGHUP", func(t *testing.T) {
+		// The files keep their modification time, so only the signal reloads them
+		newTestCertificate(t, "third", ca).writeFiles(t, certFile, keyFile, time.Now().Add(-time.Hour))
+		certificates.mu.Lock()
+		certificates.modified, _ = certificates.modTimes()
+		certificates.mu.Unlock()
+
+		hup <- syscall.SIGHUP
+		waitFor(t, "third")
+	})
+
+	t.Run("it requires client certificates on the admin listener", func(t *testing.T) {
+		caFile := filepath.Join(dir, "client-ca.pem")
+		clientCA := newTestCertificate(t, "client-ca", nil)
+		if err := os.WriteFile(caFile, clientCA.certPEM(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		adminConfig, err := adminTLSConfig(certificates, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adminAddr := serveTLS(t, NewAdminHandler(newTestConfig(), newMemoryStore()), adminConfig)
+
+		get := func(clientCerts ...tls.Certificate) (*http.Response, error) {
+			client := newClient()
+			client.Transport.(*http.Transport).TLSClientConfig.Certificates = clientCerts
+			return client.Get("https://" + adminAddr + "/metrics")
+		}
+		if res, err := get(); err == nil {
+			res.Body.Close()
+			t.Error("expected clients without a certificate to be rejected")
+		}
+		if res, err := get(newTestCertificate(t, "stranger", newTestCertificate(t, "other-ca", nil)).tlsCertificate(t)); err == nil {
+			res.Body.Close()
+			t.Error("expected clients with a certificate of another CA to be rejected")
+		}
+		res, err := get(newTestCertificate(t, "prometheus", clientCA).tlsCertificate(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("expected status 200, got %d", res.StatusCode)
+		}
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   string
+		host   string
+		target string
+		want   string
+	}{
+		{"it keeps the path and query", "8443", "example.com", "/api/cards?limit=5", "https://example.com:8443/api/cards?limit=5"},
+		{"it replaces the port of the request", "8443", "example.com:80", "/", "https://example.com:8443/"},
+		{"it leaves out the default port", "443", "example.com:80", "/dashboard", "https://example.com/dashboard"},
+		{"it handles IPv6 hosts", "443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			handleRedirectToHTTPS(tt.port).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusPermanentRedirect {
+				t.Errorf("expected status 308, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("expected a redirect to %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/benkoben/unsubtle-core/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// -- Tracing
+//
+// With tracing.exporter set, every request is traced with OpenTelemetry. traceRequests starts a server span named
+// after the route pattern, continuing the trace of the W3C traceparent header of the request, and every query run
+// for the request is a child span, see instrumentedDB. The traceparent of the server span is returned in the
+// response and its trace ID is added to the log lines of the request. Without an exporter the spans are no-ops.
+
+const (
+	tracingExporterStdout = "stdout"
+	tracingExporterOTLP   = "otlp"
+
+	serviceName = "unsubtle-core"
+	tracerName  = "github.com/benkoben/unsubtle-core"
+)
+
+// tracer returns the tracer of the server from the provider installed by setupTracing, if any.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+/*
+setupTracing installs the global tracer provider and propagator for the exporter of the configuration. The stdout
+exporter writes the spans to w, the otlp exporter sends them to a collector over plain HTTP, which is meant to run
+next to the server. The returned function flushes the spans that have not been exported yet.
+*/
+func setupTracing(ctx context.Context, config *Config, w io.Writer) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Tracing.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case tracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case tracingExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(config.Tracing.Endpoint), otlptracehttp.WithInsecure())
+	default:
+		err = fmt.Errorf("unknown exporter %q", config.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not set up tracing: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(serviceName),
+			semconv.DeploymentEnvironment(config.Environment),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request. It must run before the access log, which then includes
+// the trace ID.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if span.SpanContext().IsValid() {
+			// Clients can look the request up in the tracing backend with the traceparent of the response
+			propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+			logging.AddAttrs(ctx, "trace_id", span.SpanContext().TraceID().String())
+		}
+
+		r = r.WithContext(ctx)
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		// The mux has set the pattern that matched by now
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			_, route, _ := strings.Cut(r.Pattern, " ")
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
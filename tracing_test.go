@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stubDBTX fails every statement with err.
+type stubDBTX struct {
+	err error
+}
+
+func (s stubDBTX) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, s.err
+}
+
+func (s stubDBTX) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, s.err
+}
+
+func (s stubDBTX) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, s.err
+}
+
+func (s stubDBTX) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+// recordSpans installs a tracer provider that records the spans in memory for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func TestTracing(t *testing.T) {
+	exporter := recordSpans(t)
+
+	handler, _, user, token := newAuthenticatedServer(t, newTestConfig())
+
+	t.Run("it continues the trace of the client", func(t *testing.T) {
+		exporter.Reset()
+		const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+		req := httptest.NewRequest(http.MethodGet, "/api/subscriptions/"+user.ID.String(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("traceparent"); !strings.Contains(got, traceId) {
+			t.Errorf("expected the traceparent of the response to continue trace %s, got %q", traceId, got)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("expected a single span, got %d", len(spans))
+		}
+		span := spans[0]
+		if span.Name != "GET /api/subscriptions/{id}" || span.SpanKind != trace.SpanKindServer {
+			t.Errorf("expected a server span named after the route, got %q (%s)", span.Name, span.SpanKind)
+		}
+		if span.SpanContext.TraceID().String() != traceId || !span.Parent.IsRemote() {
+			t.Errorf("expected the span to be a child of the client span, got parent %v", span.Parent)
+		}
+	})
+
+	t.Run("it traces queries as children of the request", func(t *testing.T) {
+		exporter.Reset()
+		ctx, parent := tracer().Start(context.Background(), "request")
+		db := instrumentDB(stubDBTX{err: errors.New("connection refused")})
+		if _, err := db.ExecContext(ctx, "-- name: DeleteCard :execresult\nDELETE FROM cards WHERE id = $1"); err == nil {
+			t.Fatal("expected the error of the database")
+		}
+		parent.End()
+
+		spans := exporter.GetSpans()
+		if len(spans) != 2 {
+			t.Fatalf("expected the query and the request span, got %d", len(spans))
+		}
+		query := spans[0]
+		if query.Name != "DeleteCard" || query.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected a DeleteCard span below the request, got %q with parent %v", query.Name, query.Parent)
+		}
+		if query.Status.Code != codes.Error {
+			t.Errorf("expected the failed query to be marked as an error, got %v", query.Status)
+		}
+	})
+}